@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net"
@@ -18,6 +19,10 @@ import (
 // cmd.ExtraFiles, which the OS maps to fd 3 (stdin=0, stdout=1, stderr=2).
 const connFD = 3
 
+// reportFD is the pipe, the second entry in cmd.ExtraFiles, on which the
+// subprocess reports its session totals to the parent when it exits.
+const reportFD = 4
+
 func runProtocolHandler() {
 	flags := config.ParseFlags()
 
@@ -71,6 +76,9 @@ func runProtocolHandler() {
 		}()
 	}
 
+	// The tally feeds the parent's shutdown report and metrics.
+	tally := metrics.NewTally(&metrics.NoopCollector{})
+
 	// Build the full auth/delivery stack. Each subprocess gets its own stack
 	// instance; there is no shared state with the parent listener process.
 	stack, err := smtp.NewStack(smtp.StackConfig{
@@ -78,8 +86,12 @@ func runProtocolHandler() {
 		TLSConfig:   tlsConfig,
 		SpamChecker: spamChecker,
 		SpamConfig:  spamCheckConfig,
-		Collector:   &metrics.NoopCollector{},
+		Collector:   tally,
 		Logger:      logger,
+		TLSPolicy:   config.TLSPolicy(os.Getenv("SMTPD_TLS_POLICY")),
+		Honeypot:    os.Getenv("SMTPD_HONEYPOT") == "1",
+		Maintenance: os.Getenv("SMTPD_MAINTENANCE") == "1",
+		Warmup:      os.Getenv("SMTPD_WARMUP") == "1",
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "protocol-handler: error creating stack: %v\n", err)
@@ -105,8 +117,38 @@ func runProtocolHandler() {
 		os.Exit(1)
 	}
 
+	// On trusted_proxy listeners the parent has read the PROXY header and
+	// passes the real client address.
+	if addr := os.Getenv("SMTPD_CLIENT_ADDR"); addr != "" {
+		netConn, err = smtp.WithRemoteAddr(netConn, addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "protocol-handler: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Run exactly one SMTP session then exit.
 	if err := stack.Server.RunSingleConn(netConn, listenerMode, tlsConfig); err != nil {
 		logger.Debug("session ended", slog.String("error", err.Error()))
 	}
+
+	writeReport(smtp.HandlerReport{
+		Totals:     tally.Totals(),
+		Rejections: stack.Rejections(),
+		Counters:   tally.Counters(),
+	}, logger)
+}
+
+// writeReport sends the session report to the parent on reportFD. Nothing is
+// written unless the parent set SMTPD_REPORT, so a handler started by hand
+// never touches an fd 4 it does not own.
+func writeReport(report smtp.HandlerReport, logger *slog.Logger) {
+	if os.Getenv("SMTPD_REPORT") != "1" {
+		return
+	}
+	f := os.NewFile(uintptr(reportFD), "smtp-report")
+	defer func() { _ = f.Close() }()
+	if err := json.NewEncoder(f).Encode(report); err != nil {
+		logger.Debug("cannot report session totals", slog.String("error", err.Error()))
+	}
 }
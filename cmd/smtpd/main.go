@@ -21,8 +21,10 @@ func main() {
 		runServe()
 	case "protocol-handler":
 		runProtocolHandler()
+	case "top-senders":
+		runTopSenders()
 	default:
-		fmt.Fprintf(os.Stderr, "unknown subcommand %q\nusage: smtpd [serve|protocol-handler] [flags]\n", subcommand)
+		fmt.Fprintf(os.Stderr, "unknown subcommand %q\nusage: smtpd [serve|protocol-handler|top-senders] [flags]\n", subcommand)
 		os.Exit(1)
 	}
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/infodancer/logging"
 	"github.com/infodancer/smtpd/internal/config"
@@ -15,6 +17,7 @@ import (
 	"github.com/infodancer/smtpd/internal/rspamd"
 	"github.com/infodancer/smtpd/internal/smtp"
 	"github.com/infodancer/smtpd/internal/spamcheck"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func runServe() {
@@ -58,10 +61,48 @@ func runServe() {
 		cancel()
 	}()
 
-	// Metrics HTTP server runs in the parent process. Per-connection metrics
-	// are not aggregated from subprocesses in this release.
+	logger.Info("starting smtpd",
+		"hostname", cfg.Hostname,
+		"listeners", len(cfg.Listeners),
+		"exec", execPath)
+
+	// Protocol-handlers send back their TLS handshake failure, aborted
+	// transaction, size mismatch and filter decision counts as they exit;
+	// they are added to the series the metrics server exports.
+	var collector *metrics.PrometheusCollector
+	if cfg.Metrics.Enabled {
+		collector = metrics.NewPrometheusCollector(prometheus.DefaultRegisterer)
+	}
+
+	srv := smtp.NewSubprocessServer(smtp.SubprocessServerConfig{
+		Listeners:       cfg.Listeners,
+		ExecPath:        execPath,
+		ConfigPath:      configPath,
+		BindBestEffort:  cfg.BindBestEffort,
+		MaxConnections:  cfg.Limits.MaxConnections,
+		MaxConnShare:    cfg.Limits.MaxConnShare,
+		ConnSharePrefix: cfg.Limits.ConnSharePrefix,
+		OverloadMessage: cfg.OverloadMessage,
+		Rejections:      cfg.RecentRejections,
+		Warmup:          cfg.GetUnknownUserWarmup(),
+		Collector:       collector,
+		Temp:            cfg.Temp,
+		Logger:          logger,
+	})
+
+	// Metrics HTTP server runs in the parent process. Other per-connection
+	// metrics are not aggregated from subprocesses in this release.
 	if cfg.Metrics.Enabled {
 		metricsServer := metrics.NewPrometheusServer(cfg.Metrics.Address, cfg.Metrics.Path)
+		if cfg.Metrics.ReadinessProbe {
+			metricsServer.SetReadinessCheck(func(ctx context.Context) error {
+				return smtp.ProbeListeners(ctx, &cfg)
+			})
+		}
+		// Recent rejections are reported by each protocol-handler as it exits.
+		if cfg.RecentRejections > 0 {
+			metricsServer.HandleJSON("/rejections", func() any { return srv.RecentRejections() })
+		}
 		go func() {
 			if err := metricsServer.Start(ctx); err != nil && err != context.Canceled {
 				logger.Error("metrics server error", "error", err)
@@ -69,16 +110,61 @@ func runServe() {
 		}()
 	}
 
-	logger.Info("starting smtpd",
-		"hostname", cfg.Hostname,
-		"listeners", len(cfg.Listeners),
-		"exec", execPath)
+	// SIGUSR1 toggles maintenance mode: new sessions refuse MAIL with 421
+	// while running ones finish.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	go func() {
+		for {
+			select {
+			case <-usr1:
+				on := !srv.Maintenance()
+				srv.SetMaintenance(on)
+				logger.Info("maintenance mode toggled", "maintenance", on)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-	srv := smtp.NewSubprocessServer(cfg.Listeners, execPath, configPath, logger)
+	started := time.Now()
 	if err := srv.Run(ctx); err != nil && err != context.Canceled {
 		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		os.Exit(1)
 	}
+	logShutdownReport(logger, srv.Report(), time.Since(started), cfg.ShutdownReport)
+}
+
+// logShutdownReport logs the lifetime totals as the final record and, when
+// path is set, also writes them there as JSON. smtpd keeps no queue, so
+// there is no queue depth to report.
+func logShutdownReport(logger *slog.Logger, report smtp.Report, uptime time.Duration, path string) {
+	logger.Info("shutdown report",
+		slog.Duration("uptime", uptime),
+		slog.Int64("connections", report.Connections),
+		slog.Int64("connections_refused", report.ConnectionsRefused),
+		slog.Int64("messages_accepted", report.MessagesAccepted),
+		slog.Int64("messages_rejected", report.MessagesRejected),
+		slog.Int64("auth_successes", report.AuthSuccesses),
+		slog.Int64("auth_failures", report.AuthFailures),
+		slog.Int64("bytes_delivered", report.BytesDelivered),
+		slog.Int64("handlers_unreported", report.HandlersUnreported),
+		slog.Int64("handlers_running", report.HandlersRunning))
+
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(struct {
+		smtp.Report
+		UptimeSeconds int64 `json:"uptime_seconds"`
+	}{report, int64(uptime.Seconds())}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		logger.Error("cannot write shutdown report", "path", path, "error", err)
+	}
 }
 
 // createSpamChecker builds a spam checker from the configuration.
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+	"github.com/infodancer/smtpd/internal/smtp"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// runTopSenders prints the authenticated senders with the most accepted
+// messages in the current [smtpd.metrics] per_user window.
+func runTopSenders() {
+	n := flag.Int("n", 0, "Number of senders to list (default metrics.per_user_top)")
+	flags := config.ParseFlags()
+
+	cfg, err := config.LoadWithFlags(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !cfg.Metrics.PerUser {
+		fmt.Fprintln(os.Stderr, "top-senders: metrics.per_user is not enabled")
+		os.Exit(1)
+	}
+	// The memory backend dies with each protocol-handler; there is nothing
+	// to read from outside it.
+	if cfg.State.GetBackend() != "redis" {
+		fmt.Fprintln(os.Stderr, "top-senders: needs the redis state backend")
+		os.Exit(1)
+	}
+
+	opts, err := goredis.ParseURL(cfg.Redis.URL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "top-senders: invalid redis url: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Redis.Password != "" {
+		opts.Password = cfg.Redis.Password
+	}
+	client := goredis.NewClient(opts)
+	defer client.Close() //nolint:errcheck
+
+	limit := *n
+	if limit <= 0 {
+		limit = cfg.Metrics.GetPerUserTop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	senders, err := smtp.TopSenders(ctx, kvstore.NewRedis(client, smtp.StateKeyPrefix), limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "top-senders: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tMESSAGES\tBYTES")
+	for _, c := range senders {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", c.User, c.Messages, c.Bytes)
+	}
+	_ = w.Flush()
+}
@@ -5,6 +5,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -22,6 +27,21 @@ const (
 	ModeAlt ListenerMode = "alt"
 )
 
+// TLSPolicy selects how a listener offers STARTTLS.
+type TLSPolicy string
+
+const (
+	// TLSPolicyOff does not advertise STARTTLS, e.g. on an internal
+	// listener whose clients cannot use it.
+	TLSPolicyOff TLSPolicy = "off"
+	// TLSPolicyOptional advertises STARTTLS when a certificate is
+	// configured and accepts mail either way (default).
+	TLSPolicyOptional TLSPolicy = "optional"
+	// TLSPolicyRequired advertises STARTTLS and refuses MAIL and DATA
+	// until the client has used it.
+	TLSPolicyRequired TLSPolicy = "required"
+)
+
 // FileConfig is the top-level wrapper for the shared configuration file.
 // This allows smtpd, pop3d, and msgstore to share a single config file.
 type FileConfig struct {
@@ -64,6 +84,52 @@ const (
 	RejectionModeData RejectionMode = "data"
 )
 
+// HeaderPolicy controls which RFC 5322 headers an inbound message must carry.
+type HeaderPolicy string
+
+const (
+	// HeaderPolicyOff accepts messages regardless of their headers (default).
+	HeaderPolicyOff HeaderPolicy = "off"
+	// HeaderPolicyBasic requires exactly one From and one Date header.
+	HeaderPolicyBasic HeaderPolicy = "basic"
+	// HeaderPolicyStrict additionally requires exactly one valid Message-ID.
+	HeaderPolicyStrict HeaderPolicy = "strict"
+)
+
+// HeaderCharsetPolicy controls what happens to header fields holding bytes
+// that are not valid UTF-8. RFC 2047 encoded-words are plain ASCII, so only
+// raw 8-bit bytes can be invalid.
+type HeaderCharsetPolicy string
+
+const (
+	// HeaderCharsetOff passes headers through unchecked (default).
+	HeaderCharsetOff HeaderCharsetPolicy = "off"
+	// HeaderCharsetReject refuses the message with 550.
+	HeaderCharsetReject HeaderCharsetPolicy = "reject"
+	// HeaderCharsetDrop delivers the message without the offending fields.
+	HeaderCharsetDrop HeaderCharsetPolicy = "drop"
+	// HeaderCharsetReplace delivers the offending fields with each invalid
+	// byte sequence replaced by U+FFFD.
+	HeaderCharsetReplace HeaderCharsetPolicy = "replace"
+)
+
+// FCrDNSPolicy controls unauthenticated clients whose IP lacks
+// forward-confirmed reverse DNS: a PTR name that resolves back to the IP.
+type FCrDNSPolicy string
+
+const (
+	// FCrDNSOff does not look up the client's reverse DNS (default).
+	FCrDNSOff FCrDNSPolicy = "off"
+	// FCrDNSDefer refuses MAIL with 450 so a misconfigured sender can fix
+	// its DNS and retry.
+	FCrDNSDefer FCrDNSPolicy = "defer"
+	// FCrDNSReject refuses MAIL with 550.
+	FCrDNSReject FCrDNSPolicy = "reject"
+	// FCrDNSSignal never refuses; a client without FCrDNS counts as the
+	// no_fcrdns signal in [smtpd.signals].
+	FCrDNSSignal FCrDNSPolicy = "signal"
+)
+
 // SessionManagerConfig holds connection settings for the session-manager service.
 // This is a top-level [session-manager] section shared by all daemons.
 type SessionManagerConfig struct {
@@ -94,13 +160,51 @@ type Config struct {
 	Hostname           string               `toml:"hostname"`
 	LogLevel           string               `toml:"log_level"`
 	RecipientRejection RejectionMode        `toml:"recipient_rejection"`
+	BindBestEffort     bool                 `toml:"bind_best_effort"`     // keep running when some listeners fail to bind
+	LogTransactions    bool                 `toml:"log_transactions"`     // log protocol lines at debug level, AUTH redacted
+	OverloadMessage    string               `toml:"overload_message"`     // text of 421 4.3.2 overload replies
+	AddHeaders         map[string]string    `toml:"add_headers"`          // header name → value stamped on accepted mail
+	RequireHeaders     HeaderPolicy         `toml:"require_headers"`      // off, basic (From+Date), strict (+Message-ID)
+	RequireFCrDNS      FCrDNSPolicy         `toml:"require_fcrdns"`       // off, defer (450), reject (550) or signal unauthenticated clients without FCrDNS
+	ReturnPath         *bool                `toml:"return_path"`          // prepend Return-Path on local delivery (default true)
+	AuthUserHeader     bool                 `toml:"auth_user_header"`     // stamp X-Authenticated-User on local delivery of submitted mail
+	NoBounceRecipients []string             `toml:"no_bounce_recipients"` // addresses or "@domain" refusing MAIL FROM:<>
+	DenySenders        []string             `toml:"deny_senders"`         // MAIL FROM addresses or glob patterns refused with 550
+	AllowSenders       []string             `toml:"allow_senders"`        // exceptions to deny_senders
+	HoneypotDir        string               `toml:"honeypot_dir"`         // capture directory for honeypot listeners
+	RoleMailbox        string               `toml:"role_mailbox"`         // mailbox for role addresses with no user of their own
+	RoleRecipients     []string             `toml:"role_recipients"`      // role local parts; default postmaster, abuse
+	UnknownUserWarmup  string               `toml:"unknown_user_warmup"`  // after startup, unknown recipients get 451 rather than 550 for this long
+	ShutdownReport     string               `toml:"shutdown_report"`      // file the shutdown report is also written to
+	RecentRejections   int                  `toml:"recent_rejections"`    // rejections kept for the metrics server's /rejections; 0 = none
+	SendAs             map[string][]string  `toml:"send_as"`              // authenticated user → extra permitted sender addresses
+	CheckLocalFrom     bool                 `toml:"check_local_from"`     // From-header check on authenticated mail to local recipients too
+	RejectNullMX       bool                 `toml:"reject_null_mx"`       // refuse remote recipients whose domain publishes a null MX (RFC 7505)
+	CheckEncoding      bool                 `toml:"check_encoding"`       // authenticated mail: reject malformed Content-Transfer-Encoding
+	HeaderCharset      HeaderCharsetPolicy  `toml:"header_charset_policy"`
+	RejectDupHeaders   bool                 `toml:"reject_duplicate_headers"`
+	MaxHeaderRcpts     int                  `toml:"max_header_recipients"` // To+Cc addresses beyond which header_recipients is signalled; 0 = off
+	MaxReceived        int                  `toml:"max_received_headers"`  // newest Received fields kept on delivery, plus any DKIM-signed; 0 = all
 	Listeners          []ListenerConfig     `toml:"listeners"`
 	TLS                TLSConfig            `toml:"tls"`
 	Limits             LimitsConfig         `toml:"limits"`
 	Timeouts           TimeoutsConfig       `toml:"timeouts"`
+	Temp               TempConfig           `toml:"temp"`
 	Metrics            MetricsConfig        `toml:"metrics"`
 	SpamCheck          SpamCheckConfig      `toml:"spamcheck"`
 	Spamtrap           SpamtrapConfig       `toml:"spamtrap"`
+	State              StateConfig          `toml:"state"`
+	Reputation         ReputationConfig     `toml:"reputation"`
+	AuthRate           AuthRateConfig       `toml:"auth_rate"`
+	Auth               AuthConfig           `toml:"auth"`
+	Capture            CaptureConfig        `toml:"capture"`
+	DataPace           DataPaceConfig       `toml:"data_pace"`
+	DateCheck          DateCheckConfig      `toml:"date_check"`
+	FirstContact       FirstContactConfig   `toml:"first_contact"`
+	Signals            SignalsConfig        `toml:"signals"`
+	Webhook            WebhookConfig        `toml:"webhook"`
+	Domains            DomainsConfig        `toml:"domains"`
+	Users              UsersConfig          `toml:"users"`
 	Redis              RedisConfig          `toml:"-"` // populated from [redis] top-level section
 	SessionManager     SessionManagerConfig `toml:"-"` // populated from [session-manager] top-level section
 }
@@ -129,10 +233,600 @@ func (c *SpamtrapConfig) GetMaxLearnsPerIPPerHour() int {
 	return c.MaxLearnsPerIPPerHour
 }
 
+// StateConfig selects where defensive state (greylisting, rate limits,
+// deduplication, lockout, reputation) is kept.
+type StateConfig struct {
+	// Backend is "memory" (default) or "redis". The memory backend lives only
+	// as long as one protocol-handler subprocess, i.e. one connection; use
+	// "redis" to share state across connections and instances. The redis
+	// backend uses the shared [redis] connection.
+	Backend string `toml:"backend"`
+
+	// OnError is what the defensive features do when the store fails:
+	// fail_open (default) or fail_closed.
+	OnError StateErrorPolicy `toml:"on_error"`
+}
+
+// StateErrorPolicy selects how smtpd treats state store errors, for every
+// feature that keeps its state there.
+type StateErrorPolicy string
+
+const (
+	// StateFailOpen skips the check that could not reach the store and
+	// carries on, so mail flows unthrottled during an outage (default).
+	StateFailOpen StateErrorPolicy = "fail_open"
+	// StateFailClosed defers MAIL, RCPT, DATA and AUTH with 451 while the
+	// store is failing, so nothing bypasses the limits it holds.
+	StateFailClosed StateErrorPolicy = "fail_closed"
+)
+
+// GetBackend returns the configured state backend, defaulting to "memory".
+func (c *StateConfig) GetBackend() string {
+	if c.Backend == "" {
+		return "memory"
+	}
+	return c.Backend
+}
+
+// GetOnError returns the state error policy, defaulting to fail_open.
+func (c *StateConfig) GetOnError() StateErrorPolicy {
+	if c.OnError == StateFailClosed {
+		return StateFailClosed
+	}
+	return StateFailOpen
+}
+
+// ReputationConfig refuses connections from client IPs that recently had
+// many transactions rejected. Counts live in the state store, so they only
+// span connections with the redis state backend.
+type ReputationConfig struct {
+	// MaxRejections is the number of permanent rejections (5xx replies to
+	// MAIL, RCPT or DATA) within Window after which the IP is refused at
+	// connect time for Cooldown. 0 disables the check.
+	MaxRejections int    `toml:"max_rejections"`
+	Window        string `toml:"window"`   // default 1h
+	Cooldown      string `toml:"cooldown"` // default 1h
+	Message       string `toml:"message"`  // text of the 554 5.7.1 connect reply
+	// CountGreetings counts a connection going over [smtpd.limits]
+	// max_greetings as one rejection.
+	CountGreetings bool `toml:"count_greetings"`
+}
+
+// DefaultReputationMessage is the 554 reply text when reputation.message is unset.
+const DefaultReputationMessage = "Too many rejected transactions from your address"
+
+// GetWindow returns the counting window, defaulting to 1 hour.
+func (c *ReputationConfig) GetWindow() time.Duration {
+	return parseDurationOr(c.Window, time.Hour)
+}
+
+// GetCooldown returns how long an IP stays refused, defaulting to 1 hour.
+func (c *ReputationConfig) GetCooldown() time.Duration {
+	return parseDurationOr(c.Cooldown, time.Hour)
+}
+
+// GetMessage returns the connect rejection text.
+func (c *ReputationConfig) GetMessage() string {
+	if c.Message == "" {
+		return DefaultReputationMessage
+	}
+	return c.Message
+}
+
+// AuthRateConfig throttles AUTH attempts per client IP and per username,
+// whatever the SASL mechanism. It is separate from the session-manager's
+// account lockout: it slows guessing down rather than locking anyone out.
+type AuthRateConfig struct {
+	// MaxPerIP and MaxPerUser cap attempts within Window. 0 disables that
+	// limit; both 0 disables the throttle.
+	MaxPerIP   int    `toml:"max_per_ip"`
+	MaxPerUser int    `toml:"max_per_user"`
+	Window     string `toml:"window"` // default 10m
+	Delay      string `toml:"delay"`  // pause before a throttled 454 reply, default 1s
+}
+
+// GetWindow returns the counting window, defaulting to 10 minutes.
+func (c *AuthRateConfig) GetWindow() time.Duration {
+	return parseDurationOr(c.Window, 10*time.Minute)
+}
+
+// GetDelay returns the pause before a throttled reply, defaulting to 1 second.
+// "0s" disables the pause.
+func (c *AuthRateConfig) GetDelay() time.Duration {
+	if d, err := time.ParseDuration(c.Delay); err == nil && d >= 0 {
+		return d
+	}
+	return time.Second
+}
+
+// CaptureConfig records the whole protocol conversation of selected
+// connections, DATA included and AUTH credentials redacted, to one file per
+// session for reproducing bugs. Connections from Clients are always
+// captured; others with probability SampleRate.
+type CaptureConfig struct {
+	Dir        string   `toml:"dir"`         // capture directory; capture is off without it
+	Clients    []string `toml:"clients"`     // client IPs or CIDR prefixes to capture
+	SampleRate float64  `toml:"sample_rate"` // fraction of other connections, 0 to 1
+}
+
+// IsEnabled reports whether any connection can be captured.
+func (c *CaptureConfig) IsEnabled() bool {
+	return c.Dir != "" && (len(c.Clients) > 0 || c.SampleRate > 0)
+}
+
+// GetClients returns Clients as prefixes, a bare IP becoming a single-address
+// prefix. Entries that do not parse are skipped; Validate rejects them.
+func (c *CaptureConfig) GetClients() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, client := range c.Clients {
+		if p, err := parsePrefixOrAddr(client); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// parsePrefixOrAddr parses a CIDR prefix or a bare IP address.
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// AuthConfig holds limits on authenticated sessions.
+type AuthConfig struct {
+	// MaxSessionsPerUser caps the sessions one user may hold open at once
+	// after authenticating. Counts live in the state store, so they only
+	// span connections with the redis state backend. 0 disables the cap.
+	MaxSessionsPerUser int `toml:"max_sessions_per_user"`
+
+	// FailDelay pauses before the reply to failed credentials, plus a
+	// random extra of up to FailJitter, to slow password guessing without
+	// locking anyone out. Empty or "0s" disables the pause.
+	FailDelay  string `toml:"fail_delay"`
+	FailJitter string `toml:"fail_jitter"`
+
+	// MaxDistinctUsersPerIP caps how many different users one client IP may
+	// authenticate as within DistinctUsersWindow (default 1h), to catch
+	// credential stuffing that succeeds across accounts. 0 disables it.
+	MaxDistinctUsersPerIP int    `toml:"max_distinct_users_per_ip"`
+	DistinctUsersWindow   string `toml:"distinct_users_window"`
+}
+
+// GetFailDelay returns the minimum pause before a failed AUTH reply; 0 when unset.
+func (c *AuthConfig) GetFailDelay() time.Duration {
+	return parseDurationOr(c.FailDelay, 0)
+}
+
+// GetFailJitter returns the upper bound of the random extra pause; 0 when unset.
+func (c *AuthConfig) GetFailJitter() time.Duration {
+	return parseDurationOr(c.FailJitter, 0)
+}
+
+// GetDistinctUsersWindow returns the window for max_distinct_users_per_ip,
+// defaulting to one hour.
+func (c *AuthConfig) GetDistinctUsersWindow() time.Duration {
+	return parseDurationOr(c.DistinctUsersWindow, time.Hour)
+}
+
+// DataPaceAction selects what happens to a message transferred faster than
+// [smtpd.data_pace] allows.
+type DataPaceAction string
+
+const (
+	// DataPaceReputation accepts the message and counts a rejection against
+	// the client IP in [smtpd.reputation] (default).
+	DataPaceReputation DataPaceAction = "reputation"
+	// DataPaceDefer refuses the message with 451; a real MTA retries.
+	DataPaceDefer DataPaceAction = "defer"
+)
+
+// DataPaceConfig flags bots that dump a message and the terminating dot
+// faster than any real MTA transfers it. The minimum time between the 354
+// reply and the end of the data is the message size divided by
+// MaxBytesPerSecond, so small messages on a fast LAN are never suspect.
+type DataPaceConfig struct {
+	// MaxBytesPerSecond is the fastest plausible transfer rate. 0 disables
+	// the check.
+	MaxBytesPerSecond int            `toml:"max_bytes_per_second"`
+	MinSize           int            `toml:"min_size"` // smaller messages are exempt, default 8192
+	Action            DataPaceAction `toml:"action"`   // reputation (default) or defer
+}
+
+// IsEnabled reports whether the data pace check runs.
+func (c *DataPaceConfig) IsEnabled() bool {
+	return c.MaxBytesPerSecond > 0
+}
+
+// GetMinSize returns the size below which messages are exempt, default 8 KiB.
+func (c *DataPaceConfig) GetMinSize() int64 {
+	if c.MinSize > 0 {
+		return int64(c.MinSize)
+	}
+	return 8192
+}
+
+// GetAction returns the configured action, defaulting to reputation.
+func (c *DataPaceConfig) GetAction() DataPaceAction {
+	if c.Action == DataPaceDefer {
+		return DataPaceDefer
+	}
+	return DataPaceReputation
+}
+
+// DateCheckAction selects what happens to a message whose Date header is
+// out of range.
+type DateCheckAction string
+
+const (
+	// DateCheckReputation accepts the message, records the date_skew signal
+	// and counts a rejection against the client IP in [smtpd.reputation]
+	// (default).
+	DateCheckReputation DateCheckAction = "reputation"
+	// DateCheckReject refuses the message with 550.
+	DateCheckReject DateCheckAction = "reject"
+)
+
+// DateCheckConfig flags messages whose Date header lies too far in the
+// future or the past. Spam tools and broken senders stamp dates years off;
+// a real client's clock is rarely wrong by more than hours. A Date header
+// that is missing or cannot be parsed is left to require_headers.
+type DateCheckConfig struct {
+	// MaxFuture is how far ahead of the server's clock a Date may be, e.g.
+	// "24h". Empty = not checked.
+	MaxFuture string `toml:"max_future"`
+	// MaxPast is how far behind it a Date may be, e.g. "720h". Empty = not
+	// checked.
+	MaxPast string          `toml:"max_past"`
+	Action  DateCheckAction `toml:"action"` // reputation (default) or reject
+}
+
+// IsEnabled reports whether the date check runs.
+func (c *DateCheckConfig) IsEnabled() bool {
+	return c.GetMaxFuture() > 0 || c.GetMaxPast() > 0
+}
+
+// GetMaxFuture returns the allowed future skew; 0 = not checked.
+func (c *DateCheckConfig) GetMaxFuture() time.Duration {
+	return parseDurationOr(c.MaxFuture, 0)
+}
+
+// GetMaxPast returns the allowed age; 0 = not checked.
+func (c *DateCheckConfig) GetMaxPast() time.Duration {
+	return parseDurationOr(c.MaxPast, 0)
+}
+
+// GetAction returns the configured action, defaulting to reputation.
+func (c *DateCheckConfig) GetAction() DateCheckAction {
+	if c.Action == DateCheckReject {
+		return DateCheckReject
+	}
+	return DateCheckReputation
+}
+
+// FirstContactConfig sends a summary to an admin the first time a sender
+// domain delivers to a local recipient, so small sites notice targeted
+// phishing from lookalike domains. Domains seen are remembered in the state
+// store for Window, so they only span connections with the redis backend.
+type FirstContactConfig struct {
+	// Notify is the local address the summaries are delivered to. Empty
+	// disables the feature.
+	Notify string `toml:"notify"`
+	// Window is how long a domain counts as known after its first message,
+	// default "720h".
+	Window string `toml:"window"`
+}
+
+// IsEnabled reports whether first-contact summaries are sent.
+func (c *FirstContactConfig) IsEnabled() bool {
+	return c.Notify != ""
+}
+
+// GetWindow returns how long a sender domain stays known, default 30 days.
+func (c *FirstContactConfig) GetWindow() time.Duration {
+	return parseDurationOr(c.Window, 720*time.Hour)
+}
+
+// Weak signals counted by [smtpd.signals]. None refuses a message alone.
+const (
+	// SignalNoFCrDNS: the client has no forward-confirmed reverse DNS
+	// (require_fcrdns = "signal").
+	SignalNoFCrDNS = "no_fcrdns"
+	// SignalDataPace: the message arrived faster than [smtpd.data_pace]
+	// allows, under action "reputation".
+	SignalDataPace = "data_pace"
+	// SignalSpamScore: the spam score reached signals.spam_score, or the
+	// checker flagged the message when spam_score is 0.
+	SignalSpamScore = "spam_score"
+	// SignalHeaderCharset: a header had invalid characters under
+	// header_charset_policy "drop" or "replace".
+	SignalHeaderCharset = "header_charset"
+	// SignalHeaderRecipients: the To and Cc headers list more addresses
+	// than max_header_recipients.
+	SignalHeaderRecipients = "header_recipients"
+	// SignalDateSkew: the Date header is out of [smtpd.date_check] range,
+	// under action "reputation".
+	SignalDateSkew = "date_skew"
+)
+
+// SignalNames lists the known signal names.
+var SignalNames = []string{SignalNoFCrDNS, SignalDataPace, SignalSpamScore, SignalHeaderCharset, SignalHeaderRecipients, SignalDateSkew}
+
+// SignalsConfig rejects a message that trips several weak signals when no
+// single one of them refuses it.
+type SignalsConfig struct {
+	RejectWeight int            `toml:"reject_weight"` // reject at this total weight; 0 disables
+	SpamScore    float64        `toml:"spam_score"`    // spam score counted as the spam_score signal
+	Weights      map[string]int `toml:"weights"`       // signal name → weight, default 1
+}
+
+// IsEnabled reports whether combined signals can reject a message.
+func (c *SignalsConfig) IsEnabled() bool {
+	return c.RejectWeight > 0
+}
+
+// Weight returns the weight of signal name, default 1.
+func (c *SignalsConfig) Weight(name string) int {
+	if w, ok := c.Weights[name]; ok {
+		return w
+	}
+	return 1
+}
+
+// DomainConfig holds settings for one hosted domain, under
+// [smtpd.domains."example.com"].
+type DomainConfig struct {
+	// InboundRatePerMinute caps messages accepted for the domain per minute
+	// (451 beyond), so a flood aimed at one domain cannot use up the
+	// server for all of them. 0 = unlimited. Counted in the state store,
+	// so only across connections with the redis state backend.
+	InboundRatePerMinute int `toml:"inbound_rate_per_minute"`
+
+	// AcceptHours limits when mail for the domain is accepted, as
+	// "HH:MM-HH:MM" in TimeZone; a range may wrap past midnight, and equal
+	// ends mean the whole day. Recipients outside it get 451 at RCPT and
+	// the sender retries later. Empty = always, unless AcceptDays is set.
+	AcceptHours string `toml:"accept_hours"`
+	// AcceptDays limits AcceptHours to these weekdays ("mon" to "sun"); a
+	// window wrapping past midnight belongs to the day it starts on.
+	// Empty = every day.
+	AcceptDays []string `toml:"accept_days"`
+	// TimeZone is the IANA zone AcceptHours is given in, e.g.
+	// "Europe/Berlin". Empty = the server's local time.
+	TimeZone string `toml:"timezone"`
+
+	// DeliveryHeaders are stamped on local deliveries to the domain, after
+	// the global add_headers, with {hostname}, {queue_id}, {recipient} and
+	// {timestamp} substituted.
+	DeliveryHeaders map[string]string `toml:"delivery_headers"`
+
+	// SharedMailboxes route recipients whose local part matches a pattern
+	// to one mailbox that several users read, e.g. support@ and sales@ to a
+	// team mailbox. Unlike an alias, the message is delivered once, to the
+	// mailbox; the first matching entry wins.
+	SharedMailboxes []SharedMailbox `toml:"shared_mailboxes"`
+}
+
+// SharedMailbox is one [[smtpd.domains."example.com".shared_mailboxes]]
+// entry.
+type SharedMailbox struct {
+	// Pattern matches the recipient's local part, case-insensitively, in
+	// path.Match syntax: "support", "sales-*".
+	Pattern string `toml:"pattern"`
+	// Mailbox is the address delivered to instead.
+	Mailbox string `toml:"mailbox"`
+}
+
+// SharedMailbox returns the mailbox the local part local is routed to, or
+// "" when no shared_mailboxes pattern matches it.
+func (d DomainConfig) SharedMailbox(local string) string {
+	local = strings.ToLower(local)
+	for _, sm := range d.SharedMailboxes {
+		if ok, _ := path.Match(sm.Pattern, local); ok {
+			return sm.Mailbox
+		}
+	}
+	return ""
+}
+
+// AcceptWindow is a domain's parsed accept_hours, accept_days and timezone.
+type AcceptWindow struct {
+	start, end int     // minutes after midnight; end <= start wraps
+	days       [7]bool // indexed by time.Weekday
+	loc        *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// AcceptWindow parses the domain's acceptance window. It returns nil when
+// neither accept_hours nor accept_days is set.
+func (d DomainConfig) AcceptWindow() (*AcceptWindow, error) {
+	return parseWindow("accept", d.AcceptHours, d.AcceptDays, d.TimeZone)
+}
+
+// parseWindow parses a <kind>_hours, <kind>_days and timezone triple. It
+// returns nil when neither hours nor days is set.
+func parseWindow(kind, hours string, days []string, timeZone string) (*AcceptWindow, error) {
+	if hours == "" && len(days) == 0 {
+		return nil, nil
+	}
+	w := &AcceptWindow{loc: time.Local}
+	if hours != "" {
+		from, to, ok := strings.Cut(hours, "-")
+		var err error
+		if ok {
+			if w.start, err = parseClock(from); err == nil {
+				w.end, err = parseClock(to)
+			}
+		}
+		if !ok || err != nil || w.start == 24*60 {
+			return nil, fmt.Errorf("%s_hours %q must be HH:MM-HH:MM", kind, hours)
+		}
+	}
+	if len(days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, day := range days {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("%s_days: %q is not a weekday (mon to sun)", kind, day)
+		}
+		w.days[wd] = true
+	}
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		w.loc = loc
+	}
+	return w, nil
+}
+
+// parseClock parses "HH:MM" (00:00 to 24:00) into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		if strings.TrimSpace(s) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t falls inside the window.
+func (w *AcceptWindow) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && m >= w.start && m < w.end
+	}
+	// Wraps past midnight (or covers the whole day): the early hours
+	// belong to the previous day's window.
+	if m >= w.start {
+		return w.days[day]
+	}
+	return m < w.end && w.days[(day+6)%7]
+}
+
+// DomainsConfig maps lower-cased domain names, or "*" for every domain not
+// listed, to their settings.
+type DomainsConfig map[string]DomainConfig
+
+// Get returns the settings for domain, falling back to "*".
+func (c DomainsConfig) Get(domain string) DomainConfig {
+	if d, ok := c[strings.ToLower(domain)]; ok {
+		return d
+	}
+	return c["*"]
+}
+
+// UserConfig holds settings for one authenticated user, under
+// [smtpd.users."alice@example.com"].
+type UserConfig struct {
+	// SendHours limits when the user may send, as "HH:MM-HH:MM" in
+	// TimeZone, read like a domain's accept_hours; MAIL outside it gets
+	// 550. Meant for service accounts that only send in batch windows.
+	// Empty = always, unless SendDays is set.
+	SendHours string `toml:"send_hours"`
+	// SendDays limits SendHours to these weekdays ("mon" to "sun").
+	// Empty = every day.
+	SendDays []string `toml:"send_days"`
+	// TimeZone is the IANA zone SendHours is given in. Empty = the
+	// server's local time.
+	TimeZone string `toml:"timezone"`
+}
+
+// SendWindow parses the user's sending window. It returns nil when neither
+// send_hours nor send_days is set.
+func (u UserConfig) SendWindow() (*AcceptWindow, error) {
+	return parseWindow("send", u.SendHours, u.SendDays, u.TimeZone)
+}
+
+// UsersConfig maps lower-cased usernames, as given to AUTH, to their
+// settings.
+type UsersConfig map[string]UserConfig
+
+// WebhookEvents lists the message outcomes [smtpd.webhook] can report:
+// accepted (250 at the end of DATA), rejected (5xx) and deferred (4xx).
+var WebhookEvents = []string{"accepted", "rejected", "deferred"}
+
+// WebhookConfig posts a JSON event to an HTTP endpoint for each message
+// outcome at the end of DATA, for alerting or queue integration. Posting is
+// asynchronous and best-effort: events are dropped rather than holding up
+// the SMTP session.
+type WebhookConfig struct {
+	URL     string   `toml:"url"`     // http or https endpoint; "" disables
+	Events  []string `toml:"events"`  // subset of WebhookEvents; default all
+	Timeout string   `toml:"timeout"` // per request, default 5s
+}
+
+// IsEnabled reports whether events are posted.
+func (c *WebhookConfig) IsEnabled() bool {
+	return c.URL != ""
+}
+
+// GetEvents returns the events to post, defaulting to all of them.
+func (c *WebhookConfig) GetEvents() []string {
+	if len(c.Events) == 0 {
+		return WebhookEvents
+	}
+	return c.Events
+}
+
+// GetTimeout returns the per-request timeout, default 5s.
+func (c *WebhookConfig) GetTimeout() time.Duration {
+	return parseDurationOr(c.Timeout, 5*time.Second)
+}
+
+// parseDurationOr parses s, returning def if s is empty, invalid or not positive.
+func parseDurationOr(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
 // ListenerConfig defines settings for a single listener.
 type ListenerConfig struct {
 	Address string       `toml:"address"`
 	Mode    ListenerMode `toml:"mode"`
+	// TrustedProxy marks the listener as sitting behind a proxy that sends a
+	// PROXY protocol header. The header is required and its client address
+	// is trusted only on such listeners.
+	TrustedProxy bool `toml:"trusted_proxy"`
+	// TLSPolicy is off, optional (default) or required; see TLSPolicy.
+	// Implicit-TLS (smtps) listeners are always encrypted and take no
+	// policy but required.
+	TLSPolicy TLSPolicy `toml:"tls_policy"`
+	// Honeypot accepts every transaction and writes it to [smtpd]
+	// honeypot_dir instead of delivering it. For threat research only.
+	Honeypot bool `toml:"honeypot"`
+}
+
+// GetTLSPolicy returns the listener's STARTTLS policy, defaulting to
+// optional.
+func (l *ListenerConfig) GetTLSPolicy() TLSPolicy {
+	if l.TLSPolicy == "" {
+		return TLSPolicyOptional
+	}
+	return l.TLSPolicy
 }
 
 // TLSConfig holds TLS certificate and version settings.
@@ -140,6 +834,10 @@ type TLSConfig struct {
 	CertFile   string `toml:"cert_file"`
 	KeyFile    string `toml:"key_file"`
 	MinVersion string `toml:"min_version"`
+	// RejectALPN refuses TLS handshakes from clients that offer ALPN
+	// protocols but not "smtp", e.g. a browser sending h2 to port 465.
+	// Only read from [smtpd.tls]; clients that offer no ALPN are unaffected.
+	RejectALPN bool `toml:"reject_alpn"`
 }
 
 // LimitsConfig defines resource limits for the server.
@@ -147,6 +845,48 @@ type LimitsConfig struct {
 	MaxMessageSize  int `toml:"max_message_size"`
 	MaxRecipients   int `toml:"max_recipients"`
 	MaxSendsPerHour int `toml:"max_sends_per_hour"` // Per-sender rate limit for authenticated submission (0 = disabled)
+	MaxConnections  int `toml:"max_connections"`    // Concurrent connection cap (0 = unlimited)
+	MaxMIMEDepth    int `toml:"max_mime_depth"`     // Nested multipart/message levels (0 = unlimited)
+	MaxMIMEParts    int `toml:"max_mime_parts"`     // Body parts in one message (0 = unlimited)
+	// MaxConnShare is the fraction of max_connections one client network
+	// may hold at once (421 beyond), so a flood from a few addresses cannot
+	// starve everyone else; 0 = no per-network cap.
+	MaxConnShare float64 `toml:"max_connection_share"`
+	// ConnSharePrefix is the IPv4 prefix length that max_connection_share
+	// groups clients by (24 = per /24); 0 = 32, one address. IPv6 clients
+	// are always grouped by /64.
+	ConnSharePrefix int `toml:"connection_share_prefix"`
+	// MaxConcurrentData caps DATA transfers in progress server-wide (452
+	// beyond); 0 = unlimited. Needs the redis state backend to count
+	// across connections.
+	MaxConcurrentData int `toml:"max_concurrent_data"`
+	// MaxConnRecipients caps the recipients accepted on one connection
+	// across all its transactions (421 and close beyond); 0 = unlimited.
+	MaxConnRecipients int `toml:"max_connection_recipients"`
+	// MaxGreetings caps the HELO/EHLO commands on one connection; past it
+	// MAIL is refused with 503. The count starts over after STARTTLS, which
+	// requires a fresh EHLO. 0 = unlimited.
+	MaxGreetings int `toml:"max_greetings"`
+	// DeliveryChunkSize is the buffer a message is streamed to
+	// session-manager through, one gRPC message per chunk, so memory per
+	// delivery stays fixed whatever the message size. 0 = 64 KiB.
+	DeliveryChunkSize int `toml:"delivery_chunk_size"`
+}
+
+// Bounds for LimitsConfig.DeliveryChunkSize. The upper bound keeps a chunk
+// well under gRPC's default 4 MiB receive limit.
+const (
+	MinDeliveryChunkSize     = 4 * 1024
+	MaxDeliveryChunkSize     = 1024 * 1024
+	defaultDeliveryChunkSize = 64 * 1024
+)
+
+// GetDeliveryChunkSize returns the delivery chunk size, defaulting to 64 KiB.
+func (c *LimitsConfig) GetDeliveryChunkSize() int {
+	if c.DeliveryChunkSize <= 0 {
+		return defaultDeliveryChunkSize
+	}
+	return c.DeliveryChunkSize
 }
 
 // TimeoutsConfig defines timeout durations.
@@ -155,11 +895,57 @@ type TimeoutsConfig struct {
 	Command    string `toml:"command"`
 }
 
+// TempConfig controls where DATA buffers messages on disk, and the sweep
+// of buffers left behind by protocol-handlers that crashed.
+type TempConfig struct {
+	// Dir holds the message buffers, in one subdirectory per day so that
+	// no single directory grows without bound. "" uses the OS temp
+	// directory, without subdirectories.
+	Dir           string `toml:"dir"`
+	MaxAge        string `toml:"max_age"`        // buffers older than this are swept, default 24h
+	SweepInterval string `toml:"sweep_interval"` // between sweeps, default 1h
+}
+
+// GetMaxAge returns the age past which a buffer is swept, default 24h.
+func (c *TempConfig) GetMaxAge() time.Duration {
+	return parseDurationOr(c.MaxAge, 24*time.Hour)
+}
+
+// GetSweepInterval returns the time between sweeps, default 1h.
+func (c *TempConfig) GetSweepInterval() time.Duration {
+	return parseDurationOr(c.SweepInterval, time.Hour)
+}
+
 // MetricsConfig holds configuration for Prometheus metrics.
 type MetricsConfig struct {
 	Enabled bool   `toml:"enabled"`
 	Address string `toml:"address"`
 	Path    string `toml:"path"`
+
+	// ReadinessProbe makes /readyz open a loopback session to every
+	// listener and fail unless each greets, answers EHLO and offers its
+	// configured TLS (see smtp.ProbeListeners).
+	ReadinessProbe bool `toml:"readiness_probe"`
+
+	// PerUser counts accepted messages and bytes per authenticated sender
+	// in the state store, for "smtpd top-senders". The counts are kept out of
+	// Prometheus so a large user base cannot blow up label cardinality.
+	PerUser       bool   `toml:"per_user"`
+	PerUserTop    int    `toml:"per_user_top"`    // senders listed, default 20
+	PerUserWindow string `toml:"per_user_window"` // counting window, default 24h
+}
+
+// GetPerUserTop returns how many senders top-senders lists, defaulting to 20.
+func (c *MetricsConfig) GetPerUserTop() int {
+	if c.PerUserTop <= 0 {
+		return 20
+	}
+	return c.PerUserTop
+}
+
+// GetPerUserWindow returns the per-user counting window, defaulting to 24 hours.
+func (c *MetricsConfig) GetPerUserWindow() time.Duration {
+	return parseDurationOr(c.PerUserWindow, 24*time.Hour)
 }
 
 // DeliveryConfig holds configuration for message delivery.
@@ -182,6 +968,32 @@ const (
 	SpamCheckFailReject SpamCheckFailMode = "reject"
 )
 
+// SpamPrecheck selects the command at which the spam checkers are consulted
+// with the envelope alone, before the message body is accepted.
+type SpamPrecheck string
+
+const (
+	// SpamPrecheckOff checks only the complete message at DATA (default).
+	SpamPrecheckOff SpamPrecheck = "off"
+	// SpamPrecheckMail checks the client and sender at MAIL FROM.
+	SpamPrecheckMail SpamPrecheck = "mail"
+	// SpamPrecheckRcpt checks at each RCPT TO, before recipient validation.
+	SpamPrecheckRcpt SpamPrecheck = "rcpt"
+)
+
+// SpamAuthenticatedAction selects the reply to a spam reject verdict on mail
+// from an authenticated sender.
+type SpamAuthenticatedAction string
+
+const (
+	// SpamAuthenticatedReject refuses the message with 550, as for any
+	// other sender (default).
+	SpamAuthenticatedReject SpamAuthenticatedAction = "reject"
+	// SpamAuthenticatedTempFail defers the message with 451, so a false
+	// positive stays with the user's client instead of being bounced.
+	SpamAuthenticatedTempFail SpamAuthenticatedAction = "tempfail"
+)
+
 // SpamCheckConfig holds configuration for spam filtering.
 type SpamCheckConfig struct {
 	// Enabled indicates whether spam checking is enabled.
@@ -207,6 +1019,69 @@ type SpamCheckConfig struct {
 
 	// AddHeaders indicates whether to add spam headers to messages.
 	AddHeaders bool `toml:"add_headers"`
+
+	// TotalTimeout is the overall deadline for the whole spam-check phase of a
+	// message, including multi-checker aggregation (e.g., "30s"). When exceeded,
+	// FailMode applies. Empty or zero disables the overall deadline; individual
+	// checker timeouts still apply.
+	TotalTimeout string `toml:"total_timeout"`
+
+	// Precheck also runs the checkers before DATA, with the envelope and an
+	// empty body, so that mail from known-bad clients or senders is refused
+	// before its body is transferred. The full check at DATA still runs.
+	Precheck SpamPrecheck `toml:"precheck"`
+
+	// PrecheckThreshold is the precheck score at or above which the command
+	// is rejected (5xx). A checker's reject action always rejects.
+	PrecheckThreshold float64 `toml:"precheck_threshold"`
+
+	// GreylistRetry is the greylisting delay configured in the checker
+	// (rspamd's greylist timeout), quoted in the 451 reply to a greylisted
+	// message so cooperating senders retry once it has passed. Default 5m.
+	GreylistRetry string `toml:"greylist_retry"`
+
+	// AuthenticatedAction is the reply to a reject verdict, at DATA or in
+	// the precheck, when the sender has authenticated: "reject" (default)
+	// or "tempfail".
+	AuthenticatedAction SpamAuthenticatedAction `toml:"authenticated_action"`
+
+	// BypassSecret lets trusted systems skip the DATA spam check for one
+	// message by sending it in the BypassHeader header. Only clients in
+	// BypassClients (IPs or CIDRs) and users in BypassUsers may use it;
+	// the header is stripped from every message either way. Empty
+	// disables the bypass.
+	BypassSecret  string   `toml:"bypass_secret"`
+	BypassHeader  string   `toml:"bypass_header"` // default X-Spam-Bypass
+	BypassClients []string `toml:"bypass_clients"`
+	BypassUsers   []string `toml:"bypass_users"`
+}
+
+// DefaultSpamBypassHeader is the bypass header when bypass_header is unset.
+const DefaultSpamBypassHeader = "X-Spam-Bypass"
+
+// IsBypassEnabled reports whether any sender may use the bypass header.
+func (c *SpamCheckConfig) IsBypassEnabled() bool {
+	return c.BypassSecret != "" && (len(c.BypassClients) > 0 || len(c.BypassUsers) > 0)
+}
+
+// GetBypassHeader returns the bypass header name.
+func (c *SpamCheckConfig) GetBypassHeader() string {
+	if c.BypassHeader == "" {
+		return DefaultSpamBypassHeader
+	}
+	return c.BypassHeader
+}
+
+// GetBypassClients returns the parsed bypass_clients, skipping invalid
+// entries (Validate reports them).
+func (c *SpamCheckConfig) GetBypassClients() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, client := range c.BypassClients {
+		if p, err := parsePrefixOrAddr(client); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
 }
 
 // SpamCheckerConfig holds configuration for a single spam checker.
@@ -253,6 +1128,33 @@ func (c *SpamCheckConfig) GetFailMode() SpamCheckFailMode {
 	}
 }
 
+// GetAuthenticatedAction returns the authenticated action, defaulting to
+// reject if not set.
+func (c *SpamCheckConfig) GetAuthenticatedAction() SpamAuthenticatedAction {
+	if c.AuthenticatedAction == SpamAuthenticatedTempFail {
+		return SpamAuthenticatedTempFail
+	}
+	return SpamAuthenticatedReject
+}
+
+// GetTotalTimeout returns the overall spam-check deadline as a time.Duration.
+// Returns 0 (no overall deadline) if not configured or invalid.
+func (c *SpamCheckConfig) GetTotalTimeout() time.Duration {
+	if c.TotalTimeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.TotalTimeout)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// GetGreylistRetry returns the retry interval quoted to greylisted senders.
+func (c *SpamCheckConfig) GetGreylistRetry() time.Duration {
+	return parseDurationOr(c.GreylistRetry, 5*time.Minute)
+}
+
 // IsEnabled returns true if this checker is enabled.
 func (c *SpamCheckerConfig) IsEnabled() bool {
 	if c.Enabled == nil {
@@ -283,6 +1185,66 @@ func (c *Config) GetRejectionMode() RejectionMode {
 	}
 }
 
+// GetHeaderPolicy returns the configured header policy, defaulting to "off".
+func (c *Config) GetHeaderPolicy() HeaderPolicy {
+	switch c.RequireHeaders {
+	case HeaderPolicyBasic, HeaderPolicyStrict:
+		return c.RequireHeaders
+	default:
+		return HeaderPolicyOff
+	}
+}
+
+// GetHeaderCharsetPolicy returns the configured policy for invalid UTF-8 in
+// headers, defaulting to "off".
+func (c *Config) GetHeaderCharsetPolicy() HeaderCharsetPolicy {
+	switch c.HeaderCharset {
+	case HeaderCharsetReject, HeaderCharsetDrop, HeaderCharsetReplace:
+		return c.HeaderCharset
+	default:
+		return HeaderCharsetOff
+	}
+}
+
+// GetFCrDNSPolicy returns the configured policy for clients without
+// forward-confirmed reverse DNS, defaulting to "off".
+func (c *Config) GetFCrDNSPolicy() FCrDNSPolicy {
+	switch c.RequireFCrDNS {
+	case FCrDNSDefer, FCrDNSReject, FCrDNSSignal:
+		return c.RequireFCrDNS
+	default:
+		return FCrDNSOff
+	}
+}
+
+// DefaultRoleRecipients are the role local parts accepted on every hosted
+// domain once role_mailbox is set (RFC 5321 §4.5.1, RFC 2142).
+var DefaultRoleRecipients = []string{"postmaster", "abuse"}
+
+// GetRoleRecipients returns the role local parts routed to role_mailbox,
+// or nil when role_mailbox is not set.
+func (c *Config) GetRoleRecipients() []string {
+	if c.RoleMailbox == "" {
+		return nil
+	}
+	if len(c.RoleRecipients) == 0 {
+		return DefaultRoleRecipients
+	}
+	return c.RoleRecipients
+}
+
+// GetUnknownUserWarmup returns how long after startup unknown recipients
+// are deferred rather than refused; 0 = not at all.
+func (c *Config) GetUnknownUserWarmup() time.Duration {
+	return parseDurationOr(c.UnknownUserWarmup, 0)
+}
+
+// AddReturnPath reports whether local delivery prepends a Return-Path
+// header with the envelope sender, defaulting to true.
+func (c *Config) AddReturnPath() bool {
+	return c.ReturnPath == nil || *c.ReturnPath
+}
+
 // Default returns a Config with sensible default values.
 func Default() Config {
 	return Config{
@@ -327,6 +1289,21 @@ func (c *Config) Validate() error {
 		if !isValidMode(l.Mode) {
 			return fmt.Errorf("listener %d: invalid mode %q", i, l.Mode)
 		}
+		switch l.TLSPolicy {
+		case "", TLSPolicyOff, TLSPolicyOptional, TLSPolicyRequired:
+			// valid
+		default:
+			return fmt.Errorf("listener %d: invalid tls_policy %q (valid: off, optional, required)", i, l.TLSPolicy)
+		}
+		if l.Mode == ModeSmtps && l.TLSPolicy != "" && l.TLSPolicy != TLSPolicyRequired {
+			return fmt.Errorf("listener %d: smtps listeners are always TLS; tls_policy %q does not apply", i, l.TLSPolicy)
+		}
+		if l.GetTLSPolicy() == TLSPolicyRequired && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+			return fmt.Errorf("listener %d: tls_policy required needs tls cert_file and key_file", i)
+		}
+		if l.Honeypot && c.HoneypotDir == "" {
+			return fmt.Errorf("listener %d: honeypot needs honeypot_dir", i)
+		}
 	}
 
 	if c.Limits.MaxMessageSize <= 0 {
@@ -337,6 +1314,46 @@ func (c *Config) Validate() error {
 		return errors.New("max_recipients must be positive")
 	}
 
+	if c.Limits.MaxConnections < 0 {
+		return errors.New("max_connections must not be negative")
+	}
+
+	if c.Limits.MaxConnShare < 0 || c.Limits.MaxConnShare > 1 {
+		return errors.New("max_connection_share must be between 0 and 1")
+	}
+	if c.Limits.MaxConnShare > 0 && c.Limits.MaxConnections == 0 {
+		return errors.New("max_connection_share requires max_connections")
+	}
+	if c.Limits.ConnSharePrefix < 0 || c.Limits.ConnSharePrefix > 32 {
+		return errors.New("connection_share_prefix must be between 0 and 32")
+	}
+
+	if c.Limits.MaxConcurrentData < 0 {
+		return errors.New("max_concurrent_data must not be negative")
+	}
+	if c.Limits.MaxConnRecipients < 0 {
+		return errors.New("max_connection_recipients must not be negative")
+	}
+	if c.Limits.MaxGreetings < 0 {
+		return errors.New("max_greetings must not be negative")
+	}
+	if n := c.Limits.DeliveryChunkSize; n != 0 && (n < MinDeliveryChunkSize || n > MaxDeliveryChunkSize) {
+		return fmt.Errorf("delivery_chunk_size must be between %d and %d bytes", MinDeliveryChunkSize, MaxDeliveryChunkSize)
+	}
+
+	if c.Limits.MaxMIMEDepth < 0 || c.Limits.MaxMIMEParts < 0 {
+		return errors.New("max_mime_depth and max_mime_parts must not be negative")
+	}
+
+	for name, value := range c.AddHeaders {
+		if !isValidHeaderName(name) {
+			return fmt.Errorf("add_headers: invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("add_headers: value of %s must not contain line breaks", name)
+		}
+	}
+
 	if c.Timeouts.Connection != "" {
 		if _, err := time.ParseDuration(c.Timeouts.Connection); err != nil {
 			return fmt.Errorf("invalid connection timeout: %w", err)
@@ -363,6 +1380,14 @@ func (c *Config) Validate() error {
 			return errors.New("metrics path is required when metrics are enabled")
 		}
 	}
+	if c.Metrics.PerUserTop < 0 {
+		return errors.New("metrics.per_user_top must not be negative")
+	}
+	if c.Metrics.PerUserWindow != "" {
+		if d, err := time.ParseDuration(c.Metrics.PerUserWindow); err != nil || d <= 0 {
+			return fmt.Errorf("invalid metrics.per_user_window %q", c.Metrics.PerUserWindow)
+		}
+	}
 
 	// Validate recipient rejection mode
 	switch c.RecipientRejection {
@@ -372,6 +1397,143 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid recipient_rejection %q (valid: rcpt, data)", c.RecipientRejection)
 	}
 
+	for _, r := range c.NoBounceRecipients {
+		if i := strings.LastIndex(r, "@"); i < 0 || i == len(r)-1 {
+			return fmt.Errorf("no_bounce_recipients: %q must be an address or @domain", r)
+		}
+	}
+
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"deny_senders", c.DenySenders}, {"allow_senders", c.AllowSenders}} {
+		for _, p := range list.patterns {
+			if !strings.Contains(p, "@") {
+				return fmt.Errorf("%s: %q must be an address or pattern containing @", list.name, p)
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("%s: %q: %w", list.name, p, err)
+			}
+		}
+	}
+
+	for user, u := range c.Users {
+		if user == "" || user != strings.ToLower(user) {
+			return fmt.Errorf("users: %q must be a lower-case username", user)
+		}
+		if _, err := u.SendWindow(); err != nil {
+			return fmt.Errorf("users.%q: %w", user, err)
+		}
+	}
+
+	for domain, d := range c.Domains {
+		if domain != "*" && (domain == "" || strings.ContainsAny(domain, "@ ") || domain != strings.ToLower(domain)) {
+			return fmt.Errorf("domains: %q must be a lower-case domain name or \"*\"", domain)
+		}
+		if d.InboundRatePerMinute < 0 {
+			return fmt.Errorf("domains.%q: inbound_rate_per_minute must not be negative", domain)
+		}
+		if _, err := d.AcceptWindow(); err != nil {
+			return fmt.Errorf("domains.%q: %w", domain, err)
+		}
+		for name, value := range d.DeliveryHeaders {
+			if !isValidHeaderName(name) {
+				return fmt.Errorf("domains.%q: delivery_headers: invalid header name %q", domain, name)
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("domains.%q: delivery_headers: value of %s must not contain line breaks", domain, name)
+			}
+		}
+		for _, sm := range d.SharedMailboxes {
+			if sm.Pattern == "" || sm.Pattern != strings.ToLower(sm.Pattern) || strings.Contains(sm.Pattern, "@") {
+				return fmt.Errorf("domains.%q: shared_mailboxes: pattern %q must be a lower-case local part", domain, sm.Pattern)
+			}
+			if _, err := path.Match(sm.Pattern, ""); err != nil {
+				return fmt.Errorf("domains.%q: shared_mailboxes: pattern %q: %w", domain, sm.Pattern, err)
+			}
+			if i := strings.LastIndex(sm.Mailbox, "@"); i <= 0 || i == len(sm.Mailbox)-1 {
+				return fmt.Errorf("domains.%q: shared_mailboxes: mailbox %q must be an address", domain, sm.Mailbox)
+			}
+		}
+	}
+
+	for user, addrs := range c.SendAs {
+		if i := strings.LastIndex(user, "@"); i <= 0 || i == len(user)-1 {
+			return fmt.Errorf("send_as: %q must be an address", user)
+		}
+		for _, a := range addrs {
+			if i := strings.LastIndex(a, "@"); i <= 0 || i == len(a)-1 {
+				return fmt.Errorf("send_as %q: %q must be an address", user, a)
+			}
+		}
+	}
+
+	if n := c.FirstContact.Notify; n != "" {
+		if i := strings.LastIndex(n, "@"); i <= 0 || i == len(n)-1 {
+			return fmt.Errorf("first_contact.notify: %q must be an address", n)
+		}
+	}
+	if c.FirstContact.Window != "" {
+		if d, err := time.ParseDuration(c.FirstContact.Window); err != nil || d <= 0 {
+			return fmt.Errorf("invalid first_contact.window %q", c.FirstContact.Window)
+		}
+	}
+
+	if c.RecentRejections < 0 {
+		return errors.New("recent_rejections must not be negative")
+	}
+
+	if c.UnknownUserWarmup != "" {
+		if d, err := time.ParseDuration(c.UnknownUserWarmup); err != nil || d <= 0 {
+			return fmt.Errorf("invalid unknown_user_warmup %q", c.UnknownUserWarmup)
+		}
+	}
+
+	if c.RoleMailbox != "" {
+		if i := strings.LastIndex(c.RoleMailbox, "@"); i <= 0 || i == len(c.RoleMailbox)-1 {
+			return fmt.Errorf("role_mailbox: %q must be an address", c.RoleMailbox)
+		}
+	}
+	for _, r := range c.RoleRecipients {
+		if r == "" || strings.Contains(r, "@") {
+			return fmt.Errorf("role_recipients: %q must be a local part such as \"postmaster\"", r)
+		}
+	}
+
+	switch c.RequireHeaders {
+	case "", HeaderPolicyOff, HeaderPolicyBasic, HeaderPolicyStrict:
+		// valid
+	default:
+		return fmt.Errorf("invalid require_headers %q (valid: off, basic, strict)", c.RequireHeaders)
+	}
+
+	switch c.HeaderCharset {
+	case "", HeaderCharsetOff, HeaderCharsetReject, HeaderCharsetDrop, HeaderCharsetReplace:
+		// valid
+	default:
+		return fmt.Errorf("invalid header_charset_policy %q (valid: off, reject, drop, replace)", c.HeaderCharset)
+	}
+
+	switch c.RequireFCrDNS {
+	case "", FCrDNSOff, FCrDNSDefer, FCrDNSReject, FCrDNSSignal:
+		// valid
+	default:
+		return fmt.Errorf("invalid require_fcrdns %q (valid: off, defer, reject, signal)", c.RequireFCrDNS)
+	}
+	if c.RequireFCrDNS == FCrDNSSignal && !c.Signals.IsEnabled() {
+		return errors.New("require_fcrdns = \"signal\" requires signals.reject_weight")
+	}
+
+	if c.MaxHeaderRcpts < 0 {
+		return errors.New("max_header_recipients must not be negative")
+	}
+	if c.MaxReceived < 0 {
+		return errors.New("max_received_headers must not be negative")
+	}
+	if c.MaxHeaderRcpts > 0 && !c.Signals.IsEnabled() {
+		return errors.New("max_header_recipients requires signals.reject_weight")
+	}
+
 	// Validate spamtrap config
 	if c.Spamtrap.Enabled {
 		if c.Spamtrap.ControllerURL == "" {
@@ -379,7 +1541,179 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate state backend
+	switch c.State.Backend {
+	case "", "memory":
+		// valid
+	case "redis":
+		if c.Redis.URL == "" {
+			return errors.New("state.backend \"redis\" requires [redis] url")
+		}
+	default:
+		return fmt.Errorf("invalid state.backend %q (valid: memory, redis)", c.State.Backend)
+	}
+	switch c.State.OnError {
+	case "", StateFailOpen, StateFailClosed:
+	default:
+		return fmt.Errorf("invalid state.on_error %q (valid: fail_open, fail_closed)", c.State.OnError)
+	}
+
+	// Validate reputation config
+	if c.Reputation.MaxRejections < 0 {
+		return errors.New("reputation.max_rejections must not be negative")
+	}
+	if c.Reputation.Window != "" {
+		if d, err := time.ParseDuration(c.Reputation.Window); err != nil || d <= 0 {
+			return fmt.Errorf("invalid reputation.window %q", c.Reputation.Window)
+		}
+	}
+	if c.Reputation.Cooldown != "" {
+		if d, err := time.ParseDuration(c.Reputation.Cooldown); err != nil || d <= 0 {
+			return fmt.Errorf("invalid reputation.cooldown %q", c.Reputation.Cooldown)
+		}
+	}
+
+	// Validate auth rate config
+	if c.AuthRate.MaxPerIP < 0 || c.AuthRate.MaxPerUser < 0 {
+		return errors.New("auth_rate limits must not be negative")
+	}
+	if c.AuthRate.Window != "" {
+		if d, err := time.ParseDuration(c.AuthRate.Window); err != nil || d <= 0 {
+			return fmt.Errorf("invalid auth_rate.window %q", c.AuthRate.Window)
+		}
+	}
+	if c.AuthRate.Delay != "" {
+		if d, err := time.ParseDuration(c.AuthRate.Delay); err != nil || d < 0 {
+			return fmt.Errorf("invalid auth_rate.delay %q", c.AuthRate.Delay)
+		}
+	}
+
+	if c.Auth.MaxSessionsPerUser < 0 {
+		return errors.New("auth.max_sessions_per_user must not be negative")
+	}
+	if c.Auth.FailDelay != "" {
+		if d, err := time.ParseDuration(c.Auth.FailDelay); err != nil || d < 0 {
+			return fmt.Errorf("invalid auth.fail_delay %q", c.Auth.FailDelay)
+		}
+	}
+	if c.Auth.FailJitter != "" {
+		if d, err := time.ParseDuration(c.Auth.FailJitter); err != nil || d < 0 {
+			return fmt.Errorf("invalid auth.fail_jitter %q", c.Auth.FailJitter)
+		}
+	}
+	if c.Auth.MaxDistinctUsersPerIP < 0 {
+		return errors.New("auth.max_distinct_users_per_ip must not be negative")
+	}
+	if c.Auth.DistinctUsersWindow != "" {
+		if d, err := time.ParseDuration(c.Auth.DistinctUsersWindow); err != nil || d <= 0 {
+			return fmt.Errorf("invalid auth.distinct_users_window %q", c.Auth.DistinctUsersWindow)
+		}
+	}
+
+	// Validate data pace config
+	if c.DataPace.MaxBytesPerSecond < 0 || c.DataPace.MinSize < 0 {
+		return errors.New("data_pace limits must not be negative")
+	}
+	switch c.DataPace.Action {
+	case "", DataPaceReputation, DataPaceDefer:
+		// valid
+	default:
+		return fmt.Errorf("invalid data_pace.action %q (valid: reputation, defer)", c.DataPace.Action)
+	}
+	if c.DataPace.IsEnabled() && c.DataPace.GetAction() == DataPaceReputation && c.Reputation.MaxRejections <= 0 {
+		return errors.New("data_pace.action = \"reputation\" requires reputation.max_rejections")
+	}
+
+	// Validate date check config
+	if c.DateCheck.MaxFuture != "" {
+		if d, err := time.ParseDuration(c.DateCheck.MaxFuture); err != nil || d <= 0 {
+			return fmt.Errorf("invalid date_check.max_future %q", c.DateCheck.MaxFuture)
+		}
+	}
+	if c.DateCheck.MaxPast != "" {
+		if d, err := time.ParseDuration(c.DateCheck.MaxPast); err != nil || d <= 0 {
+			return fmt.Errorf("invalid date_check.max_past %q", c.DateCheck.MaxPast)
+		}
+	}
+	switch c.DateCheck.Action {
+	case "", DateCheckReputation, DateCheckReject:
+		// valid
+	default:
+		return fmt.Errorf("invalid date_check.action %q (valid: reputation, reject)", c.DateCheck.Action)
+	}
+	if c.DateCheck.IsEnabled() && c.DateCheck.GetAction() == DateCheckReputation && c.Reputation.MaxRejections <= 0 {
+		return errors.New("date_check.action = \"reputation\" requires reputation.max_rejections")
+	}
+
+	// Validate temp config
+	if c.Temp.MaxAge != "" {
+		if d, err := time.ParseDuration(c.Temp.MaxAge); err != nil || d <= 0 {
+			return fmt.Errorf("invalid temp.max_age %q", c.Temp.MaxAge)
+		}
+	}
+	if c.Temp.SweepInterval != "" {
+		if d, err := time.ParseDuration(c.Temp.SweepInterval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid temp.sweep_interval %q", c.Temp.SweepInterval)
+		}
+	}
+
+	// Validate combined signal config
+	if c.Signals.RejectWeight < 0 || c.Signals.SpamScore < 0 {
+		return errors.New("signals.reject_weight and signals.spam_score must not be negative")
+	}
+	for name, w := range c.Signals.Weights {
+		if !slices.Contains(SignalNames, name) {
+			return fmt.Errorf("unknown signal %q in signals.weights (valid: %s)", name, strings.Join(SignalNames, ", "))
+		}
+		if w < 0 {
+			return fmt.Errorf("signals.weights.%s must not be negative", name)
+		}
+	}
+
+	// Validate webhook config
+	if c.Webhook.URL != "" {
+		u, err := url.Parse(c.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook.url %q must be an http or https URL", c.Webhook.URL)
+		}
+	}
+	for _, e := range c.Webhook.Events {
+		if !slices.Contains(WebhookEvents, e) {
+			return fmt.Errorf("invalid webhook event %q (valid: %s)", e, strings.Join(WebhookEvents, ", "))
+		}
+	}
+	if c.Webhook.Timeout != "" {
+		if d, err := time.ParseDuration(c.Webhook.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid webhook.timeout %q", c.Webhook.Timeout)
+		}
+	}
+
+	// Validate capture config
+	if c.Capture.SampleRate < 0 || c.Capture.SampleRate > 1 {
+		return fmt.Errorf("capture.sample_rate %v must be between 0 and 1", c.Capture.SampleRate)
+	}
+	for _, client := range c.Capture.Clients {
+		if _, err := parsePrefixOrAddr(client); err != nil {
+			return fmt.Errorf("invalid capture.clients entry %q", client)
+		}
+	}
+	if c.Capture.Dir == "" && (len(c.Capture.Clients) > 0 || c.Capture.SampleRate > 0) {
+		return errors.New("capture needs capture.dir")
+	}
+
 	// Validate spamcheck config
+	for _, client := range c.SpamCheck.BypassClients {
+		if _, err := parsePrefixOrAddr(client); err != nil {
+			return fmt.Errorf("invalid spamcheck.bypass_clients entry %q", client)
+		}
+	}
+	if c.SpamCheck.BypassHeader != "" && !isValidHeaderName(c.SpamCheck.BypassHeader) {
+		return fmt.Errorf("invalid spamcheck.bypass_header %q", c.SpamCheck.BypassHeader)
+	}
+	if c.SpamCheck.BypassSecret == "" && (len(c.SpamCheck.BypassClients) > 0 || len(c.SpamCheck.BypassUsers) > 0) {
+		return errors.New("spamcheck.bypass_clients and bypass_users need spamcheck.bypass_secret")
+	}
+
 	if c.SpamCheck.Enabled {
 		for i, checker := range c.SpamCheck.Checkers {
 			if checker.Type == "" {
@@ -398,12 +1732,34 @@ func (c *Config) Validate() error {
 				}
 			}
 		}
+		if c.SpamCheck.TotalTimeout != "" {
+			if d, err := time.ParseDuration(c.SpamCheck.TotalTimeout); err != nil || d <= 0 {
+				return fmt.Errorf("invalid spamcheck.total_timeout %q", c.SpamCheck.TotalTimeout)
+			}
+		}
+		if c.SpamCheck.GreylistRetry != "" {
+			if d, err := time.ParseDuration(c.SpamCheck.GreylistRetry); err != nil || d <= 0 {
+				return fmt.Errorf("invalid spamcheck.greylist_retry %q", c.SpamCheck.GreylistRetry)
+			}
+		}
 		switch c.SpamCheck.FailMode {
 		case "", SpamCheckFailOpen, SpamCheckFailTempFail, SpamCheckFailReject:
 			// valid
 		default:
 			return fmt.Errorf("invalid spamcheck.fail_mode %q (valid: open, tempfail, reject)", c.SpamCheck.FailMode)
 		}
+		switch c.SpamCheck.Precheck {
+		case "", SpamPrecheckOff, SpamPrecheckMail, SpamPrecheckRcpt:
+			// valid
+		default:
+			return fmt.Errorf("invalid spamcheck.precheck %q (valid: off, mail, rcpt)", c.SpamCheck.Precheck)
+		}
+		switch c.SpamCheck.AuthenticatedAction {
+		case "", SpamAuthenticatedReject, SpamAuthenticatedTempFail:
+			// valid
+		default:
+			return fmt.Errorf("invalid spamcheck.authenticated_action %q (valid: reject, tempfail)", c.SpamCheck.AuthenticatedAction)
+		}
 	}
 
 	return nil
@@ -451,6 +1807,20 @@ var minTLSVersions = map[string]uint16{
 	"1.3": tls.VersionTLS13,
 }
 
+// isValidHeaderName reports whether name is an RFC 5322 field name:
+// printable US-ASCII other than colon.
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
 func isValidMode(m ListenerMode) bool {
 	switch m {
 	case ModeSmtp, ModeSubmission, ModeSmtps, ModeAlt:
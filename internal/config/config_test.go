@@ -97,6 +97,615 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "tls_policy required listener without certificate",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":25", Mode: ModeSmtp, TLSPolicy: TLSPolicyRequired}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls_policy required listener with certificate",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":25", Mode: ModeSmtp, TLSPolicy: TLSPolicyRequired}}
+				c.TLS.CertFile = "/etc/ssl/cert.pem"
+				c.TLS.KeyFile = "/etc/ssl/key.pem"
+			},
+			wantErr: false,
+		},
+		{
+			name: "tls_policy off",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":2525", Mode: ModeSmtp, TLSPolicy: TLSPolicyOff}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "tls_policy required without certificate",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":587", Mode: ModeSubmission, TLSPolicy: TLSPolicyRequired}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tls_policy",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":25", Mode: ModeSmtp, TLSPolicy: "sometimes"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "smtps with tls_policy off",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":465", Mode: ModeSmtps, TLSPolicy: TLSPolicyOff}}
+				c.TLS.CertFile = "/etc/ssl/cert.pem"
+				c.TLS.KeyFile = "/etc/ssl/key.pem"
+			},
+			wantErr: true,
+		},
+		{
+			name: "add_headers valid",
+			modify: func(c *Config) {
+				c.AddHeaders = map[string]string{"X-Virus-Scanned": "clamav on {hostname}"}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "add_headers name with colon",
+			modify:  func(c *Config) { c.AddHeaders = map[string]string{"X-Bad:": "v"} },
+			wantErr: true,
+		},
+		{
+			name:    "add_headers name with space",
+			modify:  func(c *Config) { c.AddHeaders = map[string]string{"X Bad": "v"} },
+			wantErr: true,
+		},
+		{
+			name:    "add_headers value with line break",
+			modify:  func(c *Config) { c.AddHeaders = map[string]string{"X-Org": "a\r\nBcc: victim@example.com"} },
+			wantErr: true,
+		},
+		{
+			name:    "require_headers strict",
+			modify:  func(c *Config) { c.RequireHeaders = HeaderPolicyStrict },
+			wantErr: false,
+		},
+		{
+			name:    "require_headers invalid",
+			modify:  func(c *Config) { c.RequireHeaders = "always" },
+			wantErr: true,
+		},
+		{
+			name:    "header_charset_policy replace",
+			modify:  func(c *Config) { c.HeaderCharset = HeaderCharsetReplace },
+			wantErr: false,
+		},
+		{
+			name:    "header_charset_policy invalid",
+			modify:  func(c *Config) { c.HeaderCharset = "latin1" },
+			wantErr: true,
+		},
+		{
+			name:    "require_fcrdns defer",
+			modify:  func(c *Config) { c.RequireFCrDNS = FCrDNSDefer },
+			wantErr: false,
+		},
+		{
+			name:    "require_fcrdns invalid",
+			modify:  func(c *Config) { c.RequireFCrDNS = "yes" },
+			wantErr: true,
+		},
+		{
+			name: "reputation valid",
+			modify: func(c *Config) {
+				c.Reputation = ReputationConfig{MaxRejections: 5, Window: "1h", Cooldown: "30m"}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "reputation negative max_rejections",
+			modify:  func(c *Config) { c.Reputation.MaxRejections = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "reputation invalid window",
+			modify:  func(c *Config) { c.Reputation.Window = "soon" },
+			wantErr: true,
+		},
+		{
+			name:    "reputation zero cooldown",
+			modify:  func(c *Config) { c.Reputation.Cooldown = "0s" },
+			wantErr: true,
+		},
+		{
+			name: "auth_rate valid",
+			modify: func(c *Config) {
+				c.AuthRate = AuthRateConfig{MaxPerIP: 20, MaxPerUser: 5, Window: "15m", Delay: "0s"}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "auth_rate negative max_per_user",
+			modify:  func(c *Config) { c.AuthRate.MaxPerUser = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "auth_rate zero window",
+			modify:  func(c *Config) { c.AuthRate.Window = "0s" },
+			wantErr: true,
+		},
+		{
+			name:    "auth_rate negative delay",
+			modify:  func(c *Config) { c.AuthRate.Delay = "-1s" },
+			wantErr: true,
+		},
+		{
+			name:    "negative max_mime_depth",
+			modify:  func(c *Config) { c.Limits.MaxMIMEDepth = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "no_bounce_recipients valid",
+			modify:  func(c *Config) { c.NoBounceRecipients = []string{"noreply@example.com", "@lists.example.com"} },
+			wantErr: false,
+		},
+		{
+			name:    "no_bounce_recipients without domain",
+			modify:  func(c *Config) { c.NoBounceRecipients = []string{"noreply"} },
+			wantErr: true,
+		},
+		{
+			name: "sender policy valid",
+			modify: func(c *Config) {
+				c.DenySenders = []string{"spam@example.net", "mailer-daemon*@*"}
+				c.AllowSenders = []string{"mailer-daemon@example.com"}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "deny_senders without @",
+			modify:  func(c *Config) { c.DenySenders = []string{"mailer-daemon*"} },
+			wantErr: true,
+		},
+		{
+			name:    "deny_senders bad pattern",
+			modify:  func(c *Config) { c.DenySenders = []string{"[a-@example.com"} },
+			wantErr: true,
+		},
+		{
+			name:    "allow_senders without @",
+			modify:  func(c *Config) { c.AllowSenders = []string{"postmaster"} },
+			wantErr: true,
+		},
+		{
+			name:    "temp invalid max_age",
+			modify:  func(c *Config) { c.Temp.MaxAge = "-1h" },
+			wantErr: true,
+		},
+		{
+			name:    "temp invalid sweep_interval",
+			modify:  func(c *Config) { c.Temp.SweepInterval = "hourly" },
+			wantErr: true,
+		},
+		{
+			name:    "metrics invalid per_user_window",
+			modify:  func(c *Config) { c.Metrics.PerUserWindow = "daily" },
+			wantErr: true,
+		},
+		{
+			name: "honeypot listener without honeypot_dir",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":2525", Mode: ModeSmtp, Honeypot: true}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "honeypot listener with honeypot_dir",
+			modify: func(c *Config) {
+				c.Listeners = []ListenerConfig{{Address: ":2525", Mode: ModeSmtp, Honeypot: true}}
+				c.HoneypotDir = "/var/lib/smtpd/honeypot"
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid spamcheck precheck",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.Precheck = "helo"
+			},
+			wantErr: true,
+		},
+		{
+			name: "spamcheck precheck at rcpt",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.Precheck = SpamPrecheckRcpt
+				c.SpamCheck.PrecheckThreshold = 20
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid spamcheck authenticated_action",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.AuthenticatedAction = "quarantine"
+			},
+			wantErr: true,
+		},
+		{
+			name: "spamcheck authenticated_action tempfail",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.AuthenticatedAction = SpamAuthenticatedTempFail
+			},
+			wantErr: false,
+		},
+		{
+			name: "spamcheck bypass valid",
+			modify: func(c *Config) {
+				c.SpamCheck.BypassSecret = "s3cret"
+				c.SpamCheck.BypassClients = []string{"10.0.0.0/8"}
+				c.SpamCheck.BypassUsers = []string{"notify@example.com"}
+			},
+			wantErr: false,
+		},
+		{
+			name: "spamcheck bypass clients without secret",
+			modify: func(c *Config) {
+				c.SpamCheck.BypassClients = []string{"10.0.0.0/8"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "spamcheck bypass invalid client",
+			modify: func(c *Config) {
+				c.SpamCheck.BypassSecret = "s3cret"
+				c.SpamCheck.BypassClients = []string{"10.0.0.0/33"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "spamcheck bypass invalid header",
+			modify: func(c *Config) {
+				c.SpamCheck.BypassHeader = "X Spam"
+			},
+			wantErr: true,
+		},
+		{
+			name: "send_as valid",
+			modify: func(c *Config) {
+				c.SendAs = map[string][]string{"alice@example.com": {"sales@example.com"}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "send_as alias without domain",
+			modify: func(c *Config) {
+				c.SendAs = map[string][]string{"alice@example.com": {"sales"}}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "negative auth max_sessions_per_user",
+			modify:  func(c *Config) { c.Auth.MaxSessionsPerUser = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "auth fail_delay valid",
+			modify:  func(c *Config) { c.Auth.FailDelay, c.Auth.FailJitter = "2s", "500ms" },
+			wantErr: false,
+		},
+		{
+			name:    "negative auth fail_delay",
+			modify:  func(c *Config) { c.Auth.FailDelay = "-1s" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid auth fail_jitter",
+			modify:  func(c *Config) { c.Auth.FailJitter = "soon" },
+			wantErr: true,
+		},
+		{
+			name:    "auth max_distinct_users_per_ip valid",
+			modify:  func(c *Config) { c.Auth.MaxDistinctUsersPerIP, c.Auth.DistinctUsersWindow = 5, "10m" },
+			wantErr: false,
+		},
+		{
+			name:    "negative auth max_distinct_users_per_ip",
+			modify:  func(c *Config) { c.Auth.MaxDistinctUsersPerIP = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "zero auth distinct_users_window",
+			modify:  func(c *Config) { c.Auth.DistinctUsersWindow = "0s" },
+			wantErr: true,
+		},
+		{
+			name:    "data_pace defer valid",
+			modify:  func(c *Config) { c.DataPace = DataPaceConfig{MaxBytesPerSecond: 1 << 20, Action: DataPaceDefer} },
+			wantErr: false,
+		},
+		{
+			name:    "data_pace reputation without reputation",
+			modify:  func(c *Config) { c.DataPace = DataPaceConfig{MaxBytesPerSecond: 1 << 20} },
+			wantErr: true,
+		},
+		{
+			name:    "invalid data_pace action",
+			modify:  func(c *Config) { c.DataPace.Action = "drop" },
+			wantErr: true,
+		},
+		{
+			name:    "date_check reject valid",
+			modify:  func(c *Config) { c.DateCheck = DateCheckConfig{MaxFuture: "24h", Action: DateCheckReject} },
+			wantErr: false,
+		},
+		{
+			name:    "date_check reputation without reputation",
+			modify:  func(c *Config) { c.DateCheck = DateCheckConfig{MaxPast: "720h"} },
+			wantErr: true,
+		},
+		{
+			name:    "invalid date_check max_future",
+			modify:  func(c *Config) { c.DateCheck = DateCheckConfig{MaxFuture: "1d", Action: DateCheckReject} },
+			wantErr: true,
+		},
+		{
+			name:    "invalid date_check action",
+			modify:  func(c *Config) { c.DateCheck.Action = "drop" },
+			wantErr: true,
+		},
+		{
+			name: "signals valid",
+			modify: func(c *Config) {
+				c.Signals = SignalsConfig{RejectWeight: 3, SpamScore: 4, Weights: map[string]int{SignalSpamScore: 2}}
+				c.RequireFCrDNS = FCrDNSSignal
+			},
+			wantErr: false,
+		},
+		{
+			name:    "require_fcrdns signal without signals",
+			modify:  func(c *Config) { c.RequireFCrDNS = FCrDNSSignal },
+			wantErr: true,
+		},
+		{
+			name:    "max_header_recipients with signals",
+			modify:  func(c *Config) { c.MaxHeaderRcpts = 50; c.Signals = SignalsConfig{RejectWeight: 2} },
+			wantErr: false,
+		},
+		{
+			name:    "max_header_recipients without signals",
+			modify:  func(c *Config) { c.MaxHeaderRcpts = 50 },
+			wantErr: true,
+		},
+		{
+			name:    "max_received_headers negative",
+			modify:  func(c *Config) { c.MaxReceived = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "max_header_recipients negative",
+			modify:  func(c *Config) { c.MaxHeaderRcpts = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "signals unknown weight",
+			modify:  func(c *Config) { c.Signals = SignalsConfig{RejectWeight: 2, Weights: map[string]int{"rbl": 1}} },
+			wantErr: true,
+		},
+		{
+			name:    "signals negative weight",
+			modify:  func(c *Config) { c.Signals = SignalsConfig{RejectWeight: 2, Weights: map[string]int{SignalDataPace: -1}} },
+			wantErr: true,
+		},
+		{
+			name:    "signals negative reject_weight",
+			modify:  func(c *Config) { c.Signals.RejectWeight = -1 },
+			wantErr: true,
+		},
+		{
+			name: "domains valid",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {InboundRatePerMinute: 100}, "*": {InboundRatePerMinute: 1000}}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "domains upper case",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"Example.com": {}} },
+			wantErr: true,
+		},
+		{
+			name:    "domains address",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"bob@example.com": {}} },
+			wantErr: true,
+		},
+		{
+			name:    "domains negative inbound rate",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"example.com": {InboundRatePerMinute: -1}} },
+			wantErr: true,
+		},
+		{
+			name: "domains accept window valid",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {AcceptHours: "22:00-06:00", AcceptDays: []string{"Mon", "fri"}, TimeZone: "UTC"}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "domains delivery_headers invalid name",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {DeliveryHeaders: map[string]string{"Bad Name": "x"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "domains delivery_headers line break",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {DeliveryHeaders: map[string]string{"X-Brand": "a\r\nBcc: evil@example.net"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "domains shared_mailboxes",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {SharedMailboxes: []SharedMailbox{{Pattern: "sales-*", Mailbox: "sales@example.com"}}}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "domains shared_mailboxes bad pattern",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {SharedMailboxes: []SharedMailbox{{Pattern: "sales-[", Mailbox: "sales@example.com"}}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "domains shared_mailboxes pattern with domain",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {SharedMailboxes: []SharedMailbox{{Pattern: "sales@example.com", Mailbox: "sales@example.com"}}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "domains shared_mailboxes mailbox not an address",
+			modify: func(c *Config) {
+				c.Domains = DomainsConfig{"example.com": {SharedMailboxes: []SharedMailbox{{Pattern: "support", Mailbox: "helpdesk"}}}}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "users send_hours",
+			modify:  func(c *Config) { c.Users = UsersConfig{"batch@example.com": {SendHours: "01:00-05:00"}} },
+			wantErr: false,
+		},
+		{
+			name:    "users send_hours invalid",
+			modify:  func(c *Config) { c.Users = UsersConfig{"batch@example.com": {SendHours: "1-5"}} },
+			wantErr: true,
+		},
+		{
+			name:    "users upper-case name",
+			modify:  func(c *Config) { c.Users = UsersConfig{"Batch@example.com": {SendHours: "01:00-05:00"}} },
+			wantErr: true,
+		},
+		{
+			name:    "domains accept_hours invalid",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"example.com": {AcceptHours: "9-17"}} },
+			wantErr: true,
+		},
+		{
+			name:    "domains accept_days invalid",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"example.com": {AcceptDays: []string{"weekday"}}} },
+			wantErr: true,
+		},
+		{
+			name:    "domains timezone invalid",
+			modify:  func(c *Config) { c.Domains = DomainsConfig{"example.com": {AcceptHours: "09:00-17:00", TimeZone: "Mars/Olympus"}} },
+			wantErr: true,
+		},
+		{
+			name: "webhook valid",
+			modify: func(c *Config) {
+				c.Webhook = WebhookConfig{URL: "https://hooks.example.com/smtpd", Events: []string{"rejected"}, Timeout: "2s"}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "webhook url not http",
+			modify:  func(c *Config) { c.Webhook.URL = "ftp://hooks.example.com/" },
+			wantErr: true,
+		},
+		{
+			name:    "webhook url without host",
+			modify:  func(c *Config) { c.Webhook.URL = "https:///smtpd" },
+			wantErr: true,
+		},
+		{
+			name: "invalid webhook event",
+			modify: func(c *Config) {
+				c.Webhook = WebhookConfig{URL: "https://hooks.example.com/", Events: []string{"bounced"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid webhook timeout",
+			modify: func(c *Config) {
+				c.Webhook = WebhookConfig{URL: "https://hooks.example.com/", Timeout: "0s"}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "negative data_pace rate",
+			modify:  func(c *Config) { c.DataPace.MaxBytesPerSecond = -1 },
+			wantErr: true,
+		},
+		{
+			name: "capture valid",
+			modify: func(c *Config) {
+				c.Capture = CaptureConfig{Dir: "/tmp/capture", Clients: []string{"192.0.2.10", "2001:db8::/32"}, SampleRate: 0.01}
+			},
+			wantErr: false,
+		},
+		{
+			name:    "capture without dir",
+			modify:  func(c *Config) { c.Capture.Clients = []string{"192.0.2.10"} },
+			wantErr: true,
+		},
+		{
+			name: "capture bad client",
+			modify: func(c *Config) {
+				c.Capture = CaptureConfig{Dir: "/tmp/capture", Clients: []string{"mail.example.com"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "capture sample_rate above 1",
+			modify: func(c *Config) {
+				c.Capture = CaptureConfig{Dir: "/tmp/capture", SampleRate: 1.5}
+			},
+			wantErr: true,
+		},
+		{
+			name:    "role_mailbox valid",
+			modify:  func(c *Config) { c.RoleMailbox = "hostmaster@example.com" },
+			wantErr: false,
+		},
+		{
+			name:    "role_mailbox without domain",
+			modify:  func(c *Config) { c.RoleMailbox = "hostmaster" },
+			wantErr: true,
+		},
+		{
+			name:    "role_recipients with address",
+			modify:  func(c *Config) { c.RoleRecipients = []string{"abuse@example.com"} },
+			wantErr: true,
+		},
+		{
+			name:    "first_contact.notify without domain",
+			modify:  func(c *Config) { c.FirstContact.Notify = "postmaster" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid first_contact.window",
+			modify:  func(c *Config) { c.FirstContact.Window = "a month" },
+			wantErr: true,
+		},
+		{
+			name:    "negative recent_rejections",
+			modify:  func(c *Config) { c.RecentRejections = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "valid unknown_user_warmup",
+			modify:  func(c *Config) { c.UnknownUserWarmup = "5m" },
+			wantErr: false,
+		},
+		{
+			name:    "zero unknown_user_warmup",
+			modify:  func(c *Config) { c.UnknownUserWarmup = "0s" },
+			wantErr: true,
+		},
 		{
 			name:    "zero max_message_size",
 			modify:  func(c *Config) { c.Limits.MaxMessageSize = 0 },
@@ -175,6 +784,123 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid spamcheck total_timeout",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.TotalTimeout = "soon"
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative spamcheck total_timeout",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.TotalTimeout = "-5s"
+			},
+			wantErr: true,
+		},
+		{
+			name: "zero spamcheck total_timeout",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.TotalTimeout = "0s"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid spamcheck greylist_retry",
+			modify: func(c *Config) {
+				c.SpamCheck.Enabled = true
+				c.SpamCheck.GreylistRetry = "0s"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "negative max_connections",
+			modify:  func(c *Config) { c.Limits.MaxConnections = -1 },
+			wantErr: true,
+		},
+		{
+			name: "max_connection_share",
+			modify: func(c *Config) {
+				c.Limits.MaxConnections = 100
+				c.Limits.MaxConnShare = 0.1
+				c.Limits.ConnSharePrefix = 24
+			},
+			wantErr: false,
+		},
+		{
+			name:    "max_connection_share without max_connections",
+			modify:  func(c *Config) { c.Limits.MaxConnShare = 0.1 },
+			wantErr: true,
+		},
+		{
+			name: "max_connection_share above 1",
+			modify: func(c *Config) {
+				c.Limits.MaxConnections = 100
+				c.Limits.MaxConnShare = 1.5
+			},
+			wantErr: true,
+		},
+		{
+			name:    "connection_share_prefix too long",
+			modify:  func(c *Config) { c.Limits.ConnSharePrefix = 33 },
+			wantErr: true,
+		},
+		{
+			name:    "negative max_concurrent_data",
+			modify:  func(c *Config) { c.Limits.MaxConcurrentData = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "negative max_connection_recipients",
+			modify:  func(c *Config) { c.Limits.MaxConnRecipients = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "negative max_greetings",
+			modify:  func(c *Config) { c.Limits.MaxGreetings = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "delivery_chunk_size valid",
+			modify:  func(c *Config) { c.Limits.DeliveryChunkSize = 256 * 1024 },
+			wantErr: false,
+		},
+		{
+			name:    "delivery_chunk_size too small",
+			modify:  func(c *Config) { c.Limits.DeliveryChunkSize = 512 },
+			wantErr: true,
+		},
+		{
+			name:    "delivery_chunk_size too large",
+			modify:  func(c *Config) { c.Limits.DeliveryChunkSize = 8 * 1024 * 1024 },
+			wantErr: true,
+		},
+		{
+			name:    "invalid state backend",
+			modify:  func(c *Config) { c.State.Backend = "etcd" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid state on_error",
+			modify:  func(c *Config) { c.State.OnError = "retry" },
+			wantErr: true,
+		},
+		{
+			name:    "redis state backend without redis url",
+			modify:  func(c *Config) { c.State.Backend = "redis" },
+			wantErr: true,
+		},
+		{
+			name: "redis state backend with redis url",
+			modify: func(c *Config) {
+				c.State.Backend = "redis"
+				c.Redis.URL = "redis://localhost:6379/1"
+			},
+			wantErr: false,
+		},
 		{
 			name: "metrics disabled allows empty address",
 			modify: func(c *Config) {
@@ -264,3 +990,78 @@ func TestCommandTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestSpamCheckTotalTimeout(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"30s", 30 * time.Second},
+		{"2m", 2 * time.Minute},
+		{"", 0},        // default: no overall deadline
+		{"invalid", 0}, // invalid falls back to default
+		{"-5s", 0},     // negative is treated as disabled
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cfg := SpamCheckConfig{TotalTimeout: tt.value}
+			if got := cfg.GetTotalTimeout(); got != tt.expected {
+				t.Errorf("GetTotalTimeout() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSpamCheckGreylistRetry(t *testing.T) {
+	if got := (&SpamCheckConfig{}).GetGreylistRetry(); got != 5*time.Minute {
+		t.Errorf("default GetGreylistRetry() = %v, want 5m", got)
+	}
+	if got := (&SpamCheckConfig{GreylistRetry: "90s"}).GetGreylistRetry(); got != 90*time.Second {
+		t.Errorf("GetGreylistRetry() = %v, want 90s", got)
+	}
+}
+
+func TestAcceptWindow(t *testing.T) {
+	// 2026-03-02 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		domain DomainConfig
+		at     time.Time
+		want   bool
+	}{
+		{"inside hours", DomainConfig{AcceptHours: "09:00-17:00", TimeZone: "UTC"}, at(2, 9, 0), true},
+		{"end is exclusive", DomainConfig{AcceptHours: "09:00-17:00", TimeZone: "UTC"}, at(2, 17, 0), false},
+		{"before hours", DomainConfig{AcceptHours: "09:00-17:00", TimeZone: "UTC"}, at(2, 8, 59), false},
+		{"until midnight", DomainConfig{AcceptHours: "18:00-24:00", TimeZone: "UTC"}, at(2, 23, 59), true},
+		{"wrap evening", DomainConfig{AcceptHours: "22:00-06:00", TimeZone: "UTC"}, at(2, 23, 0), true},
+		{"wrap early hours", DomainConfig{AcceptHours: "22:00-06:00", TimeZone: "UTC"}, at(3, 5, 59), true},
+		{"wrap midday", DomainConfig{AcceptHours: "22:00-06:00", TimeZone: "UTC"}, at(3, 12, 0), false},
+		{"weekday", DomainConfig{AcceptDays: []string{"mon", "tue"}, TimeZone: "UTC"}, at(3, 12, 0), true},
+		{"weekend", DomainConfig{AcceptDays: []string{"mon", "tue"}, TimeZone: "UTC"}, at(7, 12, 0), false},
+		// Friday night's window runs into Saturday morning.
+		{"wrap into next day", DomainConfig{AcceptHours: "22:00-06:00", AcceptDays: []string{"fri"}, TimeZone: "UTC"}, at(7, 2, 0), true},
+		{"wrap from unlisted day", DomainConfig{AcceptHours: "22:00-06:00", AcceptDays: []string{"fri"}, TimeZone: "UTC"}, at(6, 2, 0), false},
+		// 08:30 UTC is 09:30 in Berlin (CET, UTC+1).
+		{"timezone", DomainConfig{AcceptHours: "09:00-17:00", TimeZone: "Europe/Berlin"}, at(2, 8, 30), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := tt.domain.AcceptWindow()
+			if err != nil {
+				t.Fatalf("AcceptWindow() error = %v", err)
+			}
+			if got := w.Contains(tt.at); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.at, got, tt.want)
+			}
+		})
+	}
+
+	if w, err := (DomainConfig{InboundRatePerMinute: 10}).AcceptWindow(); w != nil || err != nil {
+		t.Errorf("AcceptWindow() without a window = %v, %v, want nil, nil", w, err)
+	}
+}
@@ -145,17 +145,30 @@ func mergeServerConfig(dst Config, src ServerConfig) Config {
 }
 
 // mergeConfig merges smtpd-specific values from [smtpd] into dst.
-// Global settings (hostname, domains_path, domains_data_path, TLS) come from
-// [server] via mergeServerConfig and are not read from [smtpd].
+// Global settings (hostname, domains_path, domains_data_path, TLS
+// certificates) come from [server] via mergeServerConfig and are not read
+// from [smtpd]; only the smtpd-specific [smtpd.tls] reject_alpn is.
 func mergeConfig(dst, src Config) Config {
 	if src.LogLevel != "" {
 		dst.LogLevel = src.LogLevel
 	}
 
+	if src.TLS.RejectALPN {
+		dst.TLS.RejectALPN = src.TLS.RejectALPN
+	}
+
 	if len(src.Listeners) > 0 {
 		dst.Listeners = src.Listeners
 	}
 
+	if src.BindBestEffort {
+		dst.BindBestEffort = src.BindBestEffort
+	}
+
+	if src.LogTransactions {
+		dst.LogTransactions = src.LogTransactions
+	}
+
 	if src.Limits.MaxMessageSize > 0 {
 		dst.Limits.MaxMessageSize = src.Limits.MaxMessageSize
 	}
@@ -164,6 +177,136 @@ func mergeConfig(dst, src Config) Config {
 		dst.Limits.MaxRecipients = src.Limits.MaxRecipients
 	}
 
+	if src.Limits.MaxConnections > 0 {
+		dst.Limits.MaxConnections = src.Limits.MaxConnections
+	}
+
+	if src.Limits.MaxConnShare > 0 {
+		dst.Limits.MaxConnShare = src.Limits.MaxConnShare
+	}
+
+	if src.Limits.ConnSharePrefix > 0 {
+		dst.Limits.ConnSharePrefix = src.Limits.ConnSharePrefix
+	}
+
+	if src.Limits.MaxConcurrentData > 0 {
+		dst.Limits.MaxConcurrentData = src.Limits.MaxConcurrentData
+	}
+
+	if src.Limits.MaxConnRecipients > 0 {
+		dst.Limits.MaxConnRecipients = src.Limits.MaxConnRecipients
+	}
+	if src.Limits.MaxGreetings > 0 {
+		dst.Limits.MaxGreetings = src.Limits.MaxGreetings
+	}
+	if src.Limits.DeliveryChunkSize > 0 {
+		dst.Limits.DeliveryChunkSize = src.Limits.DeliveryChunkSize
+	}
+
+	if src.Limits.MaxMIMEDepth > 0 {
+		dst.Limits.MaxMIMEDepth = src.Limits.MaxMIMEDepth
+	}
+
+	if src.Limits.MaxMIMEParts > 0 {
+		dst.Limits.MaxMIMEParts = src.Limits.MaxMIMEParts
+	}
+
+	if src.OverloadMessage != "" {
+		dst.OverloadMessage = src.OverloadMessage
+	}
+
+	if len(src.AddHeaders) > 0 {
+		dst.AddHeaders = src.AddHeaders
+	}
+
+	if src.RequireHeaders != "" {
+		dst.RequireHeaders = src.RequireHeaders
+	}
+
+	if src.HeaderCharset != "" {
+		dst.HeaderCharset = src.HeaderCharset
+	}
+
+	if src.RequireFCrDNS != "" {
+		dst.RequireFCrDNS = src.RequireFCrDNS
+	}
+
+	if src.MaxHeaderRcpts != 0 {
+		dst.MaxHeaderRcpts = src.MaxHeaderRcpts
+	}
+
+	if src.MaxReceived != 0 {
+		dst.MaxReceived = src.MaxReceived
+	}
+
+	if src.ReturnPath != nil {
+		dst.ReturnPath = src.ReturnPath
+	}
+
+	if len(src.NoBounceRecipients) > 0 {
+		dst.NoBounceRecipients = src.NoBounceRecipients
+	}
+
+	if len(src.DenySenders) > 0 {
+		dst.DenySenders = src.DenySenders
+	}
+
+	if len(src.AllowSenders) > 0 {
+		dst.AllowSenders = src.AllowSenders
+	}
+
+	if src.HoneypotDir != "" {
+		dst.HoneypotDir = src.HoneypotDir
+	}
+
+	if len(src.SendAs) > 0 {
+		dst.SendAs = src.SendAs
+	}
+
+	if src.CheckLocalFrom {
+		dst.CheckLocalFrom = src.CheckLocalFrom
+	}
+	if src.RejectNullMX {
+		dst.RejectNullMX = true
+	}
+
+	if len(src.Domains) > 0 {
+		dst.Domains = src.Domains
+	}
+
+	if len(src.Users) > 0 {
+		dst.Users = src.Users
+	}
+
+	if src.CheckEncoding {
+		dst.CheckEncoding = src.CheckEncoding
+	}
+	if src.AuthUserHeader {
+		dst.AuthUserHeader = src.AuthUserHeader
+	}
+	if src.RejectDupHeaders {
+		dst.RejectDupHeaders = src.RejectDupHeaders
+	}
+
+	if src.ShutdownReport != "" {
+		dst.ShutdownReport = src.ShutdownReport
+	}
+	if src.RecentRejections > 0 {
+		dst.RecentRejections = src.RecentRejections
+	}
+
+	if src.RoleMailbox != "" {
+		dst.RoleMailbox = src.RoleMailbox
+	}
+
+	if src.UnknownUserWarmup != "" {
+		dst.UnknownUserWarmup = src.UnknownUserWarmup
+	}
+
+	if len(src.RoleRecipients) > 0 {
+		dst.RoleRecipients = src.RoleRecipients
+	}
+
 	if src.Timeouts.Connection != "" {
 		dst.Timeouts.Connection = src.Timeouts.Connection
 	}
@@ -172,6 +315,16 @@ func mergeConfig(dst, src Config) Config {
 		dst.Timeouts.Command = src.Timeouts.Command
 	}
 
+	if src.Temp.Dir != "" {
+		dst.Temp.Dir = src.Temp.Dir
+	}
+	if src.Temp.MaxAge != "" {
+		dst.Temp.MaxAge = src.Temp.MaxAge
+	}
+	if src.Temp.SweepInterval != "" {
+		dst.Temp.SweepInterval = src.Temp.SweepInterval
+	}
+
 	// Metrics: enabled is explicitly set (boolean), so we merge if source has any non-zero value
 	if src.Metrics.Enabled {
 		dst.Metrics.Enabled = src.Metrics.Enabled
@@ -185,6 +338,138 @@ func mergeConfig(dst, src Config) Config {
 		dst.Metrics.Path = src.Metrics.Path
 	}
 
+	if src.Metrics.ReadinessProbe {
+		dst.Metrics.ReadinessProbe = src.Metrics.ReadinessProbe
+	}
+	if src.Metrics.PerUser {
+		dst.Metrics.PerUser = src.Metrics.PerUser
+	}
+
+	if src.Metrics.PerUserTop > 0 {
+		dst.Metrics.PerUserTop = src.Metrics.PerUserTop
+	}
+
+	if src.Metrics.PerUserWindow != "" {
+		dst.Metrics.PerUserWindow = src.Metrics.PerUserWindow
+	}
+
+	if src.State.Backend != "" {
+		dst.State.Backend = src.State.Backend
+	}
+	if src.State.OnError != "" {
+		dst.State.OnError = src.State.OnError
+	}
+
+	if src.Reputation.MaxRejections > 0 {
+		dst.Reputation.MaxRejections = src.Reputation.MaxRejections
+	}
+
+	if src.Reputation.Window != "" {
+		dst.Reputation.Window = src.Reputation.Window
+	}
+
+	if src.Reputation.Cooldown != "" {
+		dst.Reputation.Cooldown = src.Reputation.Cooldown
+	}
+
+	if src.Reputation.Message != "" {
+		dst.Reputation.Message = src.Reputation.Message
+	}
+	if src.Reputation.CountGreetings {
+		dst.Reputation.CountGreetings = true
+	}
+
+	if src.AuthRate.MaxPerIP > 0 {
+		dst.AuthRate.MaxPerIP = src.AuthRate.MaxPerIP
+	}
+
+	if src.AuthRate.MaxPerUser > 0 {
+		dst.AuthRate.MaxPerUser = src.AuthRate.MaxPerUser
+	}
+
+	if src.AuthRate.Window != "" {
+		dst.AuthRate.Window = src.AuthRate.Window
+	}
+
+	if src.AuthRate.Delay != "" {
+		dst.AuthRate.Delay = src.AuthRate.Delay
+	}
+
+	if src.Auth.MaxSessionsPerUser > 0 {
+		dst.Auth.MaxSessionsPerUser = src.Auth.MaxSessionsPerUser
+	}
+	if src.Auth.FailDelay != "" {
+		dst.Auth.FailDelay = src.Auth.FailDelay
+	}
+	if src.Auth.FailJitter != "" {
+		dst.Auth.FailJitter = src.Auth.FailJitter
+	}
+	if src.Auth.MaxDistinctUsersPerIP > 0 {
+		dst.Auth.MaxDistinctUsersPerIP = src.Auth.MaxDistinctUsersPerIP
+	}
+	if src.Auth.DistinctUsersWindow != "" {
+		dst.Auth.DistinctUsersWindow = src.Auth.DistinctUsersWindow
+	}
+
+	if src.DataPace.MaxBytesPerSecond > 0 {
+		dst.DataPace.MaxBytesPerSecond = src.DataPace.MaxBytesPerSecond
+	}
+	if src.DataPace.MinSize > 0 {
+		dst.DataPace.MinSize = src.DataPace.MinSize
+	}
+	if src.DataPace.Action != "" {
+		dst.DataPace.Action = src.DataPace.Action
+	}
+
+	if src.DateCheck.MaxFuture != "" {
+		dst.DateCheck.MaxFuture = src.DateCheck.MaxFuture
+	}
+	if src.DateCheck.MaxPast != "" {
+		dst.DateCheck.MaxPast = src.DateCheck.MaxPast
+	}
+	if src.DateCheck.Action != "" {
+		dst.DateCheck.Action = src.DateCheck.Action
+	}
+
+	if src.FirstContact.Notify != "" {
+		dst.FirstContact.Notify = src.FirstContact.Notify
+	}
+	if src.FirstContact.Window != "" {
+		dst.FirstContact.Window = src.FirstContact.Window
+	}
+
+	if src.Signals.RejectWeight > 0 {
+		dst.Signals.RejectWeight = src.Signals.RejectWeight
+	}
+	if src.Signals.SpamScore > 0 {
+		dst.Signals.SpamScore = src.Signals.SpamScore
+	}
+	if len(src.Signals.Weights) > 0 {
+		dst.Signals.Weights = src.Signals.Weights
+	}
+
+	if src.Webhook.URL != "" {
+		dst.Webhook.URL = src.Webhook.URL
+	}
+	if len(src.Webhook.Events) > 0 {
+		dst.Webhook.Events = src.Webhook.Events
+	}
+	if src.Webhook.Timeout != "" {
+		dst.Webhook.Timeout = src.Webhook.Timeout
+	}
+
+	if src.Capture.Dir != "" {
+		dst.Capture.Dir = src.Capture.Dir
+	}
+
+	if len(src.Capture.Clients) > 0 {
+		dst.Capture.Clients = src.Capture.Clients
+	}
+
+	if src.Capture.SampleRate > 0 {
+		dst.Capture.SampleRate = src.Capture.SampleRate
+	}
+
 	// Merge spamcheck config (if defined in [smtpd.spamcheck])
 	dst = mergeSpamCheckConfig(dst, src.SpamCheck)
 
@@ -245,5 +530,32 @@ func mergeSpamCheckConfig(dst Config, src SpamCheckConfig) Config {
 	if src.AddHeaders {
 		dst.SpamCheck.AddHeaders = src.AddHeaders
 	}
+	if src.TotalTimeout != "" {
+		dst.SpamCheck.TotalTimeout = src.TotalTimeout
+	}
+	if src.GreylistRetry != "" {
+		dst.SpamCheck.GreylistRetry = src.GreylistRetry
+	}
+	if src.Precheck != "" {
+		dst.SpamCheck.Precheck = src.Precheck
+	}
+	if src.PrecheckThreshold != 0 {
+		dst.SpamCheck.PrecheckThreshold = src.PrecheckThreshold
+	}
+	if src.AuthenticatedAction != "" {
+		dst.SpamCheck.AuthenticatedAction = src.AuthenticatedAction
+	}
+	if src.BypassSecret != "" {
+		dst.SpamCheck.BypassSecret = src.BypassSecret
+	}
+	if src.BypassHeader != "" {
+		dst.SpamCheck.BypassHeader = src.BypassHeader
+	}
+	if len(src.BypassClients) > 0 {
+		dst.SpamCheck.BypassClients = src.BypassClients
+	}
+	if len(src.BypassUsers) > 0 {
+		dst.SpamCheck.BypassUsers = src.BypassUsers
+	}
 	return dst
 }
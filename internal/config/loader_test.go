@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoadMissingFile(t *testing.T) {
@@ -529,6 +530,1080 @@ hostname = "mail.example.com"
 	}
 }
 
+func TestLoadBindBestEffort(t *testing.T) {
+	content := `
+[smtpd]
+bind_best_effort = true
+`
+
+	path := createTempConfig(t, content)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if !cfg.BindBestEffort {
+		t.Error("bind_best_effort = false, want true")
+	}
+
+	if Default().BindBestEffort {
+		t.Error("default bind_best_effort should be false (fail fast)")
+	}
+}
+
+func TestLoadStateBackend(t *testing.T) {
+	content := `
+[redis]
+url = "redis://localhost:6379/1"
+
+[smtpd.state]
+backend = "redis"
+on_error = "fail_closed"
+`
+
+	path := createTempConfig(t, content)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got := cfg.State.GetBackend(); got != "redis" {
+		t.Errorf("State.GetBackend() = %q, want %q", got, "redis")
+	}
+	if got := cfg.State.GetOnError(); got != StateFailClosed {
+		t.Errorf("State.GetOnError() = %q, want %q", got, StateFailClosed)
+	}
+
+	def := Default()
+	if got := def.State.GetBackend(); got != "memory" {
+		t.Errorf("default State.GetBackend() = %q, want %q", got, "memory")
+	}
+	if got := def.State.GetOnError(); got != StateFailOpen {
+		t.Errorf("default State.GetOnError() = %q, want %q", got, StateFailOpen)
+	}
+}
+
+func TestLoadTrustedProxyListener(t *testing.T) {
+	content := `
+[[smtpd.listeners]]
+address = ":25"
+mode = "smtp"
+
+[[smtpd.listeners]]
+address = ":10025"
+mode = "smtp"
+trusted_proxy = true
+`
+
+	path := createTempConfig(t, content)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Listeners) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(cfg.Listeners))
+	}
+	if cfg.Listeners[0].TrustedProxy {
+		t.Error("listener :25 should not trust PROXY headers by default")
+	}
+	if !cfg.Listeners[1].TrustedProxy {
+		t.Error("listener :10025 trusted_proxy = false, want true")
+	}
+}
+
+func TestLoadListenerTLSPolicy(t *testing.T) {
+	content := `
+[server.tls]
+cert_file = "/etc/ssl/cert.pem"
+key_file = "/etc/ssl/key.pem"
+
+[[smtpd.listeners]]
+address = ":25"
+mode = "smtp"
+
+[[smtpd.listeners]]
+address = ":2525"
+mode = "smtp"
+tls_policy = "required"
+
+[[smtpd.listeners]]
+address = ":587"
+mode = "submission"
+tls_policy = "required"
+
+[[smtpd.listeners]]
+address = ":10026"
+mode = "smtp"
+tls_policy = "off"
+`
+
+	path := createTempConfig(t, content)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Listeners) != 4 {
+		t.Fatalf("expected 4 listeners, got %d", len(cfg.Listeners))
+	}
+	if cfg.Listeners[0].TLSPolicy != "" {
+		t.Errorf("listener :25 tls_policy = %q, want unset", cfg.Listeners[0].TLSPolicy)
+	}
+	want := []TLSPolicy{TLSPolicyOptional, TLSPolicyRequired, TLSPolicyRequired, TLSPolicyOff}
+	for i, l := range cfg.Listeners {
+		if got := l.GetTLSPolicy(); got != want[i] {
+			t.Errorf("listener %s: GetTLSPolicy() = %q, want %q", l.Address, got, want[i])
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestLoadAddHeaders(t *testing.T) {
+	content := `
+[smtpd.add_headers]
+"X-Virus-Scanned" = "clamav on {hostname}"
+"X-Queue-ID" = "{queue_id}"
+`
+
+	path := createTempConfig(t, content)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.AddHeaders) != 2 {
+		t.Fatalf("expected 2 add_headers, got %v", cfg.AddHeaders)
+	}
+	if got := cfg.AddHeaders["X-Virus-Scanned"]; got != "clamav on {hostname}" {
+		t.Errorf("X-Virus-Scanned = %q", got)
+	}
+	if got := cfg.AddHeaders["X-Queue-ID"]; got != "{queue_id}" {
+		t.Errorf("X-Queue-ID = %q", got)
+	}
+}
+
+func TestLoadRequireHeaders(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+require_headers = "strict"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetHeaderPolicy(); got != HeaderPolicyStrict {
+		t.Errorf("GetHeaderPolicy() = %q, want %q", got, HeaderPolicyStrict)
+	}
+
+	// Unset defaults to off.
+	def := Default()
+	if got := def.GetHeaderPolicy(); got != HeaderPolicyOff {
+		t.Errorf("default GetHeaderPolicy() = %q, want %q", got, HeaderPolicyOff)
+	}
+}
+
+func TestLoadHeaderCharsetPolicy(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+header_charset_policy = "drop"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetHeaderCharsetPolicy(); got != HeaderCharsetDrop {
+		t.Errorf("GetHeaderCharsetPolicy() = %q, want %q", got, HeaderCharsetDrop)
+	}
+
+	def := Default()
+	if got := def.GetHeaderCharsetPolicy(); got != HeaderCharsetOff {
+		t.Errorf("default GetHeaderCharsetPolicy() = %q, want %q", got, HeaderCharsetOff)
+	}
+}
+
+func TestLoadMaxHeaderRecipients(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+max_header_recipients = 50
+
+[smtpd.signals]
+reject_weight = 2
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.MaxHeaderRcpts != 50 {
+		t.Errorf("MaxHeaderRcpts = %d, want 50", cfg.MaxHeaderRcpts)
+	}
+	if def := Default(); def.MaxHeaderRcpts != 0 {
+		t.Errorf("default MaxHeaderRcpts = %d, want 0", def.MaxHeaderRcpts)
+	}
+}
+
+func TestLoadMaxReceived(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+max_received_headers = 30
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.MaxReceived != 30 {
+		t.Errorf("MaxReceived = %d, want 30", cfg.MaxReceived)
+	}
+}
+
+func TestLoadRequireFCrDNS(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+require_fcrdns = "reject"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetFCrDNSPolicy(); got != FCrDNSReject {
+		t.Errorf("GetFCrDNSPolicy() = %q, want %q", got, FCrDNSReject)
+	}
+
+	def := Default()
+	if got := def.GetFCrDNSPolicy(); got != FCrDNSOff {
+		t.Errorf("default GetFCrDNSPolicy() = %q, want %q", got, FCrDNSOff)
+	}
+}
+
+func TestLoadReputation(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.reputation]
+max_rejections = 10
+window = "2h"
+cooldown = "6h"
+message = "Go away"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Reputation.MaxRejections != 10 {
+		t.Errorf("MaxRejections = %d, want 10", cfg.Reputation.MaxRejections)
+	}
+	if got := cfg.Reputation.GetWindow(); got != 2*time.Hour {
+		t.Errorf("GetWindow() = %v, want 2h", got)
+	}
+	if got := cfg.Reputation.GetCooldown(); got != 6*time.Hour {
+		t.Errorf("GetCooldown() = %v, want 6h", got)
+	}
+	if got := cfg.Reputation.GetMessage(); got != "Go away" {
+		t.Errorf("GetMessage() = %q, want %q", got, "Go away")
+	}
+
+	var def ReputationConfig
+	if def.GetWindow() != time.Hour || def.GetCooldown() != time.Hour || def.GetMessage() != DefaultReputationMessage {
+		t.Errorf("unexpected defaults: %v %v %q", def.GetWindow(), def.GetCooldown(), def.GetMessage())
+	}
+}
+
+func TestLoadReturnPath(t *testing.T) {
+	def := Default()
+	if !def.AddReturnPath() {
+		t.Error("AddReturnPath() should default to true")
+	}
+
+	path := createTempConfig(t, `
+[smtpd]
+return_path = false
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.AddReturnPath() {
+		t.Error("AddReturnPath() = true, want false when return_path = false")
+	}
+}
+
+func TestLoadAuthRate(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.auth_rate]
+max_per_ip = 30
+max_per_user = 5
+window = "15m"
+delay = "0s"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.AuthRate.MaxPerIP != 30 || cfg.AuthRate.MaxPerUser != 5 {
+		t.Errorf("limits = %d/%d, want 30/5", cfg.AuthRate.MaxPerIP, cfg.AuthRate.MaxPerUser)
+	}
+	if got := cfg.AuthRate.GetWindow(); got != 15*time.Minute {
+		t.Errorf("GetWindow() = %v, want 15m", got)
+	}
+	if got := cfg.AuthRate.GetDelay(); got != 0 {
+		t.Errorf("GetDelay() = %v, want 0", got)
+	}
+
+	var def AuthRateConfig
+	if def.GetWindow() != 10*time.Minute || def.GetDelay() != time.Second {
+		t.Errorf("unexpected defaults: %v %v", def.GetWindow(), def.GetDelay())
+	}
+}
+
+func TestLoadMIMELimits(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+max_mime_depth = 8
+max_mime_parts = 100
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.MaxMIMEDepth != 8 || cfg.Limits.MaxMIMEParts != 100 {
+		t.Errorf("MIME limits = %d/%d, want 8/100", cfg.Limits.MaxMIMEDepth, cfg.Limits.MaxMIMEParts)
+	}
+}
+
+func TestLoadMaxConcurrentData(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+max_concurrent_data = 20
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.MaxConcurrentData != 20 {
+		t.Errorf("MaxConcurrentData = %d, want 20", cfg.Limits.MaxConcurrentData)
+	}
+}
+
+func TestLoadMaxConnShare(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+max_connections = 200
+max_connection_share = 0.05
+connection_share_prefix = 24
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.MaxConnShare != 0.05 || cfg.Limits.ConnSharePrefix != 24 {
+		t.Errorf("share = %v per /%d, want 0.05 per /24", cfg.Limits.MaxConnShare, cfg.Limits.ConnSharePrefix)
+	}
+}
+
+func TestLoadMaxConnRecipients(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+max_connection_recipients = 500
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.MaxConnRecipients != 500 {
+		t.Errorf("MaxConnRecipients = %d, want 500", cfg.Limits.MaxConnRecipients)
+	}
+}
+
+func TestLoadMaxGreetings(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+max_greetings = 5
+
+[smtpd.reputation]
+count_greetings = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Limits.MaxGreetings != 5 {
+		t.Errorf("MaxGreetings = %d, want 5", cfg.Limits.MaxGreetings)
+	}
+	if !cfg.Reputation.CountGreetings {
+		t.Error("CountGreetings = false, want true")
+	}
+}
+
+func TestLoadDeliveryChunkSize(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.limits]
+delivery_chunk_size = 262144
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Limits.GetDeliveryChunkSize(); got != 256*1024 {
+		t.Errorf("GetDeliveryChunkSize() = %d, want 262144", got)
+	}
+	var def LimitsConfig
+	if got := def.GetDeliveryChunkSize(); got != 64*1024 {
+		t.Errorf("default GetDeliveryChunkSize() = %d, want 65536", got)
+	}
+}
+
+func TestLoadSenderPolicy(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+deny_senders = ["mailer-daemon*@*", "*@spam.example.net"]
+allow_senders = ["mailer-daemon@example.com"]
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.DenySenders) != 2 || cfg.DenySenders[1] != "*@spam.example.net" {
+		t.Errorf("DenySenders = %v", cfg.DenySenders)
+	}
+	if len(cfg.AllowSenders) != 1 || cfg.AllowSenders[0] != "mailer-daemon@example.com" {
+		t.Errorf("AllowSenders = %v", cfg.AllowSenders)
+	}
+}
+
+func TestLoadNoBounceRecipients(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+no_bounce_recipients = ["noreply@example.com", "@lists.example.com"]
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.NoBounceRecipients) != 2 || cfg.NoBounceRecipients[1] != "@lists.example.com" {
+		t.Errorf("NoBounceRecipients = %v", cfg.NoBounceRecipients)
+	}
+}
+
+func TestLoadMetricsReadinessProbe(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.metrics]
+readiness_probe = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Metrics.ReadinessProbe {
+		t.Error("ReadinessProbe = false, want true")
+	}
+}
+
+func TestLoadMetricsPerUser(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.metrics]
+per_user = true
+per_user_top = 50
+per_user_window = "1h"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Metrics.PerUser {
+		t.Error("PerUser = false, want true")
+	}
+	if got := cfg.Metrics.GetPerUserTop(); got != 50 {
+		t.Errorf("GetPerUserTop() = %d, want 50", got)
+	}
+	if got := cfg.Metrics.GetPerUserWindow(); got != time.Hour {
+		t.Errorf("GetPerUserWindow() = %v, want 1h", got)
+	}
+
+	var def MetricsConfig
+	if def.GetPerUserTop() != 20 || def.GetPerUserWindow() != 24*time.Hour {
+		t.Errorf("unexpected defaults: %d %v", def.GetPerUserTop(), def.GetPerUserWindow())
+	}
+}
+
+func TestLoadHoneypot(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+honeypot_dir = "/var/lib/smtpd/honeypot"
+
+[[smtpd.listeners]]
+address = ":2525"
+mode = "smtp"
+honeypot = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.HoneypotDir != "/var/lib/smtpd/honeypot" {
+		t.Errorf("HoneypotDir = %q", cfg.HoneypotDir)
+	}
+	if len(cfg.Listeners) != 1 || !cfg.Listeners[0].Honeypot {
+		t.Errorf("Listeners = %+v, want one honeypot listener", cfg.Listeners)
+	}
+}
+
+func TestLoadShutdownReport(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+shutdown_report = "/var/lib/smtpd/shutdown.json"
+recent_rejections = 100
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ShutdownReport != "/var/lib/smtpd/shutdown.json" {
+		t.Errorf("ShutdownReport = %q", cfg.ShutdownReport)
+	}
+	if cfg.RecentRejections != 100 {
+		t.Errorf("RecentRejections = %d, want 100", cfg.RecentRejections)
+	}
+}
+
+func TestLoadSpamPrecheck(t *testing.T) {
+	path := createTempConfig(t, `
+[spamcheck]
+precheck = "mail"
+precheck_threshold = 25.0
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.SpamCheck.Precheck != SpamPrecheckMail || cfg.SpamCheck.PrecheckThreshold != 25 {
+		t.Errorf("precheck = %q/%v, want mail/25", cfg.SpamCheck.Precheck, cfg.SpamCheck.PrecheckThreshold)
+	}
+}
+
+func TestLoadSpamAuthenticatedAction(t *testing.T) {
+	def := Default()
+	if got := def.SpamCheck.GetAuthenticatedAction(); got != SpamAuthenticatedReject {
+		t.Errorf("default GetAuthenticatedAction() = %q, want reject", got)
+	}
+
+	path := createTempConfig(t, `
+[spamcheck]
+authenticated_action = "tempfail"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.SpamCheck.GetAuthenticatedAction(); got != SpamAuthenticatedTempFail {
+		t.Errorf("GetAuthenticatedAction() = %q, want tempfail", got)
+	}
+}
+
+func TestLoadSpamBypass(t *testing.T) {
+	path := createTempConfig(t, `
+[spamcheck]
+bypass_secret = "s3cret"
+bypass_clients = ["10.1.2.3", "192.0.2.0/24"]
+bypass_users = ["notify@example.com"]
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.SpamCheck.IsBypassEnabled() {
+		t.Fatalf("bypass not enabled: %+v", cfg.SpamCheck)
+	}
+	if got := cfg.SpamCheck.GetBypassHeader(); got != DefaultSpamBypassHeader {
+		t.Errorf("GetBypassHeader() = %q, want %q", got, DefaultSpamBypassHeader)
+	}
+	clients := cfg.SpamCheck.GetBypassClients()
+	if len(clients) != 2 || clients[0].String() != "10.1.2.3/32" || clients[1].String() != "192.0.2.0/24" {
+		t.Errorf("GetBypassClients() = %v", clients)
+	}
+	if len(cfg.SpamCheck.BypassUsers) != 1 {
+		t.Errorf("BypassUsers = %v", cfg.SpamCheck.BypassUsers)
+	}
+}
+
+func TestLoadCapture(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.capture]
+dir = "/var/lib/smtpd/capture"
+clients = ["192.0.2.10", "2001:db8::/32"]
+sample_rate = 0.05
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Capture.IsEnabled() || cfg.Capture.Dir != "/var/lib/smtpd/capture" || cfg.Capture.SampleRate != 0.05 {
+		t.Errorf("Capture = %+v", cfg.Capture)
+	}
+	clients := cfg.Capture.GetClients()
+	if len(clients) != 2 || clients[0].String() != "192.0.2.10/32" || clients[1].String() != "2001:db8::/32" {
+		t.Errorf("GetClients() = %v", clients)
+	}
+}
+
+func TestLoadDataPace(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.reputation]
+max_rejections = 5
+
+[smtpd.data_pace]
+max_bytes_per_second = 1048576
+min_size = 4096
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.DataPace.IsEnabled() || cfg.DataPace.MaxBytesPerSecond != 1048576 {
+		t.Errorf("DataPace = %+v", cfg.DataPace)
+	}
+	if cfg.DataPace.GetMinSize() != 4096 {
+		t.Errorf("GetMinSize() = %d, want 4096", cfg.DataPace.GetMinSize())
+	}
+	if cfg.DataPace.GetAction() != DataPaceReputation {
+		t.Errorf("GetAction() = %q, want reputation", cfg.DataPace.GetAction())
+	}
+}
+
+func TestLoadDateCheck(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.date_check]
+max_future = "24h"
+max_past = "720h"
+action = "reject"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.DateCheck.GetMaxFuture() != 24*time.Hour || cfg.DateCheck.GetMaxPast() != 720*time.Hour {
+		t.Errorf("DateCheck = %+v", cfg.DateCheck)
+	}
+	if cfg.DateCheck.GetAction() != DateCheckReject {
+		t.Errorf("GetAction() = %q, want reject", cfg.DateCheck.GetAction())
+	}
+}
+
+func TestLoadFirstContact(t *testing.T) {
+	def := Default()
+	if def.FirstContact.IsEnabled() {
+		t.Error("first_contact enabled by default")
+	}
+	if got := def.FirstContact.GetWindow(); got != 720*time.Hour {
+		t.Errorf("default GetWindow() = %v, want 720h", got)
+	}
+
+	path := createTempConfig(t, `
+[smtpd.first_contact]
+notify = "postmaster@example.com"
+window = "168h"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.FirstContact.Notify != "postmaster@example.com" || cfg.FirstContact.GetWindow() != 168*time.Hour {
+		t.Errorf("FirstContact = %+v", cfg.FirstContact)
+	}
+}
+
+func TestLoadTemp(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.temp]
+dir = "/var/spool/smtpd/tmp"
+max_age = "6h"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Temp.Dir != "/var/spool/smtpd/tmp" {
+		t.Errorf("Temp.Dir = %q", cfg.Temp.Dir)
+	}
+	if got := cfg.Temp.GetMaxAge(); got != 6*time.Hour {
+		t.Errorf("GetMaxAge() = %v, want 6h", got)
+	}
+	if got := cfg.Temp.GetSweepInterval(); got != time.Hour {
+		t.Errorf("GetSweepInterval() = %v, want 1h", got)
+	}
+}
+
+func TestLoadSignals(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+require_fcrdns = "signal"
+
+[smtpd.signals]
+reject_weight = 3
+spam_score = 5.5
+
+[smtpd.signals.weights]
+spam_score = 2
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Signals.IsEnabled() || cfg.Signals.RejectWeight != 3 || cfg.Signals.SpamScore != 5.5 {
+		t.Errorf("Signals = %+v", cfg.Signals)
+	}
+	if got := cfg.Signals.Weight(SignalSpamScore); got != 2 {
+		t.Errorf("Weight(spam_score) = %d, want 2", got)
+	}
+	if got := cfg.Signals.Weight(SignalNoFCrDNS); got != 1 {
+		t.Errorf("Weight(no_fcrdns) = %d, want 1", got)
+	}
+	if got := cfg.GetFCrDNSPolicy(); got != FCrDNSSignal {
+		t.Errorf("GetFCrDNSPolicy() = %q, want signal", got)
+	}
+}
+
+func TestLoadWebhook(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.webhook]
+url = "https://hooks.example.com/smtpd"
+events = ["rejected", "deferred"]
+timeout = "2s"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.Webhook.IsEnabled() || cfg.Webhook.URL != "https://hooks.example.com/smtpd" {
+		t.Errorf("Webhook = %+v", cfg.Webhook)
+	}
+	if got := cfg.Webhook.GetEvents(); len(got) != 2 || got[0] != "rejected" {
+		t.Errorf("GetEvents() = %v, want [rejected deferred]", got)
+	}
+	if got := cfg.Webhook.GetTimeout(); got != 2*time.Second {
+		t.Errorf("GetTimeout() = %v, want 2s", got)
+	}
+}
+
+func TestLoadDomains(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.domains."example.com"]
+inbound_rate_per_minute = 50
+accept_hours = "08:00-18:00"
+accept_days = ["mon", "tue", "wed", "thu", "fri"]
+timezone = "UTC"
+
+[smtpd.domains."example.com".delivery_headers]
+"X-Brand" = "Example for {recipient}"
+
+[[smtpd.domains."example.com".shared_mailboxes]]
+pattern = "support"
+mailbox = "helpdesk@example.com"
+
+[[smtpd.domains."example.com".shared_mailboxes]]
+pattern = "sales-*"
+mailbox = "sales@example.com"
+
+[smtpd.domains."*"]
+inbound_rate_per_minute = 500
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	tests := []struct {
+		domain string
+		want   int
+	}{
+		{"example.com", 50},
+		{"EXAMPLE.COM", 50},
+		{"example.org", 500},
+	}
+	for _, tt := range tests {
+		if got := cfg.Domains.Get(tt.domain).InboundRatePerMinute; got != tt.want {
+			t.Errorf("Domains.Get(%q).InboundRatePerMinute = %d, want %d", tt.domain, got, tt.want)
+		}
+	}
+	if got := cfg.Domains.Get("example.com").AcceptHours; got != "08:00-18:00" {
+		t.Errorf("Domains.Get(example.com).AcceptHours = %q, want 08:00-18:00", got)
+	}
+	if got := cfg.Domains.Get("example.com").AcceptDays; len(got) != 5 {
+		t.Errorf("Domains.Get(example.com).AcceptDays = %v, want 5 days", got)
+	}
+	if got := cfg.Domains.Get("example.com").DeliveryHeaders["X-Brand"]; got != "Example for {recipient}" {
+		t.Errorf("Domains.Get(example.com).DeliveryHeaders[X-Brand] = %q", got)
+	}
+	for local, want := range map[string]string{"support": "helpdesk@example.com", "Sales-EU": "sales@example.com", "alice": ""} {
+		if got := cfg.Domains.Get("example.com").SharedMailbox(local); got != want {
+			t.Errorf("Domains.Get(example.com).SharedMailbox(%q) = %q, want %q", local, got, want)
+		}
+	}
+	var none DomainsConfig
+	if got := none.Get("example.com").InboundRatePerMinute; got != 0 {
+		t.Errorf("empty Domains: InboundRatePerMinute = %d, want 0", got)
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.users."batch@example.com"]
+send_hours = "01:00-05:00"
+send_days = ["mon", "fri"]
+timezone = "UTC"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	w, err := cfg.Users["batch@example.com"].SendWindow()
+	if err != nil || w == nil {
+		t.Fatalf("SendWindow() = %v, %v", w, err)
+	}
+	// 2026-03-02 is a Monday.
+	if !w.Contains(time.Date(2026, 3, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Error("window does not contain Monday 02:00")
+	}
+	if w.Contains(time.Date(2026, 3, 3, 2, 0, 0, 0, time.UTC)) {
+		t.Error("window contains Tuesday 02:00")
+	}
+}
+
+func TestWebhookDefaults(t *testing.T) {
+	var c WebhookConfig
+	if c.IsEnabled() {
+		t.Error("IsEnabled() = true without a url")
+	}
+	if got := c.GetEvents(); len(got) != len(WebhookEvents) {
+		t.Errorf("GetEvents() = %v, want all events", got)
+	}
+	if got := c.GetTimeout(); got != 5*time.Second {
+		t.Errorf("GetTimeout() = %v, want 5s", got)
+	}
+}
+
+func TestLoadAuthMaxSessions(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.auth]
+max_sessions_per_user = 3
+fail_delay = "2s"
+fail_jitter = "1s"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Auth.MaxSessionsPerUser != 3 {
+		t.Errorf("MaxSessionsPerUser = %d, want 3", cfg.Auth.MaxSessionsPerUser)
+	}
+	if cfg.Auth.GetFailDelay() != 2*time.Second || cfg.Auth.GetFailJitter() != time.Second {
+		t.Errorf("fail delay = %v + %v, want 2s + 1s", cfg.Auth.GetFailDelay(), cfg.Auth.GetFailJitter())
+	}
+}
+
+func TestLoadAuthDistinctUsers(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd.auth]
+max_distinct_users_per_ip = 4
+distinct_users_window = "15m"
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Auth.MaxDistinctUsersPerIP != 4 {
+		t.Errorf("MaxDistinctUsersPerIP = %d, want 4", cfg.Auth.MaxDistinctUsersPerIP)
+	}
+	if got := cfg.Auth.GetDistinctUsersWindow(); got != 15*time.Minute {
+		t.Errorf("GetDistinctUsersWindow() = %v, want 15m", got)
+	}
+	if got := (&AuthConfig{}).GetDistinctUsersWindow(); got != time.Hour {
+		t.Errorf("default GetDistinctUsersWindow() = %v, want 1h", got)
+	}
+}
+
+func TestLoadSendAs(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+check_local_from = true
+reject_null_mx = true
+
+[smtpd.send_as]
+"alice@example.com" = ["sales@example.com", "alice@example.org"]
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.CheckLocalFrom {
+		t.Error("CheckLocalFrom = false, want true")
+	}
+	if !cfg.RejectNullMX {
+		t.Error("RejectNullMX = false, want true")
+	}
+	if got := cfg.SendAs["alice@example.com"]; len(got) != 2 || got[0] != "sales@example.com" {
+		t.Errorf("SendAs = %v", cfg.SendAs)
+	}
+}
+
+func TestLoadCheckEncoding(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+check_encoding = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.CheckEncoding {
+		t.Error("CheckEncoding = false, want true")
+	}
+	if Default().CheckEncoding {
+		t.Error("CheckEncoding on by default")
+	}
+}
+
+func TestLoadAuthUserHeader(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+auth_user_header = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.AuthUserHeader {
+		t.Error("AuthUserHeader = false, want true")
+	}
+	if Default().AuthUserHeader {
+		t.Error("AuthUserHeader on by default")
+	}
+}
+
+func TestLoadRejectDuplicateHeaders(t *testing.T) {
+	path := createTempConfig(t, `
+[smtpd]
+reject_duplicate_headers = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.RejectDupHeaders {
+		t.Error("RejectDupHeaders = false, want true")
+	}
+	if Default().RejectDupHeaders {
+		t.Error("RejectDupHeaders on by default")
+	}
+}
+
+func TestLoadRejectALPN(t *testing.T) {
+	path := createTempConfig(t, `
+[server.tls]
+cert_file = "/etc/ssl/certs/mail.pem"
+key_file = "/etc/ssl/private/mail.key"
+
+[smtpd.tls]
+reject_alpn = true
+`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.TLS.RejectALPN {
+		t.Error("TLS.RejectALPN = false, want true")
+	}
+	if cfg.TLS.CertFile != "/etc/ssl/certs/mail.pem" {
+		t.Errorf("TLS.CertFile = %q, want [server.tls] value", cfg.TLS.CertFile)
+	}
+	if Default().TLS.RejectALPN {
+		t.Error("TLS.RejectALPN on by default")
+	}
+}
+
+func TestLoadRoleMailbox(t *testing.T) {
+	def := Default()
+	if got := def.GetRoleRecipients(); got != nil {
+		t.Errorf("GetRoleRecipients() = %v without role_mailbox, want nil", got)
+	}
+
+	path := createTempConfig(t, `
+[smtpd]
+role_mailbox = "hostmaster@example.com"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RoleMailbox != "hostmaster@example.com" {
+		t.Errorf("RoleMailbox = %q", cfg.RoleMailbox)
+	}
+	if got := cfg.GetRoleRecipients(); len(got) != 2 || got[0] != "postmaster" || got[1] != "abuse" {
+		t.Errorf("GetRoleRecipients() = %v, want [postmaster abuse]", got)
+	}
+}
+
+func TestLoadUnknownUserWarmup(t *testing.T) {
+	def := Default()
+	if got := def.GetUnknownUserWarmup(); got != 0 {
+		t.Errorf("GetUnknownUserWarmup() = %v by default, want 0", got)
+	}
+
+	path := createTempConfig(t, `
+[smtpd]
+unknown_user_warmup = "5m"
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetUnknownUserWarmup(); got != 5*time.Minute {
+		t.Errorf("GetUnknownUserWarmup() = %v, want 5m", got)
+	}
+}
+
 func createTempConfig(t *testing.T, content string) string {
 	t.Helper()
 	dir := t.TempDir()
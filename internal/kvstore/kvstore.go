@@ -0,0 +1,42 @@
+// Package kvstore provides keyed, expiring state shared by smtpd's defensive
+// features (greylisting, rate limiting, deduplication, lockout, reputation).
+//
+// Two backends are available: an in-memory store and a Redis-backed store.
+// smtpd runs one protocol-handler subprocess per connection, so the in-memory
+// store only lives as long as a single connection; deployments that need
+// state to persist across connections or instances should use Redis.
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotInteger is returned by Incr when the existing value is not an integer.
+var ErrNotInteger = errors.New("kvstore: value is not an integer")
+
+// Store is a keyed string store with per-key expiry.
+// A ttl of zero means the key does not expire.
+type Store interface {
+	// Get returns the value for key. ok is false if the key does not exist
+	// or has expired.
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+
+	// Set stores value under key, replacing any existing value and expiry.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// Incr atomically increments the integer stored under key and returns the
+	// new value. A missing key is treated as 0 and created with the given ttl;
+	// an existing key keeps its current expiry.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+	// IncrBy is Incr with an arbitrary delta.
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Enumerate returns all live keys beginning with prefix and their values.
+	Enumerate(ctx context.Context, prefix string) (map[string]string, error)
+}
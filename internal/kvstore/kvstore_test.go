@@ -0,0 +1,177 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// storeFactory returns a fresh Store and a function that advances the
+// store's notion of time, so TTL behaviour can be tested without sleeping.
+type storeFactory func(t *testing.T) (Store, func(time.Duration))
+
+// testStoreConformance runs the behaviour every Store backend must satisfy.
+func testStoreConformance(t *testing.T, newStore storeFactory) {
+	ctx := context.Background()
+
+	t.Run("get missing", func(t *testing.T) {
+		s, _ := newStore(t)
+		_, ok, err := s.Get(ctx, "missing")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if ok {
+			t.Error("expected missing key to report ok=false")
+		}
+	})
+
+	t.Run("set and get", func(t *testing.T) {
+		s, _ := newStore(t)
+		if err := s.Set(ctx, "k", "v1", 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := s.Set(ctx, "k", "v2", 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		v, ok, err := s.Get(ctx, "k")
+		if err != nil || !ok || v != "v2" {
+			t.Errorf("Get = %q, %v, %v; want v2, true, nil", v, ok, err)
+		}
+	})
+
+	t.Run("set ttl expires", func(t *testing.T) {
+		s, advance := newStore(t)
+		if err := s.Set(ctx, "k", "v", time.Minute); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		advance(30 * time.Second)
+		if _, ok, _ := s.Get(ctx, "k"); !ok {
+			t.Fatal("key expired before its TTL")
+		}
+		advance(31 * time.Second)
+		if _, ok, _ := s.Get(ctx, "k"); ok {
+			t.Error("key still present after its TTL")
+		}
+	})
+
+	t.Run("set zero ttl persists", func(t *testing.T) {
+		s, advance := newStore(t)
+		if err := s.Set(ctx, "k", "v", 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		advance(24 * time.Hour)
+		if _, ok, _ := s.Get(ctx, "k"); !ok {
+			t.Error("key with zero TTL expired")
+		}
+	})
+
+	t.Run("incr counts from one", func(t *testing.T) {
+		s, _ := newStore(t)
+		for want := int64(1); want <= 3; want++ {
+			n, err := s.Incr(ctx, "c", time.Hour)
+			if err != nil {
+				t.Fatalf("Incr: %v", err)
+			}
+			if n != want {
+				t.Errorf("Incr = %d, want %d", n, want)
+			}
+		}
+		v, _, _ := s.Get(ctx, "c")
+		if v != "3" {
+			t.Errorf("Get after Incr = %q, want 3", v)
+		}
+	})
+
+	t.Run("incr keeps original window", func(t *testing.T) {
+		s, advance := newStore(t)
+		if _, err := s.Incr(ctx, "c", time.Minute); err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		advance(45 * time.Second)
+		// A later Incr must not extend the window opened by the first.
+		if _, err := s.Incr(ctx, "c", time.Minute); err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		advance(20 * time.Second)
+		n, err := s.Incr(ctx, "c", time.Minute)
+		if err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("Incr after window = %d, want 1 (new window)", n)
+		}
+	})
+
+	t.Run("incrby adds delta", func(t *testing.T) {
+		s, advance := newStore(t)
+		if n, err := s.IncrBy(ctx, "b", 1500, time.Minute); err != nil || n != 1500 {
+			t.Fatalf("IncrBy = %d, %v; want 1500", n, err)
+		}
+		advance(45 * time.Second)
+		if n, err := s.IncrBy(ctx, "b", 500, time.Minute); err != nil || n != 2000 {
+			t.Fatalf("IncrBy = %d, %v; want 2000", n, err)
+		}
+		advance(20 * time.Second)
+		if n, _ := s.IncrBy(ctx, "b", 7, time.Minute); n != 7 {
+			t.Errorf("IncrBy after window = %d, want 7 (new window)", n)
+		}
+	})
+
+	t.Run("incr non-integer", func(t *testing.T) {
+		s, _ := newStore(t)
+		if err := s.Set(ctx, "k", "abc", 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if _, err := s.Incr(ctx, "k", 0); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("Incr error = %v, want ErrNotInteger", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s, _ := newStore(t)
+		_ = s.Set(ctx, "k", "v", 0)
+		if err := s.Delete(ctx, "k"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, ok, _ := s.Get(ctx, "k"); ok {
+			t.Error("key still present after Delete")
+		}
+		if err := s.Delete(ctx, "k"); err != nil {
+			t.Errorf("Delete of missing key: %v", err)
+		}
+	})
+
+	t.Run("enumerate by prefix", func(t *testing.T) {
+		s, advance := newStore(t)
+		_ = s.Set(ctx, "grey:a", "1", 0)
+		_ = s.Set(ctx, "grey:b", "2", 0)
+		_ = s.Set(ctx, "grey:old", "3", time.Second)
+		_ = s.Set(ctx, "rate:a", "4", 0)
+		_ = s.Set(ctx, "grey*:x", "5", 0)
+		advance(2 * time.Second)
+
+		got, err := s.Enumerate(ctx, "grey:")
+		if err != nil {
+			t.Fatalf("Enumerate: %v", err)
+		}
+		want := map[string]string{"grey:a": "1", "grey:b": "2"}
+		if len(got) != len(want) {
+			t.Fatalf("Enumerate = %v, want %v", got, want)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("Enumerate[%q] = %q, want %q", k, got[k], v)
+			}
+		}
+	})
+}
+
+func TestMemory_Conformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) (Store, func(time.Duration)) {
+		m := NewMemory()
+		now := time.Unix(1_700_000_000, 0)
+		m.now = func() time.Time { return now }
+		return m, func(d time.Duration) { now = now.Add(d) }
+	})
+}
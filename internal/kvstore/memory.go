@@ -0,0 +1,120 @@
+package kvstore
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store. Expired keys are removed lazily on access.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memEntry
+	now     func() time.Time
+}
+
+type memEntry struct {
+	value     string
+	expiresAt time.Time // zero = no expiry
+}
+
+// NewMemory creates an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]memEntry),
+		now:     time.Now,
+	}
+}
+
+// lookup returns the live entry for key, dropping it if expired.
+// Caller must hold m.mu.
+func (m *Memory) lookup(key string) (memEntry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return memEntry{}, false
+	}
+	if !e.expiresAt.IsZero() && !m.now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return memEntry{}, false
+	}
+	return e, true
+}
+
+func (m *Memory) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	return e.value, ok, nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memEntry{value: value, expiresAt: m.expiry(ttl)}
+	return nil
+}
+
+// Incr implements Store.
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return m.IncrBy(ctx, key, 1, ttl)
+}
+
+// IncrBy implements Store.
+func (m *Memory) IncrBy(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		m.entries[key] = memEntry{value: strconv.FormatInt(delta, 10), expiresAt: m.expiry(ttl)}
+		return delta, nil
+	}
+
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	n += delta
+	e.value = strconv.FormatInt(n, 10)
+	m.entries[key] = e
+	return n, nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// Enumerate implements Store.
+func (m *Memory) Enumerate(_ context.Context, prefix string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]string)
+	for key := range m.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if e, ok := m.lookup(key); ok {
+			out[key] = e.value
+		}
+	}
+	return out, nil
+}
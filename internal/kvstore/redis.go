@@ -0,0 +1,119 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Store backed by a shared Redis instance, so state is visible to
+// every subprocess and every smtpd instance using the same Redis database.
+// All keys are namespaced under a fixed prefix.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis creates a Redis-backed store. prefix namespaces all keys
+// (e.g. "smtpd:state:"). The client is shared and is not closed by the store.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+// incrScript increments a key and sets its expiry only when the key was just
+// created, so concurrent callers cannot extend an existing window.
+var incrScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+local n = redis.call("INCRBY", KEYS[1], ARGV[2])
+if existed == 0 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Get implements Store.
+func (r *Redis) Get(ctx context.Context, key string) (string, bool, error) {
+	v, err := r.client.Get(ctx, r.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+// Set implements Store.
+func (r *Redis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// Incr implements Store.
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return r.IncrBy(ctx, key, 1, ttl)
+}
+
+// IncrBy implements Store.
+func (r *Redis) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	n, err := incrScript.Run(ctx, r.client, []string{r.prefix + key}, ttl.Milliseconds(), delta).Int64()
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return 0, ErrNotInteger
+	}
+	return n, err
+}
+
+// Delete implements Store.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+// Enumerate implements Store. It walks the keyspace with SCAN, so it is
+// intended for administrative listing rather than per-message hot paths.
+func (r *Redis) Enumerate(ctx context.Context, prefix string) (map[string]string, error) {
+	out := make(map[string]string)
+	pattern := escapeGlob(r.prefix+prefix) + "*"
+
+	iter := r.client.Scan(ctx, 0, pattern, 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return out, nil
+	}
+
+	vals, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			continue // expired between SCAN and MGET
+		}
+		out[strings.TrimPrefix(keys[i], r.prefix)] = s
+	}
+	return out, nil
+}
+
+// escapeGlob escapes Redis MATCH metacharacters so prefix is matched literally.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
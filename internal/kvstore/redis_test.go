@@ -0,0 +1,32 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedis_Conformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) (Store, func(time.Duration)) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+		return NewRedis(client, "smtpd:state:"), mr.FastForward
+	})
+}
+
+func TestRedis_Namespaced(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() { _ = client.Close() }()
+
+	s := NewRedis(client, "smtpd:state:")
+	if err := s.Set(t.Context(), "k", "v", 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if !mr.Exists("smtpd:state:k") {
+		t.Error("expected key to be stored under the namespace prefix")
+	}
+}
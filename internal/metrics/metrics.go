@@ -11,10 +11,19 @@ type Collector interface {
 	ConnectionOpened()
 	ConnectionClosed()
 	TLSConnectionEstablished()
+	// reason should be "tls_version_too_low", "version", "cipher", "cert", or "other"
+	TLSHandshakeFailed(reason string)
 
 	// Message metrics (recipient domain first)
 	MessageReceived(recipientDomain string, sizeBytes int64)
 	MessageRejected(recipientDomain string, reason string)
+	// TransactionAborted counts transactions cut off before the message was
+	// complete; reason is "data_timeout" or "reset" (RSET or a new EHLO
+	// after MAIL, before DATA).
+	TransactionAborted(reason string)
+	// SizeMismatch counts messages larger than the SIZE their client
+	// declared in MAIL FROM.
+	SizeMismatch()
 
 	// Authentication metrics (authenticated user's domain)
 	AuthAttempt(authDomain string, success bool)
@@ -31,6 +40,10 @@ type Collector interface {
 	DKIMCheckCompleted(senderDomain string, result string)
 	DMARCCheckCompleted(senderDomain string, result string)
 	RBLHit(listName string) // IP-based, no domain
+	// FilterDecision counts the outcome of one filter stage (e.g.
+	// "fcrdns", "spamcheck", "content"); action is "accept", "defer" or
+	// "reject".
+	FilterDecision(stage string, action string)
 
 	// Rspamd metrics
 	// result should be "ham", "spam", "soft_reject", "greylist", or "error"
@@ -20,8 +20,11 @@ func TestNoopCollectorMethods(t *testing.T) {
 	c.ConnectionOpened()
 	c.ConnectionClosed()
 	c.TLSConnectionEstablished()
+	c.TLSHandshakeFailed("version")
 	c.MessageReceived("example.com", 1024)
 	c.MessageRejected("example.com", "spam")
+	c.TransactionAborted("data_timeout")
+	c.SizeMismatch()
 	c.AuthAttempt("example.com", true)
 	c.AuthAttempt("example.com", false)
 	c.CommandProcessed("EHLO")
@@ -32,6 +35,7 @@ func TestNoopCollectorMethods(t *testing.T) {
 	c.DKIMCheckCompleted("sender.com", "fail")
 	c.DMARCCheckCompleted("sender.com", "none")
 	c.RBLHit("spamhaus.org")
+	c.FilterDecision("fcrdns", "reject")
 }
 
 func TestNoopServerStart(t *testing.T) {
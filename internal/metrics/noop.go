@@ -13,6 +13,15 @@ func (n *NoopCollector) ConnectionClosed() {}
 // TLSConnectionEstablished is a no-op.
 func (n *NoopCollector) TLSConnectionEstablished() {}
 
+// TLSHandshakeFailed is a no-op.
+func (n *NoopCollector) TLSHandshakeFailed(reason string) {}
+
+// TransactionAborted is a no-op.
+func (n *NoopCollector) TransactionAborted(reason string) {}
+
+// SizeMismatch is a no-op.
+func (n *NoopCollector) SizeMismatch() {}
+
 // MessageReceived is a no-op.
 func (n *NoopCollector) MessageReceived(recipientDomain string, sizeBytes int64) {}
 
@@ -40,5 +49,8 @@ func (n *NoopCollector) DMARCCheckCompleted(senderDomain string, result string)
 // RBLHit is a no-op.
 func (n *NoopCollector) RBLHit(listName string) {}
 
+// FilterDecision is a no-op.
+func (n *NoopCollector) FilterDecision(stage string, action string) {}
+
 // RspamdCheckCompleted is a no-op.
 func (n *NoopCollector) RspamdCheckCompleted(senderDomain string, result string, score float64) {}
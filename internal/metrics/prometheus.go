@@ -10,11 +10,14 @@ type PrometheusCollector struct {
 	connectionsTotal   prometheus.Counter
 	connectionsActive  prometheus.Gauge
 	tlsConnectionTotal prometheus.Counter
+	tlsHandshakeFailed *prometheus.CounterVec
 
 	// Message metrics
 	messagesReceivedTotal *prometheus.CounterVec
 	messagesRejectedTotal *prometheus.CounterVec
 	messagesSizeBytes     prometheus.Histogram
+	transactionsAborted   *prometheus.CounterVec
+	sizeMismatches        prometheus.Counter
 
 	// Authentication metrics
 	authAttemptsTotal *prometheus.CounterVec
@@ -30,6 +33,7 @@ type PrometheusCollector struct {
 	dkimChecksTotal  *prometheus.CounterVec
 	dmarcChecksTotal *prometheus.CounterVec
 	rblHitsTotal     *prometheus.CounterVec
+	filterDecisions  *prometheus.CounterVec
 
 	// Rspamd metrics
 	rspamdChecksTotal *prometheus.CounterVec
@@ -51,6 +55,20 @@ func NewPrometheusCollector(reg prometheus.Registerer) *PrometheusCollector {
 			Name: "smtpd_tls_connections_total",
 			Help: "Total number of TLS connections established.",
 		}),
+		tlsHandshakeFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smtpd_tls_handshake_failures_total",
+			Help: "Total number of failed TLS handshakes (STARTTLS and implicit TLS).",
+		}, []string{"reason"}),
+
+		transactionsAborted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smtpd_transactions_aborted_total",
+			Help: "Total number of transactions aborted before the message was complete.",
+		}, []string{"reason"}),
+
+		sizeMismatches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "smtpd_size_mismatch_total",
+			Help: "Total number of messages larger than the SIZE declared in MAIL FROM.",
+		}),
 
 		messagesReceivedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "smtpd_messages_received_total",
@@ -97,6 +115,10 @@ func NewPrometheusCollector(reg prometheus.Registerer) *PrometheusCollector {
 			Name: "smtpd_rbl_hits_total",
 			Help: "Total number of RBL/DNSBL hits.",
 		}, []string{"list"}),
+		filterDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smtpd_filter_decisions_total",
+			Help: "Total number of filter stage decisions, by stage and action.",
+		}, []string{"stage", "action"}),
 
 		rspamdChecksTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "smtpd_rspamd_checks_total",
@@ -114,9 +136,12 @@ func NewPrometheusCollector(reg prometheus.Registerer) *PrometheusCollector {
 		c.connectionsTotal,
 		c.connectionsActive,
 		c.tlsConnectionTotal,
+		c.tlsHandshakeFailed,
 		c.messagesReceivedTotal,
 		c.messagesRejectedTotal,
 		c.messagesSizeBytes,
+		c.transactionsAborted,
+		c.sizeMismatches,
 		c.authAttemptsTotal,
 		c.commandsTotal,
 		c.deliveriesTotal,
@@ -124,6 +149,7 @@ func NewPrometheusCollector(reg prometheus.Registerer) *PrometheusCollector {
 		c.dkimChecksTotal,
 		c.dmarcChecksTotal,
 		c.rblHitsTotal,
+		c.filterDecisions,
 		c.rspamdChecksTotal,
 		c.rspamdScores,
 	)
@@ -147,6 +173,21 @@ func (c *PrometheusCollector) TLSConnectionEstablished() {
 	c.tlsConnectionTotal.Inc()
 }
 
+// TLSHandshakeFailed increments the TLS handshake failure counter.
+func (c *PrometheusCollector) TLSHandshakeFailed(reason string) {
+	c.tlsHandshakeFailed.WithLabelValues(reason).Inc()
+}
+
+// TransactionAborted increments the aborted transaction counter.
+func (c *PrometheusCollector) TransactionAborted(reason string) {
+	c.transactionsAborted.WithLabelValues(reason).Inc()
+}
+
+// SizeMismatch increments the declared-size mismatch counter.
+func (c *PrometheusCollector) SizeMismatch() {
+	c.sizeMismatches.Inc()
+}
+
 // MessageReceived increments the message received counter and observes message size.
 func (c *PrometheusCollector) MessageReceived(recipientDomain string, sizeBytes int64) {
 	c.messagesReceivedTotal.WithLabelValues(recipientDomain).Inc()
@@ -197,6 +238,28 @@ func (c *PrometheusCollector) RBLHit(listName string) {
 	c.rblHitsTotal.WithLabelValues(listName).Inc()
 }
 
+// AddCounters adds counts a protocol-handler's Tally kept (see
+// Tally.Counters) to the matching series.
+func (c *PrometheusCollector) AddCounters(counts Counters) {
+	for reason, n := range counts.TLSHandshakeFailures {
+		c.tlsHandshakeFailed.WithLabelValues(reason).Add(float64(n))
+	}
+	for reason, n := range counts.TransactionsAborted {
+		c.transactionsAborted.WithLabelValues(reason).Add(float64(n))
+	}
+	c.sizeMismatches.Add(float64(counts.SizeMismatches))
+	for stage, actions := range counts.FilterDecisions {
+		for action, n := range actions {
+			c.filterDecisions.WithLabelValues(stage, action).Add(float64(n))
+		}
+	}
+}
+
+// FilterDecision increments the filter decision counter.
+func (c *PrometheusCollector) FilterDecision(stage string, action string) {
+	c.filterDecisions.WithLabelValues(stage, action).Inc()
+}
+
 // RspamdCheckCompleted increments the rspamd check counter and observes the score.
 func (c *PrometheusCollector) RspamdCheckCompleted(senderDomain string, result string, score float64) {
 	c.rspamdChecksTotal.WithLabelValues(senderDomain, result).Inc()
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -11,23 +12,43 @@ import (
 // over HTTP.
 type PrometheusServer struct {
 	server *http.Server
+	mux    *http.ServeMux
+	ready  func(context.Context) error
 }
 
 // NewPrometheusServer creates a new PrometheusServer that will serve metrics
 // at the specified address and path. Health check endpoints are registered at
-// both /health and /healthz for compatibility with different conventions.
+// both /health and /healthz for compatibility with different conventions,
+// and a readiness endpoint at /readyz (see SetReadinessCheck).
 func NewPrometheusServer(address, metricsPath string) *PrometheusServer {
 	mux := http.NewServeMux()
+	s := &PrometheusServer{mux: mux}
 	mux.Handle(metricsPath, promhttp.Handler())
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", s.readyHandler)
 
-	return &PrometheusServer{
-		server: &http.Server{
-			Addr:    address,
-			Handler: mux,
-		},
+	s.server = &http.Server{
+		Addr:    address,
+		Handler: mux,
 	}
+	return s
+}
+
+// SetReadinessCheck makes /readyz run check on each request and answer 503
+// with its error when it fails. Without a check /readyz always reports ok.
+// Call it before Start.
+func (s *PrometheusServer) SetReadinessCheck(check func(context.Context) error) {
+	s.ready = check
+}
+
+// HandleJSON serves the value get returns, encoded as JSON, at path. Call
+// it before Start.
+func (s *PrometheusServer) HandleJSON(path string, get func() any) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(get())
+	})
 }
 
 // healthHandler responds with a simple JSON health status.
@@ -37,6 +58,20 @@ func healthHandler(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte(`{"status":"ok"}`))
 }
 
+// readyHandler responds like healthHandler, or with 503 and the error when
+// the readiness check fails.
+func (s *PrometheusServer) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil {
+		if err := s.ready(r.Context()); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "error": err.Error()})
+			return
+		}
+	}
+	healthHandler(w, r)
+}
+
 // Start begins serving metrics. It blocks until the context is canceled
 // or an error occurs. Returns nil when the server is shut down gracefully.
 func (s *PrometheusServer) Start(ctx context.Context) error {
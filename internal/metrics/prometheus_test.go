@@ -2,6 +2,10 @@ package metrics
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,8 +29,11 @@ func TestPrometheusCollectorMethods(t *testing.T) {
 	c.ConnectionOpened()
 	c.ConnectionClosed()
 	c.TLSConnectionEstablished()
+	c.TLSHandshakeFailed("version")
 	c.MessageReceived("example.com", 1024)
 	c.MessageRejected("example.com", "spam")
+	c.TransactionAborted("data_timeout")
+	c.SizeMismatch()
 	c.AuthAttempt("example.com", true)
 	c.AuthAttempt("example.com", false)
 	c.CommandProcessed("EHLO")
@@ -37,6 +44,7 @@ func TestPrometheusCollectorMethods(t *testing.T) {
 	c.DKIMCheckCompleted("sender.com", "fail")
 	c.DMARCCheckCompleted("sender.com", "none")
 	c.RBLHit("spamhaus.org")
+	c.FilterDecision("fcrdns", "reject")
 
 	// Gather metrics to verify they were recorded
 	mfs, err := reg.Gather()
@@ -54,9 +62,12 @@ func TestPrometheusCollectorMethods(t *testing.T) {
 		"smtpd_connections_total",
 		"smtpd_connections_active",
 		"smtpd_tls_connections_total",
+		"smtpd_tls_handshake_failures_total",
 		"smtpd_messages_received_total",
 		"smtpd_messages_rejected_total",
 		"smtpd_messages_size_bytes",
+		"smtpd_transactions_aborted_total",
+		"smtpd_size_mismatch_total",
 		"smtpd_auth_attempts_total",
 		"smtpd_commands_total",
 		"smtpd_deliveries_total",
@@ -64,6 +75,7 @@ func TestPrometheusCollectorMethods(t *testing.T) {
 		"smtpd_dkim_checks_total",
 		"smtpd_dmarc_checks_total",
 		"smtpd_rbl_hits_total",
+		"smtpd_filter_decisions_total",
 	}
 
 	for _, name := range expectedMetrics {
@@ -114,6 +126,47 @@ func TestPrometheusCollectorConnectionMetrics(t *testing.T) {
 	}
 }
 
+func TestPrometheusCollectorAddCounters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := NewPrometheusCollector(reg)
+
+	c.TLSHandshakeFailed("cert")
+	c.AddCounters(Counters{
+		TLSHandshakeFailures: map[string]int64{"cert": 2},
+		TransactionsAborted:  map[string]int64{"data_timeout": 1},
+		SizeMismatches:       3,
+		FilterDecisions:      map[string]map[string]int64{"fcrdns": {"reject": 4}},
+	})
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	want := map[string]float64{
+		"smtpd_tls_handshake_failures_total": 3,
+		"smtpd_transactions_aborted_total":   1,
+		"smtpd_size_mismatch_total":          3,
+		"smtpd_filter_decisions_total":       4,
+	}
+	for _, mf := range mfs {
+		n, ok := want[mf.GetName()]
+		if !ok {
+			continue
+		}
+		delete(want, mf.GetName())
+		if len(mf.GetMetric()) != 1 {
+			t.Errorf("%s has %d metric entries, want 1", mf.GetName(), len(mf.GetMetric()))
+			continue
+		}
+		if v := mf.GetMetric()[0].GetCounter().GetValue(); v != n {
+			t.Errorf("%s = %v, want %v", mf.GetName(), v, n)
+		}
+	}
+	for name := range want {
+		t.Errorf("%s not exported", name)
+	}
+}
+
 func TestPrometheusCollectorAuthMetrics(t *testing.T) {
 	reg := prometheus.NewRegistry()
 	c := NewPrometheusCollector(reg)
@@ -172,6 +225,47 @@ func TestPrometheusServerStartStop(t *testing.T) {
 	}
 }
 
+func TestPrometheusServerReadyz(t *testing.T) {
+	server := NewPrometheusServer("127.0.0.1:0", "/metrics")
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("without a check: status %d, want 200", rec.Code)
+	}
+
+	var failing error
+	server.SetReadinessCheck(func(context.Context) error { return failing })
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("passing check: status %d, want 200", rec.Code)
+	}
+	failing = errors.New("listener :25: STARTTLS not offered")
+	rec := get()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("failing check: status %d, want 503", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "STARTTLS not offered") {
+		t.Errorf("failing check: body %q does not carry the error", rec.Body.String())
+	}
+}
+
+func TestPrometheusServerHandleJSON(t *testing.T) {
+	server := NewPrometheusServer("127.0.0.1:0", "/metrics")
+	server.HandleJSON("/rejections", func() any { return []string{"550 User unknown"} })
+
+	rec := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rejections", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", rec.Code)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `["550 User unknown"]` {
+		t.Errorf("body %q", got)
+	}
+}
+
 func TestNewReturnsPrometheusImplementationsWhenEnabled(t *testing.T) {
 	// Use a separate registry to avoid conflicts with default registry
 	// Note: This test verifies the type returned, not the full functionality
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// Totals are lifetime counts kept by a Tally. They are what the shutdown
+// report prints, and what each protocol-handler subprocess sends back to the
+// listener when its session ends.
+type Totals struct {
+	Connections      int64 `json:"connections"`
+	MessagesAccepted int64 `json:"messages_accepted"`
+	MessagesRejected int64 `json:"messages_rejected"`
+	AuthSuccesses    int64 `json:"auth_successes"`
+	AuthFailures     int64 `json:"auth_failures"`
+	BytesDelivered   int64 `json:"bytes_delivered"`
+}
+
+// Add adds o to t.
+func (t *Totals) Add(o Totals) {
+	t.Connections += o.Connections
+	t.MessagesAccepted += o.MessagesAccepted
+	t.MessagesRejected += o.MessagesRejected
+	t.AuthSuccesses += o.AuthSuccesses
+	t.AuthFailures += o.AuthFailures
+	t.BytesDelivered += o.BytesDelivered
+}
+
+// Counters are labelled counts kept by a Tally for the series only the
+// listener exports: each protocol-handler sends them back with its Totals,
+// and the listener adds them to its Prometheus registry (see
+// PrometheusCollector.AddCounters).
+type Counters struct {
+	TLSHandshakeFailures map[string]int64            `json:"tls_handshake_failures,omitempty"` // by reason
+	TransactionsAborted  map[string]int64            `json:"transactions_aborted,omitempty"`   // by reason
+	SizeMismatches       int64                       `json:"size_mismatches,omitempty"`
+	FilterDecisions      map[string]map[string]int64 `json:"filter_decisions,omitempty"` // by stage, then action
+}
+
+// Tally is a Collector that keeps readable lifetime totals and passes every
+// call on to another Collector. It is safe for concurrent use.
+type Tally struct {
+	Collector
+
+	connections      atomic.Int64
+	messagesAccepted atomic.Int64
+	messagesRejected atomic.Int64
+	authSuccesses    atomic.Int64
+	authFailures     atomic.Int64
+	bytesDelivered   atomic.Int64
+
+	countersMu sync.Mutex
+	counters   Counters
+}
+
+// NewTally returns a Tally forwarding to next. A nil next is treated as a
+// NoopCollector.
+func NewTally(next Collector) *Tally {
+	if next == nil {
+		next = &NoopCollector{}
+	}
+	return &Tally{Collector: next}
+}
+
+// ConnectionOpened counts the connection and forwards the call.
+func (t *Tally) ConnectionOpened() {
+	t.connections.Add(1)
+	t.Collector.ConnectionOpened()
+}
+
+// MessageReceived counts the message and its size and forwards the call.
+func (t *Tally) MessageReceived(recipientDomain string, sizeBytes int64) {
+	t.messagesAccepted.Add(1)
+	t.bytesDelivered.Add(sizeBytes)
+	t.Collector.MessageReceived(recipientDomain, sizeBytes)
+}
+
+// MessageRejected counts the rejection and forwards the call.
+func (t *Tally) MessageRejected(recipientDomain string, reason string) {
+	t.messagesRejected.Add(1)
+	t.Collector.MessageRejected(recipientDomain, reason)
+}
+
+// AuthAttempt counts the attempt by outcome and forwards the call.
+func (t *Tally) AuthAttempt(authDomain string, success bool) {
+	if success {
+		t.authSuccesses.Add(1)
+	} else {
+		t.authFailures.Add(1)
+	}
+	t.Collector.AuthAttempt(authDomain, success)
+}
+
+// Totals returns the counts so far.
+func (t *Tally) Totals() Totals {
+	return Totals{
+		Connections:      t.connections.Load(),
+		MessagesAccepted: t.messagesAccepted.Load(),
+		MessagesRejected: t.messagesRejected.Load(),
+		AuthSuccesses:    t.authSuccesses.Load(),
+		AuthFailures:     t.authFailures.Load(),
+		BytesDelivered:   t.bytesDelivered.Load(),
+	}
+}
+
+// TLSHandshakeFailed counts the failure by reason and forwards the call.
+func (t *Tally) TLSHandshakeFailed(reason string) {
+	t.countersMu.Lock()
+	t.counters.TLSHandshakeFailures = addCount(t.counters.TLSHandshakeFailures, reason)
+	t.countersMu.Unlock()
+	t.Collector.TLSHandshakeFailed(reason)
+}
+
+// TransactionAborted counts the abort by reason and forwards the call.
+func (t *Tally) TransactionAborted(reason string) {
+	t.countersMu.Lock()
+	t.counters.TransactionsAborted = addCount(t.counters.TransactionsAborted, reason)
+	t.countersMu.Unlock()
+	t.Collector.TransactionAborted(reason)
+}
+
+// SizeMismatch counts the mismatch and forwards the call.
+func (t *Tally) SizeMismatch() {
+	t.countersMu.Lock()
+	t.counters.SizeMismatches++
+	t.countersMu.Unlock()
+	t.Collector.SizeMismatch()
+}
+
+// FilterDecision counts the decision by stage and action and forwards the
+// call.
+func (t *Tally) FilterDecision(stage string, action string) {
+	t.countersMu.Lock()
+	if t.counters.FilterDecisions == nil {
+		t.counters.FilterDecisions = make(map[string]map[string]int64)
+	}
+	t.counters.FilterDecisions[stage] = addCount(t.counters.FilterDecisions[stage], action)
+	t.countersMu.Unlock()
+	t.Collector.FilterDecision(stage, action)
+}
+
+// Counters returns a copy of the labelled counts so far.
+func (t *Tally) Counters() Counters {
+	t.countersMu.Lock()
+	defer t.countersMu.Unlock()
+	c := Counters{
+		TLSHandshakeFailures: maps.Clone(t.counters.TLSHandshakeFailures),
+		TransactionsAborted:  maps.Clone(t.counters.TransactionsAborted),
+		SizeMismatches:       t.counters.SizeMismatches,
+	}
+	if t.counters.FilterDecisions != nil {
+		c.FilterDecisions = make(map[string]map[string]int64, len(t.counters.FilterDecisions))
+		for stage, actions := range t.counters.FilterDecisions {
+			c.FilterDecisions[stage] = maps.Clone(actions)
+		}
+	}
+	return c
+}
+
+// addCount increments m[key], allocating m if it is nil, and returns m.
+func addCount(m map[string]int64, key string) map[string]int64 {
+	if m == nil {
+		m = make(map[string]int64)
+	}
+	m[key]++
+	return m
+}
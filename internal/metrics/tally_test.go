@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTallyImplementsInterface(t *testing.T) {
+	var _ Collector = NewTally(nil)
+}
+
+func TestTally_Totals(t *testing.T) {
+	tally := NewTally(&NoopCollector{})
+
+	tally.ConnectionOpened()
+	tally.ConnectionOpened()
+	tally.AuthAttempt("example.com", true)
+	tally.AuthAttempt("example.com", false)
+	tally.AuthAttempt("example.com", false)
+	tally.MessageReceived("example.com", 1000)
+	tally.MessageReceived("example.org", 234)
+	tally.MessageRejected("example.com", "spam")
+	tally.CommandProcessed("EHLO") // not tallied
+
+	want := Totals{
+		Connections:      2,
+		MessagesAccepted: 2,
+		MessagesRejected: 1,
+		AuthSuccesses:    1,
+		AuthFailures:     2,
+		BytesDelivered:   1234,
+	}
+	if got := tally.Totals(); got != want {
+		t.Errorf("Totals() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTally_Counters(t *testing.T) {
+	tally := NewTally(&NoopCollector{})
+
+	tally.TLSHandshakeFailed("tls_version_too_low")
+	tally.TLSHandshakeFailed("tls_version_too_low")
+	tally.TransactionAborted("reset")
+	tally.SizeMismatch()
+	tally.FilterDecision("fcrdns", "accept")
+	tally.FilterDecision("fcrdns", "reject")
+	tally.FilterDecision("spamcheck", "accept")
+
+	want := Counters{
+		TLSHandshakeFailures: map[string]int64{"tls_version_too_low": 2},
+		TransactionsAborted:  map[string]int64{"reset": 1},
+		SizeMismatches:       1,
+		FilterDecisions: map[string]map[string]int64{
+			"fcrdns":    {"accept": 1, "reject": 1},
+			"spamcheck": {"accept": 1},
+		},
+	}
+	got := tally.Counters()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Counters() = %+v, want %+v", got, want)
+	}
+
+	// The result is a copy.
+	got.FilterDecisions["fcrdns"]["accept"] = 10
+	if n := tally.Counters().FilterDecisions["fcrdns"]["accept"]; n != 1 {
+		t.Errorf("Counters() shares its maps with the tally: fcrdns accept = %d", n)
+	}
+}
+
+func TestTotals_Add(t *testing.T) {
+	sum := Totals{Connections: 1, BytesDelivered: 10}
+	sum.Add(Totals{Connections: 2, MessagesAccepted: 1, AuthFailures: 3, BytesDelivered: 5})
+
+	want := Totals{Connections: 3, MessagesAccepted: 1, AuthFailures: 3, BytesDelivered: 15}
+	if sum != want {
+		t.Errorf("sum = %+v, want %+v", sum, want)
+	}
+}
@@ -181,6 +181,7 @@ func (c *Checker) convertResult(r *RspamdResult) *spamcheck.CheckResult {
 	case RspamdActionSoftReject, RspamdActionGreylist:
 		result.Action = spamcheck.ActionTempFail
 		result.RejectMessage = "Message deferred, please try again later"
+		result.Greylisted = r.Action == RspamdActionGreylist
 	case RspamdActionAddHeader, RspamdActionRewriteSubject:
 		result.Action = spamcheck.ActionFlag
 	default:
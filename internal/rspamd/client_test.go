@@ -170,6 +170,9 @@ func TestChecker_Check(t *testing.T) {
 			if result.CheckerName != "rspamd" {
 				t.Errorf("expected checker name 'rspamd', got %s", result.CheckerName)
 			}
+			if want := tt.response.Action == RspamdActionGreylist; result.Greylisted != want {
+				t.Errorf("expected greylisted %v, got %v", want, result.Greylisted)
+			}
 		})
 	}
 }
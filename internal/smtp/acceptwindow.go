@@ -0,0 +1,70 @@
+package smtp
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// errOutsideWindow is the RCPT reply for a domain outside its accept_hours.
+var errOutsideWindow = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Domain not accepting mail at this time, try again later",
+}
+
+// acceptWindows enforces [smtpd.domains] accept_hours and accept_days.
+type acceptWindows struct {
+	domains config.DomainsConfig
+	windows map[string]*config.AcceptWindow // by domain, "*" for the rest
+	now     func() time.Time
+}
+
+// newAcceptWindows returns nil when no domain has a window. Windows that do
+// not parse are skipped; config.Validate has already refused them.
+func newAcceptWindows(domains config.DomainsConfig) *acceptWindows {
+	windows := make(map[string]*config.AcceptWindow)
+	for domain, d := range domains {
+		if w, err := d.AcceptWindow(); err == nil && w != nil {
+			windows[domain] = w
+		}
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+	return &acceptWindows{domains: domains, windows: windows, now: time.Now}
+}
+
+// accepts reports whether mail for domain is accepted now. A domain listed
+// without a window of its own is not covered by "*".
+func (a *acceptWindows) accepts(domain string) bool {
+	domain = strings.ToLower(domain)
+	w, ok := a.windows[domain]
+	if !ok {
+		if _, listed := a.domains[domain]; listed {
+			return true
+		}
+		w = a.windows["*"]
+	}
+	return w == nil || w.Contains(a.now())
+}
+
+// checkAcceptWindow defers a recipient whose local domain is outside its
+// acceptance window.
+func (s *Session) checkAcceptWindow(domain string) error {
+	a := s.backend.acceptWindows
+	if a == nil {
+		return nil
+	}
+	if a.accepts(domain) {
+		s.filterDecision("accept_window", nil)
+		return nil
+	}
+	s.logger.Info("recipient deferred outside domain accept window",
+		slog.String("domain", domain))
+	s.filterDecision("accept_window", errOutsideWindow)
+	return errOutsideWindow
+}
@@ -0,0 +1,73 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestSession_Rcpt_AcceptWindow(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		Domains: config.DomainsConfig{
+			"office.example": {AcceptHours: "08:00-18:00", AcceptDays: []string{"mon", "tue", "wed", "thu", "fri"}, TimeZone: "UTC"},
+			"always.example": {InboundRatePerMinute: 100},
+		},
+		RoleMailbox:    "postmaster@example.com",
+		RoleRecipients: []string{"postmaster"},
+	})
+
+	rcpt := func(to string) error {
+		s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+		return s.Rcpt(to, nil)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		to   string
+		want error
+	}{
+		// 2026-03-02 is a Monday.
+		{"inside window", time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC), "bob@office.example", nil},
+		{"after hours", time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC), "bob@office.example", errOutsideWindow},
+		{"weekend", time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC), "bob@office.example", errOutsideWindow},
+		{"role recipient exempt", time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC), "postmaster@office.example", nil},
+		{"other domain unaffected", time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC), "alice@always.example", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.acceptWindows.now = func() time.Time { return tt.now }
+			if err := rcpt(tt.to); !errors.Is(err, tt.want) {
+				t.Errorf("Rcpt(%s) = %v, want %v", tt.to, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptWindows_Default(t *testing.T) {
+	closed := time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC)
+	a := newAcceptWindows(config.DomainsConfig{
+		"*":            {AcceptHours: "08:00-18:00", TimeZone: "UTC"},
+		"open.example": {InboundRatePerMinute: 10},
+	})
+	a.now = func() time.Time { return closed }
+
+	if a.accepts("any.example") {
+		t.Error("unlisted domain accepted outside the \"*\" window")
+	}
+	if !a.accepts("OPEN.example") {
+		t.Error("listed domain without a window fell back to \"*\"")
+	}
+
+	if newAcceptWindows(config.DomainsConfig{"example.com": {InboundRatePerMinute: 10}}) != nil {
+		t.Error("newAcceptWindows without any window is not nil")
+	}
+}
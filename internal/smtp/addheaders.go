@@ -0,0 +1,73 @@
+package smtp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// newQueueID returns a random identifier for one accepted message. It is
+// logged with the delivery and available to add_headers as {queue_id}, so a
+// stamped header can be matched to smtpd's logs.
+func newQueueID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+// renderAddedHeaders formats the [smtpd] add_headers map as header lines,
+// substituting {hostname} and {queue_id}. Headers are sorted by name so the
+// output is stable. Config.Validate has already rejected names and values
+// that would break the header block.
+func renderAddedHeaders(headers map[string]string, hostname, queueID string) string {
+	return renderHeaders(headers, strings.NewReplacer("{hostname}", hostname, "{queue_id}", queueID))
+}
+
+// renderDeliveryHeaders formats a [smtpd.domains] delivery_headers map for
+// local delivery to recipient, substituting {recipient} and {timestamp}
+// (RFC 5322 date-time) as well as {hostname} and {queue_id}.
+func renderDeliveryHeaders(headers map[string]string, hostname, queueID, recipient string, now time.Time) string {
+	return renderHeaders(headers, strings.NewReplacer(
+		"{hostname}", hostname,
+		"{queue_id}", queueID,
+		"{recipient}", recipient,
+		"{timestamp}", now.Format(time.RFC1123Z),
+	))
+}
+
+// renderHeaders formats headers as header lines sorted by name, applying
+// subst to each value.
+func renderHeaders(headers map[string]string, subst *strings.Replacer) string {
+	if len(headers) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(subst.Replace(headers[name]))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// stampedMessage returns the buffered message with the configured headers
+// prepended. Each call yields a fresh reader, so every delivery carries the
+// headers exactly once.
+func (s *Session) stampedMessage(tmp tempBuffer, queueID string) io.Reader {
+	added := renderAddedHeaders(s.backend.addHeaders, s.backend.hostname, queueID)
+	if added == "" {
+		return s.messageBody(tmp)
+	}
+	return io.MultiReader(strings.NewReader(added), s.messageBody(tmp))
+}
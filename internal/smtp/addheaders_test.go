@@ -0,0 +1,146 @@
+package smtp
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestRenderAddedHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name: "none",
+			want: "",
+		},
+		{
+			name: "substitutions",
+			headers: map[string]string{
+				"X-Virus-Scanned": "clamav on {hostname}",
+				"X-Queue-ID":      "{queue_id} via {hostname}",
+			},
+			want: "X-Queue-ID: 0123ABCD via mx.example.com\r\n" +
+				"X-Virus-Scanned: clamav on mx.example.com\r\n",
+		},
+		{
+			name:    "literal value",
+			headers: map[string]string{"X-Org": "Example Corp"},
+			want:    "X-Org: Example Corp\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderAddedHeaders(tt.headers, "mx.example.com", "0123ABCD")
+			if got != tt.want {
+				t.Errorf("renderAddedHeaders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStampedMessage(t *testing.T) {
+	tmp := &memTempBuf{}
+	_, _ = tmp.Write([]byte("Subject: hi\r\n\r\nbody\r\n"))
+	s := &Session{backend: &Backend{
+		hostname:   "mx.example.com",
+		addHeaders: map[string]string{"X-Scanned": "yes"},
+	}}
+
+	// Each reader carries the header once, even when the message is read
+	// more than once (local delivery and enqueue).
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(s.stampedMessage(tmp, "ID"))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if got, want := string(data), "X-Scanned: yes\r\nSubject: hi\r\n\r\nbody\r\n"; got != want {
+			t.Errorf("read %d = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRenderDeliveryHeaders(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	got := renderDeliveryHeaders(map[string]string{
+		"X-Delivered-To": "{recipient} at {timestamp}",
+		"X-Route":        "{hostname}/{queue_id}",
+	}, "mx.example.com", "0123ABCD", "bob@example.com", now)
+	want := "X-Delivered-To: bob@example.com at Mon, 02 Mar 2026 09:30:00 +0000\r\n" +
+		"X-Route: mx.example.com/0123ABCD\r\n"
+	if got != want {
+		t.Errorf("renderDeliveryHeaders() = %q, want %q", got, want)
+	}
+}
+
+func TestSession_Data_DeliveryHeaders(t *testing.T) {
+	domains := config.DomainsConfig{
+		"shop.example":  {DeliveryHeaders: map[string]string{"X-Brand": "Shop for {recipient}"}},
+		"legal.example": {DeliveryHeaders: map[string]string{"X-Route": "legal-archive"}},
+	}
+
+	tests := []struct {
+		to      string
+		want    string
+		notWant string
+	}{
+		{"alice@shop.example", "X-Brand: Shop for alice@shop.example\r\n", "X-Route:"},
+		{"bob@legal.example", "X-Route: legal-archive\r\n", "X-Brand:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.to, func(t *testing.T) {
+			mock := &mockDeliveryServer{result: pb.DeliverResult_DELIVER_RESULT_DELIVERED}
+			agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: startMockServer(t, mock)}, nil)
+			if err != nil {
+				t.Fatalf("new agent: %v", err)
+			}
+			defer func() { _ = agent.Close() }()
+
+			s := &Session{
+				backend: NewBackend(BackendConfig{
+					Hostname:   "mx.example.com",
+					SMDelivery: agent,
+					Domains:    domains,
+					AddHeaders: map[string]string{"X-Scanned": "yes"},
+					TempDir:    t.TempDir(),
+				}),
+				mailFromSeen: true,
+				from:         "sender@example.org",
+				recipients:   []string{tt.to},
+				logger:       slog.Default(),
+			}
+			if err := s.Data(strings.NewReader("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+				t.Fatalf("Data() = %v", err)
+			}
+
+			header, _, _ := strings.Cut(string(mock.body), "\r\n\r\n")
+			if !strings.Contains(header, "X-Scanned: yes\r\n") {
+				t.Errorf("global add_headers missing from %q", header)
+			}
+			if !strings.Contains(header, tt.want) {
+				t.Errorf("header %q lacks %q", header, tt.want)
+			}
+			if strings.Contains(header, tt.notWant) {
+				t.Errorf("header %q carries the other domain's %q", header, tt.notWant)
+			}
+		})
+	}
+}
+
+func TestNewQueueID(t *testing.T) {
+	a, b := newQueueID(), newQueueID()
+	if len(a) != 16 || strings.ToUpper(a) != a {
+		t.Errorf("newQueueID() = %q, want 16 upper-case hex digits", a)
+	}
+	if a == b {
+		t.Errorf("newQueueID() returned %q twice", a)
+	}
+}
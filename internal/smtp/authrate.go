@@ -0,0 +1,108 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// authThrottle counts AUTH attempts per client IP and per username in the
+// state store. Session.Auth charges the IP for every AUTH command, before a
+// mechanism is chosen, and each mechanism's credential check charges the
+// username before verifying, so all mechanisms share one budget.
+//
+// State store errors fail open, as in reputation.go.
+type authThrottle struct {
+	store      kvstore.Store
+	maxPerIP   int
+	maxPerUser int
+	window     time.Duration
+	delay      time.Duration
+	logger     *slog.Logger
+}
+
+// newAuthThrottle returns nil when both limits are disabled.
+func newAuthThrottle(cfg config.AuthRateConfig, store kvstore.Store, logger *slog.Logger) *authThrottle {
+	if (cfg.MaxPerIP <= 0 && cfg.MaxPerUser <= 0) || store == nil {
+		return nil
+	}
+	return &authThrottle{
+		store:      store,
+		maxPerIP:   cfg.MaxPerIP,
+		maxPerUser: cfg.MaxPerUser,
+		window:     cfg.GetWindow(),
+		delay:      cfg.GetDelay(),
+		logger:     logger,
+	}
+}
+
+// allow counts one attempt against key and reports whether it is within max.
+// A max of 0 means no limit.
+func (t *authThrottle) allow(ctx context.Context, key string, max int) bool {
+	if max <= 0 {
+		return true
+	}
+	n, err := t.store.Incr(ctx, "authrate:"+key, t.window)
+	if err != nil {
+		t.logger.Debug("auth rate update failed", slog.String("error", err.Error()))
+		return true
+	}
+	return n <= int64(max)
+}
+
+// errAuthThrottled is the reply once an IP or username is over its limit.
+var errAuthThrottled = &smtp.SMTPError{
+	Code:         454,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many authentication attempts, try again later",
+}
+
+// throttleAuthIP charges one AUTH attempt to the client IP.
+func (s *Session) throttleAuthIP() error {
+	t := s.backend.authThrottle
+	if t == nil || s.clientIP == "" {
+		return nil
+	}
+	if t.allow(context.Background(), "ip:"+s.clientIP, t.maxPerIP) {
+		return nil
+	}
+	return s.authThrottled(slog.String("client_ip", s.clientIP))
+}
+
+// throttleAuthUser charges one credential check to username. Every SASL
+// mechanism calls it before verifying.
+func (s *Session) throttleAuthUser(username string) error {
+	t := s.backend.authThrottle
+	if t == nil || username == "" {
+		return nil
+	}
+	if t.allow(context.Background(), "user:"+strings.ToLower(username), t.maxPerUser) {
+		return nil
+	}
+	return s.authThrottled(slog.String("username", username))
+}
+
+// authThrottled logs, pauses to slow the client down, and returns the 454.
+func (s *Session) authThrottled(attr slog.Attr) error {
+	s.logger.Warn("authentication throttled", attr)
+	s.backend.pause(s.backend.authThrottle.delay)
+	return errAuthThrottled
+}
+
+// delayAuthFailure pauses before the reply to rejected credentials for
+// [smtpd.auth] fail_delay plus a random jitter of up to fail_jitter, so a
+// guesser cannot pipeline attempts or time the reply to spot a near miss.
+// Successful logins never wait.
+func (s *Session) delayAuthFailure() {
+	d := s.backend.authFailDelay
+	if j := s.backend.authFailJitter; j > 0 {
+		d += rand.N(j)
+	}
+	s.backend.pause(d)
+}
@@ -0,0 +1,144 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthThrottle_Disabled(t *testing.T) {
+	if th := newAuthThrottle(config.AuthRateConfig{}, kvstore.NewMemory(), slog.Default()); th != nil {
+		t.Error("expected nil throttle when both limits are 0")
+	}
+}
+
+func TestAuthThrottle_WindowExpires(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newRedisState(t)
+	th := newAuthThrottle(config.AuthRateConfig{MaxPerUser: 1, Window: "5m"}, store, slog.Default())
+
+	if !th.allow(ctx, "user:alice", th.maxPerUser) {
+		t.Fatal("first attempt throttled")
+	}
+	if th.allow(ctx, "user:alice", th.maxPerUser) {
+		t.Fatal("second attempt allowed over limit")
+	}
+	mr.FastForward(5 * time.Minute)
+	if !th.allow(ctx, "user:alice", th.maxPerUser) {
+		t.Error("still throttled after the window")
+	}
+}
+
+// TestSession_Auth_ThrottledAcrossMechanisms checks that the per-IP budget
+// is spent by every AUTH command, whichever mechanism it names.
+func TestSession_Auth_ThrottledAcrossMechanisms(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		AuthRate: config.AuthRateConfig{MaxPerIP: 3, Delay: "0s"},
+	})
+	s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+
+	for _, mech := range []string{"LOGIN", "CRAM-MD5", sasl.Plain} {
+		if _, err := s.Auth(mech); errors.Is(err, errAuthThrottled) {
+			t.Fatalf("AUTH %s throttled within the limit", mech)
+		}
+	}
+	for _, mech := range []string{sasl.Plain, "LOGIN"} {
+		_, err := s.Auth(mech)
+		var smtpErr *gosmtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 454 {
+			t.Errorf("AUTH %s over the limit: got %v, want 454", mech, err)
+		}
+	}
+
+	other := &Session{backend: backend, clientIP: "192.0.2.2", logger: slog.Default()}
+	if _, err := other.Auth(sasl.Plain); errors.Is(err, errAuthThrottled) {
+		t.Error("unrelated IP throttled")
+	}
+}
+
+func TestSession_ThrottleAuthUser(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		AuthRate: config.AuthRateConfig{MaxPerUser: 2, Delay: "0s"},
+	})
+	// Usernames are counted regardless of the connection they arrive on.
+	a := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+	b := &Session{backend: backend, clientIP: "192.0.2.2", logger: slog.Default()}
+
+	if err := a.throttleAuthUser("alice@example.com"); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	if err := b.throttleAuthUser("Alice@Example.com"); err != nil {
+		t.Fatalf("second attempt: %v", err)
+	}
+	if err := a.throttleAuthUser("alice@example.com"); !errors.Is(err, errAuthThrottled) {
+		t.Errorf("third attempt: got %v, want throttled", err)
+	}
+	if err := a.throttleAuthUser("bob@example.com"); err != nil {
+		t.Errorf("other user throttled: %v", err)
+	}
+	// No per-IP limit is configured.
+	if err := a.throttleAuthIP(); err != nil {
+		t.Errorf("IP throttled without max_per_ip: %v", err)
+	}
+}
+
+func TestSession_Auth_FailDelay(t *testing.T) {
+	const minDelay = 200 * time.Millisecond
+
+	auth := func(t *testing.T, svc *mockSessionService) (time.Duration, error) {
+		t.Helper()
+		backend := NewBackend(BackendConfig{
+			SMDelivery: startMockSessionServer(t, svc),
+			Auth:       config.AuthConfig{FailDelay: minDelay.String(), FailJitter: "50ms"},
+		})
+		s := &Session{backend: backend, clientIP: "127.0.0.1", logger: slog.Default()}
+		server, err := s.Auth(sasl.Plain)
+		if err != nil {
+			t.Fatalf("Auth: %v", err)
+		}
+		start := time.Now()
+		_, _, err = server.Next([]byte("\x00alice@example.com\x00secret"))
+		return time.Since(start), err
+	}
+
+	t.Run("failure waits", func(t *testing.T) {
+		elapsed, err := auth(t, &mockSessionService{loginErr: status.Error(codes.Unauthenticated, "bad password")})
+		var smtpErr *gosmtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 535 {
+			t.Fatalf("got %v, want 535", err)
+		}
+		if elapsed < minDelay {
+			t.Errorf("failed AUTH answered after %v, want at least %v", elapsed, minDelay)
+		}
+	})
+
+	t.Run("success does not wait", func(t *testing.T) {
+		elapsed, err := auth(t, &mockSessionService{loginResult: &smpb.LoginResponse{Mailbox: "alice@example.com"}})
+		if err != nil {
+			t.Fatalf("Auth: %v", err)
+		}
+		if elapsed >= minDelay {
+			t.Errorf("successful AUTH took %v", elapsed)
+		}
+	})
+}
+
+func TestBackend_StopEndsPause(t *testing.T) {
+	backend := NewBackend(BackendConfig{})
+	backend.Stop()
+	start := time.Now()
+	backend.pause(time.Minute)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("pause after Stop took %v", elapsed)
+	}
+}
@@ -1,15 +1,20 @@
 package smtp
 
 import (
+	"context"
 	"log/slog"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-smtp"
 	"github.com/infodancer/logging"
 	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
 	"github.com/infodancer/smtpd/internal/metrics"
 	"github.com/infodancer/smtpd/internal/spamcheck"
+	"github.com/infodancer/smtpd/internal/webhook"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -25,12 +30,62 @@ type Backend struct {
 	spamtrapRateLimiter *ipRateLimiter
 	senderRateLimiter   senderLimiter
 	maxSendsPerHour     int // global default; per-domain overrides via loginResult
+	overloadMessage     string
+	requireTLS          bool              // refuse MAIL/DATA over cleartext
+	addHeaders          map[string]string // [smtpd] add_headers templates
+	headerPolicy        config.HeaderPolicy
+	uniqueHeaders       bool              // reject duplicate From, Content-Type, Subject, Date
+	returnPath          bool              // prepend Return-Path on local delivery
+	authUserHeader      bool              // stamp X-Authenticated-User on local delivery
+	noBounce            map[string]bool   // lower-cased addresses and "@domain" refusing bounces
+	honeypotDir         string            // non-empty: capture sessions here, never deliver
+	roleMailbox         string            // delivery address for role recipients without a user
+	roleRecipients      map[string]bool   // lower-cased role local parts; empty = disabled
+	reputation          *ipReputation     // nil = disabled
+	authThrottle        *authThrottle     // nil = disabled
+	userSessions        *userSessionLimit // nil = disabled
+	senderStats         *senderStats      // nil = disabled
+	rejections          *rejectionLog     // nil = disabled
 	notifier            *Notifier
+	state               kvstore.Store // defensive state (greylist, rate limits, dedup)
+	stateGuard          *stateGuard   // nil = fail open; see checkState
 	collector           metrics.Collector
 	maxRecipients       int
 	maxMessageSize      int64
+	maxMIMEDepth        int
+	maxMIMEParts        int
 	tempDir             string
 	logger              *slog.Logger
+	sendAs              map[string]map[string]bool // lower-cased user → permitted sender addresses
+	checkLocalFrom      bool                       // From-header check for local recipients too
+	rejectNullMX        bool                       // refuse remote recipients on null-MX domains
+	authFailDelay       time.Duration              // minimum pause before a failed AUTH reply
+	authFailJitter      time.Duration              // random extra pause, up to this
+	messageTimeout      time.Duration              // processing budget per message; 0 = unlimited
+	dataPace            *dataPace                  // nil = disabled
+	dateCheck           *dateCheck                 // nil = disabled
+	firstContact        *firstContact              // nil = disabled
+	dataTransfers       *dataTransferLimit         // nil = disabled
+	spamBypass          *spamBypass                // nil = disabled
+	maxConnRecipients   int                        // 0 = unlimited
+	senderPolicy        *senderPolicy              // nil = disabled
+	webhook             *webhook.Notifier          // nil = disabled
+	checkEncoding       bool                       // Content-Transfer-Encoding check for authenticated mail
+	inboundRate         *inboundRate               // nil = disabled
+	acceptWindows       *acceptWindows             // nil = disabled
+	sendWindows         *sendWindows               // nil = disabled
+	domains             config.DomainsConfig       // per-domain delivery_headers
+	headerCharset       config.HeaderCharsetPolicy // invalid UTF-8 in headers; "" = off
+	fcrdns              config.FCrDNSPolicy        // unauthenticated clients without FCrDNS; "" = off
+	resolver            dnsResolver                // DNS lookups for fcrdns and reject_null_mx
+	signals             *config.SignalsConfig      // nil = disabled
+	maxHeaderRcpts      int                        // To+Cc addresses before header_recipients; 0 = off
+	maxReceived         int                        // Received fields kept on delivery; 0 = all
+	ipUsers             *ipUserLimit               // nil = disabled
+	maintenance         atomic.Bool                // MAIL gets 421; see SetMaintenance
+	warmup              bool                       // unknown recipients get 451; see unknownUser
+	stopping            context.Context            // done once Stop is called
+	stop                context.CancelFunc
 }
 
 // BackendConfig holds configuration for creating a Backend.
@@ -42,11 +97,55 @@ type BackendConfig struct {
 	RejectionMode   config.RejectionMode
 	SpamtrapConfig  config.SpamtrapConfig
 	MaxSendsPerHour int
-	RedisClient     *redis.Client // shared Redis for cross-subprocess rate limiting
+	OverloadMessage string              // text of 421 4.3.2 overload replies; "" → DefaultOverloadMessage
+	RequireTLS      bool                // refuse MAIL and DATA until STARTTLS
+	AddHeaders      map[string]string   // headers prepended to accepted mail; {hostname}, {queue_id} substituted
+	HeaderPolicy    config.HeaderPolicy // required RFC 5322 headers; "" → off
+	FCrDNS          config.FCrDNSPolicy // unauthenticated clients without forward-confirmed rDNS; "" → off
+	UniqueHeaders   bool                // reject duplicate From, Content-Type, Subject or Date
+	ReturnPath      bool                // prepend Return-Path with the envelope sender on local delivery
+	AuthUserHeader  bool                // prepend X-Authenticated-User on local delivery; strip it everywhere
+	NoBounce        []string            // addresses or "@domain" that refuse MAIL FROM:<>
+	DenySenders     []string            // MAIL FROM addresses or glob patterns refused with 550
+	AllowSenders    []string            // exceptions to DenySenders
+	SendAs          map[string][]string // authenticated user → extra permitted sender addresses
+	CheckLocalFrom  bool                // From-header check for local recipients too
+	RejectNullMX    bool                // refuse remote recipients whose domain has a null MX
+	CheckEncoding   bool                // authenticated mail: reject malformed Content-Transfer-Encoding
+	MessageTimeout  time.Duration       // processing budget per message (see Session.stage); 0 = unlimited
+	HoneypotDir     string              // non-empty: accept everything and capture it here instead of delivering
+	Maintenance     bool                // start in maintenance mode (see Backend.SetMaintenance)
+	Warmup          bool                // defer unknown recipients (unknown_user_warmup)
+	RoleMailbox     string              // where role recipients without a user of their own are delivered
+	RoleRecipients  []string            // role local parts (postmaster, abuse); ignored without RoleMailbox
+	RedisClient     *redis.Client       // shared Redis for cross-subprocess rate limiting
+	HeaderCharset   config.HeaderCharsetPolicy
 	Notifier        *Notifier
+	Webhook         *webhook.Notifier // nil → message events not posted
+	StateStore      kvstore.Store     // nil → in-memory store
+	StateOnError    config.StateErrorPolicy
+	Reputation      config.ReputationConfig
+	AuthRate        config.AuthRateConfig
+	Auth            config.AuthConfig     // max_sessions_per_user, fail_delay
+	DataPace        config.DataPaceConfig // minimum DATA transfer time
+	Signals         config.SignalsConfig  // combined weak-signal rejection
+	Metrics         config.MetricsConfig  // per_user sender counts
+	Domains         config.DomainsConfig  // per-domain inbound rate
+	Users           config.UsersConfig    // per-user send window
 	Collector       metrics.Collector
 	MaxRecipients   int
 	MaxMessageSize  int64
+	MaxMIMEDepth    int // nested multipart/message levels; 0 = unlimited
+	MaxMIMEParts    int // MIME parts per message; 0 = unlimited
+	MaxTransfers    int // DATA phases at once across connections sharing StateStore; 0 = unlimited
+	MaxConnRcpts    int // recipients accepted per connection across transactions; 0 = unlimited
+	MaxHeaderRcpts  int // To+Cc addresses before the header_recipients signal; 0 = off
+	MaxReceived     int // newest Received fields kept on delivery, plus any signed; 0 = all
+	Rejections      int // recent rejections kept for the report; 0 = none
+	// DateCheck flags or refuses messages whose Date header is out of range.
+	DateCheck config.DateCheckConfig
+	// FirstContact reports the first message from each sender domain.
+	FirstContact config.FirstContactConfig
 	// TempDir is the directory for temporary message files during DATA.
 	// Defaults to os.TempDir() if empty.
 	TempDir string
@@ -67,13 +166,93 @@ func NewBackend(cfg BackendConfig) *Backend {
 		spamConfig:      cfg.SpamConfig,
 		rejectionMode:   cfg.RejectionMode,
 		notifier:        cfg.Notifier,
+		state:           cfg.StateStore,
 		collector:       cfg.Collector,
 		maxRecipients:   cfg.MaxRecipients,
 		maxMessageSize:  cfg.MaxMessageSize,
+		maxMIMEDepth:    cfg.MaxMIMEDepth,
+		maxMIMEParts:    cfg.MaxMIMEParts,
 		maxSendsPerHour: cfg.MaxSendsPerHour,
+		overloadMessage: cfg.OverloadMessage,
+		requireTLS:      cfg.RequireTLS,
+		addHeaders:      cfg.AddHeaders,
+		headerPolicy:    cfg.HeaderPolicy,
+		uniqueHeaders:   cfg.UniqueHeaders,
+		returnPath:      cfg.ReturnPath,
+		authUserHeader:  cfg.AuthUserHeader,
+		honeypotDir:     cfg.HoneypotDir,
+		roleMailbox:     cfg.RoleMailbox,
+		checkLocalFrom:  cfg.CheckLocalFrom,
+		rejectNullMX:    cfg.RejectNullMX,
 		tempDir:         cfg.TempDir,
 		logger:          logger,
+		authFailDelay:   cfg.Auth.GetFailDelay(),
+		authFailJitter:  cfg.Auth.GetFailJitter(),
+		messageTimeout:  cfg.MessageTimeout,
+		fcrdns:          cfg.FCrDNS,
+		domains:         cfg.Domains,
+		resolver:        net.DefaultResolver,
 	}
+	b.stopping, b.stop = context.WithCancel(context.Background())
+
+	if len(cfg.NoBounce) > 0 {
+		b.noBounce = make(map[string]bool, len(cfg.NoBounce))
+		for _, r := range cfg.NoBounce {
+			b.noBounce[strings.ToLower(r)] = true
+		}
+	}
+
+	if len(cfg.SendAs) > 0 {
+		b.sendAs = make(map[string]map[string]bool, len(cfg.SendAs))
+		for user, addrs := range cfg.SendAs {
+			permitted := make(map[string]bool, len(addrs))
+			for _, a := range addrs {
+				permitted[strings.ToLower(a)] = true
+			}
+			b.sendAs[strings.ToLower(user)] = permitted
+		}
+	}
+
+	if cfg.RoleMailbox != "" && len(cfg.RoleRecipients) > 0 {
+		b.roleRecipients = make(map[string]bool, len(cfg.RoleRecipients))
+		for _, r := range cfg.RoleRecipients {
+			b.roleRecipients[strings.ToLower(r)] = true
+		}
+	}
+
+	if b.state == nil {
+		b.state = kvstore.NewMemory()
+	}
+	if cfg.StateOnError == config.StateFailClosed {
+		b.stateGuard = &stateGuard{Store: b.state}
+		b.state = b.stateGuard
+	}
+	b.reputation = newIPReputation(cfg.Reputation, b.state, logger)
+	b.authThrottle = newAuthThrottle(cfg.AuthRate, b.state, logger)
+	b.dataPace = newDataPace(cfg.DataPace)
+	b.dateCheck = newDateCheck(cfg.DateCheck)
+	b.spamBypass = newSpamBypass(cfg.SpamConfig)
+	b.maxConnRecipients = cfg.MaxConnRcpts
+	b.senderPolicy = newSenderPolicy(cfg.DenySenders, cfg.AllowSenders)
+	b.webhook = cfg.Webhook
+	b.checkEncoding = cfg.CheckEncoding
+	b.inboundRate = newInboundRate(cfg.Domains, b.state, logger)
+	b.acceptWindows = newAcceptWindows(cfg.Domains)
+	b.sendWindows = newSendWindows(cfg.Users)
+	b.headerCharset = cfg.HeaderCharset
+	if cfg.Signals.IsEnabled() {
+		b.signals = &cfg.Signals
+	}
+	b.maxHeaderRcpts = cfg.MaxHeaderRcpts
+	b.maxReceived = cfg.MaxReceived
+	b.maintenance.Store(cfg.Maintenance)
+	b.warmup = cfg.Warmup
+	b.dataTransfers = newDataTransferLimit(cfg.MaxTransfers, b.state, logger)
+	b.userSessions = newUserSessionLimit(cfg.Auth, b.state, logger)
+	b.ipUsers = newIPUserLimit(cfg.Auth, b.state, logger)
+	b.senderStats = newSenderStats(cfg.Metrics, b.state, logger)
+	b.rejections = newRejectionLog(cfg.Rejections)
+	b.firstContact = newFirstContact(cfg.FirstContact, b.state, logger)
 
 	if cfg.RedisClient != nil {
 		b.senderRateLimiter = newRedisRateLimiter(
@@ -93,6 +272,32 @@ func NewBackend(cfg BackendConfig) *Backend {
 	return b
 }
 
+// Stop wakes sessions pausing before an AUTH reply so they do not hold up
+// shutdown. The backend keeps serving; pauses after Stop return at once.
+func (b *Backend) Stop() {
+	b.stop()
+}
+
+// SetMaintenance switches maintenance mode. While it is on, sessions still
+// greet and answer EHLO, but MAIL gets 421 and the connection is closed, so
+// senders retry later and nothing is lost.
+func (b *Backend) SetMaintenance(on bool) {
+	b.maintenance.Store(on)
+}
+
+// pause waits for d, or until Stop is called.
+func (b *Backend) pause(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-b.stopping.Done():
+	}
+}
+
 // NewSession is called for each new connection.
 // It implements the smtp.Backend interface.
 func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
@@ -107,6 +312,17 @@ func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
 		remoteAddr = c.Conn().RemoteAddr().String()
 	}
 
+	if b.honeypotDir != "" {
+		hs := &honeypotSession{
+			backend:    b,
+			conn:       c,
+			remoteAddr: remoteAddr,
+			logger:     logging.WithConnection(b.logger, remoteAddr).With(slog.Bool("honeypot", true)),
+		}
+		hs.logger.Info("honeypot connection", slog.String("client_ip", clientIP))
+		return hs, nil
+	}
+
 	return &Session{
 		backend:  b,
 		conn:     c,
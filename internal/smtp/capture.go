@@ -0,0 +1,106 @@
+package smtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// captureSelector decides which connections [smtpd.capture] records.
+type captureSelector struct {
+	dir        string
+	clients    []netip.Prefix
+	sampleRate float64
+}
+
+// newCaptureSelector returns nil when capture is disabled.
+func newCaptureSelector(cfg config.CaptureConfig) *captureSelector {
+	if !cfg.IsEnabled() {
+		return nil
+	}
+	return &captureSelector{
+		dir:        cfg.Dir,
+		clients:    cfg.GetClients(),
+		sampleRate: cfg.SampleRate,
+	}
+}
+
+// selects reports whether the connection from clientIP is captured: always
+// for a listed client, otherwise with probability sampleRate.
+func (c *captureSelector) selects(clientIP string) bool {
+	if addr, err := netip.ParseAddr(clientIP); err == nil {
+		addr = addr.Unmap()
+		for _, p := range c.clients {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return c.sampleRate > 0 && rand.Float64() < c.sampleRate
+}
+
+// sessionCapture writes one session's protocol lines to a JSON-lines file,
+// one captureRecord per line, in the order they crossed the wire. Lines come
+// from a transactionLog, so AUTH credentials are already redacted; DATA
+// lines are kept verbatim, dot-stuffing included, so the file can be
+// replayed against a server.
+type sessionCapture struct {
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	path string
+	err  error
+}
+
+// captureRecord is one protocol line in a capture file.
+type captureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"` // "client" or "server"
+	Data      string    `json:"data"`
+}
+
+// openSessionCapture creates <time>-<client>-<id>.jsonl in dir.
+func openSessionCapture(dir, clientIP string) (*sessionCapture, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s-%s.jsonl",
+		time.Now().UTC().Format("20060102T150405Z"),
+		strings.NewReplacer(":", "_", "/", "_").Replace(clientIP),
+		newQueueID())
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionCapture{f: f, enc: json.NewEncoder(f), path: path}, nil
+}
+
+// record is the transactionSink writing to the capture file. After the
+// first write error the rest of the session is dropped; Close reports it.
+func (c *sessionCapture) record(direction, data string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = c.enc.Encode(captureRecord{Time: time.Now().UTC(), Direction: direction, Data: data})
+}
+
+// Close closes the capture file, returning the first write error if any.
+func (c *sessionCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.f.Close(); c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
@@ -0,0 +1,161 @@
+package smtp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestCaptureSelector(t *testing.T) {
+	sel := newCaptureSelector(config.CaptureConfig{
+		Dir:     t.TempDir(),
+		Clients: []string{"192.0.2.10", "2001:db8::/32"},
+	})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.10", true},
+		{"::ffff:192.0.2.10", true},
+		{"192.0.2.11", false},
+		{"2001:db8::25", true},
+		{"2001:db9::25", false},
+		{"pipe", false},
+	}
+	for _, tt := range tests {
+		if got := sel.selects(tt.ip); got != tt.want {
+			t.Errorf("selects(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if newCaptureSelector(config.CaptureConfig{Clients: []string{"192.0.2.10"}}) != nil {
+		t.Error("capture without dir should be disabled")
+	}
+}
+
+func TestRunSingleConn_CapturesSession(t *testing.T) {
+	captureDir := t.TempDir()
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	srv, err := NewServer(ServerConfig{
+		Backend:      NewBackend(BackendConfig{Hostname: "test.local", SMDelivery: agent, TempDir: t.TempDir()}),
+		Listeners:    []config.ListenerConfig{{Address: "127.0.0.1:0", Mode: config.ModeSmtp}},
+		Hostname:     "test.local",
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		Capture:      config.CaptureConfig{Dir: captureDir, SampleRate: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.RunSingleConn(server, config.ModeSmtp, nil)
+	}()
+
+	r := bufio.NewReader(client)
+	expect := func(cmd, code string) {
+		t.Helper()
+		if cmd != "" {
+			if _, err := fmt.Fprintf(client, "%s\r\n", cmd); err != nil {
+				t.Fatalf("write %q: %v", cmd, err)
+			}
+		}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: read: %v", cmd, err)
+			}
+			if !strings.HasPrefix(line, code) {
+				t.Fatalf("%q: got %q, want %s", cmd, line, code)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	secret := base64.StdEncoding.EncodeToString([]byte("\x00alice@example.com\x00hunter2"))
+	expect("", "220")
+	expect("EHLO client.example", "250")
+	expect("AUTH PLAIN "+secret, "5") // no AUTH without TLS, but the line is still on the wire
+	expect("MAIL FROM:<sender@example.com>", "250")
+	expect("RCPT TO:<rcpt@example.com>", "250")
+	expect("DATA", "354")
+	// The mock has no delivery service, so the message is deferred; the
+	// capture only needs the exchange.
+	expect("Subject: captured\r\n\r\n..dot-stuffed\r\n.", "451")
+	expect("QUIT", "221")
+	waitDone(t, done)
+
+	files, _ := filepath.Glob(filepath.Join(captureDir, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("capture files = %v, want one", files)
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("read capture: %v", err)
+	}
+	if strings.Contains(string(raw), secret) {
+		t.Fatalf("capture contains AUTH credentials:\n%s", raw)
+	}
+
+	var got []string
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	for dec.More() {
+		var rec captureRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("decode capture: %v", err)
+		}
+		if rec.Time.IsZero() {
+			t.Errorf("record without time: %+v", rec)
+		}
+		got = append(got, rec.Direction+" "+rec.Data)
+	}
+
+	// Each entry must appear after the one before it.
+	want := []string{
+		"server 220 ",
+		"client EHLO client.example",
+		"server 250-",
+		"client AUTH PLAIN [redacted]",
+		"server 5",
+		"client MAIL FROM:<sender@example.com>",
+		"server 250 ",
+		"client RCPT TO:<rcpt@example.com>",
+		"server 250 ",
+		"client DATA",
+		"server 354 ",
+		"client Subject: captured",
+		"client ..dot-stuffed",
+		"client .",
+		"server 451 ",
+		"client QUIT",
+		"server 221 ",
+	}
+	i := 0
+	for _, line := range got {
+		if i < len(want) && strings.HasPrefix(line, want[i]) {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("capture missing %q in order; got:\n%s", want[i], strings.Join(got, "\n"))
+	}
+}
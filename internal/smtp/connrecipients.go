@@ -0,0 +1,29 @@
+package smtp
+
+import (
+	"log/slog"
+
+	"github.com/emersion/go-smtp"
+)
+
+// checkConnRecipients enforces [smtpd.limits] max_connection_recipients.
+// max_recipients bounds one transaction, but a client can RSET and start
+// over as often as it likes; this bounds the total across the connection.
+// Once the cap is reached the client has nothing left to do here, so send
+// 421 and close rather than refusing RCPT after RCPT.
+func (s *Session) checkConnRecipients() error {
+	max := s.backend.maxConnRecipients
+	if max <= 0 || s.connRecipients < max {
+		return nil
+	}
+	s.logger.Info("connection recipient limit reached, closing connection",
+		slog.Int("recipients", s.connRecipients),
+		slog.Int("max_connection_recipients", max))
+
+	e := &smtp.SMTPError{
+		Code:         421,
+		EnhancedCode: smtp.EnhancedCode{4, 5, 3},
+		Message:      "Too many recipients on this connection, closing",
+	}
+	return s.closeAfterReply(e)
+}
@@ -0,0 +1,95 @@
+package smtp
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errConnShare is the greeting for a connection from a client network
+// already holding its share of max_connections.
+var errConnShare = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many connections from your network",
+}
+
+// connShare caps the protocol-handlers one client network may hold at once
+// ([smtpd.limits] max_connection_share), so that a flood from a few
+// addresses cannot take every slot under max_connections and starve
+// everyone else. It lives in the long-running listener process, which sees
+// every connection, so it needs no state store.
+type connShare struct {
+	max    int // per network
+	prefix int // IPv4 prefix length
+	mu     sync.Mutex
+	held   map[netip.Prefix]int
+}
+
+// newConnShare returns nil when the share cap is disabled. Each network may
+// hold share of maxConns, and always at least one.
+func newConnShare(maxConns int, share float64, prefix int) *connShare {
+	if maxConns <= 0 || share <= 0 {
+		return nil
+	}
+	if prefix <= 0 || prefix > 32 {
+		prefix = 32
+	}
+	return &connShare{
+		max:    max(1, int(float64(maxConns)*share)),
+		prefix: prefix,
+		held:   make(map[netip.Prefix]int),
+	}
+}
+
+// network returns the group ip counts against, and false for an address
+// that cannot be parsed.
+func (c *connShare) network(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+	bits := 64
+	if addr.Is4() {
+		bits = c.prefix
+	}
+	p, err := addr.Prefix(bits)
+	return p, err == nil
+}
+
+// acquire takes a slot for a connection from ip, reporting false when its
+// network already holds its share. A successful acquire must be paired with
+// a release of the returned network. Unparseable addresses are admitted
+// uncounted.
+func (c *connShare) acquire(ip string) (netip.Prefix, bool) {
+	if c == nil {
+		return netip.Prefix{}, true
+	}
+	p, ok := c.network(ip)
+	if !ok {
+		return netip.Prefix{}, true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held[p] >= c.max {
+		return p, false
+	}
+	c.held[p]++
+	return p, true
+}
+
+// release frees a slot taken by acquire.
+func (c *connShare) release(p netip.Prefix) {
+	if c == nil || !p.IsValid() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held[p] <= 1 {
+		delete(c.held, p)
+		return
+	}
+	c.held[p]--
+}
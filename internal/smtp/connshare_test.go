@@ -0,0 +1,51 @@
+package smtp
+
+import "testing"
+
+func TestConnShare(t *testing.T) {
+	if c := newConnShare(100, 0, 0); c != nil {
+		t.Fatal("newConnShare() with no share should be disabled")
+	}
+	if _, ok := (*connShare)(nil).acquire("192.0.2.1"); !ok {
+		t.Fatal("a disabled share refused a connection")
+	}
+
+	c := newConnShare(10, 0.2, 24) // two per /24
+	var held []string
+	for i, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		n, ok := c.acquire(ip)
+		if want := i < 2; ok != want {
+			t.Fatalf("acquire(%s) = %v, want %v", ip, ok, want)
+		}
+		if ok {
+			held = append(held, n.String())
+		}
+	}
+	if _, ok := c.acquire("198.51.100.1"); !ok {
+		t.Error("another network was refused while 192.0.2.0/24 is at its share")
+	}
+
+	n, _ := c.network("192.0.2.1")
+	c.release(n)
+	if _, ok := c.acquire("192.0.2.200"); !ok {
+		t.Error("acquire after release was refused")
+	}
+	if held[0] != "192.0.2.0/24" {
+		t.Errorf("network = %s, want 192.0.2.0/24", held[0])
+	}
+
+	// IPv6 clients share their /64; the floor is one connection.
+	c6 := newConnShare(3, 0.1, 0)
+	if _, ok := c6.acquire("2001:db8::1"); !ok {
+		t.Fatal("first IPv6 connection refused")
+	}
+	if _, ok := c6.acquire("2001:db8::2"); ok {
+		t.Error("second connection from the same /64 admitted")
+	}
+	if _, ok := c6.acquire("2001:db8:0:1::1"); !ok {
+		t.Error("connection from another /64 refused")
+	}
+	if _, ok := c6.acquire("not an ip"); !ok {
+		t.Error("unparseable address refused")
+	}
+}
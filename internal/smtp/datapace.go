@@ -0,0 +1,76 @@
+package smtp
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// dataPace flags messages whose data arrived faster than [smtpd.data_pace]
+// max_bytes_per_second allows, timed from the 354 reply to the end of the
+// data. A bot typically writes the whole body and the final dot in one go;
+// a real MTA streams it over a TCP connection that takes time to carry it.
+// The minimum scales with size, and messages under min_size are exempt, so
+// a small message over a fast LAN never trips it.
+type dataPace struct {
+	rate    int64
+	minSize int64
+	action  config.DataPaceAction
+}
+
+// newDataPace returns nil when the check is disabled.
+func newDataPace(cfg config.DataPaceConfig) *dataPace {
+	if !cfg.IsEnabled() {
+		return nil
+	}
+	return &dataPace{
+		rate:    int64(cfg.MaxBytesPerSecond),
+		minSize: cfg.GetMinSize(),
+		action:  cfg.GetAction(),
+	}
+}
+
+// minDuration returns the shortest plausible transfer time for size bytes.
+func (p *dataPace) minDuration(size int64) time.Duration {
+	return time.Duration(float64(size) / float64(p.rate) * float64(time.Second))
+}
+
+// errDataTooFast is the DATA reply under action "defer".
+var errDataTooFast = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Message transferred too fast, try again later",
+}
+
+// checkDataPace judges a message of size bytes whose data took elapsed to
+// arrive. Under action "reputation" a suspect message still counts against
+// the client IP but is accepted; under "defer" it is refused with 451.
+func (s *Session) checkDataPace(size int64, elapsed time.Duration) (err error) {
+	p := s.backend.dataPace
+	if p == nil || size < p.minSize {
+		return nil
+	}
+	defer func() { s.filterDecision("data_pace", err) }()
+	minimum := p.minDuration(size)
+	if elapsed >= minimum {
+		return nil
+	}
+	s.logger.Info("message data arrived too fast",
+		slog.Int64("size", size),
+		slog.Duration("elapsed", elapsed),
+		slog.Duration("minimum", minimum),
+		slog.String("action", string(p.action)))
+
+	if p.action == config.DataPaceDefer {
+		if s.backend.collector != nil {
+			s.backend.collector.MessageRejected(sessionExtractRecipientDomain(s.recipients), "data_pace")
+		}
+		return errDataTooFast
+	}
+
+	s.noteSignal(config.SignalDataPace)
+	s.countRejection()
+	return nil
+}
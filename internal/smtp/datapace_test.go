@@ -0,0 +1,100 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// instantMessage is a message of about size bytes that a test hands to Data
+// all at once, as a bot dumping body and dot would.
+func instantMessage(size int) *strings.Reader {
+	return strings.NewReader("Subject: bulk\r\n\r\n" + strings.Repeat("spam spam spam\r\n", size/16))
+}
+
+func TestSession_CheckDataPace(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		DataPace: config.DataPaceConfig{MaxBytesPerSecond: 1 << 20, MinSize: 4096, Action: config.DataPaceDefer},
+	})
+	s := &Session{backend: backend, logger: slog.Default()}
+
+	tests := []struct {
+		name    string
+		size    int64
+		elapsed time.Duration
+		want    error
+	}{
+		{"tiny message, instant", 1000, 0, nil},
+		{"large message, instant", 1 << 20, 0, errDataTooFast},
+		{"large message, just under", 1 << 20, 999 * time.Millisecond, errDataTooFast},
+		{"large message, at the rate", 1 << 20, time.Second, nil},
+		{"small message scaled down", 8192, 10 * time.Millisecond, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.checkDataPace(tt.size, tt.elapsed); !errors.Is(err, tt.want) {
+				t.Errorf("checkDataPace(%d, %v) = %v, want %v", tt.size, tt.elapsed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_Data_TooFastDeferred(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{})
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		TempDir:    t.TempDir(),
+		DataPace:   config.DataPaceConfig{MaxBytesPerSecond: 64 << 10, Action: config.DataPaceDefer},
+	})
+	s := &Session{
+		backend:      backend,
+		clientIP:     "192.0.2.1",
+		from:         "bot@example.net",
+		mailFromSeen: true,
+		recipients:   []string{"bob@example.com"},
+		logger:       slog.Default(),
+	}
+
+	// 64 KiB at 64 KiB/s should take a second, not microseconds.
+	if err := s.Data(instantMessage(64 << 10)); !errors.Is(err, errDataTooFast) {
+		t.Fatalf("Data = %v, want %v", err, errDataTooFast)
+	}
+}
+
+func TestSession_Data_TooFastFeedsReputation(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	store := kvstore.NewMemory()
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		TempDir:    t.TempDir(),
+		StateStore: store,
+		Reputation: config.ReputationConfig{MaxRejections: 1},
+		DataPace:   config.DataPaceConfig{MaxBytesPerSecond: 64 << 10},
+	})
+	s := &Session{
+		backend:      backend,
+		clientIP:     "192.0.2.1",
+		from:         "bot@example.net",
+		mailFromSeen: true,
+		recipients:   []string{"bob@example.com"},
+		logger:       slog.Default(),
+	}
+
+	// The message is not refused for its pace; whatever delivery makes of
+	// it, the client IP has a strike against it.
+	if err := s.Data(instantMessage(64 << 10)); errors.Is(err, errDataTooFast) {
+		t.Fatalf("Data deferred under action reputation")
+	}
+	if !backend.reputation.blocked(context.Background(), "192.0.2.1") {
+		t.Error("fast transfer not counted against the client IP")
+	}
+}
@@ -0,0 +1,34 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/emersion/go-smtp"
+)
+
+// isTimeout reports whether err is a network timeout, such as the read
+// deadline go-smtp sets on the DATA command expiring.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// abortDataTimeout ends a transaction whose client stopped sending message
+// data. go-smtp would answer with the returned error and then wait for the
+// next command; the client has shown it is not talking, so send 421 and
+// close instead. Data's deferred cleanup releases the temp buffer.
+func (s *Session) abortDataTimeout() error {
+	if s.backend.collector != nil {
+		s.backend.collector.TransactionAborted("data_timeout")
+	}
+	s.logger.Info("data timeout, closing connection",
+		slog.String("from", s.from))
+
+	return s.closeAfterReply(&smtp.SMTPError{
+		Code:         421,
+		EnhancedCode: smtp.EnhancedCode{4, 4, 2},
+		Message:      "Data timeout, closing connection",
+	})
+}
@@ -0,0 +1,141 @@
+package smtp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/metrics"
+)
+
+// abortCollector records TransactionAborted calls.
+type abortCollector struct {
+	metrics.NoopCollector
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (c *abortCollector) TransactionAborted(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reasons = append(c.reasons, reason)
+}
+
+func (c *abortCollector) got() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.reasons...)
+}
+
+func TestData_StalledClientTimesOut(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name  string
+		setup func(*BackendConfig)
+	}{
+		{"no spam check", func(*BackendConfig) {}},
+		{"spam check reading the message", func(c *BackendConfig) {
+			c.SpamChecker = &slowChecker{}
+			c.SpamConfig = config.SpamCheckConfig{
+				Enabled:  true,
+				Checkers: []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+				FailMode: config.SpamCheckFailReject,
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &abortCollector{}
+			tempDir := t.TempDir()
+			agent := startMockSessionServer(t, &mockSessionService{
+				validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+			})
+			bcfg := BackendConfig{Hostname: "test.local", SMDelivery: agent, Collector: collector, TempDir: tempDir}
+			tt.setup(&bcfg)
+
+			srv, err := NewServer(ServerConfig{
+				Backend:      NewBackend(bcfg),
+				Listeners:    []config.ListenerConfig{{Address: "127.0.0.1:0", Mode: config.ModeSmtp}},
+				Hostname:     "test.local",
+				ReadTimeout:  300 * time.Millisecond,
+				WriteTimeout: 5 * time.Second,
+			})
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+
+			server, client := net.Pipe()
+			t.Cleanup(func() { _ = client.Close() })
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = srv.RunSingleConn(server, config.ModeSmtp, nil)
+			}()
+
+			r := bufio.NewReader(client)
+			expect := func(cmd, code string) {
+				t.Helper()
+				if cmd != "" {
+					if _, err := fmt.Fprintf(client, "%s\r\n", cmd); err != nil {
+						t.Fatalf("write %q: %v", cmd, err)
+					}
+				}
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						t.Fatalf("%q: read: %v", cmd, err)
+					}
+					if !strings.HasPrefix(line, code) {
+						t.Fatalf("%q: got %q, want %s", cmd, line, code)
+					}
+					if line[3] == ' ' {
+						return
+					}
+				}
+			}
+
+			expect("", "220")
+			expect("EHLO client.example", "250")
+			expect("MAIL FROM:<sender@example.com>", "250")
+			expect("RCPT TO:<rcpt@example.com>", "250")
+			expect("DATA", "354")
+			// Part of a message, then silence.
+			if _, err := fmt.Fprint(client, "Subject: stalled\r\n\r\npartial line\r\n"); err != nil {
+				t.Fatalf("write body: %v", err)
+			}
+
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read reply: %v", err)
+			}
+			if !strings.HasPrefix(line, "421 4.4.2 ") {
+				t.Fatalf("reply = %q, want 421 4.4.2", line)
+			}
+			if _, err := r.ReadString('\n'); err != io.EOF {
+				t.Errorf("connection not closed after 421: %v", err)
+			}
+			waitDone(t, done)
+
+			if got := collector.got(); len(got) != 1 || got[0] != "data_timeout" {
+				t.Errorf("TransactionAborted reasons = %v, want [data_timeout]", got)
+			}
+			entries, err := os.ReadDir(tempDayDir(tempDir, time.Now()))
+			if err != nil {
+				t.Fatalf("read temp dir: %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
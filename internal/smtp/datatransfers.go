@@ -0,0 +1,69 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// dataTransferLease bounds how long the transfer count lives in the state
+// store without being renewed (see acquireSlot). It comfortably exceeds a
+// DATA phase, which the connection timeout already limits.
+const dataTransferLease = 30 * time.Minute
+
+// dataTransferKey counts DATA phases in progress across every
+// protocol-handler sharing the state store.
+const dataTransferKey = "datatransfers"
+
+// dataTransferLimit caps DATA phases running at once server-wide
+// ([smtpd.limits] max_concurrent_data), since message bodies are what fill
+// the temp filesystem. State store errors fail open, as in reputation.go.
+type dataTransferLimit struct {
+	store  kvstore.Store
+	max    int
+	logger *slog.Logger
+}
+
+// newDataTransferLimit returns nil when the cap is disabled.
+func newDataTransferLimit(max int, store kvstore.Store, logger *slog.Logger) *dataTransferLimit {
+	if max <= 0 || store == nil {
+		return nil
+	}
+	return &dataTransferLimit{store: store, max: max, logger: logger}
+}
+
+// errTooManyTransfers is the DATA reply while every transfer slot is taken.
+var errTooManyTransfers = &smtp.SMTPError{
+	Code:         452,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+	Message:      "Too many concurrent transfers, try later",
+}
+
+// acquireDataTransfer takes a transfer slot for the current DATA phase. The
+// returned release must be called once the message has been handled. go-smtp
+// has already sent 354 when Data runs, so a refused message is read and
+// discarded by go-smtp without touching the temp filesystem.
+func (s *Session) acquireDataTransfer() (release func(), err error) {
+	l := s.backend.dataTransfers
+	if l == nil {
+		return func() {}, nil
+	}
+	ctx := context.Background()
+	ok, err := acquireSlot(ctx, l.store, dataTransferKey, l.max, dataTransferLease)
+	if err != nil {
+		l.logger.Debug("transfer count update failed", slog.String("error", err.Error()))
+	}
+	if !ok {
+		s.logger.Warn("too many concurrent transfers",
+			slog.Int("max_concurrent_data", l.max))
+		return nil, errTooManyTransfers
+	}
+	return func() {
+		if err := releaseSlot(ctx, l.store, dataTransferKey, dataTransferLease); err != nil {
+			l.logger.Debug("transfer count update failed", slog.String("error", err.Error()))
+		}
+	}, nil
+}
@@ -0,0 +1,67 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+)
+
+func TestSession_Data_MaxConcurrentData(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	store, _ := newRedisState(t)
+	backend := NewBackend(BackendConfig{
+		SMDelivery:   agent,
+		StateStore:   store,
+		TempDir:      t.TempDir(),
+		MaxTransfers: 2,
+	})
+	newSession := func() *Session {
+		return &Session{
+			backend:      backend,
+			from:         "sender@example.net",
+			mailFromSeen: true,
+			recipients:   []string{"bob@example.com"},
+			logger:       slog.Default(),
+		}
+	}
+
+	// Two transfers in progress fill the cap.
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := newSession().acquireDataTransfer()
+		if err != nil {
+			t.Fatalf("transfer %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+
+	err := newSession().Data(strings.NewReader("Subject: x\r\n\r\nbody\r\n"))
+	if !errors.Is(err, errTooManyTransfers) {
+		t.Fatalf("Data with cap full = %v, want %v", err, errTooManyTransfers)
+	}
+
+	// Once a transfer ends, the next DATA gets past the cap (and on to
+	// delivery, which the mock does not provide).
+	releases[0]()
+	err = newSession().Data(strings.NewReader("Subject: x\r\n\r\nbody\r\n"))
+	if errors.Is(err, errTooManyTransfers) {
+		t.Fatal("Data still refused after a transfer ended")
+	}
+
+	// The finished DATA gave its slot back too.
+	if _, err := newSession().acquireDataTransfer(); err != nil {
+		t.Errorf("slot not released after DATA: %v", err)
+	}
+	releases[1]()
+}
+
+func TestDataTransferLimit_Disabled(t *testing.T) {
+	if l := newDataTransferLimit(0, nil, slog.Default()); l != nil {
+		t.Error("expected nil limit when max_concurrent_data is 0")
+	}
+}
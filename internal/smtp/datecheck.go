@@ -0,0 +1,127 @@
+package smtp
+
+import (
+	"io"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// errDateOutOfRange is the DATA reply under date_check action "reject".
+var errDateOutOfRange = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+	Message:      "Date header out of range",
+}
+
+// dateCheck flags messages whose Date header lies further from the server's
+// clock than [smtpd.date_check] max_future or max_past allows.
+type dateCheck struct {
+	maxFuture time.Duration // 0 = not checked
+	maxPast   time.Duration // 0 = not checked
+	action    config.DateCheckAction
+	now       func() time.Time
+}
+
+// newDateCheck returns nil when the check is disabled.
+func newDateCheck(cfg config.DateCheckConfig) *dateCheck {
+	if !cfg.IsEnabled() {
+		return nil
+	}
+	return &dateCheck{
+		maxFuture: cfg.GetMaxFuture(),
+		maxPast:   cfg.GetMaxPast(),
+		action:    cfg.GetAction(),
+		now:       time.Now,
+	}
+}
+
+// skew returns how far date is outside the allowed range, positive for the
+// future and negative for the past, and 0 when it is inside.
+func (c *dateCheck) skew(date time.Time) time.Duration {
+	d := date.Sub(c.now())
+	switch {
+	case c.maxFuture > 0 && d > c.maxFuture:
+		return d
+	case c.maxPast > 0 && -d > c.maxPast:
+		return d
+	}
+	return 0
+}
+
+// dateLayouts are the non-RFC 5322 forms seen in the wild that
+// parseMessageDate accepts after net/mail gives up.
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC850,
+	time.ANSIC,
+	"Mon, 2 Jan 2006 15:04:05", // no zone: read as UTC
+	"2 Jan 2006 15:04:05",
+}
+
+// parseMessageDate parses a Date field value. net/mail covers RFC 5322 and
+// its obsolete syntax (two-digit years, named zones, comments); a few
+// common malformed forms are tried after it.
+func parseMessageDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if t, err := mail.ParseDate(value); err == nil {
+		return t, true
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkDate judges the Date header of the message in r. Under action
+// "reputation" an out-of-range date records the date_skew signal and
+// counts against the client IP, and the message is accepted; under
+// "reject" it is refused with 550. Missing and unparseable dates pass:
+// require_headers decides about those.
+func (s *Session) checkDate(r io.Reader) (err error) {
+	c := s.backend.dateCheck
+	if c == nil {
+		return nil
+	}
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil
+	}
+	value := msg.Header.Get("Date")
+	if value == "" {
+		return nil
+	}
+	date, ok := parseMessageDate(value)
+	if !ok {
+		s.logger.Debug("unparseable Date header", slog.String("date", value))
+		return nil
+	}
+
+	defer func() { s.filterDecision("date_check", err) }()
+	skew := c.skew(date)
+	if skew == 0 {
+		return nil
+	}
+	s.logger.Info("Date header out of range",
+		slog.String("date", value),
+		slog.Duration("skew", skew),
+		slog.String("action", string(c.action)))
+
+	if c.action == config.DateCheckReject {
+		if s.backend.collector != nil {
+			s.backend.collector.MessageRejected(sessionExtractRecipientDomain(s.recipients), "date_check")
+		}
+		return errDateOutOfRange
+	}
+
+	s.noteSignal(config.SignalDateSkew)
+	s.countRejection()
+	return nil
+}
@@ -0,0 +1,109 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+func TestParseMessageDate(t *testing.T) {
+	want := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	tests := []string{
+		"Mon, 2 Mar 2026 09:30:00 +0000",
+		"Mon,  2 Mar 2026 10:30:00 +0100 (CET)",
+		"2 Mar 26 09:30:00 GMT",
+		"mon, 02 mar 2026 09:30:00 UT",
+		"Mon, 2 Mar 2026 09:30:00",
+		"Mon Mar  2 09:30:00 2026",
+		"2026-03-02T09:30:00Z",
+	}
+	for _, value := range tests {
+		got, ok := parseMessageDate(value)
+		if !ok || !got.Equal(want) {
+			t.Errorf("parseMessageDate(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	if _, ok := parseMessageDate("yesterday"); ok {
+		t.Error("parseMessageDate(yesterday) succeeded")
+	}
+}
+
+func TestSession_CheckDate(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	message := func(date string) *strings.Reader {
+		return strings.NewReader("From: alice@example.net\r\nDate: " + date + "\r\nSubject: hi\r\n\r\nbody\r\n")
+	}
+
+	tests := []struct {
+		name       string
+		date       string
+		action     config.DateCheckAction
+		wantErr    error
+		wantSignal bool
+	}{
+		{"reasonable date", "Mon, 2 Mar 2026 08:00:00 +0000", config.DateCheckReject, nil, false},
+		{"slightly ahead", "Mon, 2 Mar 2026 11:00:00 +0000", config.DateCheckReject, nil, false},
+		{"far future rejected", "Fri, 1 Jan 2038 00:00:00 +0000", config.DateCheckReject, errDateOutOfRange, false},
+		{"far past rejected", "Thu, 1 Jan 1970 00:00:00 +0000", config.DateCheckReject, errDateOutOfRange, false},
+		{"far future flagged", "Fri, 1 Jan 2038 00:00:00 +0000", config.DateCheckReputation, nil, true},
+		{"far past flagged", "Thu, 1 Jan 1970 00:00:00 +0000", config.DateCheckReputation, nil, true},
+		{"unparseable passes", "sometime", config.DateCheckReject, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := NewBackend(BackendConfig{
+				StateStore: kvstore.NewMemory(),
+				Reputation: config.ReputationConfig{MaxRejections: 1},
+				Signals:    config.SignalsConfig{RejectWeight: 10},
+				DateCheck:  config.DateCheckConfig{MaxFuture: "24h", MaxPast: "720h", Action: tt.action},
+			})
+			backend.dateCheck.now = func() time.Time { return now }
+			s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+
+			if err := s.checkDate(message(tt.date)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkDate(%q) = %v, want %v", tt.date, err, tt.wantErr)
+			}
+			if got := slices.Contains(s.signals, config.SignalDateSkew); got != tt.wantSignal {
+				t.Errorf("date_skew signal = %v, want %v", got, tt.wantSignal)
+			}
+			if got := backend.reputation.blocked(context.Background(), "192.0.2.1"); got != tt.wantSignal {
+				t.Errorf("counted against the client IP = %v, want %v", got, tt.wantSignal)
+			}
+		})
+	}
+}
+
+func TestNewDateCheck_Disabled(t *testing.T) {
+	if newDateCheck(config.DateCheckConfig{Action: config.DateCheckReject}) != nil {
+		t.Error("date check enabled without max_future or max_past")
+	}
+}
+
+func TestSession_Data_DateOutOfRange(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{})
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		TempDir:    t.TempDir(),
+		DateCheck:  config.DateCheckConfig{MaxFuture: "24h", Action: config.DateCheckReject},
+	})
+	s := &Session{
+		backend:      backend,
+		clientIP:     "192.0.2.1",
+		from:         "bot@example.net",
+		mailFromSeen: true,
+		recipients:   []string{"bob@example.com"},
+		logger:       slog.Default(),
+	}
+
+	msg := "From: bot@example.net\r\nDate: Fri, 1 Jan 2100 00:00:00 +0000\r\n\r\nbody\r\n"
+	if err := s.Data(strings.NewReader(msg)); !errors.Is(err, errDateOutOfRange) {
+		t.Fatalf("Data = %v, want %v", err, errDateOutOfRange)
+	}
+}
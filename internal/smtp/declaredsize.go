@@ -0,0 +1,37 @@
+package smtp
+
+import (
+	"log/slog"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errSizeMismatch refuses a message larger than the SIZE its client
+// declared in MAIL FROM (RFC 1870 §6.1).
+var errSizeMismatch = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Message size exceeds the SIZE declared in MAIL FROM",
+}
+
+// checkDeclaredSize compares the size of the message as read with the SIZE
+// parameter of MAIL FROM. go-smtp only holds SIZE against max_message_size;
+// a client that declares a small message and sends a large one is
+// misrepresenting it, which a legitimate MTA computing SIZE from its queue
+// file does not do. The 552 counts against the client IP like any other
+// permanent rejection.
+func (s *Session) checkDeclaredSize(read int64, queueID string) error {
+	if s.declaredSize <= 0 || read <= s.declaredSize {
+		return nil
+	}
+	if s.backend.collector != nil {
+		s.backend.collector.SizeMismatch()
+		s.backend.collector.MessageRejected(sessionExtractRecipientDomain(s.recipients), "size_mismatch")
+	}
+	s.logger.Warn("message larger than declared SIZE",
+		slog.String("queue_id", queueID),
+		slog.String("from", s.from),
+		slog.Int64("declared", s.declaredSize),
+		slog.Int64("read", read))
+	return errSizeMismatch
+}
@@ -0,0 +1,80 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/metrics"
+)
+
+// sizeCollector counts SizeMismatch calls.
+type sizeCollector struct {
+	metrics.NoopCollector
+	mu         sync.Mutex
+	mismatches int
+}
+
+func (c *sizeCollector) SizeMismatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mismatches++
+}
+
+func TestSession_Data_DeclaredSize(t *testing.T) {
+	body := "Subject: hi\r\n\r\n" + strings.Repeat("x", 1000) + "\r\n"
+	tests := []struct {
+		name         string
+		declared     int64
+		want         error
+		wantMismatch int
+	}{
+		{"no SIZE", 0, nil, 0},
+		{"SIZE exact", int64(len(body)), nil, 0},
+		{"SIZE generous", 1 << 20, nil, 0},
+		{"SIZE too small", 100, errSizeMismatch, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &sizeCollector{}
+			s := &Session{
+				backend: NewBackend(BackendConfig{
+					SMDelivery: startMockSessionServer(t, &mockSessionService{}),
+					TempDir:    t.TempDir(),
+					Collector:  collector,
+				}),
+				clientIP:   "192.0.2.1",
+				recipients: []string{"bob@example.com"},
+				logger:     slog.Default(),
+			}
+			if err := s.Mail("liar@example.net", &gosmtp.MailOptions{Size: tt.declared}); err != nil {
+				t.Fatalf("Mail: %v", err)
+			}
+
+			err := s.Data(strings.NewReader(body))
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Data = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && errors.Is(err, errSizeMismatch) {
+				t.Errorf("Data = %v, want no size mismatch", err)
+			}
+			if collector.mismatches != tt.wantMismatch {
+				t.Errorf("SizeMismatch called %d times, want %d", collector.mismatches, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestSession_Reset_ClearsDeclaredSize(t *testing.T) {
+	s := &Session{backend: NewBackend(BackendConfig{}), logger: slog.Default()}
+	if err := s.Mail("a@example.net", &gosmtp.MailOptions{Size: 100}); err != nil {
+		t.Fatalf("Mail: %v", err)
+	}
+	s.Reset()
+	if s.declaredSize != 0 {
+		t.Errorf("declaredSize = %d after Reset, want 0", s.declaredSize)
+	}
+}
@@ -0,0 +1,96 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// RejectedError indicates the delivery agent refused the message. Reason is
+// the agent's free-text explanation, logged but never sent to the client.
+type RejectedError struct {
+	Temporary bool
+	Reason    string
+}
+
+func (e *RejectedError) Error() string {
+	code := "550"
+	if e.Temporary {
+		code = "451"
+	}
+	return fmt.Sprintf("delivery rejected (%s): %s", code, e.Reason)
+}
+
+// errDeliveryFailed is the reply for a delivery failure that says nothing
+// more specific: transport errors, redirects and unclassified temporary
+// rejections.
+var errDeliveryFailed = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Delivery failed",
+}
+
+// Rejection reason fragments, matched case-insensitively. The session-manager
+// reports only temporary-or-not and a reason string, so the reason picks the
+// enhanced status code (RFC 3463).
+var (
+	noMailboxReasons = []string{"does not exist", "no such", "user unknown", "unknown user", "not found"}
+	quotaReasons     = []string{"quota", "mailbox full"}
+	storageReasons   = []string{"disk", "no space", "storage", "enospc"}
+)
+
+// deliveryReply maps an error from SessionManagerDeliveryAgent.Deliver to
+// the DATA reply: a permanent rejection gets a 5xx the client will not
+// retry, a full mailbox or disk a 452, anything else 451.
+func deliveryReply(err error) *smtp.SMTPError {
+	var rej *RejectedError
+	if !errors.As(err, &rej) {
+		return errDeliveryFailed
+	}
+	reason := strings.ToLower(rej.Reason)
+	switch {
+	case rej.Temporary && reasonMatches(reason, quotaReasons):
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 2, 2},
+			Message:      "Mailbox full, try again later",
+		}
+	case rej.Temporary && reasonMatches(reason, storageReasons):
+		return &smtp.SMTPError{
+			Code:         452,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+			Message:      "Insufficient system storage, try again later",
+		}
+	case rej.Temporary:
+		return errDeliveryFailed
+	case reasonMatches(reason, noMailboxReasons):
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "User unknown",
+		}
+	case reasonMatches(reason, quotaReasons):
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 2, 2},
+			Message:      "Mailbox full",
+		}
+	default:
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 2, 0},
+			Message:      "Mailbox unavailable",
+		}
+	}
+}
+
+func reasonMatches(reason string, fragments []string) bool {
+	for _, f := range fragments {
+		if strings.Contains(reason, f) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	gosmtp "github.com/emersion/go-smtp"
+	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestSession_Data_DeliveryRejection(t *testing.T) {
+	tests := []struct {
+		name      string
+		temporary bool
+		reason    string
+		wantCode  int
+		wantEnh   gosmtp.EnhancedCode
+	}{
+		{"no mailbox", false, "mailbox does not exist", 550, gosmtp.EnhancedCode{5, 1, 1}},
+		{"permanent quota", false, "Quota exceeded", 552, gosmtp.EnhancedCode{5, 2, 2}},
+		{"permanent other", false, "mailbox disabled", 550, gosmtp.EnhancedCode{5, 2, 0}},
+		{"temporary quota", true, "over quota", 452, gosmtp.EnhancedCode{4, 2, 2}},
+		{"disk full", true, "write tmp: no space left on device", 452, gosmtp.EnhancedCode{4, 3, 1}},
+		{"temporary other", true, "mail-session busy", 451, gosmtp.EnhancedCode{4, 3, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socketPath := startMockServer(t, &mockDeliveryServer{
+				result:    pb.DeliverResult_DELIVER_RESULT_REJECTED,
+				temporary: tt.temporary,
+				reason:    tt.reason,
+			})
+			agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: socketPath}, nil)
+			if err != nil {
+				t.Fatalf("new agent: %v", err)
+			}
+			defer func() { _ = agent.Close() }()
+
+			session := &Session{
+				backend:      &Backend{smDelivery: agent, tempDir: t.TempDir(), logger: slog.Default()},
+				mailFromSeen: true,
+				from:         "sender@example.com",
+				recipients:   []string{"user@example.com"},
+				logger:       slog.Default(),
+			}
+			err = session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+
+			var smtpErr *gosmtp.SMTPError
+			if !errors.As(err, &smtpErr) {
+				t.Fatalf("Data() = %v, want SMTPError", err)
+			}
+			if smtpErr.Code != tt.wantCode || smtpErr.EnhancedCode != tt.wantEnh {
+				t.Errorf("Data() = %d %v, want %d %v", smtpErr.Code, smtpErr.EnhancedCode, tt.wantCode, tt.wantEnh)
+			}
+			if strings.Contains(smtpErr.Message, tt.reason) {
+				t.Errorf("reply %q leaks the agent's reason", smtpErr.Message)
+			}
+		})
+	}
+}
+
+// TestSession_Data_NullSenderDeliveryFailure pins that a bounce whose
+// delivery fails is refused at DATA with the sender passed on empty, so the
+// failure stays with the client and nothing here can bounce the bounce.
+func TestSession_Data_NullSenderDeliveryFailure(t *testing.T) {
+	for _, temporary := range []bool{false, true} {
+		mock := &mockDeliveryServer{
+			result:    pb.DeliverResult_DELIVER_RESULT_REJECTED,
+			temporary: temporary,
+			reason:    "mailbox disabled",
+		}
+		agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: startMockServer(t, mock)}, nil)
+		if err != nil {
+			t.Fatalf("new agent: %v", err)
+		}
+		defer func() { _ = agent.Close() }()
+
+		session := &Session{
+			backend:      &Backend{smDelivery: agent, tempDir: t.TempDir(), logger: slog.Default()},
+			mailFromSeen: true,
+			from:         "",
+			recipients:   []string{"user@example.com"},
+			logger:       slog.Default(),
+		}
+		err = session.Data(strings.NewReader("Subject: Undelivered Mail\r\n\r\nBody\r\n"))
+
+		var smtpErr *gosmtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Temporary() != temporary {
+			t.Errorf("temporary=%v: Data() = %v, want a refusal at DATA", temporary, err)
+		}
+		if mock.metadata == nil || mock.metadata.GetSender() != "" {
+			t.Errorf("temporary=%v: delivered sender = %q, want the null sender", temporary, mock.metadata.GetSender())
+		}
+	}
+}
+
+func TestDeliveryReply_Unclassified(t *testing.T) {
+	for _, err := range []error{
+		errors.New("session-manager delivery: open stream: connection refused"),
+		&RedirectError{Addresses: []string{"elsewhere@example.com"}},
+	} {
+		if got := deliveryReply(err); got != errDeliveryFailed {
+			t.Errorf("deliveryReply(%v) = %v, want %v", err, got, errDeliveryFailed)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// fcrdnsTimeout bounds the PTR and forward lookups for one client.
+const fcrdnsTimeout = 10 * time.Second
+
+// maxPTRNames caps the PTR names forward-resolved for one client, so an IP
+// with a long PTR set cannot make smtpd issue a lookup per name.
+const maxPTRNames = 5
+
+// dnsResolver is the part of *net.Resolver the FCrDNS and null MX checks
+// use.
+type dnsResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+var (
+	errFCrDNSDefer = &smtp.SMTPError{
+		Code:         450,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 25},
+		Message:      "Client IP has no forward-confirmed reverse DNS, try again later",
+	}
+	errFCrDNSReject = &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 25},
+		Message:      "Client IP has no forward-confirmed reverse DNS",
+	}
+	errFCrDNSLookup = &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 4, 3},
+		Message:      "Reverse DNS lookup failed, try again later",
+	}
+)
+
+// lookupFCrDNS returns the first PTR name of ip that resolves back to ip, or
+// "" if none does. err is set only when a lookup failed for a reason other
+// than a missing record, so the caller can defer instead of judging the
+// client on an incomplete answer.
+func lookupFCrDNS(ctx context.Context, r dnsResolver, ip string) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", nil
+	}
+	names, err := r.LookupAddr(ctx, ip)
+	if err != nil {
+		if isDNSNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	var lookupErr error
+	for i, name := range names {
+		if i == maxPTRNames {
+			break
+		}
+		ips, err := r.LookupIPAddr(ctx, name)
+		if err != nil {
+			if !isDNSNotFound(err) {
+				lookupErr = err
+			}
+			continue
+		}
+		for _, a := range ips {
+			if a.IP.Equal(addr) {
+				return name, nil
+			}
+		}
+	}
+	return "", lookupErr
+}
+
+func isDNSNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// checkFCrDNS applies [smtpd] require_fcrdns to an unauthenticated client.
+// A confirmed name is remembered for the rest of the session; a failed
+// lookup is retried at the next MAIL. Under "signal" nothing is refused:
+// a client without FCrDNS only records the no_fcrdns signal.
+func (s *Session) checkFCrDNS(ctx context.Context) (err error) {
+	policy := s.backend.fcrdns
+	if policy == "" || policy == config.FCrDNSOff || s.authUser != "" ||
+		s.fcrdnsName != "" || sessionIsLocalhost(s.clientIP) {
+		return nil
+	}
+	defer func() { s.filterDecision("fcrdns", err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, fcrdnsTimeout)
+	defer cancel()
+	name, err := lookupFCrDNS(ctx, s.backend.resolver, s.clientIP)
+	if err != nil {
+		s.logger.Info("reverse DNS lookup failed", slog.String("error", err.Error()))
+		if policy == config.FCrDNSSignal {
+			return nil
+		}
+		return errFCrDNSLookup
+	}
+	if name == "" && policy == config.FCrDNSSignal {
+		s.noteSignal(config.SignalNoFCrDNS)
+		return nil
+	}
+	if name == "" {
+		s.logger.Info("client refused: no forward-confirmed reverse DNS",
+			slog.String("client_ip", s.clientIP),
+			slog.String("policy", string(policy)))
+		if policy == config.FCrDNSReject {
+			return errFCrDNSReject
+		}
+		return errFCrDNSDefer
+	}
+	s.fcrdnsName = name
+	return nil
+}
@@ -0,0 +1,142 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// mockResolver answers from fixed PTR and A/AAAA tables; a missing entry is
+// NXDOMAIN and an entry in fail is a server failure.
+type mockResolver struct {
+	ptr     map[string][]string
+	forward map[string][]string
+	mx      map[string][]*net.MX
+	fail    map[string]bool
+	lookups int
+}
+
+func (r *mockResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.lookups++
+	if r.fail[addr] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: addr, IsTemporary: true}
+	}
+	names, ok := r.ptr[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (r *mockResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups++
+	if r.fail[host] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	addrs, ok := r.forward[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var ips []net.IPAddr
+	for _, a := range addrs {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(a)})
+	}
+	return ips, nil
+}
+
+func (r *mockResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	r.lookups++
+	if r.fail[name] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	mxs, ok := r.mx[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return mxs, nil
+}
+
+func TestSession_Mail_RequireFCrDNS(t *testing.T) {
+	resolver := &mockResolver{
+		ptr: map[string][]string{
+			"192.0.2.10":  {"mail.example.com."},
+			"192.0.2.20":  {"spoofed.example.com."},
+			"192.0.2.30":  {"dangling.example.com."},
+			"2001:db8::1": {"other.example.net.", "mx.example.net."},
+			"192.0.2.50":  {"flaky.example.com."},
+		},
+		forward: map[string][]string{
+			"mail.example.com.":    {"192.0.2.10"},
+			"spoofed.example.com.": {"198.51.100.7"},
+			"other.example.net.":   {"2001:db8::99"},
+			"mx.example.net.":      {"2001:db8::1"},
+		},
+		fail: map[string]bool{"flaky.example.com.": true},
+	}
+
+	tests := []struct {
+		name     string
+		policy   config.FCrDNSPolicy
+		clientIP string
+		authUser string
+		wantErr  error
+	}{
+		{"confirmed", config.FCrDNSReject, "192.0.2.10", "", nil},
+		{"confirmed by second PTR name", config.FCrDNSReject, "2001:db8::1", "", nil},
+		{"forward mismatch rejected", config.FCrDNSReject, "192.0.2.20", "", errFCrDNSReject},
+		{"forward mismatch deferred", config.FCrDNSDefer, "192.0.2.20", "", errFCrDNSDefer},
+		{"no forward record", config.FCrDNSReject, "192.0.2.30", "", errFCrDNSReject},
+		{"no PTR", config.FCrDNSReject, "192.0.2.40", "", errFCrDNSReject},
+		{"lookup failure defers", config.FCrDNSReject, "192.0.2.50", "", errFCrDNSLookup},
+		{"authenticated exempt", config.FCrDNSReject, "192.0.2.40", "user@example.com", nil},
+		{"localhost exempt", config.FCrDNSReject, "127.0.0.1", "", nil},
+		{"off", config.FCrDNSOff, "192.0.2.40", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{
+				backend:  &Backend{fcrdns: tt.policy, resolver: resolver, logger: slog.Default()},
+				clientIP: tt.clientIP,
+				authUser: tt.authUser,
+				logger:   slog.Default(),
+			}
+			from := "sender@example.org"
+			if tt.authUser != "" {
+				from = tt.authUser
+			}
+			err := session.Mail(from, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Mail() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSession_CheckFCrDNS_RemembersName(t *testing.T) {
+	resolver := &mockResolver{
+		ptr:     map[string][]string{"192.0.2.10": {"mail.example.com."}},
+		forward: map[string][]string{"mail.example.com.": {"192.0.2.10"}},
+	}
+	session := &Session{
+		backend:  &Backend{fcrdns: config.FCrDNSReject, resolver: resolver, logger: slog.Default()},
+		clientIP: "192.0.2.10",
+		logger:   slog.Default(),
+	}
+
+	for range 3 {
+		if err := session.checkFCrDNS(context.Background()); err != nil {
+			t.Fatalf("checkFCrDNS() = %v", err)
+		}
+	}
+	if session.fcrdnsName != "mail.example.com." {
+		t.Errorf("fcrdnsName = %q, want mail.example.com.", session.fcrdnsName)
+	}
+	if resolver.lookups != 2 {
+		t.Errorf("lookups = %d, want 2 (one PTR, one forward)", resolver.lookups)
+	}
+}
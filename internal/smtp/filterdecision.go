@@ -0,0 +1,34 @@
+package smtp
+
+import (
+	"errors"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Actions counted by smtpd_filter_decisions_total.
+const (
+	filterAccept = "accept"
+	filterDefer  = "defer"
+	filterReject = "reject"
+)
+
+// filterDecision counts the outcome of a filter stage that ran: a nil err
+// is accept, a 4xx reply defer and anything else reject. Call it only when
+// the stage is enabled, so the accept counts form a funnel.
+func (s *Session) filterDecision(stage string, err error) {
+	if s.backend.collector != nil {
+		s.backend.collector.FilterDecision(stage, filterAction(err))
+	}
+}
+
+func filterAction(err error) string {
+	if err == nil {
+		return filterAccept
+	}
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) && smtpErr.Code >= 400 && smtpErr.Code < 500 {
+		return filterDefer
+	}
+	return filterReject
+}
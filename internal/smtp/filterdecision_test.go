@@ -0,0 +1,224 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+	"github.com/infodancer/smtpd/internal/metrics"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+// filterCollector records FilterDecision calls as "stage/action".
+type filterCollector struct {
+	metrics.NoopCollector
+	decisions []string
+}
+
+func (c *filterCollector) FilterDecision(stage, action string) {
+	c.decisions = append(c.decisions, stage+"/"+action)
+}
+
+func (c *filterCollector) want(t *testing.T, want ...string) {
+	t.Helper()
+	if strings.Join(c.decisions, ",") != strings.Join(want, ",") {
+		t.Errorf("decisions = %v, want %v", c.decisions, want)
+	}
+	c.decisions = nil
+}
+
+func TestFilterAction(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, filterAccept},
+		{errDomainRate, filterDefer},
+		{errFCrDNSReject, filterReject},
+		{errors.New("not an SMTP reply"), filterReject},
+	}
+	for _, tt := range tests {
+		if got := filterAction(tt.err); got != tt.want {
+			t.Errorf("filterAction(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFilterDecision_MailStages(t *testing.T) {
+	c := &filterCollector{}
+	resolver := &mockResolver{
+		ptr:     map[string][]string{"192.0.2.10": {"mail.example.com."}},
+		forward: map[string][]string{"mail.example.com.": {"192.0.2.10"}},
+	}
+	session := func(ip string) *Session {
+		return &Session{
+			backend: &Backend{
+				collector:    c,
+				senderPolicy: newSenderPolicy([]string{"*@spam.example"}, nil),
+				fcrdns:       config.FCrDNSDefer,
+				resolver:     resolver,
+				logger:       slog.Default(),
+			},
+			clientIP: ip,
+			logger:   slog.Default(),
+		}
+	}
+
+	if err := session("192.0.2.10").Mail("a@example.com", nil); err != nil {
+		t.Fatalf("Mail() = %v", err)
+	}
+	c.want(t, "sender_policy/accept", "fcrdns/accept")
+
+	if err := session("192.0.2.10").Mail("a@spam.example", nil); err == nil {
+		t.Fatal("Mail() from a denied sender succeeded")
+	}
+	c.want(t, "sender_policy/reject")
+
+	if err := session("192.0.2.40").Mail("a@example.com", nil); !errors.Is(err, errFCrDNSDefer) {
+		t.Fatalf("Mail() = %v, want %v", err, errFCrDNSDefer)
+	}
+	c.want(t, "sender_policy/accept", "fcrdns/defer")
+
+	// Bounces skip the sender policy rather than pass it.
+	if err := session("192.0.2.10").Mail("", nil); err != nil {
+		t.Fatalf("Mail(<>) = %v", err)
+	}
+	c.want(t, "fcrdns/accept")
+}
+
+func TestFilterDecision_SpamPrecheck(t *testing.T) {
+	c := &filterCollector{}
+	checker := &verdictChecker{result: &spamcheck.CheckResult{Action: spamcheck.ActionReject}}
+	backend := precheckBackend(checker, config.SpamPrecheckMail, 0)
+	backend.collector = c
+	s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+
+	_ = s.precheckSpam(context.Background(), config.SpamPrecheckMail, "a@example.com", nil)
+	c.want(t, "spam_precheck/reject")
+
+	checker.result = &spamcheck.CheckResult{Action: spamcheck.ActionAccept}
+	_ = s.precheckSpam(context.Background(), config.SpamPrecheckMail, "a@example.com", nil)
+	c.want(t, "spam_precheck/accept")
+
+	// The precheck runs at MAIL only, so RCPT records nothing.
+	_ = s.precheckSpam(context.Background(), config.SpamPrecheckRcpt, "a@example.com", []string{"b@example.com"})
+	c.want(t)
+}
+
+func TestFilterDecision_RcptStages(t *testing.T) {
+	c := &filterCollector{}
+	domains := config.DomainsConfig{
+		"office.example": {AcceptHours: "08:00-18:00", TimeZone: "UTC"},
+		"busy.example":   {InboundRatePerMinute: 1},
+	}
+	windows := newAcceptWindows(domains)
+	s := &Session{
+		backend: &Backend{
+			collector:     c,
+			acceptWindows: windows,
+			inboundRate:   newInboundRate(domains, kvstore.NewMemory(), slog.Default()),
+			logger:        slog.Default(),
+		},
+		logger: slog.Default(),
+	}
+
+	windows.now = func() time.Time { return time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC) }
+	_ = s.checkAcceptWindow("office.example")
+	windows.now = func() time.Time { return time.Date(2026, 3, 2, 20, 0, 0, 0, time.UTC) }
+	_ = s.checkAcceptWindow("office.example")
+	c.want(t, "accept_window/accept", "accept_window/defer")
+
+	_ = s.checkInboundRate("busy.example")
+	_ = s.checkInboundRate("busy.example")
+	_ = s.checkInboundRate("office.example") // no limit
+	c.want(t, "inbound_rate/accept", "inbound_rate/defer")
+}
+
+func TestFilterDecision_DataPace(t *testing.T) {
+	c := &filterCollector{}
+	s := &Session{
+		backend: &Backend{
+			collector: c,
+			dataPace:  newDataPace(config.DataPaceConfig{MaxBytesPerSecond: 1 << 20, MinSize: 4096, Action: config.DataPaceDefer}),
+			logger:    slog.Default(),
+		},
+		logger: slog.Default(),
+	}
+
+	_ = s.checkDataPace(1000, 0) // under min_size
+	_ = s.checkDataPace(1<<20, time.Second)
+	_ = s.checkDataPace(1<<20, 0)
+	c.want(t, "data_pace/accept", "data_pace/defer")
+}
+
+func TestFilterDecision_SpamCheck(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name   string
+		result *spamcheck.CheckResult
+		err    error
+		mode   config.SpamCheckFailMode
+		want   string
+	}{
+		{"ham", &spamcheck.CheckResult{Action: spamcheck.ActionAccept}, nil, "", "spamcheck/accept"},
+		{"spam", &spamcheck.CheckResult{Action: spamcheck.ActionReject}, nil, "", "spamcheck/reject"},
+		{"soft reject", &spamcheck.CheckResult{Action: spamcheck.ActionTempFail}, nil, "", "spamcheck/defer"},
+		{"error fail open", nil, errors.New("rspamd down"), config.SpamCheckFailOpen, "spamcheck/accept"},
+		{"error tempfail", nil, errors.New("rspamd down"), config.SpamCheckFailTempFail, "spamcheck/defer"},
+		{"error reject", nil, errors.New("rspamd down"), config.SpamCheckFailReject, "spamcheck/reject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &filterCollector{}
+			session := &Session{
+				backend: &Backend{
+					collector:   c,
+					spamChecker: &verdictChecker{result: tt.result, err: tt.err},
+					spamConfig: config.SpamCheckConfig{
+						Enabled:           true,
+						Checkers:          []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+						FailMode:          tt.mode,
+						TempFailThreshold: 100,
+					},
+					tempDir: t.TempDir(),
+					logger:  slog.Default(),
+				},
+				mailFromSeen: true,
+				from:         "alice@example.com",
+				// Accepted mail ends at the deferred user-unknown rejection,
+				// so no delivery agent is needed.
+				deferredInvalidRecipient: "nobody@example.com",
+				logger:                   slog.Default(),
+			}
+			_ = session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+			c.want(t, tt.want)
+		})
+	}
+}
+
+func TestFilterDecision_Content(t *testing.T) {
+	c := &filterCollector{}
+	s := &Session{
+		backend: &Backend{collector: c, headerPolicy: config.HeaderPolicyBasic, logger: slog.Default()},
+		logger:  slog.Default(),
+	}
+	check := func(msg string) {
+		tmp := &memTempBuf{}
+		tmp.buf.WriteString(msg)
+		_ = s.checkContent(tmp)
+	}
+
+	check("From: a@example.com\r\nDate: Mon, 2 Mar 2026 09:00:00 +0000\r\n\r\nBody\r\n")
+	check("Subject: no From or Date\r\n\r\nBody\r\n")
+	c.want(t, "content/accept", "content/reject")
+
+	s.backend.headerPolicy = config.HeaderPolicyOff
+	check("Subject: no From or Date\r\n\r\nBody\r\n")
+	c.want(t)
+}
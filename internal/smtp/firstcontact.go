@@ -0,0 +1,89 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+const firstContactKeyPrefix = "firstcontact:"
+
+// firstContact delivers a summary to [smtpd.first_contact] notify the first
+// time a sender domain reaches a local recipient. A domain stays known for
+// window after that first message, however many follow.
+//
+// State store errors are logged and send nothing.
+type firstContact struct {
+	notify string
+	window time.Duration
+	store  kvstore.Store
+	logger *slog.Logger
+}
+
+// newFirstContact returns nil when no notify address is configured.
+func newFirstContact(cfg config.FirstContactConfig, store kvstore.Store, logger *slog.Logger) *firstContact {
+	if !cfg.IsEnabled() || store == nil {
+		return nil
+	}
+	return &firstContact{notify: cfg.Notify, window: cfg.GetWindow(), store: store, logger: logger}
+}
+
+// first marks domain as seen and reports whether it was new. Incr keeps the
+// expiry of an existing key, so the window runs from the first message.
+func (f *firstContact) first(ctx context.Context, domain string) bool {
+	n, err := f.store.Incr(ctx, firstContactKeyPrefix+strings.ToLower(domain), f.window)
+	if err != nil {
+		f.logger.Debug("first contact update failed", slog.String("error", err.Error()))
+		return false
+	}
+	return n == 1
+}
+
+// noteFirstContact sends the summary when this delivered message is the
+// first from its sender's domain. Only unauthenticated mail with a sender
+// counts: submissions come from local users and bounces have no domain.
+// The summary is delivered with a null sender so it cannot bounce back, and
+// a failure to deliver it never touches the message it reports.
+func (s *Session) noteFirstContact(ctx context.Context, queueID string) {
+	f := s.backend.firstContact
+	if f == nil || s.authUser != "" || s.from == "" {
+		return
+	}
+	i := strings.LastIndex(s.from, "@")
+	if i < 0 || i == len(s.from)-1 {
+		return
+	}
+	domain := strings.ToLower(s.from[i+1:])
+	if !f.first(ctx, domain) {
+		return
+	}
+
+	s.logger.Info("first message from sender domain", slog.String("domain", domain))
+	now := time.Now()
+	summary := fmt.Sprintf("From: MAILER-DAEMON@%s\r\n"+
+		"To: <%s>\r\n"+
+		"Subject: First message from %s\r\n"+
+		"Date: %s\r\n"+
+		"Auto-Submitted: auto-generated\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"The first message from %s in first_contact.window was delivered.\r\n"+
+		"\r\n"+
+		"Sender:    %s\r\n"+
+		"Recipient: %s\r\n"+
+		"Client:    %s (%s)\r\n"+
+		"Queue ID:  %s\r\n",
+		s.backend.hostname, f.notify, domain, now.Format(time.RFC1123Z),
+		domain, s.from, strings.Join(s.recipients, ", "), s.clientIP, s.helo, queueID)
+	if err := s.backend.smDelivery.Deliver(ctx, "", f.notify, "", s.backend.hostname, now, strings.NewReader(summary)); err != nil {
+		s.logger.Warn("first contact summary not delivered",
+			slog.String("notify", f.notify),
+			slog.String("domain", domain),
+			slog.String("error", err.Error()))
+	}
+}
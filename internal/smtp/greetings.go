@@ -0,0 +1,182 @@
+package smtp
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errTooManyGreetings refuses MAIL on a connection over max_greetings.
+var errTooManyGreetings = &smtp.SMTPError{
+	Code:         503,
+	EnhancedCode: smtp.EnhancedCode{5, 5, 1},
+	Message:      "Too many greetings",
+}
+
+// greetingCounter counts the HELO/EHLO commands on one connection
+// ([smtpd.limits] max_greetings). A client repeating EHLO to reset state or
+// probe capabilities is not behaving like a mail server.
+//
+// go-smtp answers HELO and EHLO itself and only tells the session through
+// Reset, which RSET and the end of DATA call too, so the commands are
+// counted from the protocol stream instead, skipping message content sent
+// with DATA or BDAT. It is a writer on go-smtp's Server.Debug tee beside
+// the transactionLog, not one of its sinks: BDAT chunks are counted in
+// bytes, which the log's split, trimmed and redacted lines do not preserve.
+// STARTTLS requires a fresh EHLO (RFC 3207 §4.2), so the count starts over
+// once the server accepts it with 220.
+type greetingCounter struct {
+	mu          sync.Mutex
+	max         int
+	count       int
+	awaitingTLS bool
+	reported    bool
+	// buf holds a partial protocol line; skipLine discards the rest of one
+	// too long to be a command.
+	buf      []byte
+	skipLine bool
+	// dataPending is set by DATA until the server answers it; inData runs
+	// from the 354 to the lone "." that ends the message.
+	dataPending bool
+	inData      bool
+	// chunk is the number of bytes of BDAT data still to come.
+	chunk int
+}
+
+// newGreetingCounter returns nil when the cap is disabled.
+func newGreetingCounter(max int) *greetingCounter {
+	if max <= 0 {
+		return nil
+	}
+	return &greetingCounter{max: max}
+}
+
+// Write implements io.Writer for the raw protocol stream, both directions.
+func (g *greetingCounter) Write(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		if g.chunk > 0 {
+			skip := min(g.chunk, len(p))
+			g.chunk -= skip
+			p = p[skip:]
+			continue
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			g.buffer(p)
+			break
+		}
+		g.buffer(p[:i])
+		p = p[i+1:]
+		if !g.skipLine {
+			g.line(string(bytes.TrimSuffix(g.buf, []byte("\r"))))
+		}
+		g.buf, g.skipLine = g.buf[:0], false
+	}
+	return n, nil
+}
+
+// buffer adds part of a line to buf, unless it has grown past any command.
+func (g *greetingCounter) buffer(p []byte) {
+	if g.skipLine {
+		return
+	}
+	if len(g.buf)+len(p) > maxTransactionLine {
+		g.buf, g.skipLine = g.buf[:0], true
+		return
+	}
+	g.buf = append(g.buf, p...)
+}
+
+// line handles one complete protocol line.
+func (g *greetingCounter) line(data string) {
+	if g.inData {
+		// A body line that looks like a reply is still client data.
+		if data == "." {
+			g.inData = false
+		}
+		return
+	}
+	if isReplyLine(data) {
+		if g.dataPending && strings.HasPrefix(data, "354") {
+			g.inData = true
+		}
+		g.dataPending = false
+		if g.awaitingTLS && strings.HasPrefix(data, "220") {
+			g.count = 0
+		}
+		g.awaitingTLS = false
+		return
+	}
+	verb, args, _ := strings.Cut(data, " ")
+	switch {
+	case strings.EqualFold(verb, "HELO"), strings.EqualFold(verb, "EHLO"):
+		g.count++
+	case strings.EqualFold(verb, "STARTTLS"):
+		g.awaitingTLS = true
+	case strings.EqualFold(verb, "DATA"):
+		g.dataPending = true
+	case strings.EqualFold(verb, "BDAT"):
+		size, _, _ := strings.Cut(args, " ")
+		if n, err := strconv.Atoi(size); err == nil && n > 0 {
+			g.chunk = n
+		}
+	}
+}
+
+// exceeded reports whether the connection has gone over the cap.
+func (g *greetingCounter) exceeded() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.count > g.max
+}
+
+// firstExcess reports whether the connection is over the cap and this is
+// the first time anyone asked since it went over.
+func (g *greetingCounter) firstExcess() bool {
+	if !g.exceeded() {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	first := !g.reported
+	g.reported = true
+	return first
+}
+
+// noteGreetings logs a connection going over max_greetings, once, and
+// counts it against the client IP when [smtpd.reputation] count_greetings
+// is set. It runs from Reset, which go-smtp calls for every greeting after
+// the first.
+func (s *Session) noteGreetings() {
+	if !s.greetings.firstExcess() {
+		return
+	}
+	s.logger.Info("client over greeting limit", slog.Int("max_greetings", s.greetings.max))
+	if rep := s.backend.reputation; rep != nil && rep.countGreetings {
+		s.countRejection()
+	}
+}
+
+// greetingBackend hands each session the connection's greetingCounter.
+type greetingBackend struct {
+	smtp.Backend
+	greetings *greetingCounter
+}
+
+func (b *greetingBackend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	sess, err := b.Backend.NewSession(c)
+	if s, ok := sess.(*Session); ok {
+		s.greetings = b.greetings
+	}
+	return sess, err
+}
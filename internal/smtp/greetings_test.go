@@ -0,0 +1,222 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestGreetingCounter(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  bool
+	}{
+		{"within cap", []string{"EHLO a", "250 ok", "HELO a", "250 ok"}, false},
+		{"over cap", []string{"EHLO a", "250 ok", "ehlo a", "250 ok", "HELO a", "250 ok"}, true},
+		{"reset by STARTTLS", []string{"EHLO a", "250 ok", "STARTTLS", "220 go ahead", "EHLO a", "250 ok", "EHLO a", "250 ok"}, false},
+		{"refused STARTTLS does not reset", []string{"EHLO a", "250 ok", "STARTTLS", "454 no", "EHLO a", "250 ok", "EHLO a", "250 ok"}, true},
+		{"other commands not counted", []string{"EHLO a", "250 ok", "RSET", "250 ok", "NOOP", "250 ok"}, false},
+		{"greetings in a message body", []string{
+			"EHLO a", "250 ok", "MAIL FROM:<a@example.com>", "250 ok", "RCPT TO:<b@example.com>", "250 ok",
+			"DATA", "354 go ahead", "STARTTLS", "220 quoted", "EHLO x", "HELO y", "EHLO z", ".", "250 queued",
+			"EHLO a", "250 ok",
+		}, false},
+		{"greetings after a refused DATA", []string{"EHLO a", "250 ok", "DATA", "503 no", "EHLO a", "250 ok", "EHLO a", "250 ok"}, true},
+		{"greetings in a BDAT chunk", []string{
+			"EHLO a", "250 ok", "BDAT 16", "EHLO x", "HELO y", "250 ok",
+			"BDAT 0 LAST", "250 ok", "EHLO a", "250 ok",
+		}, false},
+		{"BDAT chunk ending mid-line", []string{
+			"EHLO a", "250 ok", "BDAT 11", "EHLO x", "HELBDAT 5 LAST", "O y", "250 ok",
+			"EHLO a", "250 ok", "EHLO a", "250 ok",
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGreetingCounter(2)
+			for _, line := range tt.lines {
+				_, _ = g.Write([]byte(line + "\r\n"))
+			}
+			if got := g.exceeded(); got != tt.want {
+				t.Errorf("exceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// BDAT chunks are skipped by byte count, whatever their lines look like.
+	long := strings.Repeat("x", 5000)
+	chunks := []struct {
+		name string
+		data string
+	}{
+		{"LF-only lines", "EHLO x\nHELO y\nEHLO z\n"},
+		{"lines over 4096 bytes", long + "\r\nEHLO x\r\n" + long + "HELO y\r\nEHLO z\r\n"},
+		{"lines the log redacts", "334 x\r\nEHLO x\r\nAUTH PLAIN secret\r\nHELO y\r\nEHLO z\r\n"},
+		{"ending mid-line", "EHLO x\r\nHEL"},
+	}
+	for _, tt := range chunks {
+		t.Run("BDAT "+tt.name, func(t *testing.T) {
+			g := newGreetingCounter(2)
+			stream := "EHLO a\r\n250 ok\r\n" +
+				"BDAT " + strconv.Itoa(len(tt.data)) + "\r\n" + tt.data + "250 ok\r\n" +
+				"BDAT 0 LAST\r\n250 ok\r\nEHLO a\r\n250 ok\r\n"
+			// Written in small pieces, as go-smtp's buffered reads may be.
+			for len(stream) > 0 {
+				n := min(7, len(stream))
+				_, _ = g.Write([]byte(stream[:n]))
+				stream = stream[n:]
+			}
+			if g.exceeded() {
+				t.Errorf("greetings in the chunk counted: count = %d", g.count)
+			}
+			if g.count != 2 {
+				t.Errorf("count = %d, want 2", g.count)
+			}
+		})
+	}
+
+	t.Run("long DATA line ending in a dot", func(t *testing.T) {
+		g := newGreetingCounter(2)
+		_, _ = g.Write([]byte("EHLO a\r\nDATA\r\n354 go ahead\r\n" + long + ".\r\nEHLO x\r\nHELO y\r\n.\r\n250 ok\r\n"))
+		if g.exceeded() {
+			t.Errorf("greetings in the body counted: count = %d", g.count)
+		}
+	})
+
+	if newGreetingCounter(0) != nil {
+		t.Error("newGreetingCounter(0) should disable the cap")
+	}
+	var disabled *greetingCounter
+	if disabled.exceeded() {
+		t.Error("nil counter reports exceeded")
+	}
+}
+
+func TestRunSingleConn_MaxGreetings(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	conn, done := serveConfiguredConn(t, config.ModeSmtp, ServerConfig{
+		Backend:      NewBackend(BackendConfig{Hostname: "test.local"}),
+		TLSConfig:    serverTLS,
+		MaxGreetings: 2,
+	})
+
+	var r *bufio.Reader
+	send := func(cmd, want string) {
+		t.Helper()
+		if cmd != "" {
+			_, _ = io.WriteString(conn, cmd+"\r\n")
+		}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: read: %v", cmd, err)
+			}
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("%q: got %q, want %s", cmd, line, want)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	// Two greetings before STARTTLS and two after stay within the cap.
+	r = bufio.NewReader(conn)
+	send("", "220")
+	send("EHLO client.example", "250")
+	send("EHLO client.example", "250")
+	send("STARTTLS", "220")
+	tlsConn := tls.Client(conn, clientTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	conn, r = tlsConn, bufio.NewReader(tlsConn)
+	send("EHLO client.example", "250")
+	send("EHLO client.example", "250")
+	send("MAIL FROM:<alice@example.com>", "250")
+	send("RSET", "250")
+
+	// The third greeting after STARTTLS goes over it.
+	send("EHLO client.example", "250")
+	send("MAIL FROM:<alice@example.com>", "503 5.5.1 Too many greetings")
+	send("QUIT", "221")
+	waitDone(t, done)
+}
+
+func TestRunSingleConn_MaxGreetingsMessageBody(t *testing.T) {
+	conn, done := serveConfiguredConn(t, config.ModeSmtp, ServerConfig{
+		Backend:      NewBackend(BackendConfig{Hostname: "test.local"}),
+		MaxGreetings: 2,
+	})
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	send := func(cmd, want string) {
+		t.Helper()
+		if cmd != "" {
+			_, _ = io.WriteString(conn, cmd+"\r\n")
+		}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: read: %v", cmd, err)
+			}
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("%q: got %q, want %s", cmd, line, want)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	// A message quoting an SMTP transcript is not the client greeting.
+	send("", "220")
+	send("EHLO client.example", "250")
+	send("MAIL FROM:<alice@example.com>", "250")
+	send("RCPT TO:<bob@example.com>", "250")
+	send("DATA", "354")
+	send("Subject: transcript\r\n\r\nEHLO x\r\nHELO y\r\nEHLO z\r\n.", "451")
+	send("MAIL FROM:<alice@example.com>", "250")
+	send("QUIT", "221")
+	waitDone(t, done)
+}
+
+func TestRunSingleConn_MaxGreetingsUnset(t *testing.T) {
+	conn, done := serveConfiguredConn(t, config.ModeSmtp, ServerConfig{
+		Backend: NewBackend(BackendConfig{Hostname: "test.local"}),
+	})
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	read := func() string {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if line[3] == ' ' {
+				return line
+			}
+		}
+	}
+	read()
+	for range 10 {
+		_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+		read()
+	}
+	_, _ = io.WriteString(conn, "MAIL FROM:<alice@example.com>\r\n")
+	if got := read(); !strings.HasPrefix(got, "250") {
+		t.Errorf("MAIL reply = %q, want 250", got)
+	}
+	_, _ = io.WriteString(conn, "QUIT\r\n")
+	read()
+	waitDone(t, done)
+}
@@ -0,0 +1,134 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// errHeaderCharset is the reply to a message refused under
+// header_charset_policy = "reject".
+var errHeaderCharset = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+	Message:      "Invalid characters in message header",
+}
+
+// checkHeaderCharset enforces [smtpd] header_charset_policy on the header
+// section of r. Only "reject" refuses the message; "drop" and "replace" are
+// applied to the delivered copy by newHeaderCharsetFilter, and are only
+// logged here. The body is never read, so 8-bit bodies are unaffected.
+func (s *Session) checkHeaderCharset(r io.Reader) error {
+	policy := s.backend.headerCharset
+	if policy == config.HeaderCharsetOff || policy == "" {
+		return nil
+	}
+	field, ok := firstInvalidHeader(r)
+	if ok {
+		return nil
+	}
+	s.logger.Info("invalid UTF-8 in message header",
+		slog.String("field", field),
+		slog.String("policy", string(policy)))
+	if policy == config.HeaderCharsetReject {
+		return errHeaderCharset
+	}
+	s.noteSignal(config.SignalHeaderCharset)
+	return nil
+}
+
+// firstInvalidHeader scans a message's header section and returns the name
+// of the first field that is not valid UTF-8, and false; or true when every
+// field is valid.
+func firstInvalidHeader(r io.Reader) (string, bool) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return "", true
+		}
+		if !utf8.Valid(line) {
+			name, _, _ := bytes.Cut(line, []byte(":"))
+			return strings.ToValidUTF8(string(name), "?"), false
+		}
+		if err != nil {
+			return "", true
+		}
+	}
+}
+
+// headerCharsetFilter passes a message through, dropping or repairing the
+// header fields that are not valid UTF-8 (header_charset_policy "drop" or
+// "replace"). A field is judged with its folded continuation lines, so a
+// dropped field goes whole. The body is passed through unchanged.
+type headerCharsetFilter struct {
+	r        *bufio.Reader
+	drop     bool // drop invalid fields; otherwise replace the bad bytes
+	inHeader bool
+	field    []byte // the current field, continuation lines included
+	pending  []byte
+}
+
+func newHeaderCharsetFilter(r io.Reader, drop bool) *headerCharsetFilter {
+	return &headerCharsetFilter{r: bufio.NewReader(r), drop: drop, inHeader: true}
+}
+
+func (f *headerCharsetFilter) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if !f.inHeader {
+			return f.r.Read(p)
+		}
+		line, err := f.r.ReadBytes('\n')
+		f.pending = f.feed(line)
+		if err != nil {
+			f.pending = append(f.pending, f.flush()...)
+			f.inHeader = false // emit what is left, then report err from r
+			if len(f.pending) == 0 {
+				return 0, err
+			}
+			break
+		}
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// feed takes one header-section line and returns what can be emitted: the
+// previous field once a new one starts, and the blank line ending the
+// header section.
+func (f *headerCharsetFilter) feed(line []byte) []byte {
+	if len(line) == 0 {
+		return nil
+	}
+	if (line[0] == ' ' || line[0] == '\t') && len(f.field) > 0 {
+		f.field = append(f.field, line...)
+		return nil
+	}
+	out := f.flush()
+	if len(bytes.TrimRight(line, "\r\n")) == 0 {
+		f.inHeader = false // blank line: body follows
+		return append(out, line...)
+	}
+	f.field = append([]byte(nil), line...)
+	return out
+}
+
+// flush returns the current field as it is to be emitted.
+func (f *headerCharsetFilter) flush() []byte {
+	field := f.field
+	f.field = nil
+	if utf8.Valid(field) {
+		return field
+	}
+	if f.drop {
+		return nil
+	}
+	return []byte(strings.ToValidUTF8(string(field), "�"))
+}
@@ -0,0 +1,77 @@
+package smtp
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+const (
+	badHeaderMsg  = "Subject: caf\xe9\r\nX-Note: one\r\n two \xff\r\nFrom: a@example.com\r\n\r\nbody \xe9\r\n"
+	goodHeaderMsg = "Subject: =?utf-8?q?caf=C3=A9?=\r\nX-Name: Zoë\r\n\r\nbody \xe9\r\n"
+)
+
+func TestCheckHeaderCharset(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  config.HeaderCharsetPolicy
+		msg     string
+		wantErr bool
+	}{
+		{"off", config.HeaderCharsetOff, badHeaderMsg, false},
+		{"reject invalid", config.HeaderCharsetReject, badHeaderMsg, true},
+		{"reject encoded-word and UTF-8", config.HeaderCharsetReject, goodHeaderMsg, false},
+		{"drop invalid", config.HeaderCharsetDrop, badHeaderMsg, false},
+		{"replace invalid", config.HeaderCharsetReplace, badHeaderMsg, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{
+				backend: &Backend{headerCharset: tt.policy},
+				logger:  slog.Default(),
+			}
+			err := s.checkHeaderCharset(strings.NewReader(tt.msg))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errHeaderCharset) {
+				t.Errorf("err = %v, want errHeaderCharset", err)
+			}
+		})
+	}
+}
+
+func TestMessageBody_HeaderCharset(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy config.HeaderCharsetPolicy
+		msg    string
+		want   string
+	}{
+		{"drop", config.HeaderCharsetDrop, badHeaderMsg,
+			"From: a@example.com\r\n\r\nbody \xe9\r\n"},
+		{"replace", config.HeaderCharsetReplace, badHeaderMsg,
+			"Subject: caf�\r\nX-Note: one\r\n two �\r\nFrom: a@example.com\r\n\r\nbody \xe9\r\n"},
+		{"valid headers untouched", config.HeaderCharsetDrop, goodHeaderMsg, goodHeaderMsg},
+		{"no body", config.HeaderCharsetDrop, "Subject: caf\xe9\r\nFrom: a@example.com", "From: a@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := &memTempBuf{}
+			_, _ = tmp.Write([]byte(tt.msg))
+			s := &Session{backend: &Backend{headerCharset: tt.policy}}
+
+			got, err := io.ReadAll(s.messageBody(tmp))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package smtp
+
+import (
+	"io"
+	"log/slog"
+	"net/mail"
+	"strings"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// checkHeaderRecipients counts the addresses in the To and Cc fields of r
+// and records the header_recipients signal when they exceed [smtpd]
+// max_header_recipients. Bulk mail often shows a long visible recipient
+// list however few envelope recipients it has. It never refuses the
+// message itself.
+func (s *Session) checkHeaderRecipients(r io.Reader) {
+	limit := s.backend.maxHeaderRcpts
+	if limit <= 0 || s.backend.signals == nil {
+		return
+	}
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return
+	}
+	n := countHeaderAddresses(msg.Header, "To") + countHeaderAddresses(msg.Header, "Cc")
+	if n <= limit {
+		return
+	}
+	s.logger.Info("message lists too many header recipients",
+		slog.Int("addresses", n),
+		slog.Int("envelope_recipients", len(s.recipients)),
+		slog.Int("limit", limit))
+	s.noteSignal(config.SignalHeaderRecipients)
+}
+
+// countHeaderAddresses returns the number of addresses in every instance
+// of the named field. A list net/mail cannot parse is counted by its "@"
+// signs, so malformed lists are not a way around the limit.
+func countHeaderAddresses(h mail.Header, name string) int {
+	n := 0
+	for _, v := range h[name] {
+		if list, err := (&mail.AddressParser{}).ParseList(v); err == nil {
+			n += len(list)
+		} else {
+			n += strings.Count(v, "@")
+		}
+	}
+	return n
+}
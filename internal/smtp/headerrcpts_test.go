@@ -0,0 +1,65 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestSession_CheckHeaderRecipients(t *testing.T) {
+	var bulk strings.Builder
+	bulk.WriteString("From: a@example.com\r\nTo: ")
+	for i := range 300 {
+		if i > 0 {
+			bulk.WriteString(",\r\n ")
+		}
+		fmt.Fprintf(&bulk, "user%d@example.net", i)
+	}
+	bulk.WriteString("\r\nSubject: hi\r\n\r\nBody\r\n")
+
+	tests := []struct {
+		name string
+		msg  string
+		want bool
+	}{
+		{"normal", "From: a@example.com\r\nTo: b@example.net\r\nCc: \"C\" <c@example.net>\r\n\r\nBody\r\n", false},
+		{"hundreds of To", bulk.String(), true},
+		{"split over To and Cc", "To: a@x.example, b@x.example\r\nCc: c@x.example, d@x.example\r\n\r\nBody\r\n", true},
+		{"unparseable list", "To: a@x.example b@x.example c@x.example d@x.example\r\n\r\nBody\r\n", true},
+		{"at the limit", "To: a@x.example, b@x.example, c@x.example\r\n\r\nBody\r\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{
+				backend: &Backend{
+					signals:        &config.SignalsConfig{RejectWeight: 1},
+					maxHeaderRcpts: 3,
+					logger:         slog.Default(),
+				},
+				recipients: []string{"b@example.net"},
+				logger:     slog.Default(),
+			}
+			s.checkHeaderRecipients(strings.NewReader(tt.msg))
+			err := s.checkSignals()
+			if got := errors.Is(err, errSignals); got != tt.want {
+				t.Errorf("signalled = %v, want %v (signals %v)", got, tt.want, s.signals)
+			}
+		})
+	}
+}
+
+func TestSession_CheckHeaderRecipients_Off(t *testing.T) {
+	s := &Session{
+		backend: &Backend{signals: &config.SignalsConfig{RejectWeight: 1}, logger: slog.Default()},
+		logger:  slog.Default(),
+	}
+	s.checkHeaderRecipients(strings.NewReader("To: a@x.example, b@x.example, c@x.example, d@x.example\r\n\r\nBody\r\n"))
+	if len(s.signals) != 0 {
+		t.Errorf("signals = %v with max_header_recipients unset", s.signals)
+	}
+}
@@ -0,0 +1,135 @@
+package smtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// honeypotSession serves connections on honeypot listeners. It accepts
+// every MAIL, RCPT and DATA and writes each message, with its connection
+// metadata, to the capture directory. It never calls the delivery agent and
+// never rejects: even a failed capture is answered 250, so the client sees
+// an open relay either way.
+type honeypotSession struct {
+	backend    *Backend
+	conn       *smtp.Conn
+	remoteAddr string
+	from       string
+	recipients []string
+	logger     *slog.Logger
+}
+
+// honeypotCapture is the metadata written next to each captured message.
+type honeypotCapture struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Helo       string    `json:"helo"`
+	TLS        bool      `json:"tls"`
+	MailFrom   string    `json:"mail_from"`
+	RcptTo     []string  `json:"rcpt_to"`
+	Size       int64     `json:"size"`
+}
+
+// Mail implements smtp.Session.
+func (s *honeypotSession) Mail(from string, opts *smtp.MailOptions) error {
+	s.from = from
+	s.logger.Info("MAIL FROM", slog.String("from", from),
+		slog.String("helo", s.conn.Hostname()), slog.Any("options", opts))
+	return nil
+}
+
+// Rcpt implements smtp.Session.
+func (s *honeypotSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	s.recipients = append(s.recipients, to)
+	s.logger.Info("RCPT TO", slog.String("to", to), slog.Any("options", opts))
+	return nil
+}
+
+// Data implements smtp.Session.
+func (s *honeypotSession) Data(r io.Reader) error {
+	capture := honeypotCapture{
+		ID:         newQueueID(),
+		Time:       time.Now().UTC(),
+		RemoteAddr: s.remoteAddr,
+		Helo:       s.conn.Hostname(),
+		TLS:        sessionConnIsTLS(s.conn),
+		MailFrom:   s.from,
+		RcptTo:     s.recipients,
+	}
+	if err := s.capture(&capture, r); err != nil {
+		s.logger.Error("honeypot capture failed",
+			slog.String("id", capture.ID), slog.String("error", err.Error()))
+		return nil
+	}
+	s.logger.Info("honeypot message captured",
+		slog.String("id", capture.ID),
+		slog.String("from", capture.MailFrom),
+		slog.Any("rcpt_to", capture.RcptTo),
+		slog.Int64("size", capture.Size))
+	return nil
+}
+
+// capture writes the message to <id>.eml and then the metadata to
+// <id>.json, each through a temporary file so readers never see a partial
+// capture. A .json file therefore marks a complete capture.
+func (s *honeypotSession) capture(c *honeypotCapture, r io.Reader) error {
+	dir := s.backend.honeypotDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	size, err := writeFileAtomic(filepath.Join(dir, c.ID+".eml"), func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+	if err != nil {
+		return err
+	}
+	c.Size = size
+
+	_, err = writeFileAtomic(filepath.Join(dir, c.ID+".json"), func(w io.Writer) (int64, error) {
+		return 0, json.NewEncoder(w).Encode(c)
+	})
+	return err
+}
+
+// writeFileAtomic writes path by way of a temporary file in the same
+// directory, renaming it into place once write has succeeded.
+func writeFileAtomic(path string, write func(io.Writer) (int64, error)) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".capture-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	n, err := write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// Reset implements smtp.Session.
+func (s *honeypotSession) Reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+// Logout implements smtp.Session.
+func (s *honeypotSession) Logout() error {
+	if s.backend.collector != nil {
+		s.backend.collector.ConnectionClosed()
+	}
+	s.logger.Info("honeypot session closed")
+	return nil
+}
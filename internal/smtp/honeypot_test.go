@@ -0,0 +1,84 @@
+package smtp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+	smtpserver "github.com/infodancer/smtpd/internal/smtp"
+)
+
+// TestHoneypot_CapturesWithoutDelivery verifies that a honeypot listener
+// accepts mail it would otherwise refuse (relay to a remote domain from an
+// unauthenticated client, several recipients), writes it to the capture
+// directory, and never calls the delivery agent.
+func TestHoneypot_CapturesWithoutDelivery(t *testing.T) {
+	t.Parallel()
+
+	captureDir := t.TempDir()
+	srv, deliverySrv := newSingleConnEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.HoneypotDir = captureDir
+	})
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		srv.RunSingleConn(serverConn, config.ModeSmtp, nil) //nolint:errcheck
+		close(done)
+	}()
+
+	c := &smtpClient{conn: clientConn, r: bufio.NewReader(clientConn)}
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.MailExpect(t, "spammer@example.net", 250)
+	c.RcptExpect(t, "victim@elsewhere.example", 250)
+	c.RcptExpect(t, "carol@single.local", 250)
+	c.mustCode(t, "DATA", 354)
+	if _, err := fmt.Fprintf(c.conn, "Subject: bait\r\n\r\nhello honeypot\r\n.\r\n"); err != nil {
+		t.Fatalf("write DATA body: %v", err)
+	}
+	c.mustCode(t, "", 250)
+	c.Quit(t)
+	<-done
+
+	if got := deliverySrv.count(); got != 0 {
+		t.Errorf("delivered %d messages, want 0", got)
+	}
+
+	metas, err := filepath.Glob(filepath.Join(captureDir, "*.json"))
+	if err != nil || len(metas) != 1 {
+		t.Fatalf("capture metadata files = %v (err %v), want 1", metas, err)
+	}
+	raw, err := os.ReadFile(metas[0])
+	if err != nil {
+		t.Fatalf("read metadata: %v", err)
+	}
+	var meta struct {
+		ID       string   `json:"id"`
+		Helo     string   `json:"helo"`
+		MailFrom string   `json:"mail_from"`
+		RcptTo   []string `json:"rcpt_to"`
+		Size     int64    `json:"size"`
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		t.Fatalf("decode metadata: %v", err)
+	}
+	if meta.Helo != "localhost" || meta.MailFrom != "spammer@example.net" ||
+		strings.Join(meta.RcptTo, ",") != "victim@elsewhere.example,carol@single.local" {
+		t.Errorf("metadata = %+v", meta)
+	}
+
+	msg, err := os.ReadFile(filepath.Join(captureDir, meta.ID+".eml"))
+	if err != nil {
+		t.Fatalf("read captured message: %v", err)
+	}
+	if !strings.Contains(string(msg), "hello honeypot") || int64(len(msg)) != meta.Size {
+		t.Errorf("captured message = %q (size %d)", msg, meta.Size)
+	}
+}
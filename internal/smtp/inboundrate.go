@@ -0,0 +1,72 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// inboundRateWindow is the fixed window inbound_rate_per_minute counts in.
+const inboundRateWindow = time.Minute
+
+// inboundRateKeyPrefix namespaces the per-domain counters in the state store.
+const inboundRateKeyPrefix = "inrate:"
+
+// inboundRate enforces [smtpd.domains] inbound_rate_per_minute. Each local
+// RCPT counts against its domain; one recipient per message makes that a
+// message count. State store errors fail open, as in reputation.go.
+type inboundRate struct {
+	store   kvstore.Store
+	domains config.DomainsConfig
+	logger  *slog.Logger
+}
+
+// newInboundRate returns nil when no domain has a limit.
+func newInboundRate(domains config.DomainsConfig, store kvstore.Store, logger *slog.Logger) *inboundRate {
+	if store == nil {
+		return nil
+	}
+	for _, d := range domains {
+		if d.InboundRatePerMinute > 0 {
+			return &inboundRate{store: store, domains: domains, logger: logger}
+		}
+	}
+	return nil
+}
+
+// errDomainRate is the RCPT reply while a domain is over its inbound rate.
+var errDomainRate = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too much mail for this domain, try again later",
+}
+
+// checkInboundRate counts a message for the local domain and defers it once
+// the domain is over its limit for the current minute.
+func (s *Session) checkInboundRate(domain string) (err error) {
+	r := s.backend.inboundRate
+	if r == nil {
+		return nil
+	}
+	max := r.domains.Get(domain).InboundRatePerMinute
+	if max <= 0 {
+		return nil
+	}
+	defer func() { s.filterDecision("inbound_rate", err) }()
+	n, err := r.store.Incr(context.Background(), inboundRateKeyPrefix+domain, inboundRateWindow)
+	if err != nil {
+		r.logger.Debug("inbound rate update failed", slog.String("error", err.Error()))
+		return nil
+	}
+	if n <= int64(max) {
+		return nil
+	}
+	s.logger.Warn("domain inbound rate exceeded",
+		slog.String("domain", domain),
+		slog.Int("inbound_rate_per_minute", max))
+	return errDomainRate
+}
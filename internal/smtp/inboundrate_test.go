@@ -0,0 +1,85 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+func TestSession_Rcpt_InboundRate(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	// One store stands in for redis shared by every connection.
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		StateStore: kvstore.NewMemory(),
+		Domains: config.DomainsConfig{
+			"flooded.example": {InboundRatePerMinute: 3},
+		},
+	})
+
+	rcpt := func(to string) error {
+		s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+		return s.Rcpt(to, nil)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := rcpt("bob@flooded.example"); err != nil {
+			t.Fatalf("message %d to flooded.example: %v", i+1, err)
+		}
+	}
+	if err := rcpt("carol@flooded.example"); !errors.Is(err, errDomainRate) {
+		t.Errorf("message 4 to flooded.example = %v, want %v", err, errDomainRate)
+	}
+	for i := 0; i < 10; i++ {
+		if err := rcpt("alice@quiet.example"); err != nil {
+			t.Fatalf("message %d to quiet.example: %v", i+1, err)
+		}
+	}
+}
+
+func TestSession_Rcpt_InboundRateDefault(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		Domains: config.DomainsConfig{
+			"*":           {InboundRatePerMinute: 1},
+			"big.example": {InboundRatePerMinute: 100},
+		},
+		RoleMailbox:    "postmaster@example.com",
+		RoleRecipients: []string{"postmaster"},
+	})
+
+	rcpt := func(to string) error {
+		s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+		return s.Rcpt(to, nil)
+	}
+
+	if err := rcpt("bob@small.example"); err != nil {
+		t.Fatalf("first message to small.example: %v", err)
+	}
+	if err := rcpt("bob@small.example"); !errors.Is(err, errDomainRate) {
+		t.Errorf("second message to small.example = %v, want %v", err, errDomainRate)
+	}
+	if err := rcpt("postmaster@small.example"); err != nil {
+		t.Errorf("postmaster over the limit = %v, want accepted", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := rcpt("bob@big.example"); err != nil {
+			t.Fatalf("message %d to big.example: %v", i+1, err)
+		}
+	}
+}
+
+func TestNewInboundRate_Disabled(t *testing.T) {
+	if r := newInboundRate(config.DomainsConfig{"example.com": {}}, kvstore.NewMemory(), slog.Default()); r != nil {
+		t.Error("expected nil inbound rate when no domain has a limit")
+	}
+}
@@ -0,0 +1,91 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// ipUserLimit caps how many distinct users one client IP may authenticate
+// as within a window ([smtpd.auth] max_distinct_users_per_ip). Each
+// (IP, user) pair gets a marker key; the first login for a pair in the
+// window also bumps the IP's count, so repeat logins as the same user are
+// free. Session.Auth checks it once credentials are accepted, so only
+// logins that succeed count.
+//
+// State store errors fail open, as in reputation.go.
+type ipUserLimit struct {
+	store  kvstore.Store
+	max    int
+	window time.Duration
+	logger *slog.Logger
+}
+
+// newIPUserLimit returns nil when the cap is disabled.
+func newIPUserLimit(cfg config.AuthConfig, store kvstore.Store, logger *slog.Logger) *ipUserLimit {
+	if cfg.MaxDistinctUsersPerIP <= 0 || store == nil {
+		return nil
+	}
+	return &ipUserLimit{
+		store:  store,
+		max:    cfg.MaxDistinctUsersPerIP,
+		window: cfg.GetDistinctUsersWindow(),
+		logger: logger,
+	}
+}
+
+// allow records a login by user from ip and reports whether ip is still
+// within its distinct-user budget. A refused pair's marker is removed, so
+// retrying as that user stays refused until the IP's count expires.
+func (l *ipUserLimit) allow(ctx context.Context, ip, user string) bool {
+	pair := "ipusers:" + ip + "|" + strings.ToLower(user)
+	seen, err := l.store.Incr(ctx, pair, l.window)
+	if err != nil {
+		l.logger.Debug("distinct user update failed", slog.String("error", err.Error()))
+		return true
+	}
+	if seen > 1 {
+		return true
+	}
+	n, err := l.store.Incr(ctx, "ipusers:"+ip, l.window)
+	if err != nil {
+		l.logger.Debug("distinct user update failed", slog.String("error", err.Error()))
+		return true
+	}
+	if n <= int64(l.max) {
+		return true
+	}
+	if err := l.store.Delete(ctx, pair); err != nil {
+		l.logger.Debug("distinct user update failed", slog.String("error", err.Error()))
+	}
+	return false
+}
+
+// errTooManyUsers is the AUTH reply once an IP has logged in as too many
+// different users.
+var errTooManyUsers = &smtp.SMTPError{
+	Code:         454,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many accounts from this address, try again later",
+}
+
+// checkIPUsers charges a successful login as user to the client IP.
+func (s *Session) checkIPUsers(user string) error {
+	l := s.backend.ipUsers
+	if l == nil || s.clientIP == "" {
+		return nil
+	}
+	if l.allow(context.Background(), s.clientIP, user) {
+		return nil
+	}
+	s.logger.Warn("too many distinct users from client IP",
+		slog.String("username", user),
+		slog.String("client_ip", s.clientIP),
+		slog.Int("max_distinct_users_per_ip", l.max))
+	return errTooManyUsers
+}
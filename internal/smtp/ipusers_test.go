@@ -0,0 +1,67 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+func TestIPUserLimit_Disabled(t *testing.T) {
+	if l := newIPUserLimit(config.AuthConfig{}, kvstore.NewMemory(), slog.Default()); l != nil {
+		t.Error("expected nil limit when max_distinct_users_per_ip is 0")
+	}
+}
+
+func TestSession_Auth_MaxDistinctUsersPerIP(t *testing.T) {
+	mock := &mockSessionService{}
+	backend := NewBackend(BackendConfig{
+		SMDelivery: startMockSessionServer(t, mock),
+		StateStore: kvstore.NewMemory(),
+		Auth:       config.AuthConfig{MaxDistinctUsersPerIP: 3},
+	})
+
+	login := func(ip, user string) error {
+		t.Helper()
+		mock.loginResult = &smpb.LoginResponse{Mailbox: user}
+		s := &Session{backend: backend, clientIP: ip, logger: slog.Default()}
+		server, err := s.Auth(sasl.Plain)
+		if err != nil {
+			t.Fatalf("Auth: %v", err)
+		}
+		_, _, err = server.Next([]byte("\x00" + user + "\x00secret"))
+		if err != nil && s.authUser != "" {
+			t.Errorf("refused login as %s is authenticated", user)
+		}
+		return err
+	}
+
+	for _, user := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if err := login("192.0.2.1", user); err != nil {
+			t.Fatalf("login as %s: %v", user, err)
+		}
+	}
+	// Logging in again as a known user costs nothing.
+	if err := login("192.0.2.1", "A@example.com"); err != nil {
+		t.Fatalf("repeat login: %v", err)
+	}
+
+	if err := login("192.0.2.1", "d@example.com"); !errors.Is(err, errTooManyUsers) {
+		t.Fatalf("fourth user: got %v, want %v", err, errTooManyUsers)
+	}
+	if err := login("192.0.2.1", "d@example.com"); !errors.Is(err, errTooManyUsers) {
+		t.Fatalf("fourth user retried: got %v, want %v", err, errTooManyUsers)
+	}
+	if err := login("192.0.2.1", "b@example.com"); err != nil {
+		t.Errorf("known user after throttling: %v", err)
+	}
+
+	// Other IPs have budgets of their own.
+	if err := login("192.0.2.2", "d@example.com"); err != nil {
+		t.Errorf("other IP: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package smtp
+
+import (
+	"github.com/emersion/go-smtp"
+)
+
+// errMaintenance is the MAIL reply in maintenance mode.
+var errMaintenance = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Server in maintenance mode, try later",
+}
+
+// checkMaintenance refuses a transaction while the backend is in
+// maintenance mode (Backend.SetMaintenance). The greeting and EHLO still
+// work, so monitoring sees a live server; MAIL gets 421 and, as 421
+// requires, the connection is closed.
+func (s *Session) checkMaintenance() error {
+	if !s.backend.maintenance.Load() {
+		return nil
+	}
+	s.logger.Info("transaction refused in maintenance mode")
+	return s.closeAfterReply(errMaintenance)
+}
@@ -0,0 +1,317 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errMIMETooComplex wraps the reason a message failed the MIME limits.
+var errMIMETooComplex = errors.New("message structure too complex")
+
+// errBadEncoding wraps the reason a message failed the transfer encoding
+// check.
+var errBadEncoding = errors.New("malformed message encoding")
+
+// errMalformedEncoding is the reply to a message failing [smtpd]
+// check_encoding.
+var errMalformedEncoding = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+	Message:      "Malformed message encoding",
+}
+
+// mimeScanner walks a message's MIME structure in a single pass over its
+// lines. Open containers are kept on an explicit stack instead of being
+// parsed recursively, so a hostile message costs one read of its bytes no
+// matter how deeply it nests.
+type mimeScanner struct {
+	br            *bufio.Reader
+	stack         []string // boundaries of open multiparts; "" for message/rfc822
+	parts         int
+	maxDepth      int          // 0 = unlimited
+	maxParts      int          // 0 = unlimited
+	checkEncoding bool         // validate Content-Transfer-Encoding
+	body          *bodyDecoder // current leaf body under check; nil = none
+	truncated     bool         // the last line read was overlong
+}
+
+// scanMIMEStructure reports an error wrapping errMIMETooComplex when the
+// message nests multipart or message/rfc822 entities deeper than maxDepth,
+// or contains more than maxParts body parts. With checkEncoding it also
+// reports an error wrapping errBadEncoding for an unknown
+// Content-Transfer-Encoding, or a base64 or quoted-printable body that does
+// not decode. Other errors come from r.
+func scanMIMEStructure(r io.Reader, maxDepth, maxParts int, checkEncoding bool) error {
+	sc := &mimeScanner{br: bufio.NewReader(r), maxDepth: maxDepth, maxParts: maxParts, checkEncoding: checkEncoding}
+	if err := sc.enterEntity(); err != nil {
+		return err
+	}
+	for {
+		line, err := sc.readLine()
+		if len(line) > 0 {
+			if err := sc.bodyLine(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return sc.endBody()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// enterEntity reads an entity's header section and opens a container when
+// the entity is a multipart or an encapsulated message.
+func (sc *mimeScanner) enterEntity() error {
+	for {
+		mediaType, boundary, cte, err := sc.readHeader()
+		if err != nil {
+			return err
+		}
+		if sc.checkEncoding {
+			switch cte {
+			case "", "7bit", "8bit", "binary", "base64", "quoted-printable":
+			default:
+				return fmt.Errorf("%w: unknown Content-Transfer-Encoding %q", errBadEncoding, cte)
+			}
+		}
+		switch {
+		case strings.HasPrefix(mediaType, "multipart/") && boundary != "":
+			return sc.push(boundary)
+		case mediaType == "message/rfc822":
+			// The body is a message of its own; its header comes next.
+			if err := sc.push(""); err != nil {
+				return err
+			}
+		default:
+			if sc.checkEncoding && (cte == "base64" || cte == "quoted-printable") {
+				sc.body = &bodyDecoder{cte: cte}
+			}
+			return nil
+		}
+	}
+}
+
+// endBody finishes the check of the current leaf body, if any.
+func (sc *mimeScanner) endBody() error {
+	if sc.body == nil {
+		return nil
+	}
+	err := sc.body.end()
+	sc.body = nil
+	return err
+}
+
+func (sc *mimeScanner) push(boundary string) error {
+	if sc.maxDepth > 0 && len(sc.stack) >= sc.maxDepth {
+		return fmt.Errorf("%w: nesting deeper than %d", errMIMETooComplex, sc.maxDepth)
+	}
+	sc.stack = append(sc.stack, boundary)
+	return nil
+}
+
+// bodyLine handles a body line, acting on delimiters of any open multipart.
+// A delimiter of an outer multipart implicitly closes everything inside it.
+func (sc *mimeScanner) bodyLine(line []byte) error {
+	text := strings.TrimRight(string(line), " \t\r\n")
+	if rest, ok := strings.CutPrefix(text, "--"); ok {
+		for i := len(sc.stack) - 1; i >= 0; i-- {
+			b := sc.stack[i]
+			if b == "" || (rest != b && rest != b+"--") {
+				continue
+			}
+			if err := sc.endBody(); err != nil {
+				return err
+			}
+			if rest == b+"--" {
+				sc.stack = sc.stack[:i]
+				return nil
+			}
+			sc.stack = sc.stack[:i+1]
+			sc.parts++
+			if sc.maxParts > 0 && sc.parts > sc.maxParts {
+				return fmt.Errorf("%w: more than %d parts", errMIMETooComplex, sc.maxParts)
+			}
+			return sc.enterEntity()
+		}
+	}
+	if sc.body == nil {
+		return nil
+	}
+	if sc.truncated {
+		// Only the head of an overlong line was kept, which would not
+		// decode on its own; leave this part unchecked.
+		sc.body = nil
+		return nil
+	}
+	return sc.body.line(line)
+}
+
+// readHeader consumes a header section and returns the entity's media type,
+// boundary parameter and lower-cased Content-Transfer-Encoding. A missing or
+// unparsable Content-Type yields "" for the first two.
+func (sc *mimeScanner) readHeader() (mediaType, boundary, cte string, err error) {
+	var ct, te strings.Builder
+	var field *strings.Builder
+	for {
+		line, err := sc.readLine()
+		text := strings.TrimRight(string(line), "\r\n")
+		if text == "" {
+			if err != nil && err != io.EOF {
+				return "", "", "", err
+			}
+			break
+		}
+		switch {
+		case text[0] == ' ' || text[0] == '\t':
+			if field != nil {
+				field.WriteString(text)
+			}
+		default:
+			name, value, ok := strings.Cut(text, ":")
+			name = strings.TrimSpace(name)
+			switch {
+			case ok && strings.EqualFold(name, "Content-Type"):
+				field = &ct
+			case ok && strings.EqualFold(name, "Content-Transfer-Encoding"):
+				field = &te
+			default:
+				field = nil
+			}
+			if field != nil {
+				field.Reset()
+				field.WriteString(value)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+
+	cte = strings.ToLower(strings.TrimSpace(te.String()))
+	mediaType, params, perr := mime.ParseMediaType(ct.String())
+	if perr != nil {
+		return "", "", cte, nil
+	}
+	return mediaType, params["boundary"], cte, nil
+}
+
+// readLine returns the next line. The tail of an overlong line is skipped:
+// it cannot be a delimiter, and the head holds any header name.
+func (sc *mimeScanner) readLine() ([]byte, error) {
+	line, err := sc.br.ReadSlice('\n')
+	sc.truncated = err == bufio.ErrBufferFull
+	if !sc.truncated {
+		return line, err
+	}
+	head := append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		_, err = sc.br.ReadSlice('\n')
+	}
+	return head, err
+}
+
+// checkMIMEStructure enforces [smtpd.limits] max_mime_depth and
+// max_mime_parts. Deeply nested or part-heavy messages are a common way to
+// slip past content filters and to exhaust recursive MIME parsers. For
+// authenticated senders it also enforces [smtpd] check_encoding, so that a
+// broken client's mangled attachments are refused at submission rather
+// than rendered as garbage downstream. A no-op when all are off.
+func (s *Session) checkMIMEStructure(r io.Reader) error {
+	checkEncoding := s.backend.checkEncoding && s.authUser != ""
+	if s.backend.maxMIMEDepth <= 0 && s.backend.maxMIMEParts <= 0 && !checkEncoding {
+		return nil
+	}
+
+	err := scanMIMEStructure(r, s.backend.maxMIMEDepth, s.backend.maxMIMEParts, checkEncoding)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errBadEncoding):
+		s.logger.Info("message rejected", slog.String("reason", err.Error()))
+		return errMalformedEncoding
+	case errors.Is(err, errMIMETooComplex):
+		s.logger.Info("message rejected", slog.String("reason", err.Error()))
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+			Message:      "Message structure too complex",
+		}
+	default:
+		s.logger.Debug("failed to read message data", slog.String("error", err.Error()))
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Error reading message",
+		}
+	}
+}
+
+// bodyDecoder checks, a line at a time, that a leaf body decodes under its
+// Content-Transfer-Encoding. Only gross violations fail: characters outside
+// the base64 alphabet, data after base64 padding, a base64 body that is not
+// a whole number of quanta, or a quoted-printable "=" not followed by two
+// hex digits or a line break.
+type bodyDecoder struct {
+	cte     string // "base64" or "quoted-printable"
+	quanta  int    // base64 characters seen, padding included
+	padding int    // base64 "=" seen
+}
+
+func (d *bodyDecoder) line(line []byte) error {
+	if d.cte == "quoted-printable" {
+		text := bytes.TrimRight(line, " \t\r\n")
+		for i := 0; i < len(text); i++ {
+			if text[i] != '=' || i == len(text)-1 {
+				continue // a trailing "=" is a soft line break
+			}
+			if i+2 >= len(text) || !isHex(text[i+1]) || !isHex(text[i+2]) {
+				return fmt.Errorf("%w: quoted-printable: bad escape %q", errBadEncoding, text[i:min(i+3, len(text))])
+			}
+			i += 2
+		}
+		return nil
+	}
+	for _, c := range line {
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			continue
+		case c == '=':
+			d.padding++
+			if d.padding > 2 {
+				return fmt.Errorf("%w: base64: too much padding", errBadEncoding)
+			}
+		case d.padding > 0:
+			return fmt.Errorf("%w: base64: data after padding", errBadEncoding)
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '+', c == '/':
+		default:
+			return fmt.Errorf("%w: base64: invalid character %q", errBadEncoding, c)
+		}
+		d.quanta++
+	}
+	return nil
+}
+
+func (d *bodyDecoder) end() error {
+	if d.cte == "base64" && d.quanta%4 != 0 {
+		return fmt.Errorf("%w: base64: truncated", errBadEncoding)
+	}
+	return nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'F' || 'a' <= c && c <= 'f'
+}
@@ -0,0 +1,195 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// nestedMultipart builds a message with depth levels of multipart/mixed,
+// each holding the next level and a text part.
+func nestedMultipart(depth int) string {
+	var b strings.Builder
+	b.WriteString("Subject: nested\r\n")
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=\"b%d\"\r\n\r\n--b%d\r\n", i, i)
+	}
+	b.WriteString("Content-Type: text/plain\r\n\r\ninnermost\r\n")
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "--b%d\r\nContent-Type: text/plain\r\n\r\nsibling\r\n--b%d--\r\n", i, i)
+	}
+	return b.String()
+}
+
+const normalMultipart = "From: a@example.com\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative;\r\n" +
+	" boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"hello\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>hello</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--outer--\r\n" +
+	"epilogue\r\n"
+
+func TestScanMIMEStructure(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		maxDepth int
+		maxParts int
+		wantErr  bool
+	}{
+		{"plain text", "Subject: hi\r\n\r\nbody\r\n", 1, 1, false},
+		{"normal multipart", normalMultipart, 2, 4, false},
+		{"multipart one level too deep", normalMultipart, 1, 0, true},
+		{"too many parts", normalMultipart, 0, 3, true},
+		{"pathological nesting", nestedMultipart(500), 20, 0, true},
+		{"pathological nesting unlimited", nestedMultipart(500), 0, 0, false},
+		{"nesting at the limit", nestedMultipart(5), 5, 0, false},
+		{
+			name: "encapsulated messages count as levels",
+			msg: "Content-Type: message/rfc822\r\n\r\n" +
+				"Content-Type: message/rfc822\r\n\r\n" +
+				"Content-Type: multipart/mixed; boundary=x\r\n\r\n" +
+				"--x\r\n\r\nbody\r\n--x--\r\n",
+			maxDepth: 2,
+			wantErr:  true,
+		},
+		{
+			// An unterminated inner multipart is closed by the outer
+			// delimiter, so later siblings do not nest deeper.
+			name: "outer delimiter closes inner multipart",
+			msg: "Content-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/mixed; boundary=inner\r\n\r\n--inner\r\n\r\nx\r\n" +
+				"--outer\r\nContent-Type: multipart/mixed; boundary=inner2\r\n\r\n--inner2\r\n\r\ny\r\n" +
+				"--outer--\r\n",
+			maxDepth: 2,
+		},
+		{
+			name:     "delimiter-like body lines are ignored",
+			msg:      "Content-Type: text/plain\r\n\r\n--b0\r\n--b0\r\n",
+			maxParts: 1,
+		},
+		{"no header/body separator", "Content-Type: multipart/mixed; boundary=x", 1, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scanMIMEStructure(strings.NewReader(tt.msg), tt.maxDepth, tt.maxParts, false)
+			if tt.wantErr != errors.Is(err, errMIMETooComplex) {
+				t.Errorf("scanMIMEStructure() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errMIMETooComplex) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestScanMIMEStructure_LongLines(t *testing.T) {
+	// Lines longer than the read buffer must not hide a later delimiter.
+	msg := "Content-Type: multipart/mixed; boundary=x\r\n\r\n" +
+		"--x\r\n\r\n" + strings.Repeat("a", 10000) + "\r\n" +
+		"--x\r\n\r\nsecond\r\n--x--\r\n"
+	if err := scanMIMEStructure(strings.NewReader(msg), 0, 1, false); !errors.Is(err, errMIMETooComplex) {
+		t.Errorf("expected part limit error, got %v", err)
+	}
+}
+
+// attachment builds a multipart message whose second part has the given
+// Content-Transfer-Encoding and body.
+func attachment(cte, body string) string {
+	return "From: a@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=x\r\n\r\n" +
+		"--x\r\nContent-Type: text/plain\r\n\r\nsee attached\r\n" +
+		"--x\r\nContent-Type: application/pdf\r\nContent-Transfer-Encoding: " + cte + "\r\n\r\n" +
+		body +
+		"--x--\r\n"
+}
+
+func TestScanMIMEStructure_Encoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		wantErr bool
+	}{
+		{"valid base64", attachment("base64", "JVBERi0xLjQK\r\nJVBERi0=\r\n"), false},
+		{"base64 upper case token", attachment("BASE64", "JVBERi0xLjQK\r\n"), false},
+		{"base64 bad character", attachment("base64", "JVBE*i0xLjQK\r\n"), true},
+		{"base64 truncated", attachment("base64", "JVBERi0xLjQ\r\n"), true},
+		{"base64 data after padding", attachment("base64", "JVBERi0=\r\nJVBE\r\n"), true},
+		{"valid quoted-printable", attachment("quoted-printable", "caf=C3=A9 soft=\r\nbreak\r\n"), false},
+		{"quoted-printable bad escape", attachment("quoted-printable", "caf=ZZ\r\n"), true},
+		{"quoted-printable cut escape", attachment("quoted-printable", "caf=C\r\n"), true},
+		{"unknown encoding", attachment("uuencode", "begin 644 x\r\n"), true},
+		{"7bit", attachment("7bit", "plain\r\n"), false},
+		{
+			name: "single part base64",
+			msg:  "Content-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n",
+		},
+		{
+			name:    "single part base64 corrupt",
+			msg:     "Content-Transfer-Encoding: base64\r\n\r\naGVsbG8\r\n",
+			wantErr: true,
+		},
+		{
+			// An overlong line cannot be checked from its head alone.
+			name: "base64 overlong line skipped",
+			msg:  attachment("base64", strings.Repeat("QUJD", 3000)+"\r\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := scanMIMEStructure(strings.NewReader(tt.msg), 0, 0, true)
+			if tt.wantErr != errors.Is(err, errBadEncoding) {
+				t.Errorf("scanMIMEStructure() = %v, wantErr %v", err, tt.wantErr)
+			}
+			// Without the check the same messages pass.
+			if err := scanMIMEStructure(strings.NewReader(tt.msg), 0, 0, false); err != nil {
+				t.Errorf("scanMIMEStructure() without check = %v", err)
+			}
+		})
+	}
+}
+
+func TestSession_CheckMIMEStructure_Encoding(t *testing.T) {
+	corrupt := attachment("base64", "JVBE*i0xLjQK\r\n")
+	valid := attachment("base64", "JVBERi0xLjQK\r\n")
+	backend := NewBackend(BackendConfig{CheckEncoding: true})
+
+	tests := []struct {
+		name     string
+		authUser string
+		msg      string
+		want     error
+	}{
+		{"authenticated corrupt", "alice@example.com", corrupt, errMalformedEncoding},
+		{"authenticated valid", "alice@example.com", valid, nil},
+		{"unauthenticated corrupt", "", corrupt, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{backend: backend, authUser: tt.authUser, logger: slog.Default()}
+			if err := s.checkMIMEStructure(strings.NewReader(tt.msg)); err != tt.want {
+				t.Errorf("checkMIMEStructure() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errMessageDeadline is the reply when smtpd's own work on one message has
+// used up its time budget.
+var errMessageDeadline = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Message processing timed out, try again later",
+}
+
+// stage starts one processing stage of the current message (the MAIL and
+// RCPT lookups, the spam check, delivery) and returns its context, which
+// expires when the message's budget ([smtpd.timeouts] command) is spent.
+// done ends the stage and charges its duration to the budget, so a single
+// slow stage leaves less time for the later ones; time spent waiting for
+// the client between commands is not charged. Reset refills the budget.
+func (s *Session) stage() (ctx context.Context, done func()) {
+	limit := s.backend.messageTimeout
+	if limit <= 0 {
+		return context.Background(), func() {}
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), limit-s.budgetUsed)
+	return ctx, func() {
+		s.budgetUsed += time.Since(start)
+		cancel()
+	}
+}
+
+// checkDeadline returns errMessageDeadline if ctx, from stage, expired
+// during the named stage, and nil otherwise.
+func (s *Session) checkDeadline(ctx context.Context, name string) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	if s.backend.collector != nil {
+		recipients := s.recipients
+		if len(recipients) == 0 {
+			recipients = s.remoteRecipients
+		}
+		s.backend.collector.MessageRejected(sessionExtractRecipientDomain(recipients), "deadline")
+	}
+	s.logger.Warn("message processing deadline exceeded",
+		slog.String("stage", name),
+		slog.String("from", s.from),
+		slog.Duration("budget", s.backend.messageTimeout))
+	return errMessageDeadline
+}
@@ -0,0 +1,165 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestSession_Stage_ChargesBudget(t *testing.T) {
+	session := &Session{
+		backend: &Backend{messageTimeout: time.Hour},
+		logger:  slog.Default(),
+	}
+
+	_, done := session.stage()
+	time.Sleep(20 * time.Millisecond)
+	done()
+	if session.budgetUsed < 20*time.Millisecond {
+		t.Fatalf("budgetUsed = %v after a 20ms stage", session.budgetUsed)
+	}
+
+	ctx, done := session.stage()
+	deadline, ok := ctx.Deadline()
+	done()
+	if !ok {
+		t.Fatal("stage context has no deadline")
+	}
+	if left := time.Until(deadline); left > time.Hour-20*time.Millisecond {
+		t.Errorf("second stage has %v left, want the first stage charged", left)
+	}
+
+	session.Reset()
+	if session.budgetUsed != 0 {
+		t.Errorf("budgetUsed = %v after Reset, want 0", session.budgetUsed)
+	}
+}
+
+func TestSession_Stage_Unlimited(t *testing.T) {
+	session := &Session{backend: &Backend{}, logger: slog.Default()}
+	ctx, done := session.stage()
+	defer done()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("stage has a deadline with messageTimeout unset")
+	}
+}
+
+func TestSession_Data_MessageDeadline(t *testing.T) {
+	logger := slog.Default()
+	enabled := true
+	backend := &Backend{
+		// The spam check has no total_timeout of its own, and fail-open
+		// would carry on; the message budget must still stop it.
+		spamChecker: &slowChecker{delay: 10 * time.Second},
+		spamConfig: config.SpamCheckConfig{
+			Enabled:  true,
+			Checkers: []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+			FailMode: config.SpamCheckFailOpen,
+		},
+		messageTimeout: 100 * time.Millisecond,
+		tempDir:        t.TempDir(),
+		logger:         logger,
+	}
+	session := &Session{
+		backend:                  backend,
+		mailFromSeen:             true,
+		from:                     "sender@example.com",
+		deferredInvalidRecipient: "nobody@example.com",
+		logger:                   logger,
+		// MAIL and RCPT already used most of the budget.
+		budgetUsed: 80 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err := session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("message deadline did not fire (took %v)", elapsed)
+	}
+	if !errors.Is(err, errMessageDeadline) {
+		t.Fatalf("Data() = %v, want %v", err, errMessageDeadline)
+	}
+}
+
+// trickleReader serves its lines one at a time, pausing before each.
+type trickleReader struct {
+	lines []string
+	pause time.Duration
+}
+
+func (r *trickleReader) Read(p []byte) (int, error) {
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	n := copy(p, r.lines[0])
+	r.lines[0] = r.lines[0][n:]
+	if r.lines[0] == "" {
+		r.lines = r.lines[1:]
+	}
+	return n, nil
+}
+
+func TestSession_Data_SlowTransferNotCharged(t *testing.T) {
+	enabled := true
+	mock := &mockDeliveryServer{result: pb.DeliverResult_DELIVER_RESULT_DELIVERED}
+	agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: startMockServer(t, mock)}, nil)
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	defer func() { _ = agent.Close() }()
+
+	backend := NewBackend(BackendConfig{
+		SMDelivery:  agent,
+		SpamChecker: &slowChecker{},
+		SpamConfig: config.SpamCheckConfig{
+			Enabled:  true,
+			Checkers: []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+		},
+		MessageTimeout: 100 * time.Millisecond,
+		TempDir:        t.TempDir(),
+	})
+	session := &Session{
+		backend:      backend,
+		mailFromSeen: true,
+		from:         "sender@example.com",
+		recipients:   []string{"bob@example.com"},
+		logger:       slog.Default(),
+	}
+
+	// The client takes longer to send the message than the budget allows;
+	// only smtpd's own work after the final dot is charged.
+	body := &trickleReader{
+		lines: []string{"Subject: test\r\n", "\r\n", "line one\r\n", "line two\r\n"},
+		pause: 50 * time.Millisecond,
+	}
+	if err := session.Data(body); err != nil {
+		t.Fatalf("Data() = %v, want nil", err)
+	}
+}
+
+func TestSession_CheckDeadline(t *testing.T) {
+	session := &Session{backend: &Backend{}, logger: slog.Default()}
+
+	if err := session.checkDeadline(context.Background(), "rcpt"); err != nil {
+		t.Errorf("checkDeadline(live) = %v, want nil", err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := session.checkDeadline(canceled, "rcpt"); err != nil {
+		t.Errorf("checkDeadline(canceled) = %v, want nil", err)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if err := session.checkDeadline(expired, "rcpt"); err != errMessageDeadline {
+		t.Errorf("checkDeadline(expired) = %v, want %v", err, errMessageDeadline)
+	}
+}
@@ -0,0 +1,37 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errNullMX refuses a remote recipient whose domain publishes a null MX
+// (RFC 7505 §4.3).
+var errNullMX = &smtp.SMTPError{
+	Code:         556,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 10},
+	Message:      "Recipient address has null MX",
+}
+
+// checkNullMX enforces [smtpd] reject_null_mx for a remote recipient on
+// authenticated submission. A domain whose only MX is "." with preference 0
+// declares that it accepts no mail, so queueing the message would only end
+// in a bounce; refusing at RCPT tells the user at once. Lookup failures
+// pass: the queue copes with those.
+func (s *Session) checkNullMX(ctx context.Context, domain string) error {
+	if !s.backend.rejectNullMX || domain == "" {
+		return nil
+	}
+	mxs, err := s.backend.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		s.logger.Debug("MX lookup failed", slog.String("domain", domain), slog.String("error", err.Error()))
+		return nil
+	}
+	if len(mxs) != 1 || mxs[0].Host != "." || mxs[0].Pref != 0 {
+		return nil
+	}
+	s.logger.Info("recipient domain has null MX", slog.String("domain", domain))
+	return errNullMX
+}
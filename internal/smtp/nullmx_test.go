@@ -0,0 +1,60 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+)
+
+func TestSession_Rcpt_NullMX(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: false},
+	})
+	resolver := &mockResolver{
+		mx: map[string][]*net.MX{
+			"nomail.example":   {{Host: ".", Pref: 0}},
+			"mail.example":     {{Host: "mx1.mail.example.", Pref: 10}},
+			"confused.example": {{Host: ".", Pref: 0}, {Host: "mx.confused.example.", Pref: 10}},
+		},
+		fail: map[string]bool{"flaky.example": true},
+	}
+
+	tests := []struct {
+		name   string
+		reject bool
+		to     string
+		want   error
+	}{
+		{"null MX refused", true, "bob@nomail.example", errNullMX},
+		{"null MX, any case", true, "bob@NoMail.Example", errNullMX},
+		{"ordinary MX queued", true, "bob@mail.example", nil},
+		{"null MX beside others queued", true, "bob@confused.example", nil},
+		{"lookup failure queued", true, "bob@flaky.example", nil},
+		{"no MX at all queued", true, "bob@unknown.example", nil},
+		{"check off", false, "bob@nomail.example", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := NewBackend(BackendConfig{SMDelivery: agent, RejectNullMX: tt.reject})
+			backend.resolver = resolver
+			s := &Session{
+				backend:      backend,
+				authUser:     "alice@example.com",
+				from:         "alice@example.com",
+				mailFromSeen: true,
+				logger:       slog.Default(),
+			}
+
+			err := s.Rcpt(tt.to, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Rcpt(%s) = %v, want %v", tt.to, err, tt.want)
+			}
+			if queued := len(s.remoteRecipients) == 1; queued != (tt.want == nil) {
+				t.Errorf("remote recipients = %v", s.remoteRecipients)
+			}
+		})
+	}
+}
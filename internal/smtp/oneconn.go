@@ -1,8 +1,10 @@
 package smtp
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
+	"sync/atomic"
 )
 
 // oneConnListener is a net.Listener that serves exactly one connection.
@@ -71,9 +73,54 @@ type notifyConn struct {
 	net.Conn
 	closeOnce sync.Once
 	done      chan struct{}
+	// closing is set by markCloseAfterReply; the next write closes the
+	// connection once it is sent.
+	closing atomic.Bool
 }
 
 func (c *notifyConn) Close() error {
 	c.closeOnce.Do(func() { close(c.done) })
 	return c.Conn.Close()
 }
+
+// Write passes p on, then closes the connection if it was marked to close
+// after its next reply. go-smtp flushes each reply line with one write.
+func (c *notifyConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if c.closing.Load() {
+		_ = c.Close()
+	}
+	return n, err
+}
+
+// markCloseAfterReply marks the notifyConn under conn, which STARTTLS may
+// have wrapped in a *tls.Conn, to close once the next reply is written. It
+// reports false when conn is not served through one.
+func markCloseAfterReply(conn net.Conn) bool {
+	for {
+		switch c := conn.(type) {
+		case *notifyConn:
+			c.closing.Store(true)
+			return true
+		case *tls.Conn:
+			conn = c.NetConn()
+		default:
+			return false
+		}
+	}
+}
+
+// notifyListener wraps each accepted connection in a notifyConn, so that
+// connections served by Run can be closed after a reply like those served
+// by RunSingleConn.
+type notifyListener struct {
+	net.Listener
+}
+
+func (l notifyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &notifyConn{Conn: c, done: make(chan struct{})}, nil
+}
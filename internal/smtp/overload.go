@@ -0,0 +1,56 @@
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// DefaultOverloadMessage is the reply text for overload responses when
+// [smtpd] overload_message is not set (RFC 3463 status 4.3.2).
+const DefaultOverloadMessage = "System not accepting network messages"
+
+// overloadError returns the reply used for every overload and backpressure
+// condition (rate limits, connection caps, shutdown). Using one code for all
+// of them makes deferrals caused by load easy to tell apart in the sending
+// MTA's logs. An empty message falls back to DefaultOverloadMessage.
+func overloadError(message string) *smtp.SMTPError {
+	if message == "" {
+		message = DefaultOverloadMessage
+	}
+	return &smtp.SMTPError{
+		Code:         421,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+		Message:      message,
+	}
+}
+
+// rejectOverloaded writes the overload reply as the greeting on a connection
+// that will not be served, then closes it.
+func rejectOverloaded(conn net.Conn, message string) {
+	rejectConn(conn, overloadError(message))
+}
+
+// rejectConn writes e as the greeting on a connection that will not be
+// served, then closes it. Once the session has started, replies go through
+// go-smtp instead; see Session.closeAfterReply.
+func rejectConn(conn net.Conn, e *smtp.SMTPError) {
+	_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn, "%d %d.%d.%d %s\r\n",
+		e.Code, e.EnhancedCode[0], e.EnhancedCode[1], e.EnhancedCode[2], e.Message)
+	_ = conn.Close()
+}
+
+// closeAfterReply returns e, a 421 reply to the current command, and has the
+// connection closed once go-smtp has written it: 421 means the server is
+// closing the transmission channel (RFC 5321 §4.2.2). The reply goes out
+// once, through go-smtp's writer, so log_transactions and [smtpd.capture]
+// record it.
+func (s *Session) closeAfterReply(e *smtp.SMTPError) *smtp.SMTPError {
+	if s.conn != nil && s.conn.Conn() != nil && !markCloseAfterReply(s.conn.Conn()) {
+		s.logger.Debug("connection not closable after reply")
+	}
+	return e
+}
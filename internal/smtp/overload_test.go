@@ -0,0 +1,98 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestOverloadError(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"default message", "", DefaultOverloadMessage},
+		{"configured message", "Try again in 10 minutes", "Try again in 10 minutes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := overloadError(tt.message)
+			if err.Code != 421 {
+				t.Errorf("Code = %d, want 421", err.Code)
+			}
+			if err.EnhancedCode != (gosmtp.EnhancedCode{4, 3, 2}) {
+				t.Errorf("EnhancedCode = %v, want 4.3.2", err.EnhancedCode)
+			}
+			if err.Message != tt.want {
+				t.Errorf("Message = %q, want %q", err.Message, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_CloseAfterReply(t *testing.T) {
+	var logs bytes.Buffer
+	backend := NewBackend(BackendConfig{Hostname: "test.local"})
+	backend.SetMaintenance(true)
+	serverTLS, clientTLS := selfSignedTLS(t)
+	conn, done := serveConfiguredConn(t, config.ModeSmtp, ServerConfig{
+		Backend:         backend,
+		TLSConfig:       serverTLS,
+		LogTransactions: true,
+		Logger:          slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	r := bufio.NewReader(conn)
+	send := func(cmd, want string) {
+		t.Helper()
+		if cmd != "" {
+			_, _ = io.WriteString(conn, cmd+"\r\n")
+		}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("%q: read: %v", cmd, err)
+			}
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("%q: got %q, want %s", cmd, line, want)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	// After STARTTLS the session's connection is a *tls.Conn over the
+	// notifyConn that does the closing.
+	send("", "220")
+	send("EHLO client.example", "250")
+	send("STARTTLS", "220")
+	tlsConn := tls.Client(conn, clientTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	conn, r = tlsConn, bufio.NewReader(tlsConn)
+	send("EHLO client.example", "250")
+	send("MAIL FROM:<alice@example.com>", "421 4.3.2 Server in maintenance mode")
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("connection not closed after 421: %v", err)
+	}
+	waitDone(t, done)
+
+	// The reply went out once, through go-smtp's writer and so through the
+	// transaction log.
+	if n := strings.Count(logs.String(), "data=\"421 4.3.2 Server in maintenance mode"); n != 1 {
+		t.Errorf("421 logged %d times, want 1:\n%s", n, logs.String())
+	}
+}
@@ -0,0 +1,94 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// probeTimeout bounds one listener probe when the caller's context has no
+// deadline of its own.
+const probeTimeout = 5 * time.Second
+
+// ProbeListeners opens a loopback session to each configured listener and
+// checks that it greets, answers EHLO, and offers the TLS it is configured
+// for: a TLS handshake on smtps listeners, and STARTTLS elsewhere when a
+// certificate is configured and tls_policy is not "off". TLS
+// misconfiguration leaves the listener itself up: without a key_file
+// STARTTLS is silently not offered, and an unreadable certificate makes
+// every protocol-handler exit before the greeting. It backs /readyz when
+// [metrics] readiness_probe is set.
+func ProbeListeners(ctx context.Context, cfg *config.Config) error {
+	haveCert := cfg.TLS.CertFile != ""
+	for _, l := range cfg.Listeners {
+		wantTLS := haveCert && l.GetTLSPolicy() != config.TLSPolicyOff
+		if err := ProbeListener(ctx, l, wantTLS); err != nil {
+			return fmt.Errorf("listener %s: %w", l.Address, err)
+		}
+	}
+	return nil
+}
+
+// ProbeListener runs one probe session against l (see ProbeListeners).
+// wantTLS requires STARTTLS on listeners without implicit TLS.
+func ProbeListener(ctx context.Context, l config.ListenerConfig, wantTLS bool) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", probeAddr(l.Address))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// The probe checks that TLS is offered and works, not who we are: the
+	// certificate names the public hostname, not the loopback address.
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+	var c *gosmtp.Client
+	switch {
+	case l.Mode == config.ModeSmtps:
+		c = gosmtp.NewClient(tls.Client(conn, tlsConfig))
+		err = c.Hello("localhost")
+	case wantTLS:
+		c, err = gosmtp.NewClientStartTLS(conn, tlsConfig)
+		if err == nil {
+			err = c.Noop() // EHLO again, over TLS, so the handshake runs
+		}
+	default:
+		c = gosmtp.NewClient(conn)
+		err = c.Hello("localhost")
+	}
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// probeAddr turns a listen address into one to dial: an empty or
+// unspecified host becomes the loopback address of the same family.
+func probeAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case host == "" || (ip != nil && ip.To4() != nil && ip.IsUnspecified()):
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
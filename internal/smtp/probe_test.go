@@ -0,0 +1,112 @@
+package smtp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	smtpserver "github.com/infodancer/smtpd/internal/smtp"
+)
+
+// startProbeTarget runs an in-process server on one loopback listener and
+// returns its port. A nil serverTLS stands in for a certificate that
+// failed to load: the listener runs, but without STARTTLS.
+func startProbeTarget(t *testing.T, serverTLS *tls.Config) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	srv, err := smtpserver.NewServer(smtpserver.ServerConfig{
+		Backend:      smtpserver.NewBackend(smtpserver.BackendConfig{Hostname: "test.local", TempDir: t.TempDir()}),
+		Listeners:    []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+		Hostname:     "test.local",
+		TLSConfig:    serverTLS,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = srv.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if c, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+			_ = c.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	return port
+}
+
+func TestProbeListener(t *testing.T) {
+	serverTLS, _ := generateTestTLS(t)
+	withTLS := startProbeTarget(t, serverTLS)
+	withoutTLS := startProbeTarget(t, nil)
+
+	tests := []struct {
+		name    string
+		port    string
+		wantTLS bool
+		wantErr string
+	}{
+		{"STARTTLS offered", withTLS, true, ""},
+		{"STARTTLS missing", withoutTLS, true, "STARTTLS"},
+		{"no TLS expected", withoutTLS, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An unspecified host is probed on loopback.
+			l := config.ListenerConfig{Address: ":" + tt.port, Mode: config.ModeSmtp}
+			err := smtpserver.ProbeListener(context.Background(), l, tt.wantTLS)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ProbeListener: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ProbeListener: got %v, want error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeListeners_MisconfiguredTLS(t *testing.T) {
+	port := startProbeTarget(t, nil)
+	cfg := config.Default()
+	cfg.TLS.CertFile = "/nonexistent/cert.pem"
+	cfg.Listeners = []config.ListenerConfig{{Address: "127.0.0.1:" + port, Mode: config.ModeSmtp}}
+
+	err := smtpserver.ProbeListeners(context.Background(), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:"+port) {
+		t.Fatalf("ProbeListeners: got %v, want error naming the listener", err)
+	}
+
+	// With tls_policy = "off" the listener is not expected to offer TLS.
+	cfg.Listeners[0].TLSPolicy = config.TLSPolicyOff
+	if err := smtpserver.ProbeListeners(context.Background(), &cfg); err != nil {
+		t.Fatalf("ProbeListeners with tls_policy off: %v", err)
+	}
+}
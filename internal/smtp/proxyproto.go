@@ -0,0 +1,141 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature is the fixed 12-byte prefix of a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Line is the longest valid PROXY v1 header including CRLF.
+const maxProxyV1Line = 107
+
+var errBadProxyHeader = errors.New("invalid PROXY protocol header")
+
+// readProxyHeader reads a PROXY protocol (v1 or v2) header from r and returns
+// the original client address. It returns a nil address for headers that do
+// not carry one (v1 UNKNOWN, v2 LOCAL, or non-IP families), in which case the
+// connection's own peer address applies.
+//
+// The header is read byte-exact: the socket is handed to a protocol-handler
+// subprocess afterwards, so nothing past the header may be consumed.
+func readProxyHeader(r io.Reader) (*net.TCPAddr, error) {
+	// Both versions are at least 12 bytes long ("PROXY UNKNOWN\r\n" is 15).
+	prefix := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+
+	if bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readProxyV1(r, prefix)
+	}
+	return nil, errBadProxyHeader
+}
+
+// readProxyV1 reads the remainder of a text header whose first bytes are in
+// prefix, e.g. "PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n".
+func readProxyV1(r io.Reader, prefix []byte) (*net.TCPAddr, error) {
+	line := append([]byte(nil), prefix...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxProxyV1Line {
+			return nil, errBadProxyHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("reading PROXY header: %w", err)
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errBadProxyHeader
+	}
+
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, errBadProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errBadProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 reads the binary header that follows the v2 signature.
+func readProxyV2(r io.Reader) (*net.TCPAddr, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+	if hdr[0]>>4 != 2 {
+		return nil, errBadProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[2:4]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+
+	switch hdr[0] & 0x0f {
+	case 0x0: // LOCAL: health check from the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errBadProxyHeader
+	}
+
+	switch hdr[1] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errBadProxyHeader
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		port := binary.BigEndian.Uint16(body[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errBadProxyHeader
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16]))
+		port := binary.BigEndian.Uint16(body[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, port)), nil
+	default:
+		return nil, nil
+	}
+}
+
+// proxiedConn reports the client address from a PROXY header instead of the
+// proxy's own address.
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr { return c.remote }
+
+// WithRemoteAddr wraps conn so RemoteAddr reports addr ("ip:port"). The
+// protocol-handler uses it to apply the client address the parent process
+// read from a PROXY header on a trusted_proxy listener.
+func WithRemoteAddr(conn net.Conn, addr string) (net.Conn, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client address %q: %w", addr, err)
+	}
+	return &proxiedConn{Conn: conn, remote: net.TCPAddrFromAddrPort(ap)}, nil
+}
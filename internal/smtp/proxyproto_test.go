@@ -0,0 +1,143 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyV2 builds a PROXY v2 header for a TCP/IPv4 or TCP/IPv6 source.
+func proxyV2(cmd byte, src net.IP, srcPort uint16) []byte {
+	var body []byte
+	fam := byte(0x11)
+	if v4 := src.To4(); v4 != nil {
+		body = append(body, v4...)
+		body = append(body, 192, 0, 2, 1)
+	} else {
+		fam = 0x21
+		body = append(body, src.To16()...)
+		body = append(body, net.ParseIP("2001:db8::1").To16()...)
+	}
+	body = binary.BigEndian.AppendUint16(body, srcPort)
+	body = binary.BigEndian.AppendUint16(body, 25)
+
+	hdr := append([]byte(nil), proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, fam)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return append(hdr, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string // "" = no address
+		wantErr bool
+	}{
+		{
+			name:   "v1 TCP4",
+			header: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n"),
+			want:   "203.0.113.7:40000",
+		},
+		{
+			name:   "v1 TCP6",
+			header: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 40000 25\r\n"),
+			want:   "[2001:db8::7]:40000",
+		},
+		{
+			name:   "v1 UNKNOWN",
+			header: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v2 TCP4",
+			header: proxyV2(0x1, net.ParseIP("203.0.113.7"), 40000),
+			want:   "203.0.113.7:40000",
+		},
+		{
+			name:   "v2 TCP6",
+			header: proxyV2(0x1, net.ParseIP("2001:db8::7"), 40000),
+			want:   "[2001:db8::7]:40000",
+		},
+		{
+			name:   "v2 LOCAL",
+			header: proxyV2(0x0, net.ParseIP("203.0.113.7"), 40000),
+		},
+		{
+			name:    "plain SMTP instead of header",
+			header:  []byte("EHLO client.example\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 family mismatch",
+			header:  []byte("PROXY TCP4 2001:db8::7 192.0.2.1 40000 25\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 bad port",
+			header:  []byte("PROXY TCP4 203.0.113.7 192.0.2.1 99999 25\r\n"),
+			wantErr: true,
+		},
+		{
+			name:    "v1 missing CRLF",
+			header:  bytes.Repeat([]byte("PROXY TCP4 "), 20),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			header:  []byte("PROXY"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// SMTP bytes following the header must be left unread.
+			const rest = "EHLO client.example\r\n"
+			r := bytes.NewReader(append(append([]byte(nil), tt.header...), rest...))
+
+			addr, err := readProxyHeader(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got address %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader: %v", err)
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("address = %q, want %q", got, tt.want)
+			}
+
+			left, _ := io.ReadAll(r)
+			if string(left) != rest {
+				t.Errorf("bytes after header = %q, want %q", left, rest)
+			}
+		})
+	}
+}
+
+func TestWithRemoteAddr(t *testing.T) {
+	server, client := net.Pipe()
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	conn, err := WithRemoteAddr(server, "203.0.113.7:40000")
+	if err != nil {
+		t.Fatalf("WithRemoteAddr: %v", err)
+	}
+	if got := extractIPFromConn(conn); got != "203.0.113.7" {
+		t.Errorf("client IP = %q, want 203.0.113.7", got)
+	}
+
+	if _, err := WithRemoteAddr(server, "not-an-address"); err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
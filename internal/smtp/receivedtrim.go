@@ -0,0 +1,148 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// receivedRange is the run of Received fields, counted from the top of
+// the header section, that max_received_headers drops on delivery. The
+// zero value drops nothing.
+type receivedRange struct {
+	from, to int
+}
+
+// planReceivedTrim decides which Received fields of the message in r go
+// over [smtpd] max_received_headers. Fields are added at the top at each
+// hop, so the newest max are kept. The oldest are kept too when a
+// DKIM-Signature or ARC-Message-Signature covers them: a signature listing
+// "received" k times in h= signs the bottom k instances (RFC 6376 §5.4.2),
+// and dropping one would break it. What is left in between is dropped.
+func (s *Session) planReceivedTrim(r io.Reader) receivedRange {
+	keep := s.backend.maxReceived
+	if keep <= 0 {
+		return receivedRange{}
+	}
+	total, signed := countReceived(r)
+	rr := receivedRange{from: keep, to: total - signed}
+	if rr.to <= rr.from {
+		return receivedRange{}
+	}
+	s.logger.Info("trimming Received headers",
+		slog.Int("received", total),
+		slog.Int("dropped", rr.to-rr.from),
+		slog.Int("signed", signed),
+		slog.Int("max_received_headers", keep))
+	return rr
+}
+
+// countReceived returns the number of Received fields in the header
+// section of r, and the most of them any one signature covers.
+func countReceived(r io.Reader) (total, signed int) {
+	br := bufio.NewReader(r)
+	var field strings.Builder
+	done := func() {
+		name, value, ok := strings.Cut(field.String(), ":")
+		field.Reset()
+		if !ok {
+			return
+		}
+		switch name = strings.TrimRight(name, " \t"); {
+		case strings.EqualFold(name, "Received"):
+			total++
+		case strings.EqualFold(name, "DKIM-Signature"), strings.EqualFold(name, "ARC-Message-Signature"):
+			signed = max(signed, signedReceived(value))
+		}
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == "" {
+			done()
+			return total, signed
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			done()
+		}
+		field.WriteString(line)
+		if err != nil {
+			done()
+			return total, signed
+		}
+	}
+}
+
+// signedReceived returns how many times the h= tag of a signature field
+// value lists Received.
+func signedReceived(value string) int {
+	n := 0
+	for _, tag := range strings.Split(value, ";") {
+		name, list, ok := strings.Cut(tag, "=")
+		if !ok || strings.TrimSpace(name) != "h" {
+			continue
+		}
+		for _, h := range strings.Split(list, ":") {
+			if strings.EqualFold(strings.Join(strings.Fields(h), ""), "Received") {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// receivedFilter drops the Received fields in a receivedRange, including
+// their folded continuation lines, from a message's header section. The
+// body is passed through unchanged.
+type receivedFilter struct {
+	r        *bufio.Reader
+	drop     receivedRange
+	seen     int // Received fields met so far
+	inHeader bool
+	skipping bool
+	pending  []byte
+}
+
+func newReceivedFilter(r io.Reader, drop receivedRange) *receivedFilter {
+	return &receivedFilter{r: bufio.NewReader(r), drop: drop, inHeader: true}
+}
+
+func (f *receivedFilter) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if !f.inHeader {
+			return f.r.Read(p)
+		}
+		line, err := f.r.ReadBytes('\n')
+		if len(line) > 0 && f.keep(line) {
+			f.pending = line
+		}
+		if err != nil {
+			if len(f.pending) == 0 {
+				return 0, err
+			}
+			f.inHeader = false // emit what is left, then report err from r
+			break
+		}
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// keep decides whether a header-section line is passed on.
+func (f *receivedFilter) keep(line []byte) bool {
+	switch {
+	case len(bytes.TrimRight(line, "\r\n")) == 0:
+		f.inHeader = false // blank line: body follows
+		return true
+	case line[0] == ' ' || line[0] == '\t':
+		return !f.skipping
+	}
+	f.skipping = false
+	if name, _, ok := bytes.Cut(line, []byte(":")); ok && bytes.EqualFold(bytes.TrimRight(name, " \t"), []byte("Received")) {
+		f.skipping = f.seen >= f.drop.from && f.seen < f.drop.to
+		f.seen++
+	}
+	return !f.skipping
+}
@@ -0,0 +1,103 @@
+package smtp
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// receivedChain returns n Received fields, newest (hop0) first, the second
+// line of each folded.
+func receivedChain(n int) string {
+	var b strings.Builder
+	for i := range n {
+		fmt.Fprintf(&b, "Received: from hop%d.example\r\n\tby mx.example; Mon, 2 Mar 2026 09:00:00 +0000\r\n", i)
+	}
+	return b.String()
+}
+
+// hops returns the hop numbers of the Received fields left in msg.
+func hops(msg string) []string {
+	var got []string
+	for _, line := range strings.Split(msg, "\r\n") {
+		if rest, ok := strings.CutPrefix(line, "Received: from "); ok {
+			got = append(got, strings.TrimSuffix(rest, ".example"))
+		}
+	}
+	return got
+}
+
+func TestReceivedTrim(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		signature string
+		want      string
+	}{
+		{"off", 0, "", "hop0,hop1,hop2,hop3,hop4,hop5,hop6,hop7,hop8,hop9"},
+		{"under the limit", 20, "", "hop0,hop1,hop2,hop3,hop4,hop5,hop6,hop7,hop8,hop9"},
+		{"trims the oldest", 3, "", "hop0,hop1,hop2"},
+		{"keeps signed", 3, "DKIM-Signature: v=1; a=rsa-sha256; d=example.org;\r\n\th=From:Subject:Received:\r\n\t Received; bh=x; b=y\r\n", "hop0,hop1,hop2,hop8,hop9"},
+		{"arc signed", 3, "ARC-Message-Signature: i=1; h=received:from; b=y\r\n", "hop0,hop1,hop2,hop9"},
+		{"signature covers the rest", 3, "DKIM-Signature: h=" + strings.Repeat("received:", 8) + "from; b=y\r\n", "hop0,hop1,hop2,hop3,hop4,hop5,hop6,hop7,hop8,hop9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := receivedChain(10) + tt.signature + "From: a@example.org\r\nSubject: loop\r\n\r\nReceived: from body.example\r\n"
+			s := &Session{
+				backend: &Backend{maxReceived: tt.max, logger: slog.Default()},
+				logger:  slog.Default(),
+			}
+			s.receivedTrim = s.planReceivedTrim(strings.NewReader(msg))
+			tmp := &memTempBuf{}
+			tmp.buf.WriteString(msg)
+			out, err := io.ReadAll(s.messageBody(tmp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(hops(string(out)), ","); got != tt.want+",body" {
+				t.Errorf("Received kept = %s, want %s,body", got, tt.want)
+			}
+			if strings.Count(string(out), "\tby mx.example") != strings.Count(tt.want, "hop") {
+				t.Errorf("continuation lines of dropped fields left behind:\n%s", out)
+			}
+			if !strings.HasSuffix(string(out), "From: a@example.org\r\nSubject: loop\r\n\r\nReceived: from body.example\r\n") {
+				t.Errorf("other headers or body altered:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestSession_Data_TrimsReceived(t *testing.T) {
+	mock := &mockDeliveryServer{result: pb.DeliverResult_DELIVER_RESULT_DELIVERED}
+	agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: startMockServer(t, mock)}, nil)
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	defer func() { _ = agent.Close() }()
+
+	s := &Session{
+		backend: &Backend{
+			smDelivery:  agent,
+			maxReceived: 2,
+			tempDir:     t.TempDir(),
+			logger:      slog.Default(),
+		},
+		mailFromSeen: true,
+		from:         "sender@example.org",
+		recipients:   []string{"user@example.com"},
+		logger:       slog.Default(),
+	}
+	if err := s.Data(strings.NewReader(receivedChain(5) + "Subject: loop\r\n\r\nBody\r\n")); err != nil {
+		t.Fatalf("Data() = %v", err)
+	}
+	if got := strings.Join(hops(string(mock.body)), ","); got != "hop0,hop1" {
+		t.Errorf("delivered Received = %s, want hop0,hop1", got)
+	}
+}
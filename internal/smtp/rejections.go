@@ -0,0 +1,101 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Rejection is one refused MAIL, RCPT or DATA, kept for [smtpd]
+// recent_rejections. It holds the reply, never message content.
+type Rejection struct {
+	Time         time.Time `json:"time"`
+	ClientIP     string    `json:"client_ip"`
+	Command      string    `json:"command"`
+	Sender       string    `json:"sender"`
+	Recipient    string    `json:"recipient,omitempty"`
+	Code         int       `json:"code"`
+	EnhancedCode string    `json:"enhanced_code,omitempty"`
+	Reason       string    `json:"reason"`
+}
+
+// rejectionLog keeps the last max rejections in a ring, oldest first once
+// read. Protocol-handlers fill one each and send it with their report; the
+// listener merges them into its own, which /rejections serves.
+type rejectionLog struct {
+	mu     sync.Mutex
+	max    int
+	events []Rejection
+	next   int // slot the next event goes into once events is full
+}
+
+// newRejectionLog returns nil when max is not positive.
+func newRejectionLog(max int) *rejectionLog {
+	if max <= 0 {
+		return nil
+	}
+	return &rejectionLog{max: max}
+}
+
+// add records r, evicting the oldest event once the log is full.
+func (l *rejectionLog) add(r Rejection) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) < l.max {
+		l.events = append(l.events, r)
+		return
+	}
+	l.events[l.next] = r
+	l.next = (l.next + 1) % l.max
+}
+
+// list returns the recorded events, oldest first.
+func (l *rejectionLog) list() []Rejection {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Rejection, 0, len(l.events))
+	out = append(out, l.events[l.next:]...)
+	return append(out, l.events[:l.next]...)
+}
+
+// Rejections returns the rejections this backend's sessions have recorded,
+// oldest first; nil unless recent_rejections is set.
+func (b *Backend) Rejections() []Rejection {
+	return b.rejections.list()
+}
+
+// Rejections returns the stack's recorded rejections; see Backend.Rejections.
+func (s *Stack) Rejections() []Rejection {
+	return s.Server.backend.Rejections()
+}
+
+// noteRejection records err, when it is an SMTP error reply, in the
+// backend's rejection log. recipient is empty for MAIL.
+func (s *Session) noteRejection(command, sender, recipient string, err error) {
+	var smtpErr *smtp.SMTPError
+	if s.backend.rejections == nil || !errors.As(err, &smtpErr) {
+		return
+	}
+	r := Rejection{
+		Time:      time.Now().UTC(),
+		ClientIP:  s.clientIP,
+		Command:   command,
+		Sender:    sender,
+		Recipient: recipient,
+		Code:      smtpErr.Code,
+		Reason:    smtpErr.Message,
+	}
+	if ec := smtpErr.EnhancedCode; ec != smtp.NoEnhancedCode && ec != smtp.EnhancedCodeNotSet {
+		r.EnhancedCode = fmt.Sprintf("%d.%d.%d", ec[0], ec[1], ec[2])
+	}
+	s.backend.rejections.add(r)
+}
@@ -0,0 +1,69 @@
+package smtp
+
+import (
+	"log/slog"
+	"testing"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+)
+
+func TestRejectionLog(t *testing.T) {
+	l := newRejectionLog(3)
+	for _, reason := range []string{"a", "b"} {
+		l.add(Rejection{Reason: reason})
+	}
+	if got := reasons(l.list()); got != "ab" {
+		t.Errorf("before filling: %q, want ab", got)
+	}
+	for _, reason := range []string{"c", "d", "e"} {
+		l.add(Rejection{Reason: reason})
+	}
+	if got := reasons(l.list()); got != "cde" {
+		t.Errorf("after evicting: %q, want cde", got)
+	}
+
+	if newRejectionLog(0) != nil {
+		t.Error("newRejectionLog(0) should disable the log")
+	}
+	var disabled *rejectionLog
+	disabled.add(Rejection{Reason: "x"})
+	if disabled.list() != nil {
+		t.Error("nil log returned events")
+	}
+}
+
+func reasons(events []Rejection) string {
+	var s string
+	for _, r := range events {
+		s += r.Reason
+	}
+	return s
+}
+
+func TestSession_RecordsRejections(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: false},
+	})
+	backend := NewBackend(BackendConfig{SMDelivery: agent, Rejections: 10})
+	s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+
+	if err := s.Mail("sender@example.net", nil); err != nil {
+		t.Fatalf("Mail: %v", err)
+	}
+	if err := s.Rcpt("nobody@example.com", nil); err == nil {
+		t.Fatal("Rcpt accepted an unknown user")
+	}
+
+	got := backend.Rejections()
+	if len(got) != 1 {
+		t.Fatalf("Rejections() = %+v, want one", got)
+	}
+	r := got[0]
+	if r.Command != "RCPT" || r.ClientIP != "192.0.2.1" || r.Sender != "sender@example.net" ||
+		r.Recipient != "nobody@example.com" || r.Code != 550 || r.EnhancedCode != "5.1.1" || r.Reason != "User unknown" {
+		t.Errorf("rejection = %+v", r)
+	}
+	if r.Time.IsZero() {
+		t.Error("rejection has no time")
+	}
+}
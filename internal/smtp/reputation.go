@@ -0,0 +1,114 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// ipReputation counts permanent rejections per client IP and refuses IPs
+// that accumulate too many. Unlike rate limiting, which counts connections,
+// it counts bad outcomes, so a well-behaved busy sender is unaffected.
+//
+// State store errors fail open: a broken store must not refuse mail.
+type ipReputation struct {
+	store         kvstore.Store
+	maxRejections int
+	window        time.Duration
+	cooldown      time.Duration
+	message       string
+	logger        *slog.Logger
+
+	// countGreetings counts going over max_greetings; see greetings.go.
+	countGreetings bool
+}
+
+// newIPReputation returns nil when the check is disabled.
+func newIPReputation(cfg config.ReputationConfig, store kvstore.Store, logger *slog.Logger) *ipReputation {
+	if cfg.MaxRejections <= 0 || store == nil {
+		return nil
+	}
+	return &ipReputation{
+		store:         store,
+		maxRejections: cfg.MaxRejections,
+		window:        cfg.GetWindow(),
+		cooldown:      cfg.GetCooldown(),
+		message:       cfg.GetMessage(),
+		logger:        logger,
+
+		countGreetings: cfg.CountGreetings,
+	}
+}
+
+// blocked reports whether ip is in its cooldown period.
+func (r *ipReputation) blocked(ctx context.Context, ip string) bool {
+	if ip == "" {
+		return false
+	}
+	_, ok, err := r.store.Get(ctx, "reputation:block:"+ip)
+	if err != nil {
+		r.logger.Debug("reputation lookup failed", slog.String("error", err.Error()))
+		return false
+	}
+	return ok
+}
+
+// recordRejection counts a rejection against ip and starts the cooldown once
+// the count reaches the threshold. It reports whether this rejection did so.
+func (r *ipReputation) recordRejection(ctx context.Context, ip string) bool {
+	if ip == "" {
+		return false
+	}
+	n, err := r.store.Incr(ctx, "reputation:rejects:"+ip, r.window)
+	if err != nil {
+		r.logger.Debug("reputation update failed", slog.String("error", err.Error()))
+		return false
+	}
+	if n != int64(r.maxRejections) {
+		return false
+	}
+	if err := r.store.Set(ctx, "reputation:block:"+ip, "1", r.cooldown); err != nil {
+		r.logger.Debug("reputation update failed", slog.String("error", err.Error()))
+		return false
+	}
+	// Start a fresh count for when the cooldown ends.
+	_ = r.store.Delete(ctx, "reputation:rejects:"+ip)
+	return true
+}
+
+// rejectError is the connect-time reply for a blocked IP.
+func (r *ipReputation) rejectError() *smtp.SMTPError {
+	return &smtp.SMTPError{
+		Code:         554,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      r.message,
+	}
+}
+
+// noteOutcome records err against the client IP when it is a permanent
+// policy rejection (550-554). Syntax and sequencing errors (50x) and
+// temporary failures do not count.
+func (s *Session) noteOutcome(err error) {
+	rep := s.backend.reputation
+	var smtpErr *smtp.SMTPError
+	if rep == nil || !errors.As(err, &smtpErr) || smtpErr.Code < 550 {
+		return
+	}
+	s.countRejection()
+}
+
+// countRejection counts one rejection against the client IP, and logs the
+// client going over max_rejections. It does nothing when reputation
+// tracking is disabled.
+func (s *Session) countRejection() {
+	if rep := s.backend.reputation; rep != nil && rep.recordRejection(context.Background(), s.clientIP) {
+		s.logger.Warn("client over rejection threshold, refusing connections",
+			slog.String("client_ip", s.clientIP),
+			slog.Duration("cooldown", rep.cooldown))
+	}
+}
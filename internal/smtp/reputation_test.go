@@ -0,0 +1,152 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisState returns a state store on a miniredis instance whose clock
+// the test can advance.
+func newRedisState(t *testing.T) (kvstore.Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return kvstore.NewRedis(client, "smtpd:state:"), mr
+}
+
+func TestIPReputation_Disabled(t *testing.T) {
+	if rep := newIPReputation(config.ReputationConfig{}, kvstore.NewMemory(), slog.Default()); rep != nil {
+		t.Error("expected nil reputation when max_rejections is 0")
+	}
+}
+
+func TestIPReputation_ThresholdAndCooldown(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newRedisState(t)
+	rep := newIPReputation(config.ReputationConfig{
+		MaxRejections: 3,
+		Window:        "10m",
+		Cooldown:      "1h",
+	}, store, slog.Default())
+
+	for i := 1; i <= 2; i++ {
+		if rep.recordRejection(ctx, "192.0.2.1") {
+			t.Fatalf("rejection %d triggered cooldown early", i)
+		}
+	}
+	if rep.blocked(ctx, "192.0.2.1") {
+		t.Fatal("blocked below threshold")
+	}
+	if !rep.recordRejection(ctx, "192.0.2.1") {
+		t.Fatal("third rejection did not trigger cooldown")
+	}
+	if !rep.blocked(ctx, "192.0.2.1") {
+		t.Fatal("not blocked after reaching threshold")
+	}
+	if rep.blocked(ctx, "192.0.2.2") {
+		t.Error("unrelated IP blocked")
+	}
+
+	mr.FastForward(time.Hour)
+	if rep.blocked(ctx, "192.0.2.1") {
+		t.Error("still blocked after cooldown")
+	}
+	// The count restarted when the cooldown began.
+	if rep.recordRejection(ctx, "192.0.2.1") {
+		t.Error("first rejection after cooldown triggered a new cooldown")
+	}
+}
+
+func TestIPReputation_WindowExpires(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newRedisState(t)
+	rep := newIPReputation(config.ReputationConfig{MaxRejections: 2, Window: "10m"}, store, slog.Default())
+
+	rep.recordRejection(ctx, "192.0.2.1")
+	mr.FastForward(11 * time.Minute)
+	if rep.recordRejection(ctx, "192.0.2.1") {
+		t.Error("rejections outside the window were combined")
+	}
+}
+
+func TestSession_NoteOutcome(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		count bool
+	}{
+		{"nil", nil, false},
+		{"550 counts", &gosmtp.SMTPError{Code: 550}, true},
+		{"554 counts", &gosmtp.SMTPError{Code: 554}, true},
+		{"503 sequencing", &gosmtp.SMTPError{Code: 503}, false},
+		{"451 temporary", &gosmtp.SMTPError{Code: 451}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := kvstore.NewMemory()
+			rep := newIPReputation(config.ReputationConfig{MaxRejections: 1}, store, slog.Default())
+			s := &Session{backend: &Backend{reputation: rep}, clientIP: "192.0.2.1", logger: slog.Default()}
+
+			s.noteOutcome(tt.err)
+			if got := rep.blocked(ctx, "192.0.2.1"); got != tt.count {
+				t.Errorf("blocked = %v, want %v", got, tt.count)
+			}
+		})
+	}
+}
+
+func TestRunSingleConn_RefusesAfterRejections(t *testing.T) {
+	store, mr := newRedisState(t)
+	bcfg := BackendConfig{
+		Hostname:   "test.local",
+		StateStore: store,
+		Reputation: config.ReputationConfig{MaxRejections: 2, Cooldown: "30m"},
+	}
+
+	greeting := func() string {
+		t.Helper()
+		conn, done := serveOneConn(t, config.ModeSmtp, nil, bcfg)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("read greeting: %v", err)
+		}
+		_ = conn.Close()
+		waitDone(t, done)
+		return line
+	}
+
+	if got := greeting(); !strings.HasPrefix(got, "220 ") {
+		t.Fatalf("greeting before rejections = %q, want 220", got)
+	}
+
+	// Accrue rejections from the client's address (serveOneConn dials from
+	// loopback).
+	s := &Session{backend: NewBackend(bcfg), clientIP: "127.0.0.1", logger: slog.Default()}
+	for i := 0; i < 2; i++ {
+		if err := s.Rcpt("not-an-address", nil); err == nil {
+			t.Fatal("expected RCPT rejection")
+		}
+	}
+
+	if got := greeting(); !strings.HasPrefix(got, "554 5.7.1 ") {
+		t.Fatalf("greeting after rejections = %q, want 554 5.7.1", got)
+	}
+
+	mr.FastForward(30 * time.Minute)
+	if got := greeting(); !strings.HasPrefix(got, "220 ") {
+		t.Errorf("greeting after cooldown = %q, want 220", got)
+	}
+}
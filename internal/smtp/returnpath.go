@@ -0,0 +1,115 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"time"
+)
+
+// returnPathHeader formats the Return-Path field for envelope sender from
+// (RFC 5321 §4.4); the null sender of a bounce becomes "<>".
+func returnPathHeader(from string) string {
+	return "Return-Path: <" + from + ">\r\n"
+}
+
+// originalToHeader formats the X-Original-To field for recipient, the
+// RCPT address as the client gave it. session-manager resolves aliases and
+// plus-addresses after smtpd hands the message over, so this is the address
+// the mail was sent to even when it lands in another mailbox.
+func originalToHeader(recipient string) string {
+	return "X-Original-To: " + recipient + "\r\n"
+}
+
+// authUserHeaderName is the field auth_user_header stamps. Downstream
+// systems trust it, so messageBody removes any copy the client sent.
+const authUserHeaderName = "X-Authenticated-User"
+
+// finalDeliveryMessage returns the message as handed to local (final)
+// delivery: a Return-Path with the envelope sender (when return_path is on),
+// an X-Original-To with the recipient and, for authenticated sessions with
+// auth_user_header on, an X-Authenticated-User on top, any copies of those
+// the client sent removed, then the add_headers block and the recipient
+// domain's delivery_headers.
+func (s *Session) finalDeliveryMessage(tmp tempBuffer, queueID string) io.Reader {
+	var top string
+	var body io.Reader = newHeaderFilter(s.messageBody(tmp), "X-Original-To")
+	if s.backend.returnPath {
+		top = returnPathHeader(s.from)
+		body = newHeaderFilter(body, "Return-Path")
+	}
+	recipient := s.originalRecipient
+	if recipient == "" && len(s.recipients) > 0 {
+		recipient = s.recipients[0]
+	}
+	if recipient != "" {
+		top += originalToHeader(recipient)
+	}
+	if s.backend.authUserHeader && s.authUser != "" {
+		top += authUserHeaderName + ": " + s.authUser + "\r\n"
+	}
+	top += renderAddedHeaders(s.backend.addHeaders, s.backend.hostname, queueID)
+	if recipient != "" {
+		domain := s.backend.domains.Get(extractDomain(recipient))
+		top += renderDeliveryHeaders(domain.DeliveryHeaders, s.backend.hostname, queueID, recipient, time.Now())
+	}
+	return io.MultiReader(strings.NewReader(top), body)
+}
+
+// headerFilter drops every occurrence of one header field, including folded
+// continuation lines, from a message's header section. The body is passed
+// through unchanged.
+type headerFilter struct {
+	r        *bufio.Reader
+	prefix   []byte // lower-case "name:"
+	inHeader bool
+	skipping bool
+	pending  []byte
+}
+
+func newHeaderFilter(r io.Reader, name string) *headerFilter {
+	return &headerFilter{
+		r:        bufio.NewReader(r),
+		prefix:   []byte(strings.ToLower(name) + ":"),
+		inHeader: true,
+	}
+}
+
+func (f *headerFilter) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if !f.inHeader {
+			return f.r.Read(p)
+		}
+		line, err := f.r.ReadBytes('\n')
+		if len(line) > 0 && f.keep(line) {
+			f.pending = line
+		}
+		if err != nil {
+			if len(f.pending) == 0 {
+				return 0, err
+			}
+			f.inHeader = false // emit what is left, then report err from r
+			break
+		}
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// keep decides whether a header-section line is passed on, tracking the
+// end of the header section and folded lines of a dropped field.
+func (f *headerFilter) keep(line []byte) bool {
+	switch {
+	case len(bytes.TrimRight(line, "\r\n")) == 0:
+		f.inHeader = false // blank line: body follows
+		return true
+	case line[0] == ' ' || line[0] == '\t':
+		return !f.skipping
+	default:
+		f.skipping = len(line) >= len(f.prefix) &&
+			bytes.Equal(bytes.ToLower(line[:len(f.prefix)]), f.prefix)
+		return !f.skipping
+	}
+}
@@ -0,0 +1,187 @@
+package smtp
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "no match",
+			in:   "From: a@example.com\r\n\r\nbody\r\n",
+			want: "From: a@example.com\r\n\r\nbody\r\n",
+		},
+		{
+			name: "drops every occurrence, any case",
+			in:   "Return-Path: <x@example.com>\r\nFrom: a@example.com\r\nreturn-path: <y@example.com>\r\n\r\nbody\r\n",
+			want: "From: a@example.com\r\n\r\nbody\r\n",
+		},
+		{
+			name: "drops folded continuation lines",
+			in:   "Return-Path:\r\n <x@example.com>\r\nSubject: folded\r\n  subject\r\n\r\nbody\r\n",
+			want: "Subject: folded\r\n  subject\r\n\r\nbody\r\n",
+		},
+		{
+			name: "leaves the body alone",
+			in:   "From: a@example.com\r\n\r\nReturn-Path: <quoted@example.com>\r\n",
+			want: "From: a@example.com\r\n\r\nReturn-Path: <quoted@example.com>\r\n",
+		},
+		{
+			name: "similar field names kept",
+			in:   "Return-Path-Extra: x\r\n\r\n",
+			want: "Return-Path-Extra: x\r\n\r\n",
+		},
+		{
+			name: "header only, no trailing newline",
+			in:   "From: a@example.com\r\nReturn-Path: <x@example.com>",
+			want: "From: a@example.com\r\n",
+		},
+		{
+			name: "bare LF line endings",
+			in:   "Return-Path: <x@example.com>\nFrom: a@example.com\n\nbody\n",
+			want: "From: a@example.com\n\nbody\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range map[string]io.Reader{
+				"whole":    strings.NewReader(tt.in),
+				"one byte": iotest.OneByteReader(strings.NewReader(tt.in)),
+			} {
+				got, err := io.ReadAll(newHeaderFilter(r, "Return-Path"))
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFinalDeliveryMessage(t *testing.T) {
+	const msg = "Return-Path: <forged@example.net>\r\nX-Original-To: forged@example.net\r\nSubject: hi\r\n\r\nbody\r\n"
+
+	tests := []struct {
+		name    string
+		from    string
+		backend *Backend
+		want    string
+	}{
+		{
+			name:    "sender",
+			from:    "alice@example.com",
+			backend: &Backend{returnPath: true},
+			want:    "Return-Path: <alice@example.com>\r\nX-Original-To: sales@example.org\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:    "bounce",
+			from:    "",
+			backend: &Backend{returnPath: true},
+			want:    "Return-Path: <>\r\nX-Original-To: sales@example.org\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name: "with add_headers",
+			from: "alice@example.com",
+			backend: &Backend{
+				returnPath: true,
+				hostname:   "mx.example.com",
+				addHeaders: map[string]string{"X-Scanned": "{hostname}"},
+			},
+			want: "Return-Path: <alice@example.com>\r\nX-Original-To: sales@example.org\r\nX-Scanned: mx.example.com\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:    "return_path disabled",
+			from:    "alice@example.com",
+			backend: &Backend{},
+			want:    "X-Original-To: sales@example.org\r\nReturn-Path: <forged@example.net>\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := &memTempBuf{}
+			_, _ = tmp.Write([]byte(msg))
+			s := &Session{backend: tt.backend, from: tt.from, recipients: []string{"sales@example.org"}}
+
+			got, err := io.ReadAll(s.finalDeliveryMessage(tmp, "ID"))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthUserHeader(t *testing.T) {
+	const msg = "X-Authenticated-User: ceo@example.com\r\nSubject: hi\r\n\r\nbody\r\n"
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		authUser string
+		relay    bool
+		want     string
+	}{
+		{
+			name:     "authenticated local delivery",
+			enabled:  true,
+			authUser: "alice@example.com",
+			want:     "X-Original-To: sales@example.org\r\nX-Authenticated-User: alice@example.com\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:    "inbound mail is stripped",
+			enabled: true,
+			want:    "X-Original-To: sales@example.org\r\nSubject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:     "relayed mail is stripped",
+			enabled:  true,
+			authUser: "alice@example.com",
+			relay:    true,
+			want:     "Subject: hi\r\n\r\nbody\r\n",
+		},
+		{
+			name:     "disabled",
+			authUser: "alice@example.com",
+			want:     "X-Original-To: sales@example.org\r\n" + msg,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := &memTempBuf{}
+			_, _ = tmp.Write([]byte(msg))
+			s := &Session{
+				backend:    &Backend{authUserHeader: tt.enabled},
+				authUser:   tt.authUser,
+				recipients: []string{"sales@example.org"},
+			}
+
+			var r io.Reader
+			if tt.relay {
+				r = s.stampedMessage(tmp, "ID")
+			} else {
+				r = s.finalDeliveryMessage(tmp, "ID")
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,25 +7,33 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
 	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
 	"github.com/infodancer/smtpd/internal/config"
 	smtpserver "github.com/infodancer/smtpd/internal/smtp"
+	"github.com/infodancer/smtpd/internal/webhook"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
 )
 
@@ -134,6 +142,7 @@ type testEnv struct {
 	wg             sync.WaitGroup
 	deliveryServer *mockDeliveryServer
 	sessionServer  *mockSessionServer
+	backend        *smtpserver.Backend
 }
 
 // generateTestTLS generates a self-signed ECDSA certificate for testing.
@@ -177,6 +186,13 @@ func generateTestTLS(t *testing.T) (serverCfg, clientCfg *tls.Config) {
 
 func newTestEnv(t *testing.T) *testEnv {
 	t.Helper()
+	return newTestEnvWith(t, nil)
+}
+
+// newTestEnvWith is newTestEnv with a hook to adjust the backend
+// configuration before the server starts.
+func newTestEnvWith(t *testing.T, configure func(*smtpserver.BackendConfig)) *testEnv {
+	t.Helper()
 
 	domainName := "test.local"
 
@@ -218,13 +234,17 @@ func newTestEnv(t *testing.T) *testEnv {
 		t.Fatalf("close listener: %v", err)
 	}
 
-	backend := smtpserver.NewBackend(smtpserver.BackendConfig{
+	bcfg := smtpserver.BackendConfig{
 		Hostname:       "test.local",
 		SMDelivery:     smDelivery,
 		MaxRecipients:  10,
 		MaxMessageSize: 10 * 1024 * 1024,
 		TempDir:        t.TempDir(),
-	})
+	}
+	if configure != nil {
+		configure(&bcfg)
+	}
+	backend := smtpserver.NewBackend(bcfg)
 
 	srv, err := smtpserver.NewServer(smtpserver.ServerConfig{
 		Backend: backend,
@@ -250,6 +270,7 @@ func newTestEnv(t *testing.T) *testEnv {
 		cancel:         cancel,
 		deliveryServer: deliverySrv,
 		sessionServer:  sessionSrv,
+		backend:        backend,
 	}
 
 	env.wg.Add(1)
@@ -484,6 +505,536 @@ func TestRoundTrip_SMTP_MessageContent_Preserved(t *testing.T) {
 	}
 }
 
+// TestRoundTrip_SMTP_MessageBytes_Preserved verifies that a DKIM-signed
+// message reaches delivery byte for byte after dot-unstuffing: no CRLF
+// normalization, trailing whitespace and bare LF kept, so a c=simple/simple
+// signature still verifies. smtpd only prepends its own header fields.
+func TestRoundTrip_SMTP_MessageBytes_Preserved(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "bob", "testpass")
+
+	body := "Trailing spaces   \r\n" +
+		"\tleading tab\r\n" +
+		".leading dot\r\n" +
+		"mixed\nline endings\r\n" +
+		"\r\n"
+	bh := dkimSimpleBodyHash(body)
+	headers := "DKIM-Signature: v=1; a=rsa-sha256; c=simple/simple; d=example.com;\r\n" +
+		"\ts=sel; h=From:Subject; bh=" + bh + "; b=c2lnbmF0dXJl\r\n" +
+		"From: sender@example.com\r\n" +
+		"Subject:  spaced   \r\n"
+	wire := headers + "\r\n" + body
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	// Dot-stuff the one line that needs it; the trailing CRLF is the send's.
+	stuffed := strings.Replace(wire, "\r\n.leading", "\r\n..leading", 1)
+	c.mustCode(t, strings.TrimSuffix(stuffed, "\r\n")+"\r\n.", 250)
+	c.Quit(t)
+
+	if env.deliveryServer.countMessages() != 1 {
+		t.Fatalf("expected 1 message, got %d", env.deliveryServer.countMessages())
+	}
+	got := string(env.deliveryServer.getMessage(0).body)
+	if !strings.HasSuffix(got, wire) {
+		t.Fatalf("delivered message does not end with the sent bytes\ngot:  %q\nwant: ...%q", got, wire)
+	}
+	_, delivered, _ := strings.Cut(got, "\r\n\r\n")
+	if h := dkimSimpleBodyHash(delivered); h != bh {
+		t.Errorf("body hash after delivery = %s, want %s", h, bh)
+	}
+}
+
+// dkimSimpleBodyHash returns the RFC 6376 bh= value of body under "simple"
+// body canonicalization: trailing empty lines dropped, SHA-256, base64.
+func dkimSimpleBodyHash(body string) string {
+	for strings.HasSuffix(body, "\r\n\r\n") {
+		body = strings.TrimSuffix(body, "\r\n")
+	}
+	if body == "" {
+		body = "\r\n"
+	}
+	sum := sha256.Sum256([]byte(body))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestRoundTrip_SMTP_AddHeaders(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.AddHeaders = map[string]string{
+			"X-Virus-Scanned": "clamav on {hostname}",
+			"X-Queue-ID":      "{queue_id}",
+		}
+	})
+	env.addUser(t, "bob", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.SendMessage(t, "sender@example.com", "bob@test.local", "Stamped", "hello")
+	c.Quit(t)
+
+	if env.deliveryServer.countMessages() != 1 {
+		t.Fatalf("expected 1 message, got %d", env.deliveryServer.countMessages())
+	}
+	content := string(env.deliveryServer.getMessage(0).body)
+
+	if n := strings.Count(content, "X-Virus-Scanned: clamav on test.local\r\n"); n != 1 {
+		t.Errorf("X-Virus-Scanned appears %d times, want 1; got:\n%s", n, content)
+	}
+	if n := strings.Count(content, "X-Queue-ID: "); n != 1 {
+		t.Errorf("X-Queue-ID appears %d times, want 1; got:\n%s", n, content)
+	}
+	if strings.Contains(content, "{queue_id}") {
+		t.Errorf("{queue_id} not substituted; got:\n%s", content)
+	}
+	if !strings.Contains(content, "\r\n\r\nhello") {
+		t.Errorf("message body not preserved; got:\n%s", content)
+	}
+}
+
+func TestRoundTrip_SMTP_RequireHeaders(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.HeaderPolicy = config.HeaderPolicyBasic
+	})
+	env.addUser(t, "bob", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+
+	// No Date header.
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	c.mustCode(t, "From: sender@example.com\r\nSubject: no date\r\n\r\nhello\r\n.", 550)
+
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	c.mustCode(t, "From: sender@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0000\r\n\r\nhello\r\n.", 250)
+
+	if got := env.deliveryServer.countMessages(); got != 1 {
+		t.Errorf("expected 1 delivered message, got %d", got)
+	}
+}
+
+func TestRoundTrip_SMTP_Webhook(t *testing.T) {
+	events := make(chan webhook.Event, 4)
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+			return
+		}
+		events <- e
+	}))
+	defer hookSrv.Close()
+
+	hook := webhook.New(hookSrv.URL, config.WebhookEvents, time.Second, nil)
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.HeaderPolicy = config.HeaderPolicyBasic
+		c.Webhook = hook
+	})
+	env.addUser(t, "bob", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	c.mustCode(t, "From: sender@example.com\r\nDate: Mon, 12 Oct 2026 10:00:00 +0000\r\n\r\nhello\r\n.", 250)
+
+	// No Date header.
+	c.mustCode(t, "MAIL FROM:<spammer@example.net>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	c.mustCode(t, "From: spammer@example.net\r\n\r\nbuy now\r\n.", 550)
+	c.Quit(t)
+
+	if err := hook.Close(); err != nil {
+		t.Fatalf("webhook Close: %v", err)
+	}
+	close(events)
+	var got []webhook.Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("received %d events, want 2: %+v", len(got), got)
+	}
+
+	accepted := got[0]
+	if accepted.Event != webhook.EventAccepted || accepted.From != "sender@example.com" ||
+		len(accepted.Recipients) != 1 || accepted.Recipients[0] != "bob@test.local" ||
+		accepted.QueueID == "" || accepted.Size == 0 || accepted.Code != 0 || accepted.ClientIP != "127.0.0.1" {
+		t.Errorf("accepted event = %+v", accepted)
+	}
+
+	rejected := got[1]
+	if rejected.Event != webhook.EventRejected || rejected.From != "spammer@example.net" ||
+		rejected.Code != 550 || rejected.Message == "" || rejected.QueueID == accepted.QueueID {
+		t.Errorf("rejected event = %+v", rejected)
+	}
+}
+
+func TestRoundTrip_SMTP_BDAT_OversizedChunk(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "bob", "testpass")
+
+	const maxSize = 10 * 1024 * 1024 // newTestEnv's MaxMessageSize
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+
+	// A chunk larger than the whole message limit is refused before any of
+	// it reaches the session, so nothing is buffered or delivered.
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, fmt.Sprintf("BDAT %d LAST", maxSize+1), 552)
+
+	// The declared bytes are read and discarded, keeping the protocol in
+	// sync. (go-smtp still applies its line-length limit while discarding,
+	// so a chunk without line breaks ends the connection instead.)
+	line := strings.Repeat("x", 998) + "\r\n"
+	chunk := strings.Repeat(line, (maxSize+1)/len(line)+1)[:maxSize+1]
+	if _, err := io.WriteString(c.conn, chunk); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	c.mustCode(t, "NOOP", 250)
+
+	// Sizes beyond 32 bits are rejected as malformed without reading.
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "BDAT 9999999999 LAST", 501)
+	c.mustCode(t, "RSET", 250)
+
+	if got := env.deliveryServer.countMessages(); got != 0 {
+		t.Errorf("expected no delivered messages, got %d", got)
+	}
+}
+
+// TestRoundTrip_SMTP_DataReplyEnhancedCode pins the on-wire reply to an
+// accepted message: go-smtp answers a nil error from Data with
+// "250 2.0.0 OK: queued", after DATA and after BDAT LAST alike. Refusals
+// carry the enhanced code of the SMTPError that Data returns.
+func TestRoundTrip_SMTP_DataReplyEnhancedCode(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.HeaderPolicy = config.HeaderPolicyBasic
+	})
+	env.addUser(t, "bob", "testpass")
+	body := "From: sender@example.com\r\nDate: Mon, 2 Mar 2026 09:00:00 +0000\r\n\r\nbody\r\n"
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	if msg := c.mustCode(t, body+".", 250); !strings.HasPrefix(msg, "2.0.0 ") {
+		t.Errorf("DATA reply = %q, want 250 2.0.0", msg)
+	}
+
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.send(t, fmt.Sprintf("BDAT %d LAST", len(body)))
+	if _, err := c.conn.Write([]byte(body)); err != nil {
+		t.Fatalf("write chunk: %v", err)
+	}
+	if msg := c.mustCode(t, "", 250); !strings.HasPrefix(msg, "2.0.0 ") {
+		t.Errorf("BDAT LAST reply = %q, want 250 2.0.0", msg)
+	}
+
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	if msg := c.mustCode(t, "Subject: no From or Date\r\n\r\nbody\r\n.", 550); !strings.HasPrefix(msg, "5.6.0 ") {
+		t.Errorf("refusal reply = %q, want 550 5.6.0", msg)
+	}
+}
+
+func TestRoundTrip_SMTP_BDAT_BinaryMIME(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "bob", "testpass")
+
+	// Everything the DATA path would mangle: a lone dot line, bare CR and
+	// LF, NUL and high bytes, and a line longer than 998 octets.
+	msg := []byte("Subject: binary\r\nMIME-Version: 1.0\r\n" +
+		"Content-Type: application/octet-stream\r\nContent-Transfer-Encoding: binary\r\n\r\n" +
+		"\x00\x01\xff\xfe\r\n.\r\n..\r\nbare\rcr bare\nlf " + strings.Repeat("z", 1200) + "\r\n\x00end")
+	first, last := msg[:len(msg)/2], msg[len(msg)/2:]
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	if caps := c.Ehlo(t); !strings.Contains(caps, "CHUNKING") || !strings.Contains(caps, "BINARYMIME") {
+		t.Fatalf("EHLO does not advertise CHUNKING and BINARYMIME:\n%s", caps)
+	}
+	c.mustCode(t, "MAIL FROM:<sender@example.com> BODY=BINARYMIME", 250)
+	c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+	c.mustCode(t, "DATA", 502) // binary bodies only travel by BDAT
+
+	sendChunk := func(chunk []byte, last bool) {
+		t.Helper()
+		cmd := fmt.Sprintf("BDAT %d", len(chunk))
+		if last {
+			cmd += " LAST"
+		}
+		c.send(t, cmd)
+		if _, err := c.conn.Write(chunk); err != nil {
+			t.Fatalf("write chunk: %v", err)
+		}
+		c.mustCode(t, "", 250)
+	}
+	sendChunk(first, false)
+	sendChunk(last, true)
+
+	if got := env.deliveryServer.countMessages(); got != 1 {
+		t.Fatalf("expected 1 delivered message, got %d", got)
+	}
+	// Trace headers may be prepended; the message itself must arrive as sent.
+	body := env.deliveryServer.getMessage(0).body
+	if !bytes.HasSuffix(body, msg) {
+		t.Errorf("binary message altered in delivery:\ngot  %q\nwant suffix %q", body, msg)
+	}
+}
+
+func TestRoundTrip_SMTP_BinaryMIMENotRelayed(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "s3cret")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.StartTLS(t, env.clientTLS)
+	c.AuthPlain(t, "alice@test.local", "s3cret")
+
+	// The outbound queue cannot carry the body type, so a binary message
+	// is only accepted for local recipients.
+	c.mustCode(t, "MAIL FROM:<alice@test.local> BODY=BINARYMIME", 250)
+	c.RcptExpect(t, "carol@remote.example", 554)
+	c.RcptExpect(t, "bob@test.local", 250)
+	c.mustCode(t, "RSET", 250)
+
+	c.MailExpect(t, "alice@test.local", 250)
+	c.RcptExpect(t, "carol@remote.example", 250)
+}
+
+func TestRoundTrip_SMTP_ReturnPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		sender string
+		want   string
+	}{
+		{"sender", "sender@example.com", "Return-Path: <sender@example.com>\r\n"},
+		{"bounce", "", "Return-Path: <>\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+				c.ReturnPath = true
+			})
+			env.addUser(t, "bob", "testpass")
+
+			c := dialSMTP(t, env.addr)
+			c.Greeting(t)
+			c.Ehlo(t)
+			c.mustCode(t, fmt.Sprintf("MAIL FROM:<%s>", tt.sender), 250)
+			c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+			c.mustCode(t, "DATA", 354)
+			c.mustCode(t, "Return-Path: <forged@example.net>\r\nSubject: rp\r\n\r\nhello\r\n.", 250)
+
+			if env.deliveryServer.countMessages() != 1 {
+				t.Fatalf("expected 1 message, got %d", env.deliveryServer.countMessages())
+			}
+			content := string(env.deliveryServer.getMessage(0).body)
+			if n := strings.Count(content, "Return-Path:"); n != 1 {
+				t.Errorf("Return-Path appears %d times, want 1; got:\n%s", n, content)
+			}
+			if !strings.HasPrefix(content, tt.want) {
+				t.Errorf("message does not start with %q; got:\n%s", tt.want, content)
+			}
+		})
+	}
+}
+
+// TestRoundTrip_SMTP_OriginalTo verifies that local delivery carries the
+// RCPT address in X-Original-To, replacing any the client sent. Resolving
+// sales@ to alice's mailbox is session-manager's job, after the hand-off;
+// the header and DeliverMetadata.Recipient keep the address it resolves.
+func TestRoundTrip_SMTP_OriginalTo(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+	c.mustCode(t, "RCPT TO:<sales@test.local>", 250)
+	c.mustCode(t, "DATA", 354)
+	c.mustCode(t, "X-Original-To: alice@test.local\r\nSubject: quote\r\n\r\nhello\r\n.", 250)
+
+	if env.deliveryServer.countMessages() != 1 {
+		t.Fatalf("expected 1 message, got %d", env.deliveryServer.countMessages())
+	}
+	msg := env.deliveryServer.getMessage(0)
+	if msg.metadata.GetRecipient() != "sales@test.local" {
+		t.Errorf("Recipient = %q, want sales@test.local", msg.metadata.GetRecipient())
+	}
+	content := string(msg.body)
+	if n := strings.Count(content, "X-Original-To:"); n != 1 {
+		t.Errorf("X-Original-To appears %d times, want 1; got:\n%s", n, content)
+	}
+	if !strings.Contains(content, "X-Original-To: sales@test.local\r\n") {
+		t.Errorf("missing X-Original-To: sales@test.local; got:\n%s", content)
+	}
+}
+
+// TestRoundTrip_SMTP_RecipientVerbatim pins that smtpd does not
+// canonicalize recipients: case folding, plus-extensions and aliases are
+// resolved by session-manager, which needs the address exactly as given to
+// pick both the mailbox and the folder.
+func TestRoundTrip_SMTP_RecipientVerbatim(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.SendMessage(t, "sender@example.com", "Alice+Lists@Test.Local", "digest", "body")
+
+	if env.deliveryServer.countMessages() != 1 {
+		t.Fatalf("expected 1 message, got %d", env.deliveryServer.countMessages())
+	}
+	msg := env.deliveryServer.getMessage(0)
+	if got := msg.metadata.GetRecipient(); got != "Alice+Lists@Test.Local" {
+		t.Errorf("Recipient = %q, want Alice+Lists@Test.Local", got)
+	}
+	if !strings.Contains(string(msg.body), "X-Original-To: Alice+Lists@Test.Local\r\n") {
+		t.Errorf("missing verbatim X-Original-To; got:\n%s", msg.body)
+	}
+}
+
+func TestRoundTrip_SMTP_SharedMailbox(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.Domains = config.DomainsConfig{
+			"test.local": {SharedMailboxes: []config.SharedMailbox{
+				{Pattern: "support", Mailbox: "helpdesk@test.local"},
+				{Pattern: "sales-*", Mailbox: "sales-team@test.local"},
+			}},
+		}
+	})
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.SendMessage(t, "sender@example.com", "Sales-EMEA@test.local", "quote", "body")
+	c.SendMessage(t, "sender@example.com", "alice@test.local", "hello", "body")
+
+	if env.deliveryServer.countMessages() != 2 {
+		t.Fatalf("expected 2 messages, got %d", env.deliveryServer.countMessages())
+	}
+	msg := env.deliveryServer.getMessage(0)
+	if got := msg.metadata.GetRecipient(); got != "sales-team@test.local" {
+		t.Errorf("Recipient = %q, want sales-team@test.local", got)
+	}
+	if !strings.Contains(string(msg.body), "X-Original-To: Sales-EMEA@test.local\r\n") {
+		t.Errorf("missing X-Original-To with the address sent to; got:\n%s", msg.body)
+	}
+	if got := env.deliveryServer.getMessage(1).metadata.GetRecipient(); got != "alice@test.local" {
+		t.Errorf("unmatched Recipient = %q, want alice@test.local", got)
+	}
+}
+
+func TestRoundTrip_SMTP_FirstContact(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.FirstContact = config.FirstContactConfig{Notify: "admin@test.local"}
+	})
+	env.addUser(t, "alice", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.SendMessage(t, "bob@new.example", "alice@test.local", "hello", "body")
+	c.SendMessage(t, "carol@New.Example", "alice@test.local", "again", "body")
+	c.SendMessage(t, "dave@other.example", "alice@test.local", "hi", "body")
+
+	// Each domain's first message is followed by one summary; the second
+	// message from new.example is not.
+	var summaries []string
+	for i := range env.deliveryServer.countMessages() {
+		msg := env.deliveryServer.getMessage(i)
+		if msg.metadata.GetRecipient() != "admin@test.local" {
+			continue
+		}
+		if got := msg.metadata.GetSender(); got != "" {
+			t.Errorf("summary sender = %q, want null", got)
+		}
+		for _, line := range strings.Split(string(msg.body), "\r\n") {
+			if subject, ok := strings.CutPrefix(line, "Subject: "); ok {
+				summaries = append(summaries, subject)
+			}
+		}
+	}
+	want := []string{"First message from new.example", "First message from other.example"}
+	if !slices.Equal(summaries, want) {
+		t.Errorf("summaries = %q, want %q", summaries, want)
+	}
+	if got := env.deliveryServer.countMessages(); got != 5 {
+		t.Errorf("delivered %d messages, want 3 and 2 summaries", got)
+	}
+}
+
+func TestRoundTrip_SMTP_MIMELimits(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.MaxMIMEDepth = 10
+		c.MaxMIMEParts = 50
+	})
+	env.addUser(t, "bob", "testpass")
+
+	send := func(body string) (int, string) {
+		t.Helper()
+		c := dialSMTP(t, env.addr)
+		c.Greeting(t)
+		c.Ehlo(t)
+		c.mustCode(t, "MAIL FROM:<sender@example.com>", 250)
+		c.mustCode(t, "RCPT TO:<bob@test.local>", 250)
+		c.mustCode(t, "DATA", 354)
+		if _, err := fmt.Fprintf(c.conn, "%s.\r\n", body); err != nil {
+			t.Fatalf("write DATA body: %v", err)
+		}
+		return c.readResponse(t)
+	}
+
+	var deep strings.Builder
+	deep.WriteString("Subject: deep\r\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&deep, "Content-Type: multipart/mixed; boundary=\"b%d\"\r\n\r\n--b%d\r\n", i, i)
+	}
+	deep.WriteString("\r\nx\r\n")
+
+	code, msg := send(deep.String())
+	if code != 550 || !strings.Contains(msg, "Message structure too complex") {
+		t.Errorf("deeply nested message: got %d %s, want 550 structure too complex", code, msg)
+	}
+
+	normal := "Subject: ok\r\nContent-Type: multipart/alternative; boundary=alt\r\n\r\n" +
+		"--alt\r\nContent-Type: text/plain\r\n\r\nhi\r\n" +
+		"--alt\r\nContent-Type: text/html\r\n\r\n<p>hi</p>\r\n--alt--\r\n"
+	if code, msg := send(normal); code != 250 {
+		t.Errorf("normal multipart: got %d %s, want 250", code, msg)
+	}
+	if env.deliveryServer.countMessages() != 1 {
+		t.Errorf("expected 1 delivered message, got %d", env.deliveryServer.countMessages())
+	}
+}
+
 func TestRoundTrip_SMTP_UnknownDomain_Rejected(t *testing.T) {
 	env := newTestEnv(t)
 
@@ -494,6 +1045,94 @@ func TestRoundTrip_SMTP_UnknownDomain_Rejected(t *testing.T) {
 	c.RcptExpect(t, "alice@unknown.domain", 550)
 }
 
+// TestRoundTrip_SMTP_UnqualifiedAddress_Rejected pins that addresses without
+// a domain are refused with 501 before they reach the session.
+func TestRoundTrip_SMTP_UnqualifiedAddress_Rejected(t *testing.T) {
+	env := newTestEnv(t)
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.MailExpect(t, "alice", 501)
+	c.MailExpect(t, "sender@example.com", 250)
+	c.RcptExpect(t, "bob", 501)
+}
+
+func TestRoundTrip_SMTP_DuplicateMail_Rejected(t *testing.T) {
+	env := newTestEnv(t)
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.MailExpect(t, "sender@example.com", 250)
+	c.MailExpect(t, "other@example.com", 503)
+	c.Rset(t)
+	c.MailExpect(t, "other@example.com", 250)
+}
+
+func TestRoundTrip_SMTP_Maintenance(t *testing.T) {
+	env := newTestEnv(t)
+	env.backend.SetMaintenance(true)
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	if msg := c.mustCode(t, "MAIL FROM:<sender@example.com>", 421); !strings.Contains(msg, "maintenance") {
+		t.Errorf("MAIL reply = %q, want the maintenance message", msg)
+	}
+	// 421 closes the connection.
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Error("connection still open after 421")
+	}
+
+	env.backend.SetMaintenance(false)
+	c = dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.MailExpect(t, "sender@example.com", 250)
+	c.Quit(t)
+}
+
+// Obsolete RFC 821 verbs are answered 502 (not implemented), which go-smtp
+// does itself; unknown verbs stay 500 and keep the session usable.
+func TestRoundTrip_SMTP_ObsoleteCommands(t *testing.T) {
+	env := newTestEnv(t)
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	for _, cmd := range []string{"SEND FROM:<a@example.com>", "SOML FROM:<a@example.com>", "saml FROM:<a@example.com>", "TURN"} {
+		if msg := c.mustCode(t, cmd, 502); !strings.HasPrefix(msg, "5.5.1") {
+			t.Errorf("%q -> %q, want enhanced code 5.5.1", cmd, msg)
+		}
+	}
+	c.mustCode(t, "XYZW foo", 500)
+	c.MailExpect(t, "sender@example.com", 250)
+	c.Quit(t)
+}
+
+// go-smtp advertises PIPELINING on every listener and has no option to
+// withhold it, so a client sending commands in one batch is never out of
+// protocol and must be served like any other.
+func TestRoundTrip_SMTP_PipeliningAlwaysOffered(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	if caps := c.Ehlo(t); !slices.Contains(strings.Split(caps, "\n"), "PIPELINING") {
+		t.Fatalf("EHLO reply %q does not offer PIPELINING", caps)
+	}
+	c.send(t, "MAIL FROM:<sender@example.com>\r\nRCPT TO:<alice@test.local>\r\nRSET")
+	for _, cmd := range []string{"MAIL", "RCPT", "RSET"} {
+		if code, msg := c.readResponse(t); code != 250 {
+			t.Errorf("pipelined %s -> %d %s, want 250", cmd, code, msg)
+		}
+	}
+	c.Quit(t)
+}
+
 func TestRoundTrip_SMTP_MultipleRcpt_Rejected(t *testing.T) {
 	env := newTestEnv(t)
 	env.addUser(t, "alice", "testpass")
@@ -518,6 +1157,33 @@ func TestRoundTrip_SMTP_AuthPlain_Success(t *testing.T) {
 	c.AuthPlain(t, "alice@test.local", "s3cret")
 }
 
+// An authenticated sender over its hourly limit is deferred with 421, and
+// the connection is closed as 421 requires.
+func TestRoundTrip_SMTP_SenderRateLimit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	env := newTestEnvWith(t, func(cfg *smtpserver.BackendConfig) {
+		cfg.RedisClient = rdb
+		cfg.MaxSendsPerHour = 1
+	})
+	env.addUser(t, "alice", "s3cret")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.StartTLS(t, env.clientTLS)
+	c.AuthPlain(t, "alice@test.local", "s3cret")
+	c.MailExpect(t, "alice@test.local", 250)
+	c.Rset(t)
+	c.MailExpect(t, "alice@test.local", 421)
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed after 421: %v", err)
+	}
+}
+
 func TestRoundTrip_SMTP_AuthPlain_WrongPassword(t *testing.T) {
 	env := newTestEnv(t)
 	env.addUser(t, "alice", "rightpass")
@@ -536,6 +1202,114 @@ func TestRoundTrip_SMTP_AuthPlain_WrongPassword(t *testing.T) {
 	}
 }
 
+func TestRoundTrip_SMTP_SendAs(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.CheckLocalFrom = true
+		c.SendAs = map[string][]string{"alice@test.local": {"Sales@test.local"}}
+	})
+	env.addUser(t, "alice", "s3cret")
+
+	login := func() *smtpClient {
+		c := dialSMTP(t, env.addr)
+		c.Greeting(t)
+		c.Ehlo(t)
+		c.StartTLS(t, env.clientTLS)
+		c.AuthPlain(t, "alice@test.local", "s3cret")
+		return c
+	}
+
+	t.Run("as herself", func(t *testing.T) {
+		login().SendMessage(t, "alice@test.local", "bob@test.local", "hi", "body")
+	})
+
+	t.Run("as a send_as address", func(t *testing.T) {
+		login().SendMessage(t, "sales@test.local", "bob@test.local", "hi", "body")
+	})
+
+	t.Run("envelope as another user", func(t *testing.T) {
+		login().MailExpect(t, "bob@test.local", 553)
+	})
+
+	t.Run("From header as another user", func(t *testing.T) {
+		c := login()
+		c.MailExpect(t, "alice@test.local", 250)
+		c.RcptExpect(t, "carol@test.local", 250)
+		c.mustCode(t, "DATA", 354)
+		msg := c.mustCode(t, "From: bob@test.local\r\nTo: carol@test.local\r\nSubject: hi\r\n\r\nbody\r\n.", 550)
+		if !strings.Contains(msg, "From address not permitted") {
+			t.Errorf("DATA reply = %q", msg)
+		}
+	})
+
+	if got := env.deliveryServer.countMessages(); got != 2 {
+		t.Errorf("delivered %d messages, want 2", got)
+	}
+}
+
+func TestRoundTrip_SMTP_AuthPlain_Twice(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "s3cret")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.StartTLS(t, env.clientTLS)
+	c.AuthPlain(t, "alice@test.local", "s3cret")
+
+	creds := base64.StdEncoding.EncodeToString([]byte("\x00alice@test.local\x00s3cret"))
+	msg := c.mustCode(t, "AUTH PLAIN "+creds, 503)
+	if !strings.Contains(msg, "Already authenticated") {
+		t.Errorf("expected 'Already authenticated', got %q", msg)
+	}
+}
+
+func TestRoundTrip_SMTP_AuthPlain_Throttled(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.AuthRate = config.AuthRateConfig{MaxPerUser: 2, Delay: "0s"}
+	})
+	env.addUser(t, "alice", "s3cret")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.StartTLS(t, env.clientTLS)
+
+	// The mock session-manager fails bad credentials with a plain error,
+	// which smtpd reports as a temporary 454; tell them apart by text.
+	wrong := base64.StdEncoding.EncodeToString([]byte("\x00alice@test.local\x00wrong"))
+	for i := 0; i < 2; i++ {
+		if msg := c.mustCode(t, "AUTH PLAIN "+wrong, 454); strings.Contains(msg, "Too many") {
+			t.Fatalf("attempt %d throttled within the limit", i+1)
+		}
+	}
+
+	// Over the limit even the right password is refused unverified.
+	right := base64.StdEncoding.EncodeToString([]byte("\x00alice@test.local\x00s3cret"))
+	msg := c.mustCode(t, "AUTH PLAIN "+right, 454)
+	if !strings.Contains(msg, "Too many authentication attempts") {
+		t.Errorf("unexpected 454 text %q", msg)
+	}
+}
+
+func TestRoundTrip_SMTP_AuthPlain_Aborted(t *testing.T) {
+	env := newTestEnv(t)
+	env.addUser(t, "alice", "s3cret")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.StartTLS(t, env.clientTLS)
+
+	// No initial response: the server prompts with 334 and the client
+	// cancels with "*". The reply text comes from go-smtp.
+	c.mustCode(t, "AUTH PLAIN", 334)
+	c.mustCode(t, "*", 501)
+
+	// The session is still unauthenticated, so a fresh exchange is allowed
+	// (an authenticated session would get 503).
+	c.AuthPlain(t, "alice@test.local", "s3cret")
+}
+
 func TestRoundTrip_SMTP_AuthenticatedDelivery(t *testing.T) {
 	env := newTestEnv(t)
 	env.addUser(t, "alice", "testpass")
@@ -573,6 +1347,34 @@ func TestRoundTrip_SMTP_Reset_ClearsEnvelope(t *testing.T) {
 	}
 }
 
+func TestRoundTrip_SMTP_MaxConnRecipients(t *testing.T) {
+	env := newTestEnvWith(t, func(c *smtpserver.BackendConfig) {
+		c.MaxConnRcpts = 3
+	})
+	env.addUser(t, "alice", "testpass")
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+
+	// RSET clears the transaction, not the connection's tally.
+	c.MailExpect(t, "sender@example.com", 250)
+	c.RcptExpect(t, "alice@test.local", 250)
+	c.Rset(t)
+	c.SendMessage(t, "sender@example.com", "alice@test.local", "One", "Body.")
+	c.SendMessage(t, "sender@example.com", "alice@test.local", "Two", "Body.")
+
+	c.MailExpect(t, "sender@example.com", 250)
+	c.RcptExpect(t, "alice@test.local", 421)
+	if line, err := c.r.ReadString('\n'); err == nil {
+		t.Errorf("connection still open after 421, read %q", line)
+	}
+
+	if got := env.deliveryServer.countMessages(); got != 2 {
+		t.Errorf("expected 2 messages before the cap, got %d", got)
+	}
+}
+
 func TestRoundTrip_SMTP_MultipleMessages_SameSession(t *testing.T) {
 	env := newTestEnv(t)
 	env.addUser(t, "alice", "testpass")
@@ -590,6 +1392,31 @@ func TestRoundTrip_SMTP_MultipleMessages_SameSession(t *testing.T) {
 	}
 }
 
+// TestRoundTrip_SMTP_CatchAll_RecipientPreserved verifies that each delivery
+// carries the exact RCPT address, so msgstore can route catch-all mail to
+// folders by the address it was sent to.
+func TestRoundTrip_SMTP_CatchAll_RecipientPreserved(t *testing.T) {
+	env := newTestEnv(t)
+
+	c := dialSMTP(t, env.addr)
+	c.Greeting(t)
+	c.Ehlo(t)
+	c.SendMessage(t, "sender@example.com", "support@test.local", "Help", "Ticket.")
+	c.SendMessage(t, "sender@example.com", "sales@test.local", "Quote", "Order.")
+	c.Quit(t)
+
+	env.deliveryServer.mu.Lock()
+	defer env.deliveryServer.mu.Unlock()
+	if len(env.deliveryServer.messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(env.deliveryServer.messages))
+	}
+	for i, want := range []string{"support@test.local", "sales@test.local"} {
+		if got := env.deliveryServer.messages[i].metadata.GetRecipient(); got != want {
+			t.Errorf("message %d recipient = %q, want %q", i+1, got, want)
+		}
+	}
+}
+
 func TestRoundTrip_SMTP_EmptyFrom_Bounce(t *testing.T) {
 	env := newTestEnv(t)
 	env.addUser(t, "alice", "testpass")
@@ -0,0 +1,75 @@
+package smtp
+
+import (
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errSenderRejected is the MAIL reply for a sender listed in deny_senders.
+var errSenderRejected = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Sender address rejected",
+}
+
+// senderPolicy enforces [smtpd] deny_senders and allow_senders. Entries are
+// lower-cased addresses, or glob patterns in path.Match syntax such as
+// "mailer-daemon*@*" or "*@spam.example.net". allow_senders carves
+// exceptions out of deny_senders; it admits nothing on its own.
+type senderPolicy struct {
+	deny  []string
+	allow []string
+}
+
+// newSenderPolicy returns nil when deny is empty.
+func newSenderPolicy(deny, allow []string) *senderPolicy {
+	if len(deny) == 0 {
+		return nil
+	}
+	p := &senderPolicy{}
+	for _, d := range deny {
+		p.deny = append(p.deny, strings.ToLower(d))
+	}
+	for _, a := range allow {
+		p.allow = append(p.allow, strings.ToLower(a))
+	}
+	return p
+}
+
+// denies reports whether MAIL FROM addr is refused.
+func (p *senderPolicy) denies(addr string) bool {
+	addr = strings.ToLower(strings.Trim(addr, "<>"))
+	return matchesSender(p.deny, addr) && !matchesSender(p.allow, addr)
+}
+
+func matchesSender(patterns []string, addr string) bool {
+	for _, p := range patterns {
+		if p == addr {
+			return true
+		}
+		// Patterns are validated at config load.
+		if ok, _ := path.Match(p, addr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSenderPolicy refuses a MAIL FROM listed in deny_senders. The null
+// sender of a bounce has no address to match and is never refused here.
+func (s *Session) checkSenderPolicy(from string) error {
+	p := s.backend.senderPolicy
+	if p == nil || from == "" {
+		return nil
+	}
+	if !p.denies(from) {
+		s.filterDecision("sender_policy", nil)
+		return nil
+	}
+	s.filterDecision("sender_policy", errSenderRejected)
+	s.logger.Info("sender refused by policy", slog.String("from", from))
+	return errSenderRejected
+}
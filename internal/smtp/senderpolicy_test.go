@@ -0,0 +1,46 @@
+package smtp
+
+import (
+	"log/slog"
+	"testing"
+
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+func TestSession_Mail_SenderPolicy(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		DenySenders:  []string{"spam@example.net", "MAILER-DAEMON*@*", "*@bulk.example.org"},
+		AllowSenders: []string{"mailer-daemon@example.com"},
+	})
+
+	tests := []struct {
+		from     string
+		wantCode int // 0 = accepted
+	}{
+		{"spam@example.net", 550},
+		{"<Spam@Example.NET>", 550},
+		{"mailer-daemon@mx.example.net", 550},
+		{"mailer-daemon-2@example.org", 550},
+		{"news@bulk.example.org", 550},
+		{"mailer-daemon@example.com", 0},
+		{"alice@example.net", 0},
+		{"spam@example.net.evil", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			s := &Session{backend: backend, logger: slog.Default()}
+			err := s.Mail(tt.from, nil)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("Mail(%q) = %v, want accepted", tt.from, err)
+				}
+				return
+			}
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != tt.wantCode {
+				t.Errorf("Mail(%q) = %v, want %d", tt.from, err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package smtp
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+const (
+	senderMsgsPrefix  = "senders:msgs:"
+	senderBytesPrefix = "senders:bytes:"
+)
+
+// senderStats counts accepted messages and bytes per authenticated sender
+// in the state store ([smtpd.metrics] per_user). A sender's counters cover a
+// window that starts with their first message in it. The counts are read
+// back by TopSenders rather than exported to Prometheus, where one label per
+// user would be unbounded.
+//
+// State store errors are logged and otherwise ignored.
+type senderStats struct {
+	store  kvstore.Store
+	window time.Duration
+	logger *slog.Logger
+}
+
+// newSenderStats returns nil when per-user metrics are disabled.
+func newSenderStats(cfg config.MetricsConfig, store kvstore.Store, logger *slog.Logger) *senderStats {
+	if !cfg.PerUser || store == nil {
+		return nil
+	}
+	return &senderStats{store: store, window: cfg.GetPerUserWindow(), logger: logger}
+}
+
+// record counts one accepted message of size bytes from user.
+func (st *senderStats) record(ctx context.Context, user string, size int64) {
+	user = strings.ToLower(user)
+	if _, err := st.store.Incr(ctx, senderMsgsPrefix+user, st.window); err != nil {
+		st.logger.Debug("sender stats update failed", slog.String("error", err.Error()))
+		return
+	}
+	if _, err := st.store.IncrBy(ctx, senderBytesPrefix+user, size, st.window); err != nil {
+		st.logger.Debug("sender stats update failed", slog.String("error", err.Error()))
+	}
+}
+
+// recordSender counts an accepted message against the authenticated user.
+func (s *Session) recordSender(size int64) {
+	if s.backend.senderStats == nil || s.authUser == "" {
+		return
+	}
+	s.backend.senderStats.record(context.Background(), s.authUser, size)
+}
+
+// SenderCount is one authenticated sender's totals in the current window.
+type SenderCount struct {
+	User     string
+	Messages int64
+	Bytes    int64
+}
+
+// less orders senders by messages, then bytes; ties go to the later name so
+// that sorting in reverse lists names alphabetically.
+func (a SenderCount) less(b SenderCount) bool {
+	if a.Messages != b.Messages {
+		return a.Messages < b.Messages
+	}
+	if a.Bytes != b.Bytes {
+		return a.Bytes < b.Bytes
+	}
+	return a.User > b.User
+}
+
+// senderTopN keeps the n largest senders offered to it. It is a min-heap, so
+// the smallest kept sender is the one evicted when a larger one arrives and
+// memory stays bounded by n however many senders are scanned.
+type senderTopN struct {
+	n    int
+	heap senderHeap
+}
+
+func newSenderTopN(n int) *senderTopN {
+	return &senderTopN{n: n}
+}
+
+func (t *senderTopN) add(c SenderCount) {
+	switch {
+	case t.n <= 0:
+	case len(t.heap) < t.n:
+		heap.Push(&t.heap, c)
+	case t.heap[0].less(c):
+		t.heap[0] = c
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// sorted returns the kept senders, largest first.
+func (t *senderTopN) sorted() []SenderCount {
+	out := append([]SenderCount(nil), t.heap...)
+	sort.Slice(out, func(i, j int) bool { return out[j].less(out[i]) })
+	return out
+}
+
+type senderHeap []SenderCount
+
+func (h senderHeap) Len() int           { return len(h) }
+func (h senderHeap) Less(i, j int) bool { return h[i].less(h[j]) }
+func (h senderHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *senderHeap) Push(x any)        { *h = append(*h, x.(SenderCount)) }
+func (h *senderHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// TopSenders returns up to n authenticated senders with the most accepted
+// messages in the current window, largest first. It enumerates the state
+// store, so it is meant for administrative use, not per-message paths.
+func TopSenders(ctx context.Context, store kvstore.Store, n int) ([]SenderCount, error) {
+	msgs, err := store.Enumerate(ctx, senderMsgsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := store.Enumerate(ctx, senderBytesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	top := newSenderTopN(n)
+	for key, v := range msgs {
+		user := strings.TrimPrefix(key, senderMsgsPrefix)
+		m, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		b, _ := strconv.ParseInt(sizes[senderBytesPrefix+user], 10, 64)
+		top.add(SenderCount{User: user, Messages: m, Bytes: b})
+	}
+	return top.sorted(), nil
+}
@@ -0,0 +1,137 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+func TestSenderTopN(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		add  []SenderCount
+		want []string
+	}{
+		{
+			name: "fewer than n",
+			n:    3,
+			add:  []SenderCount{{User: "a", Messages: 1}, {User: "b", Messages: 5}},
+			want: []string{"b", "a"},
+		},
+		{
+			name: "smallest evicted",
+			n:    2,
+			add: []SenderCount{
+				{User: "a", Messages: 3},
+				{User: "b", Messages: 1},
+				{User: "c", Messages: 2},
+				{User: "d", Messages: 9},
+			},
+			want: []string{"d", "a"},
+		},
+		{
+			name: "smaller than all kept is ignored",
+			n:    2,
+			add: []SenderCount{
+				{User: "a", Messages: 5},
+				{User: "b", Messages: 4},
+				{User: "c", Messages: 1},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "ties broken by bytes then name",
+			n:    3,
+			add: []SenderCount{
+				{User: "b", Messages: 2, Bytes: 10},
+				{User: "a", Messages: 2, Bytes: 10},
+				{User: "c", Messages: 2, Bytes: 99},
+				{User: "d", Messages: 2, Bytes: 1},
+			},
+			want: []string{"c", "a", "b"},
+		},
+		{
+			name: "zero capacity",
+			n:    0,
+			add:  []SenderCount{{User: "a", Messages: 1}},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := newSenderTopN(tt.n)
+			for _, c := range tt.add {
+				top.add(c)
+			}
+			var got []string
+			for _, c := range top.sorted() {
+				got = append(got, c.User)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopSenders(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newRedisState(t)
+	st := newSenderStats(config.MetricsConfig{PerUser: true, PerUserWindow: "1h"}, store, slog.Default())
+
+	for i := 0; i < 3; i++ {
+		st.record(ctx, "alice@example.com", 100)
+	}
+	st.record(ctx, "Bob@example.com", 5000)
+	st.record(ctx, "carol@example.com", 10)
+	st.record(ctx, "carol@example.com", 10)
+
+	got, err := TopSenders(ctx, store, 2)
+	if err != nil {
+		t.Fatalf("TopSenders: %v", err)
+	}
+	want := []SenderCount{
+		{User: "alice@example.com", Messages: 3, Bytes: 300},
+		{User: "carol@example.com", Messages: 2, Bytes: 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TopSenders = %+v, want %+v", got, want)
+	}
+
+	mr.FastForward(time.Hour)
+	if got, _ := TopSenders(ctx, store, 2); len(got) != 0 {
+		t.Errorf("counts survived the window: %+v", got)
+	}
+}
+
+func TestSession_RecordSender(t *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewMemory()
+	backend := NewBackend(BackendConfig{
+		StateStore: store,
+		Metrics:    config.MetricsConfig{PerUser: true},
+	})
+
+	(&Session{backend: backend, logger: slog.Default()}).recordSender(42)
+	(&Session{backend: backend, authUser: "alice@example.com", logger: slog.Default()}).recordSender(42)
+
+	got, err := TopSenders(ctx, store, 10)
+	if err != nil {
+		t.Fatalf("TopSenders: %v", err)
+	}
+	if len(got) != 1 || got[0].User != "alice@example.com" {
+		t.Errorf("only the authenticated sender should be counted, got %+v", got)
+	}
+
+	disabled := NewBackend(BackendConfig{StateStore: store})
+	if disabled.senderStats != nil {
+		t.Error("sender stats enabled without metrics.per_user")
+	}
+}
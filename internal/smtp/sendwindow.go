@@ -0,0 +1,63 @@
+package smtp
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// errOutsideSendWindow is the MAIL reply for an authenticated user outside
+// their send_hours.
+var errOutsideSendWindow = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Sending not permitted at this time",
+}
+
+// sendWindows enforces [smtpd.users] send_hours and send_days.
+type sendWindows struct {
+	windows map[string]*config.AcceptWindow // by lower-cased username
+	now     func() time.Time
+}
+
+// newSendWindows returns nil when no user has a window. Windows that do not
+// parse are skipped; config.Validate has already refused them.
+func newSendWindows(users config.UsersConfig) *sendWindows {
+	windows := make(map[string]*config.AcceptWindow)
+	for user, u := range users {
+		if w, err := u.SendWindow(); err == nil && w != nil {
+			windows[user] = w
+		}
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+	return &sendWindows{windows: windows, now: time.Now}
+}
+
+// permits reports whether user may send now. Users without a window are
+// unrestricted.
+func (w *sendWindows) permits(user string) bool {
+	win, ok := w.windows[strings.ToLower(user)]
+	return !ok || win.Contains(w.now())
+}
+
+// checkSendWindow refuses MAIL from an authenticated user outside their
+// sending window. Unauthenticated sessions are not covered.
+func (s *Session) checkSendWindow() error {
+	w := s.backend.sendWindows
+	if w == nil || s.authUser == "" {
+		return nil
+	}
+	if w.permits(s.authUser) {
+		s.filterDecision("send_window", nil)
+		return nil
+	}
+	s.logger.Info("sender refused outside send window",
+		slog.String("auth_user", s.authUser))
+	s.filterDecision("send_window", errOutsideSendWindow)
+	return errOutsideSendWindow
+}
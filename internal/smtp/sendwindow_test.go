@@ -0,0 +1,52 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+func TestSession_Mail_SendWindow(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		Users: config.UsersConfig{
+			"batch@example.com": {SendHours: "01:00-05:00", SendDays: []string{"mon", "tue", "wed", "thu", "fri"}, TimeZone: "UTC"},
+		},
+	})
+
+	tests := []struct {
+		name string
+		now  time.Time
+		user string
+		want error
+	}{
+		// 2026-03-02 is a Monday.
+		{"inside window", time.Date(2026, 3, 2, 2, 0, 0, 0, time.UTC), "batch@example.com", nil},
+		{"outside window", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "batch@example.com", errOutsideSendWindow},
+		{"weekend", time.Date(2026, 3, 7, 2, 0, 0, 0, time.UTC), "batch@example.com", errOutsideSendWindow},
+		{"username case", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "Batch@Example.com", errOutsideSendWindow},
+		{"user without window", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "alice@example.com", nil},
+		{"unauthenticated", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.sendWindows.now = func() time.Time { return tt.now }
+			s := &Session{backend: backend, clientIP: "192.0.2.1", authUser: tt.user, logger: slog.Default()}
+			from := tt.user
+			if from == "" {
+				from = "someone@example.org"
+			}
+			if err := s.Mail(from, nil); !errors.Is(err, tt.want) {
+				t.Errorf("Mail() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSendWindows_None(t *testing.T) {
+	if w := newSendWindows(config.UsersConfig{"alice@example.com": {}}); w != nil {
+		t.Error("newSendWindows() without any send_hours should be nil")
+	}
+}
@@ -4,26 +4,42 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"sync"
 	"time"
 
 	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/logging"
 	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/metrics"
 )
 
 // serverEntry holds a go-smtp server and its mode.
 type serverEntry struct {
 	server *gosmtp.Server
 	mode   config.ListenerMode
+	// backend and tlsConfig are the unwrapped values RunSingleConn
+	// re-wraps with per-connection observers.
+	backend   gosmtp.Backend
+	tlsConfig *tls.Config
 }
 
 // Server wraps multiple go-smtp servers for multi-mode listener support.
 type Server struct {
-	entries []serverEntry
-	logger  *slog.Logger
-	wg      sync.WaitGroup
+	entries         []serverEntry
+	backend         *Backend
+	logTransactions bool
+	maxGreetings    int
+	capture         *captureSelector
+	tlsPolicy       config.TLSPolicy
+	tlsConfig       *tls.Config
+	rejectALPN      bool
+	collector       metrics.Collector
+	reputation      *ipReputation
+	logger          *slog.Logger
+	wg              sync.WaitGroup
 }
 
 // ServerConfig holds configuration for creating a multi-mode Server.
@@ -36,7 +52,22 @@ type ServerConfig struct {
 	WriteTimeout   time.Duration
 	MaxMessageSize int
 	MaxRecipients  int
-	Logger         *slog.Logger
+	// LogTransactions logs every protocol line at debug level, with AUTH
+	// credentials redacted. Only applied to RunSingleConn.
+	LogTransactions bool
+	// MaxGreetings caps the HELO/EHLO commands on the connection; see
+	// greetingCounter. Only applied to RunSingleConn.
+	MaxGreetings int
+	// Capture records whole sessions of selected connections to files.
+	// Only applied to RunSingleConn.
+	Capture config.CaptureConfig
+	// TLSPolicy is the tls_policy of the listener RunSingleConn connections
+	// arrived on; "off" withholds STARTTLS. Run applies each listener's own.
+	TLSPolicy config.TLSPolicy
+	// RejectALPN refuses TLS clients that offer ALPN protocols other than
+	// "smtp"; see tlsObserver.
+	RejectALPN bool
+	Logger     *slog.Logger
 }
 
 // NewServer creates a new multi-mode Server with go-smtp servers for each listener.
@@ -47,8 +78,22 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 	}
 
 	srv := &Server{
-		entries: make([]serverEntry, 0, len(cfg.Listeners)),
-		logger:  logger,
+		entries:         make([]serverEntry, 0, len(cfg.Listeners)),
+		backend:         cfg.Backend,
+		logTransactions: cfg.LogTransactions,
+		maxGreetings:    cfg.MaxGreetings,
+		capture:         newCaptureSelector(cfg.Capture),
+		tlsPolicy:       cfg.TLSPolicy,
+		tlsConfig:       cfg.TLSConfig,
+		rejectALPN:      cfg.RejectALPN,
+		logger:          logger,
+	}
+	if cfg.Backend != nil {
+		srv.collector = cfg.Backend.collector
+		// A honeypot wants the clients reputation would turn away.
+		if cfg.Backend.honeypotDir == "" {
+			srv.reputation = cfg.Backend.reputation
+		}
 	}
 
 	for _, listener := range cfg.Listeners {
@@ -60,13 +105,17 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		s.MaxMessageBytes = int64(cfg.MaxMessageSize)
 		s.MaxRecipients = cfg.MaxRecipients
 		s.EnableSMTPUTF8 = true
+		// STARTTLS is advertised whenever TLSConfig is set.
+		startTLS := cfg.TLSConfig != nil && listener.GetTLSPolicy() != config.TLSPolicyOff
+		// Binary bodies arrive by BDAT only; see errBinaryMIMERelay.
+		s.EnableBINARYMIME = true
 
 		switch listener.Mode {
 		case config.ModeSmtp:
 			// Standard SMTP on port 25
 			// AUTH only allowed after STARTTLS (except localhost)
 			s.AllowInsecureAuth = false
-			if cfg.TLSConfig != nil {
+			if startTLS {
 				s.TLSConfig = cfg.TLSConfig
 			}
 
@@ -74,7 +123,7 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 			// Submission on port 587
 			// Requires STARTTLS before AUTH
 			s.AllowInsecureAuth = false
-			if cfg.TLSConfig != nil {
+			if startTLS {
 				s.TLSConfig = cfg.TLSConfig
 			}
 
@@ -94,12 +143,17 @@ func NewServer(cfg ServerConfig) (*Server, error) {
 		case config.ModeAlt:
 			// Alternative mode - similar to SMTP
 			s.AllowInsecureAuth = false
-			if cfg.TLSConfig != nil {
+			if startTLS {
 				s.TLSConfig = cfg.TLSConfig
 			}
 		}
 
-		srv.entries = append(srv.entries, serverEntry{server: s, mode: listener.Mode})
+		srv.entries = append(srv.entries, serverEntry{
+			server:    s,
+			mode:      listener.Mode,
+			backend:   s.Backend,
+			tlsConfig: s.TLSConfig,
+		})
 		logger.Info("configured listener",
 			slog.String("address", listener.Address),
 			slog.String("mode", string(listener.Mode)))
@@ -118,13 +172,17 @@ func (s *Server) Run(ctx context.Context) error {
 		go func(entry serverEntry) {
 			defer s.wg.Done()
 
-			var err error
-			if entry.mode == config.ModeSmtps {
-				s.logger.Info("starting SMTPS listener", slog.String("address", entry.server.Addr))
-				err = entry.server.ListenAndServeTLS()
-			} else {
-				s.logger.Info("starting listener", slog.String("address", entry.server.Addr))
-				err = entry.server.ListenAndServe()
+			// Like go-smtp's ListenAndServe(TLS), but with each connection
+			// wrapped as RunSingleConn wraps its one.
+			ln, err := net.Listen("tcp", entry.server.Addr)
+			if err == nil {
+				if entry.mode == config.ModeSmtps {
+					s.logger.Info("starting SMTPS listener", slog.String("address", entry.server.Addr))
+					ln = tls.NewListener(ln, entry.server.TLSConfig)
+				} else {
+					s.logger.Info("starting listener", slog.String("address", entry.server.Addr))
+				}
+				err = entry.server.Serve(notifyListener{ln})
 			}
 
 			if err != nil {
@@ -137,6 +195,9 @@ func (s *Server) Run(ctx context.Context) error {
 	<-ctx.Done()
 
 	s.logger.Info("shutting down servers")
+	if s.backend != nil {
+		s.backend.Stop()
+	}
 
 	// Gracefully close all servers
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -188,13 +249,90 @@ func (s *Server) RunSingleConn(conn net.Conn, mode config.ListenerMode, tlsConfi
 		return fmt.Errorf("no server entries configured")
 	}
 
-	// SMTPS uses implicit TLS: wrap conn before handing to go-smtp.
-	// For SMTP/Submission modes, go-smtp handles STARTTLS via entry.server.TLSConfig.
+	// Entries are per mode, so the entry may have been built for another
+	// listener of the same mode; apply the accepting listener's policy.
+	if s.tlsPolicy != "" && mode != config.ModeSmtps {
+		entry.tlsConfig = s.tlsConfig
+		if s.tlsPolicy == config.TLSPolicyOff {
+			entry.tlsConfig = nil
+		}
+		entry.server.TLSConfig = entry.tlsConfig
+	}
+
+	connLogger := logging.WithConnection(s.logger, conn.RemoteAddr().String())
+
+	// Count TLS handshake failures. For SMTP/Submission modes go-smtp runs
+	// the STARTTLS handshake with entry.server.TLSConfig, so the observer
+	// hooks that config; this server instance only serves this connection.
+	obs := newTLSObserver(s.collector, connLogger, s.rejectALPN)
+	defer obs.finish()
+	if entry.tlsConfig != nil && mode != config.ModeSmtps {
+		entry.server.TLSConfig = obs.wrap(entry.tlsConfig)
+		entry.server.Backend = &observingBackend{Backend: entry.backend, obs: obs}
+	}
+
+	// SMTPS uses implicit TLS: complete the handshake before handing the
+	// connection to go-smtp so failures can be classified and counted.
 	if mode == config.ModeSmtps {
 		if tlsConfig == nil {
 			return fmt.Errorf("SMTPS mode requires TLS configuration")
 		}
-		conn = tls.Server(conn, tlsConfig)
+		tlsConn := tls.Server(conn, obs.wrap(tlsConfig))
+		if entry.server.ReadTimeout > 0 {
+			_ = conn.SetDeadline(time.Now().Add(entry.server.ReadTimeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			obs.handshakeError(err)
+			_ = conn.Close()
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+		obs.established()
+		_ = conn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+
+	// Refuse clients whose recent transactions were mostly rejected. This
+	// runs after the SMTPS handshake so the reply is readable.
+	if s.reputation != nil {
+		if ip := extractIPFromConn(conn); s.reputation.blocked(context.Background(), ip) {
+			connLogger.Info("refusing connection from IP in rejection cooldown")
+			rejectConn(conn, s.reputation.rejectError())
+			return nil
+		}
+	}
+
+	// The transaction log keeps per-connection redaction state, which is safe
+	// here because this server instance only ever serves this one connection.
+	var sinks []transactionSink
+	if s.logTransactions {
+		sinks = append(sinks, logSink(connLogger))
+	}
+	if ip := extractIPFromConn(conn); s.capture != nil && s.capture.selects(ip) {
+		capture, err := openSessionCapture(s.capture.dir, ip)
+		if err != nil {
+			connLogger.Warn("cannot capture session", slog.String("error", err.Error()))
+		} else {
+			connLogger.Info("capturing session", slog.String("file", capture.path))
+			defer func() {
+				if err := capture.Close(); err != nil {
+					connLogger.Warn("session capture incomplete",
+						slog.String("file", capture.path),
+						slog.String("error", err.Error()))
+				}
+			}()
+			sinks = append(sinks, capture.record)
+		}
+	}
+	var debug []io.Writer
+	if len(sinks) > 0 {
+		debug = append(debug, newTransactionLog(sinks...))
+	}
+	if greetings := newGreetingCounter(s.maxGreetings); greetings != nil {
+		debug = append(debug, greetings)
+		entry.server.Backend = &greetingBackend{Backend: entry.server.Backend, greetings: greetings}
+	}
+	if len(debug) > 0 {
+		entry.server.Debug = io.MultiWriter(debug...)
 	}
 
 	ln := newOneConnListener(conn)
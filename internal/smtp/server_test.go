@@ -1,8 +1,11 @@
 package smtp
 
 import (
+	"bufio"
 	"context"
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -199,3 +202,87 @@ func TestServerMultipleListeners(t *testing.T) {
 	}
 	_ = conn2.Close()
 }
+
+func TestRunSingleConn_TLSPolicy(t *testing.T) {
+	serverTLS, _ := selfSignedTLS(t)
+
+	tests := []struct {
+		policy       config.TLSPolicy
+		wantSTARTTLS bool
+		wantMail     string
+	}{
+		{config.TLSPolicyOff, false, "250"},
+		{config.TLSPolicyOptional, true, "250"},
+		{config.TLSPolicyRequired, true, "530"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			// Two smtp listeners with opposite policies: the connection
+			// must get the policy it was accepted under, not the first
+			// listener's.
+			other := config.TLSPolicyOff
+			if tt.policy == config.TLSPolicyOff {
+				other = config.TLSPolicyOptional
+			}
+			srv, err := NewServer(ServerConfig{
+				Backend: NewBackend(BackendConfig{
+					Hostname:   "test.local",
+					RequireTLS: tt.policy == config.TLSPolicyRequired,
+				}),
+				Listeners: []config.ListenerConfig{
+					{Address: "127.0.0.1:0", Mode: config.ModeSmtp, TLSPolicy: other},
+					{Address: "127.0.0.1:0", Mode: config.ModeSmtp, TLSPolicy: tt.policy},
+				},
+				Hostname:     "test.local",
+				TLSConfig:    serverTLS,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 5 * time.Second,
+				TLSPolicy:    tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+
+			server, client := net.Pipe()
+			t.Cleanup(func() { _ = client.Close() })
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_ = srv.RunSingleConn(server, config.ModeSmtp, serverTLS)
+			}()
+
+			r := bufio.NewReader(client)
+			reply := func(cmd string) string {
+				t.Helper()
+				if cmd != "" {
+					if _, err := fmt.Fprintf(client, "%s\r\n", cmd); err != nil {
+						t.Fatalf("write %q: %v", cmd, err)
+					}
+				}
+				var lines []string
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						t.Fatalf("%q: read: %v", cmd, err)
+					}
+					lines = append(lines, line)
+					if line[3] == ' ' {
+						return strings.Join(lines, "")
+					}
+				}
+			}
+
+			reply("")
+			ehlo := reply("EHLO client.example")
+			if got := strings.Contains(ehlo, "STARTTLS"); got != tt.wantSTARTTLS {
+				t.Errorf("STARTTLS advertised = %v, want %v:\n%s", got, tt.wantSTARTTLS, ehlo)
+			}
+			if got := reply("MAIL FROM:<alice@example.com>"); !strings.HasPrefix(got, tt.wantMail) {
+				t.Errorf("MAIL reply = %q, want %s", got, tt.wantMail)
+			}
+			reply("QUIT")
+			waitDone(t, done)
+		})
+	}
+}
@@ -1,13 +1,18 @@
 package smtp
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/mail"
+	"net/textproto"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -49,33 +54,76 @@ func (b *memTempBuf) Write(p []byte) (int, error) { return b.buf.Write(p) }
 func (b *memTempBuf) reader() io.Reader           { return bytes.NewReader(b.buf.Bytes()) }
 func (b *memTempBuf) cleanup()                    {}
 
-// newTempBuffer tries to create a temp file in dir (falling back to os.TempDir
-// when dir is ""). If file creation fails for any reason, it returns an
-// in-memory buffer so message delivery can still proceed.
+// newTempBuffer tries to create a temp file in today's subdirectory of dir
+// (falling back to os.TempDir when dir is ""). If file creation fails for
+// any reason, it returns an in-memory buffer so message delivery can still
+// proceed. Buffers left behind by a crash are removed by sweepTempDir.
 func newTempBuffer(dir string) tempBuffer {
 	if dir != "" {
+		dir = tempDayDir(dir, time.Now())
 		if err := os.MkdirAll(dir, 0700); err == nil {
-			if f, err := os.CreateTemp(dir, "smtp-msg-*"); err == nil {
+			if f, err := os.CreateTemp(dir, tempFilePrefix+"*"); err == nil {
 				return &fileTempBuf{f: f}
 			}
 		}
 	} else {
-		if f, err := os.CreateTemp("", "smtp-msg-*"); err == nil {
+		if f, err := os.CreateTemp("", tempFilePrefix+"*"); err == nil {
 			return &fileTempBuf{f: f}
 		}
 	}
 	return &memTempBuf{}
 }
 
-// countingReader wraps an io.Reader and counts bytes read.
+// messageBody returns the buffered message as it is delivered: without the
+// spam bypass header or, with auth_user_header on, a client-sent
+// X-Authenticated-User, and with header_charset_policy "drop" or "replace"
+// applied.
+func (s *Session) messageBody(tmp tempBuffer) io.Reader {
+	r := tmp.reader()
+	if b := s.backend.spamBypass; b != nil {
+		r = newHeaderFilter(r, b.header)
+	}
+	if s.backend.authUserHeader {
+		r = newHeaderFilter(r, authUserHeaderName)
+	}
+	switch s.backend.headerCharset {
+	case config.HeaderCharsetDrop:
+		r = newHeaderCharsetFilter(r, true)
+	case config.HeaderCharsetReplace:
+		r = newHeaderCharsetFilter(r, false)
+	}
+	if s.receivedTrim != (receivedRange{}) {
+		r = newReceivedFilter(r, s.receivedTrim)
+	}
+	return r
+}
+
+// countingReader wraps an io.Reader and counts bytes read. With a limit it
+// fails with smtp.ErrDataTooLarge as soon as the message goes past limit
+// bytes, reading at most one byte beyond it.
 type countingReader struct {
-	r io.Reader
-	n int64
+	r     io.Reader
+	n     int64
+	limit int64     // 0 = unlimited
+	err   error     // first read error other than io.EOF
+	eof   time.Time // when the end of the data was reached
 }
 
 func (c *countingReader) Read(p []byte) (int, error) {
+	if c.limit > 0 && int64(len(p)) > c.limit-c.n+1 {
+		p = p[:c.limit-c.n+1]
+	}
 	n, err := c.r.Read(p)
+	if c.limit > 0 && c.n+int64(n) > c.limit {
+		n, err = int(c.limit-c.n), smtp.ErrDataTooLarge
+	}
 	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	if err == io.EOF && c.eof.IsZero() {
+		c.eof = time.Now()
+	}
 	return n, err
 }
 
@@ -88,12 +136,32 @@ type Session struct {
 	helo                     string
 	from                     string
 	mailFromSeen             bool     // true once MAIL FROM is accepted (from may be "" for bounces)
+	dataSeen                 bool     // true once DATA or the first BDAT chunk starts
+	binaryMIME               bool     // MAIL FROM carried BODY=BINARYMIME
+	declaredSize             int64    // MAIL FROM SIZE=; 0 = not given
 	recipients               []string // local recipients → mail-session
 	remoteRecipients         []string // remote recipients → queue (authenticated submission only)
 	authUser                 string
 	loginResult              *LoginResult // set on successful session-manager Login
+	sessionSlot              string       // user holding a max_sessions_per_user slot
 	deferredInvalidRecipient string       // non-empty when data-mode deferred an unknown user
+	originalRecipient        string       // RCPT address when recipients[0] is role_mailbox or a shared mailbox
+	fcrdnsName               string       // client's forward-confirmed PTR name, once checked
+	signals                  []string     // weak signals tripped by this message; see signals.go
+	connRecipients           int          // recipients accepted on this connection; survives Reset
 	logger                   *slog.Logger
+
+	// budgetUsed is the processing time charged to the current message;
+	// see stage.
+	budgetUsed time.Duration
+
+	// receivedTrim is the run of Received fields dropped from the current
+	// message on delivery; see planReceivedTrim.
+	receivedTrim receivedRange
+
+	// greetings counts this connection's HELO/EHLO commands; nil when
+	// max_greetings is unset. See greetings.go.
+	greetings *greetingCounter
 }
 
 // AuthMechanisms returns the available authentication mechanisms.
@@ -121,6 +189,24 @@ func (s *Session) AuthMechanisms() []string {
 // Auth handles authentication.
 // Implements smtp.AuthSession interface.
 func (s *Session) Auth(mech string) (sasl.Server, error) {
+	// go-smtp answers a repeated AUTH itself; this guards the session state
+	// should a second exchange ever reach us (RFC 4954 §4).
+	if s.authUser != "" {
+		return nil, &smtp.SMTPError{
+			Code:         503,
+			EnhancedCode: smtp.EnhancedCode{5, 5, 1},
+			Message:      "Already authenticated",
+		}
+	}
+
+	// Throttling is shared by all mechanisms (see authrate.go).
+	if err := s.throttleAuthIP(); err != nil {
+		return nil, err
+	}
+	if err := s.checkState(); err != nil {
+		return nil, err
+	}
+
 	switch mech {
 	case sasl.Plain:
 		if s.backend.smDelivery == nil {
@@ -128,6 +214,12 @@ func (s *Session) Auth(mech string) (sasl.Server, error) {
 		}
 
 		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if err := s.throttleAuthUser(username); err != nil {
+				return err
+			}
+			if err := s.checkState(); err != nil {
+				return err
+			}
 			ctx := context.Background()
 
 			result, err := s.backend.smDelivery.Login(ctx, username, password)
@@ -152,6 +244,7 @@ func (s *Session) Auth(mech string) (sasl.Server, error) {
 							Message:      "Too many failed authentication attempts, try again later",
 						}
 					case codes.Unauthenticated:
+						s.delayAuthFailure()
 						return &smtp.SMTPError{
 							Code:         535,
 							EnhancedCode: smtp.EnhancedCode{5, 7, 8},
@@ -167,6 +260,17 @@ func (s *Session) Auth(mech string) (sasl.Server, error) {
 				}
 			}
 
+			if err := s.checkIPUsers(result.Mailbox); err != nil {
+				return err
+			}
+			if err := s.acquireUserSession(result.Mailbox); err != nil {
+				return err
+			}
+			if err := s.checkState(); err != nil {
+				s.releaseUserSession()
+				return err
+			}
+
 			// Use normalized mailbox from session-manager.
 			s.authUser = result.Mailbox
 			s.loginResult = result
@@ -188,7 +292,46 @@ func (s *Session) Auth(mech string) (sasl.Server, error) {
 
 // Mail handles the MAIL FROM command.
 // Implements smtp.Session interface.
-func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
+func (s *Session) Mail(from string, opts *smtp.MailOptions) (err error) {
+	// Permanent rejections count against the client IP (see reputation.go).
+	defer func() {
+		s.noteOutcome(err)
+		s.noteRejection("MAIL", from, "", err)
+	}()
+
+	// A second MAIL inside an open transaction is a sequencing error
+	// (RFC 5321 §4.1.4); the client must RSET first. go-smtp resets the
+	// session after DATA, RSET and EHLO, so mailFromSeen marks an open
+	// transaction rather than a previous one.
+	if s.mailFromSeen {
+		return &smtp.SMTPError{
+			Code:         503,
+			EnhancedCode: smtp.EnhancedCode{5, 5, 1},
+			Message:      "Sender already specified",
+		}
+	}
+
+	if s.greetings.exceeded() {
+		return errTooManyGreetings
+	}
+
+	if err := s.checkMaintenance(); err != nil {
+		return err
+	}
+
+	// Covers the reputation lookup made when the connection opened.
+	if err := s.checkState(); err != nil {
+		return err
+	}
+
+	if err := s.checkTLSRequired(); err != nil {
+		return err
+	}
+
+	if err := s.checkSenderPolicy(from); err != nil {
+		return err
+	}
+
 	// Per-sender rate limiting for authenticated submission (Redis-backed).
 	// Resolves per-domain limit from loginResult with global fallback.
 	if s.authUser != "" && s.backend.senderRateLimiter != nil {
@@ -197,24 +340,25 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 			maxRate = s.loginResult.MaxSendsPerHour
 		}
 		if maxRate > 0 && !s.backend.senderRateLimiter.allow(context.Background(), s.authUser, maxRate) {
-			s.logger.Warn("sender rate limit exceeded",
+			s.logger.Warn("sender rate limit exceeded, closing connection",
 				slog.String("auth_user", s.authUser))
-			return &smtp.SMTPError{
-				Code:         452,
-				EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-				Message:      "Too many messages, try again later",
-			}
+			return s.closeAfterReply(overloadError(s.backend.overloadMessage))
 		}
 	}
 
+	if err := s.checkSendWindow(); err != nil {
+		return err
+	}
+
 	// Sender verification: authenticated users may only send as their exact
-	// authenticated address. No aliases, no other local parts on the same domain.
-	// Bounce messages (empty sender) are exempt.
+	// authenticated address, or an address [smtpd.send_as] grants them. No
+	// other local parts on the same domain. Bounce messages (empty sender)
+	// are exempt.
 	if s.authUser != "" && from != "" {
 		// Normalize both addresses: strip angle brackets, lowercase.
 		normFrom := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(from, "<"), ">"))
 		normAuth := strings.ToLower(s.authUser)
-		if normFrom != normAuth {
+		if normFrom != normAuth && !s.backend.sendAs[normAuth][normFrom] {
 			s.logger.Warn("sender verification failed",
 				slog.String("auth_user", s.authUser),
 				slog.String("from", from))
@@ -226,8 +370,31 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 		}
 	}
 
+	// go-smtp keeps the EHLO/HELO name on the connection.
+	if s.conn != nil {
+		s.helo = s.conn.Hostname()
+	}
+
+	ctx, done := s.stage()
+	defer done()
+
+	if err := s.checkFCrDNS(ctx); err != nil {
+		return err
+	}
+
+	if err := s.precheckSpam(ctx, config.SpamPrecheckMail, from, nil); err != nil {
+		return err
+	}
+	if err := s.checkDeadline(ctx, "mail"); err != nil {
+		return err
+	}
+
 	s.from = from
 	s.mailFromSeen = true
+	s.binaryMIME = opts != nil && opts.Body == smtp.BodyBinaryMIME
+	if opts != nil {
+		s.declaredSize = opts.Size
+	}
 
 	if s.backend.collector != nil {
 		s.backend.collector.CommandProcessed("MAIL")
@@ -239,7 +406,22 @@ func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 
 // Rcpt handles the RCPT TO command.
 // Implements smtp.Session interface.
-func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) (err error) {
+	// Permanent rejections count against the client IP (see reputation.go).
+	defer func() {
+		s.noteOutcome(err)
+		s.noteRejection("RCPT", s.from, to, err)
+	}()
+
+	if err := s.checkConnRecipients(); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			s.connRecipients++
+		}
+	}()
+
 	// Enforce single recipient per message to avoid partial delivery scenarios.
 	// Remote (queued) recipients and deferred-invalid count against the same limit.
 	if len(s.recipients)+len(s.remoteRecipients) > 0 || s.deferredInvalidRecipient != "" {
@@ -260,11 +442,38 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 		}
 	}
 
+	// Role addresses (postmaster@, abuse@) must stay reachable, so smtpd's
+	// own refusals below do not apply to them.
+	role := s.isRoleRecipient(to)
+
+	if s.from == "" && !role && s.refusesBounces(to, domainName) {
+		s.logger.Info("bounce refused by recipient policy", slog.String("to", to))
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "This address does not accept bounces",
+		}
+	}
+
+	ctx, done := s.stage()
+	defer done()
+
+	if !role {
+		if err := s.precheckSpam(ctx, config.SpamPrecheckRcpt, s.from, []string{to}); err != nil {
+			return err
+		}
+		if err := s.checkDeadline(ctx, "rcpt"); err != nil {
+			return err
+		}
+	}
+
 	// Validate recipient via session-manager
 	if s.backend.smDelivery != nil {
-		ctx := context.Background()
 		vr, err := s.backend.smDelivery.ValidateRecipient(ctx, to)
 		if err != nil {
+			if err := s.checkDeadline(ctx, "rcpt"); err != nil {
+				return err
+			}
 			s.logger.Debug("recipient validation failed",
 				slog.String("recipient", to),
 				slog.String("error", err.Error()))
@@ -285,6 +494,16 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 					Message:      "Relay denied",
 				}
 			}
+			// The outbound queue has no way to carry the body type, so it
+			// would relay binary content over DATA. Refuse rather than
+			// corrupt it (RFC 3030 §4).
+			if s.binaryMIME {
+				s.logger.Debug("binary message to remote recipient refused", slog.String("recipient", to))
+				return errBinaryMIMERelay
+			}
+			if err := s.checkNullMX(ctx, domainName); err != nil {
+				return err
+			}
 			// Authenticated submission: queue for remote delivery.
 			s.remoteRecipients = append(s.remoteRecipients, to)
 			if s.backend.collector != nil {
@@ -294,7 +513,49 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 			return nil
 		}
 
+		if !role {
+			if err := s.checkAcceptWindow(domainName); err != nil {
+				return err
+			}
+			if err := s.checkInboundRate(domainName); err != nil {
+				return err
+			}
+			if err := s.checkState(); err != nil {
+				return err
+			}
+		}
+
+		mailbox, err := s.sharedMailbox(ctx, to, domainName)
+		if err != nil {
+			return err
+		}
+		if mailbox != "" {
+			s.recipients = append(s.recipients, mailbox)
+			s.originalRecipient = to
+			if s.backend.collector != nil {
+				s.backend.collector.CommandProcessed("RCPT")
+			}
+			s.logger.Info("RCPT TO (shared mailbox)",
+				slog.String("from", s.from), slog.String("to", to),
+				slog.String("mailbox", mailbox))
+			return nil
+		}
+
 		if !vr.UserExists {
+			if role {
+				// RFC 5321 §4.5.1: postmaster must be deliverable on every
+				// hosted domain, user or not.
+				s.recipients = append(s.recipients, s.backend.roleMailbox)
+				s.originalRecipient = to
+				if s.backend.collector != nil {
+					s.backend.collector.CommandProcessed("RCPT")
+				}
+				s.logger.Info("RCPT TO (role mailbox)",
+					slog.String("from", s.from), slog.String("to", to),
+					slog.String("mailbox", s.backend.roleMailbox))
+				return nil
+			}
+
 			if vr.DeferRejection {
 				// Defer rejection to after DATA to hide address validity
 				// and enable spamtrap auto-learning.
@@ -309,11 +570,7 @@ func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 			}
 
 			s.logger.Debug("user unknown", slog.String("recipient", to))
-			return &smtp.SMTPError{
-				Code:         550,
-				EnhancedCode: smtp.EnhancedCode{5, 1, 1},
-				Message:      "User unknown",
-			}
+			return s.unknownUser(to)
 		}
 	}
 
@@ -340,8 +597,208 @@ func extractDomain(email string) string {
 	return strings.ToLower(email[idx+1:])
 }
 
+// refusesBounces reports whether [smtpd] no_bounce_recipients lists to, or
+// its domain as "@domain". Role addresses that never send mail cannot
+// legitimately receive bounces, so refusing them cuts backscatter.
+func (s *Session) refusesBounces(to, domain string) bool {
+	if len(s.backend.noBounce) == 0 {
+		return false
+	}
+	addr := strings.ToLower(strings.Trim(to, "<>"))
+	return s.backend.noBounce[addr] || s.backend.noBounce["@"+domain]
+}
+
+// isRoleRecipient reports whether the local part of to is one of the
+// role_recipients, which are routed to role_mailbox when the domain has no
+// such user.
+func (s *Session) isRoleRecipient(to string) bool {
+	if len(s.backend.roleRecipients) == 0 {
+		return false
+	}
+	local := to
+	if i := strings.LastIndex(to, "@"); i >= 0 {
+		local = to[:i]
+	}
+	return s.backend.roleRecipients[strings.ToLower(local)]
+}
+
+// checkContent runs the DATA content checks over the buffered message and
+// counts their joint outcome as the "content" filter stage.
+func (s *Session) checkContent(tmp tempBuffer) error {
+	if err := s.checkMIMEStructure(tmp.reader()); err != nil {
+		if s.backend.collector != nil {
+			reason := "mime_too_complex"
+			if err == errMalformedEncoding {
+				reason = "bad_encoding"
+			}
+			domain := sessionExtractRecipientDomain(s.recipients)
+			s.backend.collector.MessageRejected(domain, reason)
+		}
+		s.filterDecision("content", err)
+		return err
+	}
+
+	if err := s.checkRequiredHeaders(tmp.reader()); err != nil {
+		if s.backend.collector != nil {
+			domain := sessionExtractRecipientDomain(s.recipients)
+			s.backend.collector.MessageRejected(domain, "missing_header")
+		}
+		s.filterDecision("content", err)
+		return err
+	}
+
+	if err := s.checkDuplicateHeaders(tmp.reader()); err != nil {
+		if s.backend.collector != nil {
+			domain := sessionExtractRecipientDomain(s.recipients)
+			s.backend.collector.MessageRejected(domain, "duplicate_header")
+		}
+		s.filterDecision("content", err)
+		return err
+	}
+
+	if err := s.checkHeaderCharset(tmp.reader()); err != nil {
+		if s.backend.collector != nil {
+			domain := sessionExtractRecipientDomain(s.recipients)
+			s.backend.collector.MessageRejected(domain, "header_charset")
+		}
+		s.filterDecision("content", err)
+		return err
+	}
+
+	if s.contentChecks() {
+		s.filterDecision("content", nil)
+	}
+	return nil
+}
+
+// contentChecks reports whether any of the checks run by checkContent is
+// enabled for s.
+func (s *Session) contentChecks() bool {
+	b := s.backend
+	return b.maxMIMEDepth > 0 || b.maxMIMEParts > 0 ||
+		(b.checkEncoding && s.authUser != "") ||
+		b.headerPolicy == config.HeaderPolicyBasic || b.headerPolicy == config.HeaderPolicyStrict ||
+		b.uniqueHeaders ||
+		(b.headerCharset != "" && b.headerCharset != config.HeaderCharsetOff)
+}
+
+// checkRequiredHeaders enforces [smtpd] require_headers: RFC 5322 §3.6
+// requires exactly one From and one Date field, and the strict policy also
+// demands exactly one well-formed Message-ID. Malformed bulk mail often
+// lacks them. A no-op when the policy is off.
+func (s *Session) checkRequiredHeaders(r io.Reader) error {
+	required := []string{"From", "Date"}
+	switch s.backend.headerPolicy {
+	case config.HeaderPolicyBasic:
+	case config.HeaderPolicyStrict:
+		required = append(required, "Message-ID")
+	default:
+		return nil
+	}
+
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		s.logger.Info("failed to parse message headers",
+			slog.String("error", err.Error()))
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+			Message:      "Message headers could not be parsed",
+		}
+	}
+
+	for _, name := range required {
+		values := msg.Header[textproto.CanonicalMIMEHeaderKey(name)]
+		switch {
+		case len(values) == 0:
+			s.logger.Info("message missing required header", slog.String("header", name))
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+				Message:      "Missing required header: " + name,
+			}
+		case len(values) > 1:
+			s.logger.Info("message has duplicate header", slog.String("header", name))
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+				Message:      "Duplicate header: " + name,
+			}
+		case name == "Message-ID" && !isValidMessageID(values[0]):
+			s.logger.Info("message has invalid Message-ID", slog.String("message_id", values[0]))
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+				Message:      "Invalid header: Message-ID",
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueHeaderFields are the fields reject_duplicate_headers allows once.
+// A second copy is the usual header smuggling trick: a filter checks one
+// value and the reader displays the other.
+var uniqueHeaderFields = []string{"From", "Content-Type", "Subject", "Date"}
+
+// checkDuplicateHeaders enforces [smtpd] reject_duplicate_headers over the
+// header section of r. Field names are compared without case and without
+// the whitespace RFC 5322 §4.5.3 allows before the colon, since "From :"
+// is the same field to a lenient reader.
+func (s *Session) checkDuplicateHeaders(r io.Reader) error {
+	if !s.backend.uniqueHeaders {
+		return nil
+	}
+	seen := make(map[string]bool, len(uniqueHeaderFields))
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == "" {
+			return nil
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			if name, _, ok := strings.Cut(line, ":"); ok {
+				name = strings.TrimRight(name, " \t")
+				for _, f := range uniqueHeaderFields {
+					if !strings.EqualFold(name, f) {
+						continue
+					}
+					if seen[f] {
+						s.logger.Info("message has duplicate header", slog.String("header", f))
+						return &smtp.SMTPError{
+							Code:         550,
+							EnhancedCode: smtp.EnhancedCode{5, 6, 0},
+							Message:      "Ambiguous headers: more than one " + f,
+						}
+					}
+					seen[f] = true
+				}
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// isValidMessageID reports whether v has the RFC 5322 msg-id shape
+// "<left@right>" with no whitespace.
+func isValidMessageID(v string) bool {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "<") || !strings.HasSuffix(v, ">") {
+		return false
+	}
+	inner := v[1 : len(v)-1]
+	if strings.ContainsAny(inner, "<> \t") {
+		return false
+	}
+	left, right, ok := strings.Cut(inner, "@")
+	return ok && left != "" && right != "" && !strings.Contains(right, "@")
+}
+
 // checkFromAlignment parses the RFC 5322 From header and verifies it exactly
-// matches the envelope sender (and therefore the authenticated user). This
+// matches the envelope sender (and therefore the authenticated user or one of
+// their send_as addresses). This
 // enforces both DMARC alignment and prevents header forgery — the DKIM
 // signature domain will match the From header domain at the receiving MTA.
 func (s *Session) checkFromAlignment(r io.Reader) error {
@@ -401,7 +858,7 @@ func (s *Session) checkFromAlignment(r io.Reader) error {
 		return &smtp.SMTPError{
 			Code:         550,
 			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
-			Message:      "From header must match the authenticated sender address",
+			Message:      "From address not permitted",
 		}
 	}
 
@@ -413,8 +870,20 @@ func (s *Session) checkFromAlignment(r io.Reader) error {
 //
 // Uses TeeReader to stream message data to a temp file during spam checking,
 // avoiding triple buffering of large messages in memory.
-func (s *Session) Data(r io.Reader) error {
-	ctx := context.Background()
+func (s *Session) Data(r io.Reader) (err error) {
+	s.dataSeen = true
+
+	// Permanent rejections count against the client IP (see reputation.go).
+	defer func() {
+		s.noteOutcome(err)
+		s.noteRejection("DATA", s.from, strings.Join(slices.Concat(s.recipients, s.remoteRecipients), ","), err)
+	}()
+
+	// Defense in depth: Mail already enforces tls_policy "required", but never read
+	// message content over cleartext on such a listener.
+	if err := s.checkTLSRequired(); err != nil {
+		return err
+	}
 
 	if s.backend.collector != nil {
 		s.backend.collector.CommandProcessed("DATA")
@@ -458,6 +927,22 @@ func (s *Session) Data(r io.Reader) error {
 		}
 	}
 
+	release, err := s.acquireDataTransfer()
+	if err != nil {
+		if s.backend.collector != nil {
+			s.backend.collector.MessageRejected(sessionExtractRecipientDomain(s.recipients), "too_many_transfers")
+		}
+		return err
+	}
+	if err := s.checkState(); err != nil {
+		release()
+		return err
+	}
+	defer release()
+
+	// queueID identifies this message in logs and in add_headers.
+	queueID := newQueueID()
+
 	// Buffer the message data. Prefer a temp file on the mail store filesystem
 	// (Maildir spec: tmp/ on same device enables atomic rename). Falls back to
 	// an in-memory buffer if file creation fails (e.g. read-only filesystem,
@@ -465,24 +950,56 @@ func (s *Session) Data(r io.Reader) error {
 	tmp := newTempBuffer(s.backend.tempDir)
 	defer tmp.cleanup()
 
-	// TeeReader writes to tmp as data is read
-	tee := io.TeeReader(r, tmp)
+	// countingReader tracks the message size and stops at max_message_size
+	// (go-smtp enforces the same limit; this holds when it is not set), so
+	// an oversized message is never spooled past the limit.
+	counter := &countingReader{r: r, limit: s.backend.maxMessageSize}
+	defer func() { s.postMessageEvent(queueID, counter.n, err) }()
+	// go-smtp has sent 354 (or read the first BDAT chunk) by now.
+	dataStart := time.Now()
+
+	// TeeReader writes to tmp as data is read. The whole message is
+	// buffered before smtpd's own work on it starts, so the time the client
+	// takes to send it is not charged to the message's budget.
+	tee := io.TeeReader(counter, tmp)
+	if err := s.drainMessage(tee, counter); err != nil {
+		return err
+	}
 
-	// Wrap in countingReader to track message size
-	counter := &countingReader{r: tee}
+	ctx, done := s.stage()
+	defer done()
 
-	// Spam check (if enabled) - reads through counter, which fills tmpFile
+	// Spam check (if enabled) - reads the buffered copy
 	var checkResult *spamcheck.CheckResult
-	if s.backend.spamChecker != nil && s.backend.spamConfig.IsEnabled() {
+	checkInput, bypassSpam := s.spamCheckInput(tmp)
+	if s.backend.spamChecker != nil && s.backend.spamConfig.IsEnabled() && !bypassSpam {
+		// Bound the entire spam-check phase (all checkers) so a slow backend
+		// cannot hold the DATA command open indefinitely.
+		checkCtx := ctx
+		if d := s.backend.spamConfig.GetTotalTimeout(); d > 0 {
+			var cancel context.CancelFunc
+			checkCtx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+
 		var checkErr error
-		checkResult, checkErr = s.backend.spamChecker.Check(ctx, counter, spamcheck.CheckOptions{
+		checkResult, checkErr = s.backend.spamChecker.Check(checkCtx, checkInput, spamcheck.CheckOptions{
 			From:       s.from,
 			Recipients: s.recipients,
 			IP:         s.clientIP,
 			Helo:       s.helo,
 			Hostname:   s.backend.hostname,
 			User:       s.authUser,
+			QueueID:    queueID,
 		})
+		if checkErr == nil && checkCtx.Err() != nil {
+			// The checker ignored the context and answered after the deadline.
+			checkResult = nil
+			checkErr = fmt.Errorf("spam check exceeded total timeout: %w", checkCtx.Err())
+		}
+		if err := s.checkDeadline(ctx, "spamcheck"); err != nil {
+			return err
+		}
 
 		senderDomain := sessionExtractSenderDomain(s.from)
 
@@ -501,24 +1018,29 @@ func (s *Session) Data(r io.Reader) error {
 					domain := sessionExtractRecipientDomain(s.recipients)
 					s.backend.collector.MessageRejected(domain, "spamcheck_error")
 				}
-				return &smtp.SMTPError{
+				err := &smtp.SMTPError{
 					Code:         550,
 					EnhancedCode: smtp.EnhancedCode{5, 7, 1},
 					Message:      "Spam check failed",
 				}
+				s.filterDecision("spamcheck", err)
+				return err
 			case config.SpamCheckFailTempFail:
 				if s.backend.collector != nil {
 					domain := sessionExtractRecipientDomain(s.recipients)
 					s.backend.collector.MessageRejected(domain, "spamcheck_error")
 				}
-				return &smtp.SMTPError{
+				err := &smtp.SMTPError{
 					Code:         451,
 					EnhancedCode: smtp.EnhancedCode{4, 7, 1},
 					Message:      "Temporary spam check failure, try again later",
 				}
+				s.filterDecision("spamcheck", err)
+				return err
 			default:
-				// SpamCheckFailOpen - continue with delivery
+				// SpamCheckFailOpen - continue with delivery.
 				s.logger.Debug("spam check failed, continuing (fail open mode)")
+				s.filterDecision("spamcheck", nil)
 			}
 		} else {
 			// Determine result for metrics
@@ -527,6 +1049,9 @@ func (s *Session) Data(r io.Reader) error {
 				metricResult = "spam"
 			} else if checkResult.ShouldTempFail(s.backend.spamConfig.TempFailThreshold) {
 				metricResult = "soft_reject"
+				if checkResult.Greylisted {
+					metricResult = "greylist"
+				}
 			}
 
 			if s.backend.collector != nil {
@@ -549,41 +1074,40 @@ func (s *Session) Data(r io.Reader) error {
 					slog.Float64("score", checkResult.Score),
 					slog.String("action", string(checkResult.Action)),
 					slog.String("reason", checkResult.RejectMessage))
-				return &smtp.SMTPError{
-					Code:         550,
-					EnhancedCode: smtp.EnhancedCode{5, 7, 1},
-					Message:      "Message rejected",
-				}
+				err := s.spamRejection()
+				s.filterDecision("spamcheck", err)
+				return err
 			}
 
 			// Check if message should be temp-failed
 			if s.backend.spamConfig.TempFailThreshold > 0 && checkResult.ShouldTempFail(s.backend.spamConfig.TempFailThreshold) {
 				if s.backend.collector != nil {
 					domain := sessionExtractRecipientDomain(s.recipients)
-					s.backend.collector.MessageRejected(domain, "soft_reject")
+					s.backend.collector.MessageRejected(domain, metricResult)
 				}
 				s.logger.Debug("message deferred by spam check",
 					slog.Float64("score", checkResult.Score),
 					slog.String("action", string(checkResult.Action)),
+					slog.Bool("greylisted", checkResult.Greylisted),
 					slog.String("reason", checkResult.RejectMessage))
-				return &smtp.SMTPError{
-					Code:         451,
-					EnhancedCode: smtp.EnhancedCode{4, 7, 1},
-					Message:      "Message deferred, please try again later",
-				}
+				err := s.spamDeferral(checkResult)
+				s.filterDecision("spamcheck", err)
+				return err
 			}
 
+			s.filterDecision("spamcheck", nil)
+			s.noteSpamScore(checkResult)
 			// checkResult is used below for the delivery envelope.
 		}
-	} else {
-		// No spam check - read the entire message into tmp
-		if _, err := io.Copy(tmp, counter); err != nil {
-			s.logger.Debug("failed to read message data", slog.String("error", err.Error()))
-			return &smtp.SMTPError{
-				Code:         451,
-				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
-				Message:      "Error reading message",
-			}
+	}
+
+	if err := s.checkDeclaredSize(counter.n, queueID); err != nil {
+		return err
+	}
+
+	if !counter.eof.IsZero() {
+		if err := s.checkDataPace(counter.n, counter.eof.Sub(dataStart)); err != nil {
+			return err
 		}
 	}
 
@@ -593,7 +1117,9 @@ func (s *Session) Data(r io.Reader) error {
 		recipientDomain := sessionExtractRecipientDomain([]string{s.deferredInvalidRecipient})
 		spamAlreadyRejected := checkResult != nil && checkResult.ShouldReject(s.backend.spamConfig.RejectThreshold)
 
-		if s.backend.spamtrapLearner != nil && !spamAlreadyRejected {
+		// During warmup the recipient may be a real user session-manager
+		// does not see yet, so its mail must not be learned as spam.
+		if s.backend.spamtrapLearner != nil && !spamAlreadyRejected && !s.backend.warmup {
 			if s.backend.spamtrapRateLimiter.allow(s.clientIP) {
 				if err := s.backend.spamtrapLearner.learnSpam(ctx, s.deferredInvalidRecipient, tmp.reader()); err != nil {
 					s.logger.Warn("spamtrap auto-learn failed",
@@ -616,10 +1142,31 @@ func (s *Session) Data(r io.Reader) error {
 
 		s.logger.Debug("deferred rejection: user unknown",
 			slog.String("recipient", s.deferredInvalidRecipient))
-		return &smtp.SMTPError{
-			Code:         550,
-			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
-			Message:      "User unknown",
+		return s.unknownUser(s.deferredInvalidRecipient)
+	}
+
+	if err := s.checkContent(tmp); err != nil {
+		return err
+	}
+	s.checkHeaderRecipients(tmp.reader())
+	if err := s.checkDate(tmp.reader()); err != nil {
+		return err
+	}
+	s.receivedTrim = s.planReceivedTrim(tmp.reader())
+
+	if err := s.checkSignals(); err != nil {
+		return err
+	}
+
+	// From check for authenticated submission: the RFC 5322 From header
+	// must be the envelope sender, which Mail has already limited to the
+	// user's own and send-as addresses. For relayed mail this is also DMARC
+	// alignment: DKIM signatures use the envelope sender domain. For local
+	// recipients it stops one user posing as another, and is opt-in
+	// (check_local_from).
+	if s.authUser != "" && s.from != "" && (len(s.remoteRecipients) > 0 || s.backend.checkLocalFrom) {
+		if err := s.checkFromAlignment(tmp.reader()); err != nil {
+			return err
 		}
 	}
 
@@ -629,9 +1176,12 @@ func (s *Session) Data(r io.Reader) error {
 
 		// Session-manager is the only delivery path.
 		deliverErr := s.backend.smDelivery.Deliver(ctx,
-			s.from, s.recipients[0], s.clientIP, s.helo, now, tmp.reader())
+			s.from, s.recipients[0], s.clientIP, s.helo, now, s.finalDeliveryMessage(tmp, queueID))
 
 		if deliverErr != nil {
+			if err := s.checkDeadline(ctx, "delivery"); err != nil {
+				return err
+			}
 			s.logger.Warn("local delivery failed",
 				slog.String("from", s.from),
 				slog.String("to", s.recipients[0]),
@@ -642,11 +1192,7 @@ func (s *Session) Data(r io.Reader) error {
 				s.backend.collector.MessageRejected(recipientDomain, "delivery_error")
 			}
 
-			return &smtp.SMTPError{
-				Code:         451,
-				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
-				Message:      "Delivery failed",
-			}
+			return deliveryReply(deliverErr)
 		}
 
 		// Notify Redis pub/sub so IMAP IDLE clients see new mail.
@@ -662,22 +1208,14 @@ func (s *Session) Data(r io.Reader) error {
 			recipientDomain := sessionExtractRecipientDomain(s.recipients)
 			s.backend.collector.MessageReceived(recipientDomain, counter.n)
 		}
+		s.recordSender(counter.n)
 
 		s.logger.Info("local delivery complete",
+			slog.String("queue_id", queueID),
 			slog.String("from", s.from),
 			slog.String("to", s.recipients[0]),
 			slog.Int64("size", counter.n))
-	}
-
-	// DMARC alignment check for outbound submission: verify the RFC 5322
-	// From header domain matches the envelope sender domain. This ensures
-	// DKIM signatures (applied using the envelope sender domain) will pass
-	// DMARC alignment at the receiving MTA. Only checked for authenticated
-	// outbound messages.
-	if len(s.remoteRecipients) > 0 && s.authUser != "" && s.from != "" {
-		if err := s.checkFromAlignment(tmp.reader()); err != nil {
-			return err
-		}
+		s.noteFirstContact(ctx, queueID)
 	}
 
 	// Remote delivery: enqueue via session-manager's OutboundService.
@@ -691,9 +1229,11 @@ func (s *Session) Data(r io.Reader) error {
 			}
 		}
 
-		ctx := context.Background()
-		msgID, err := s.backend.smDelivery.Enqueue(ctx, s.from, s.remoteRecipients, tmp.reader())
+		msgID, err := s.backend.smDelivery.Enqueue(ctx, s.from, s.remoteRecipients, s.stampedMessage(tmp, queueID))
 		if err != nil {
+			if err := s.checkDeadline(ctx, "enqueue"); err != nil {
+				return err
+			}
 			s.logger.Warn("enqueue failed",
 				slog.String("from", s.from),
 				slog.Any("to", s.remoteRecipients),
@@ -715,9 +1255,11 @@ func (s *Session) Data(r io.Reader) error {
 			recipientDomain := sessionExtractRecipientDomain(s.remoteRecipients)
 			s.backend.collector.MessageReceived(recipientDomain, counter.n)
 		}
+		s.recordSender(counter.n)
 
 		s.logger.Info("enqueued for remote delivery",
 			slog.String("msg_id", msgID),
+			slog.String("queue_id", queueID),
 			slog.String("from", s.from),
 			slog.Any("to", s.remoteRecipients),
 			slog.Int64("size", counter.n))
@@ -726,20 +1268,139 @@ func (s *Session) Data(r io.Reader) error {
 	return nil
 }
 
-// Reset is called when the client sends RSET.
+// drainMessage reads the rest of the message through tee, which fills the
+// temp buffer, and maps a read failure to the DATA reply.
+func (s *Session) drainMessage(tee io.Reader, counter *countingReader) error {
+	_, err := io.Copy(io.Discard, tee)
+	if err == nil {
+		return nil
+	}
+	if isTimeout(err) {
+		return s.abortDataTimeout()
+	}
+	if errors.Is(err, smtp.ErrDataTooLarge) {
+		return s.rejectTooLarge(counter.n)
+	}
+	s.logger.Debug("failed to read message data", slog.String("error", err.Error()))
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+		Message:      "Error reading message",
+	}
+}
+
+// rejectTooLarge answers a message that went past max_message_size with
+// 552 5.3.4. go-smtp discards the rest of the data once Data returns, and
+// Data's deferred cleanup removes what was spooled.
+func (s *Session) rejectTooLarge(read int64) error {
+	if s.backend.collector != nil {
+		rcpts := s.recipients
+		if len(rcpts) == 0 {
+			rcpts = s.remoteRecipients
+		}
+		s.backend.collector.MessageRejected(sessionExtractRecipientDomain(rcpts), "too_large")
+	}
+	s.logger.Info("message rejected: too large",
+		slog.String("from", s.from),
+		slog.Int64("read", read),
+		slog.Int64("limit", s.backend.maxMessageSize))
+	return smtp.ErrDataTooLarge
+}
+
+// spamRejection is the reply to a spam reject verdict: 550, or 451 when the
+// sender has authenticated and [spamcheck] authenticated_action is
+// "tempfail", so that a false positive on a user's own mail is retried by
+// their client rather than bounced.
+func (s *Session) spamRejection() error {
+	if s.authUser != "" && s.backend.spamConfig.GetAuthenticatedAction() == config.SpamAuthenticatedTempFail {
+		s.logger.Info("deferring spam verdict for authenticated sender",
+			slog.String("auth_user", s.authUser),
+			slog.String("from", s.from))
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      "Message deferred, please try again later",
+		}
+	}
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      "Message rejected",
+	}
+}
+
+// spamDeferral is the reply to a tempfail verdict. Greylisting quotes
+// [spamcheck] greylist_retry so that cooperating senders retry as soon as
+// the delay has passed, and is told apart from other deferrals in their
+// logs; a soft reject on the content gets the generic deferral.
+func (s *Session) spamDeferral(result *spamcheck.CheckResult) error {
+	if result.Greylisted {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      fmt.Sprintf("Greylisted, please retry in %d seconds", int(s.backend.spamConfig.GetGreylistRetry().Seconds())),
+		}
+	}
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Message deferred, please try again later",
+	}
+}
+
+// checkTLSRequired returns 530 when the listener requires TLS and the
+// connection has not completed STARTTLS (RFC 3207 §4).
+func (s *Session) checkTLSRequired() error {
+	if !s.backend.requireTLS || sessionConnIsTLS(s.conn) {
+		return nil
+	}
+	s.logger.Info("refusing transaction without TLS")
+	return &smtp.SMTPError{
+		Code:         530,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+		Message:      "Must issue a STARTTLS command first",
+	}
+}
+
+// errBinaryMIMERelay refuses a remote recipient for a BODY=BINARYMIME
+// message. Local delivery stores the bytes as received.
+var errBinaryMIMERelay = &smtp.SMTPError{
+	Code:         554,
+	EnhancedCode: smtp.EnhancedCode{5, 6, 3},
+	Message:      "Binary messages cannot be relayed to remote recipients",
+}
+
+// Reset is called when the client sends RSET, and by go-smtp after DATA,
+// BDAT LAST, HELO/EHLO and AUTH. It clears everything scoped to the
+// transaction; HELO, TLS state and authentication belong to the connection
+// and persist. Nothing from the spam precheck or reputation lookups is kept
+// on the session, so there is no verdict to clear. A transaction dropped
+// after MAIL but before DATA counts as aborted with reason "reset".
 // Implements smtp.Session interface.
 func (s *Session) Reset() {
+	if s.mailFromSeen && !s.dataSeen && s.backend.collector != nil {
+		s.backend.collector.TransactionAborted("reset")
+	}
 	s.from = ""
 	s.mailFromSeen = false
+	s.dataSeen = false
+	s.binaryMIME = false
+	s.declaredSize = 0
 	s.recipients = nil
 	s.remoteRecipients = nil
 	s.deferredInvalidRecipient = ""
+	s.originalRecipient = ""
+	s.budgetUsed = 0
+	s.signals = nil
+	s.receivedTrim = receivedRange{}
+	s.noteGreetings()
 	s.logger.Debug("session reset")
 }
 
 // Logout is called when the client quits or the connection closes.
 // Implements smtp.Session interface.
 func (s *Session) Logout() error {
+	s.releaseUserSession()
 	if s.backend.collector != nil {
 		s.backend.collector.ConnectionClosed()
 	}
@@ -795,6 +1456,9 @@ func sessionIsLocalhost(ip string) bool {
 // connections in notifyConn for session-end detection, which hides the
 // *tls.Conn from go-smtp's direct type assertion.
 func sessionConnIsTLS(c *smtp.Conn) bool {
+	if c == nil {
+		return false
+	}
 	if _, ok := c.TLSConnectionState(); ok {
 		return true
 	}
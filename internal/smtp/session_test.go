@@ -1,15 +1,22 @@
 package smtp
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"io"
 	"log/slog"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	gosmtp "github.com/emersion/go-smtp"
 	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
 	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -345,6 +352,565 @@ func TestSession_Mail_SenderVerification(t *testing.T) {
 	})
 }
 
+func TestSession_Mail_DuplicateSender(t *testing.T) {
+	logger := slog.Default()
+
+	t.Run("second MAIL in transaction rejected", func(t *testing.T) {
+		session := &Session{backend: &Backend{}, logger: logger}
+		if err := session.Mail("alice@example.com", nil); err != nil {
+			t.Fatalf("first MAIL: %v", err)
+		}
+		err := session.Mail("bob@example.com", nil)
+		smtpErr, ok := err.(*gosmtp.SMTPError)
+		if !ok {
+			t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+		}
+		if smtpErr.Code != 503 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{5, 5, 1}) {
+			t.Errorf("expected 503 5.5.1, got %d %v", smtpErr.Code, smtpErr.EnhancedCode)
+		}
+		if session.from != "alice@example.com" {
+			t.Errorf("sender overwritten: got %q", session.from)
+		}
+	})
+
+	t.Run("null sender counts as specified", func(t *testing.T) {
+		session := &Session{backend: &Backend{}, logger: logger}
+		if err := session.Mail("", nil); err != nil {
+			t.Fatalf("first MAIL: %v", err)
+		}
+		if err := session.Mail("bob@example.com", nil); err == nil {
+			t.Fatal("expected error for second MAIL after MAIL FROM:<>")
+		}
+	})
+
+	t.Run("MAIL after RSET accepted", func(t *testing.T) {
+		session := &Session{backend: &Backend{}, logger: logger}
+		if err := session.Mail("alice@example.com", nil); err != nil {
+			t.Fatalf("first MAIL: %v", err)
+		}
+		session.Reset()
+		if err := session.Mail("bob@example.com", nil); err != nil {
+			t.Fatalf("MAIL after RSET: %v", err)
+		}
+	})
+}
+
+func TestSession_Reset_ClearsTransactionKeepsAuth(t *testing.T) {
+	collector := &abortCollector{}
+	session := &Session{
+		backend:                  &Backend{collector: collector},
+		helo:                     "client.example",
+		from:                     "alice@example.com",
+		mailFromSeen:             true,
+		recipients:               []string{"bob@example.com"},
+		remoteRecipients:         []string{"carol@example.net"},
+		authUser:                 "alice@example.com",
+		loginResult:              &LoginResult{Mailbox: "alice@example.com"},
+		sessionSlot:              "alice@example.com",
+		deferredInvalidRecipient: "nobody@example.com",
+		originalRecipient:        "postmaster@example.com",
+		logger:                   slog.Default(),
+	}
+
+	session.Reset()
+
+	if session.from != "" || session.mailFromSeen || session.dataSeen {
+		t.Errorf("sender state survived RSET: from=%q mailFromSeen=%v dataSeen=%v",
+			session.from, session.mailFromSeen, session.dataSeen)
+	}
+	if session.recipients != nil || session.remoteRecipients != nil {
+		t.Errorf("recipients survived RSET: %v %v", session.recipients, session.remoteRecipients)
+	}
+	if session.deferredInvalidRecipient != "" || session.originalRecipient != "" {
+		t.Errorf("recipient flags survived RSET: %q %q",
+			session.deferredInvalidRecipient, session.originalRecipient)
+	}
+	if session.helo != "client.example" {
+		t.Errorf("helo cleared by RSET: %q", session.helo)
+	}
+	if session.authUser != "alice@example.com" || session.loginResult == nil || session.sessionSlot == "" {
+		t.Errorf("auth cleared by RSET: user=%q login=%v slot=%q",
+			session.authUser, session.loginResult, session.sessionSlot)
+	}
+	if got := collector.got(); len(got) != 1 || got[0] != "reset" {
+		t.Errorf("aborted transactions = %v, want [reset]", got)
+	}
+
+	// A reset with no open transaction, or after DATA, is not an abort.
+	session.Reset()
+	session.mailFromSeen, session.dataSeen = true, true
+	session.Reset()
+	if got := collector.got(); len(got) != 1 {
+		t.Errorf("aborted transactions = %v, want one", got)
+	}
+}
+
+// userDirectory is a SessionService whose users can be added while the
+// server runs, like a domain's passwd file edited in place.
+type userDirectory struct {
+	smpb.UnimplementedSessionServiceServer
+	mu    sync.Mutex
+	users map[string]bool
+}
+
+func (d *userDirectory) add(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[addr] = true
+}
+
+func (d *userDirectory) ValidateRecipient(_ context.Context, req *smpb.ValidateRecipientRequest) (*smpb.ValidateRecipientResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: d.users[req.Address]}, nil
+}
+
+// TestSession_Rcpt_UserAddedLater pins that smtpd keeps no recipient or
+// domain cache of its own: every RCPT asks session-manager, so a user added
+// after the first connection is accepted without restarting smtpd.
+func TestSession_Rcpt_UserAddedLater(t *testing.T) {
+	dir := &userDirectory{users: map[string]bool{"alice@example.com": true}}
+	socketPath := t.TempDir() + "/session.sock"
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	gsrv := grpc.NewServer()
+	smpb.RegisterSessionServiceServer(gsrv, dir)
+	go func() { _ = gsrv.Serve(ln) }()
+	t.Cleanup(func() { gsrv.Stop() })
+	agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: socketPath}, nil)
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	t.Cleanup(func() { _ = agent.Close() })
+
+	backend := NewBackend(BackendConfig{SMDelivery: agent})
+	rcpt := func(to string) error {
+		s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+		return s.Rcpt(to, nil)
+	}
+
+	if err := rcpt("alice@example.com"); err != nil {
+		t.Fatalf("first connection: RCPT alice = %v", err)
+	}
+	if err := rcpt("newuser@example.com"); err == nil {
+		t.Fatal("RCPT to a user that does not exist yet was accepted")
+	}
+
+	dir.add("newuser@example.com")
+	if err := rcpt("newuser@example.com"); err != nil {
+		t.Errorf("RCPT to user added after the first connection = %v, want accepted", err)
+	}
+}
+
+func TestSession_Rcpt_NoBounceRecipients(t *testing.T) {
+	backend := NewBackend(BackendConfig{
+		NoBounce: []string{"NoReply@example.com", "@lists.example.org"},
+	})
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		wantCode int // 0 = accepted
+	}{
+		{"bounce to no-bounce address", "", "noreply@example.com", 550},
+		{"bounce to no-bounce domain", "", "news@Lists.Example.org", 550},
+		{"bounce to normal address", "", "alice@example.com", 0},
+		{"normal mail to no-bounce address", "sender@example.net", "noreply@example.com", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{backend: backend, from: tt.from, logger: slog.Default()}
+			err := session.Rcpt(tt.to, nil)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("expected acceptance, got %v", err)
+				}
+				return
+			}
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok {
+				t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+			}
+			if smtpErr.Code != tt.wantCode || smtpErr.Message != "This address does not accept bounces" {
+				t.Errorf("got %d %q", smtpErr.Code, smtpErr.Message)
+			}
+		})
+	}
+}
+
+func TestSession_Rcpt_RoleMailbox(t *testing.T) {
+	hosted := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: false},
+	})
+	unhosted := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: false},
+	})
+
+	tests := []struct {
+		name     string
+		agent    *SessionManagerDeliveryAgent
+		from     string
+		to       string
+		wantCode int // 0 = accepted for role_mailbox
+	}{
+		{"postmaster on hosted domain", hosted, "sender@example.net", "postmaster@hosted.example", 0},
+		{"abuse, any case", hosted, "sender@example.net", "Abuse@hosted.example", 0},
+		{"bounce to postmaster on a no-bounce domain", hosted, "", "postmaster@hosted.example", 0},
+		{"other unknown user", hosted, "sender@example.net", "bob@hosted.example", 550},
+		{"postmaster on unhosted domain", unhosted, "sender@example.net", "postmaster@elsewhere.example", 550},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := NewBackend(BackendConfig{
+				SMDelivery:     tt.agent,
+				NoBounce:       []string{"@hosted.example"},
+				RoleMailbox:    "hostmaster@example.com",
+				RoleRecipients: config.DefaultRoleRecipients,
+			})
+			session := &Session{backend: backend, from: tt.from, mailFromSeen: true, logger: slog.Default()}
+
+			err := session.Rcpt(tt.to, nil)
+			if tt.wantCode != 0 {
+				smtpErr, ok := err.(*gosmtp.SMTPError)
+				if !ok || smtpErr.Code != tt.wantCode {
+					t.Fatalf("got %v, want %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected acceptance, got %v", err)
+			}
+			if len(session.recipients) != 1 || session.recipients[0] != "hostmaster@example.com" {
+				t.Errorf("recipients = %v, want [hostmaster@example.com]", session.recipients)
+			}
+
+			tmp := &memTempBuf{}
+			_, _ = tmp.Write([]byte("Subject: report\r\n\r\nbody\r\n"))
+			got, _ := io.ReadAll(session.finalDeliveryMessage(tmp, "ID"))
+			if want := "X-Original-To: " + tt.to + "\r\n"; !strings.HasPrefix(string(got), want) {
+				t.Errorf("message starts %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSession_Rcpt_SharedMailboxUnknown(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: false},
+	})
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		Domains: config.DomainsConfig{
+			"hosted.example": {SharedMailboxes: []config.SharedMailbox{{Pattern: "support", Mailbox: "team@hosted.example"}}},
+		},
+	})
+	session := &Session{backend: backend, from: "sender@example.net", mailFromSeen: true, logger: slog.Default()}
+
+	// A shared mailbox that is not a user is a configuration mistake:
+	// defer rather than bounce.
+	if err := session.Rcpt("support@hosted.example", nil); err != errSharedMailboxUnavailable {
+		t.Errorf("Rcpt(support) = %v, want %v", err, errSharedMailboxUnavailable)
+	}
+	err := session.Rcpt("bob@hosted.example", nil)
+	if smtpErr, ok := err.(*gosmtp.SMTPError); !ok || smtpErr.Code != 550 {
+		t.Errorf("Rcpt(bob) = %v, want 550", err)
+	}
+}
+
+func TestSession_Rcpt_UnknownUserWarmup(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: false},
+	})
+
+	for _, tt := range []struct {
+		warmup bool
+		want   int
+	}{
+		{true, 451},
+		{false, 550},
+	} {
+		backend := NewBackend(BackendConfig{SMDelivery: agent, Warmup: tt.warmup})
+		session := &Session{backend: backend, from: "sender@example.net", mailFromSeen: true, logger: slog.Default()}
+		err := session.Rcpt("nobody@example.com", nil)
+		if smtpErr, ok := err.(*gosmtp.SMTPError); !ok || smtpErr.Code != tt.want {
+			t.Errorf("warmup %v: Rcpt = %v, want %d", tt.warmup, err, tt.want)
+		}
+	}
+}
+
+func TestSession_Auth_AlreadyAuthenticated(t *testing.T) {
+	session := &Session{backend: &Backend{}, authUser: "alice@example.com", logger: slog.Default()}
+
+	_, err := session.Auth("PLAIN")
+	smtpErr, ok := err.(*gosmtp.SMTPError)
+	if !ok {
+		t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+	}
+	if smtpErr.Code != 503 {
+		t.Errorf("expected code 503, got %d", smtpErr.Code)
+	}
+}
+
+func TestSession_RequireTLS_Cleartext(t *testing.T) {
+	logger := slog.Default()
+
+	t.Run("MAIL refused", func(t *testing.T) {
+		session := &Session{backend: &Backend{requireTLS: true}, logger: logger}
+		err := session.Mail("alice@example.com", nil)
+		smtpErr, ok := err.(*gosmtp.SMTPError)
+		if !ok {
+			t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+		}
+		if smtpErr.Code != 530 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{5, 7, 0}) {
+			t.Errorf("expected 530 5.7.0, got %d %v", smtpErr.Code, smtpErr.EnhancedCode)
+		}
+	})
+
+	t.Run("DATA refused without reading the body", func(t *testing.T) {
+		// Reaching DATA despite the MAIL check, e.g. via a future code path.
+		session := &Session{
+			backend:      &Backend{requireTLS: true},
+			from:         "alice@example.com",
+			mailFromSeen: true,
+			recipients:   []string{"bob@example.com"},
+			logger:       logger,
+		}
+		body := strings.NewReader("Subject: test\r\n\r\nhello\r\n")
+		err := session.Data(body)
+		smtpErr, ok := err.(*gosmtp.SMTPError)
+		if !ok {
+			t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+		}
+		if smtpErr.Code != 530 {
+			t.Errorf("expected code 530, got %d", smtpErr.Code)
+		}
+		if body.Len() != int(body.Size()) {
+			t.Errorf("body was read: %d of %d bytes left", body.Len(), body.Size())
+		}
+	})
+
+	t.Run("no-op when not required", func(t *testing.T) {
+		session := &Session{backend: &Backend{}, logger: logger}
+		if err := session.Mail("alice@example.com", nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestSession_RequireTLS_AfterSTARTTLS(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	conn, done := serveOneConn(t, config.ModeSmtp, serverTLS,
+		BackendConfig{Hostname: "test.local", RequireTLS: true})
+
+	r := bufio.NewReader(conn)
+	expect := func(want string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("expected %s, got %q", want, line)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	expect("220")
+	_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+	expect("250")
+	_, _ = io.WriteString(conn, "MAIL FROM:<alice@example.com>\r\n")
+	expect("530")
+	_, _ = io.WriteString(conn, "STARTTLS\r\n")
+	expect("220")
+
+	tlsConn := tls.Client(conn, clientTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	r = bufio.NewReader(tlsConn)
+	conn = tlsConn
+	_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+	expect("250")
+	_, _ = io.WriteString(conn, "MAIL FROM:<alice@example.com>\r\n")
+	expect("250")
+	_, _ = io.WriteString(conn, "QUIT\r\n")
+	expect("221")
+	waitDone(t, done)
+}
+
+// TestSession_STARTTLS_DiscardsInjectedPlaintext pins the defence against
+// STARTTLS command injection (CVE-2011-0411 and kin): a command sent in
+// the same packet as STARTTLS sits in the plaintext read buffer and must
+// not run inside the TLS session. go-smtp rebuilds its reader on the TLS
+// connection, dropping whatever the old one had buffered.
+func TestSession_STARTTLS_DiscardsInjectedPlaintext(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	conn, done := serveOneConn(t, config.ModeSmtp, serverTLS, BackendConfig{Hostname: "test.local"})
+
+	r := bufio.NewReader(conn)
+	expect := func(want string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !strings.HasPrefix(line, want) {
+				t.Fatalf("expected %s, got %q", want, line)
+			}
+			if line[3] == ' ' {
+				return
+			}
+		}
+	}
+
+	expect("220")
+	_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+	expect("250")
+	// One write, so the injected MAIL lands in the server's read buffer
+	// alongside STARTTLS.
+	_, _ = io.WriteString(conn, "STARTTLS\r\nMAIL FROM:<mallory@example.com>\r\n")
+	expect("220")
+
+	tlsConn := tls.Client(conn, clientTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	r = bufio.NewReader(tlsConn)
+	conn = tlsConn
+	_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+	expect("250")
+	// Had the injected MAIL run, this would be accepted.
+	_, _ = io.WriteString(conn, "RCPT TO:<bob@example.com>\r\n")
+	expect("502 5.5.1 Missing MAIL FROM")
+	_, _ = io.WriteString(conn, "MAIL FROM:<alice@example.com>\r\n")
+	expect("250")
+	_, _ = io.WriteString(conn, "QUIT\r\n")
+	expect("221")
+	waitDone(t, done)
+}
+
+func TestSession_CheckRequiredHeaders(t *testing.T) {
+	const (
+		from  = "From: alice@example.com\r\n"
+		date  = "Date: Mon, 12 Oct 2026 10:00:00 +0000\r\n"
+		msgID = "Message-ID: <abc123@example.com>\r\n"
+		body  = "\r\nhello\r\n"
+	)
+
+	tests := []struct {
+		name    string
+		policy  config.HeaderPolicy
+		message string
+		wantErr string // "" = accepted
+	}{
+		{"policy off accepts anything", config.HeaderPolicyOff, "Subject: x\r\n" + body, ""},
+		{"well-formed", config.HeaderPolicyBasic, from + date + body, ""},
+		{"missing From", config.HeaderPolicyBasic, date + body, "Missing required header: From"},
+		{"missing Date", config.HeaderPolicyBasic, from + body, "Missing required header: Date"},
+		{"duplicate From", config.HeaderPolicyBasic, from + from + date + body, "Duplicate header: From"},
+		{"basic ignores Message-ID", config.HeaderPolicyBasic, from + date + msgID + msgID + body, ""},
+		{"strict well-formed", config.HeaderPolicyStrict, from + date + msgID + body, ""},
+		{"strict missing Message-ID", config.HeaderPolicyStrict, from + date + body, "Missing required header: Message-ID"},
+		{"strict duplicate Message-ID", config.HeaderPolicyStrict, from + date + msgID + msgID + body, "Duplicate header: Message-ID"},
+		{"strict invalid Message-ID", config.HeaderPolicyStrict, from + date + "Message-ID: abc123\r\n" + body, "Invalid header: Message-ID"},
+		{"unparseable headers", config.HeaderPolicyBasic, "not a header\r\n" + body, "Message headers could not be parsed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{backend: &Backend{headerPolicy: tt.policy}, logger: slog.Default()}
+			err := session.checkRequiredHeaders(strings.NewReader(tt.message))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok {
+				t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+			}
+			if smtpErr.Code != 550 || smtpErr.Message != tt.wantErr {
+				t.Errorf("got %d %q, want 550 %q", smtpErr.Code, smtpErr.Message, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSession_CheckDuplicateHeaders(t *testing.T) {
+	const (
+		from  = "From: alice@example.com\r\n"
+		ctype = "Content-Type: text/plain\r\n"
+		body  = "\r\nFrom: not a header\r\n"
+	)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		message string
+		wantErr string // "" = accepted
+	}{
+		{"disabled accepts duplicates", false, from + from + body, ""},
+		{"single instances", true, from + ctype + "Subject: hi\r\nDate: Mon, 12 Oct 2026 10:00:00 +0000\r\n" + body, ""},
+		{"other fields may repeat", true, from + "Received: a\r\nReceived: b\r\n" + body, ""},
+		{"duplicate From", true, from + ctype + "FROM: mallory@example.com\r\n" + body, "Ambiguous headers: more than one From"},
+		{"duplicate From with space before colon", true, from + "From : mallory@example.com\r\n" + body, "Ambiguous headers: more than one From"},
+		{"duplicate Content-Type", true, ctype + from + "Content-Type: text/html\r\n" + body, "Ambiguous headers: more than one Content-Type"},
+		{"folded value is not a field", true, "Subject: a\r\n From: b\r\n" + from + body, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{backend: &Backend{uniqueHeaders: tt.enabled}, logger: slog.Default()}
+			err := session.checkDuplicateHeaders(strings.NewReader(tt.message))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok {
+				t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+			}
+			if smtpErr.Code != 550 || smtpErr.Message != tt.wantErr {
+				t.Errorf("got %d %q, want 550 %q", smtpErr.Code, smtpErr.Message, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsValidMessageID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"<abc123@example.com>", true},
+		{" <abc123@example.com> ", true},
+		{"abc123@example.com", false},
+		{"<abc123>", false},
+		{"<@example.com>", false},
+		{"<abc@>", false},
+		{"<a b@example.com>", false},
+		{"<a@b@example.com>", false},
+	}
+	for _, tt := range tests {
+		if got := isValidMessageID(tt.id); got != tt.want {
+			t.Errorf("isValidMessageID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
 func TestSession_Mail_SenderRateLimit(t *testing.T) {
 	logger := slog.Default()
 
@@ -368,8 +934,29 @@ func TestSession_Mail_SenderRateLimit(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected SMTPError, got %T", err)
 		}
-		if smtpErr.Code != 452 {
-			t.Errorf("expected code 452, got %d", smtpErr.Code)
+		if smtpErr.Code != 421 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{4, 3, 2}) {
+			t.Errorf("expected 421 4.3.2, got %d %v", smtpErr.Code, smtpErr.EnhancedCode)
+		}
+	})
+
+	t.Run("rate limit uses configured overload message", func(t *testing.T) {
+		limiter := newMemRateLimiter()
+		backend := &Backend{
+			senderRateLimiter: limiter,
+			maxSendsPerHour:   1,
+			overloadMessage:   "Busy, come back later",
+		}
+		session := &Session{backend: backend, authUser: "alice@example.com", logger: logger}
+
+		_ = session.Mail("alice@example.com", nil)
+		session.Reset()
+		err := session.Mail("alice@example.com", nil)
+		smtpErr, ok := err.(*gosmtp.SMTPError)
+		if !ok {
+			t.Fatalf("expected SMTPError, got %T", err)
+		}
+		if smtpErr.Message != "Busy, come back later" {
+			t.Errorf("message = %q, want configured overload message", smtpErr.Message)
 		}
 	})
 
@@ -449,8 +1036,8 @@ func TestSession_Mail_SenderRateLimit(t *testing.T) {
 		if !ok {
 			t.Fatalf("expected SMTPError, got %T", err)
 		}
-		if smtpErr.Code != 452 {
-			t.Errorf("expected code 452, got %d", smtpErr.Code)
+		if smtpErr.Code != 421 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{4, 3, 2}) {
+			t.Errorf("expected 421 4.3.2, got %d %v", smtpErr.Code, smtpErr.EnhancedCode)
 		}
 	})
 
@@ -572,3 +1159,261 @@ func TestExtractDomain(t *testing.T) {
 		}
 	}
 }
+
+// slowChecker is a spam checker that blocks until its context is done (or a
+// long fallback delay elapses), simulating a hung backend.
+type slowChecker struct {
+	delay time.Duration
+}
+
+func (c *slowChecker) Name() string { return "slow" }
+
+func (c *slowChecker) Check(ctx context.Context, message io.Reader, _ spamcheck.CheckOptions) (*spamcheck.CheckResult, error) {
+	if _, err := io.ReadAll(message); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(c.delay):
+		return &spamcheck.CheckResult{CheckerName: "slow", Action: spamcheck.ActionAccept}, nil
+	}
+}
+
+func (c *slowChecker) Close() error { return nil }
+
+// endlessReader serves an unending message body and counts what was read.
+type endlessReader struct{ n int64 }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestSession_Data_MessageTooLarge(t *testing.T) {
+	const limit = 4096
+	enabled := true
+
+	tests := []struct {
+		name  string
+		setup func(*Backend)
+	}{
+		{"no spam check", func(*Backend) {}},
+		{"spam check reading the message", func(b *Backend) {
+			b.spamChecker = &slowChecker{}
+			b.spamConfig = config.SpamCheckConfig{
+				Enabled:  true,
+				Checkers: []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+				FailMode: config.SpamCheckFailOpen,
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			backend := &Backend{maxMessageSize: limit, tempDir: tempDir, logger: slog.Default()}
+			tt.setup(backend)
+			session := &Session{
+				backend:                  backend,
+				mailFromSeen:             true,
+				from:                     "sender@example.com",
+				deferredInvalidRecipient: "nobody@example.com",
+				logger:                   slog.Default(),
+			}
+
+			body := &endlessReader{}
+			err := session.Data(body)
+
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != 552 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{5, 3, 4}) {
+				t.Fatalf("got %v, want 552 5.3.4", err)
+			}
+			if body.n > limit+1 {
+				t.Errorf("read %d bytes of an endless message, want at most %d", body.n, limit+1)
+			}
+			if entries, _ := os.ReadDir(tempDayDir(tempDir, time.Now())); len(entries) != 0 {
+				t.Errorf("temp files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestCountingReader_Limit(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{"under the limit", 99, nil},
+		{"exactly the limit", 100, nil},
+		{"one byte over", 101, gosmtp.ErrDataTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &countingReader{r: strings.NewReader(strings.Repeat("x", tt.size)), limit: 100}
+			got, err := io.ReadAll(c)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if want := min(tt.size, 100); len(got) != want || c.n != int64(want) {
+				t.Errorf("read %d bytes (n=%d), want %d", len(got), c.n, want)
+			}
+		})
+	}
+}
+
+func TestSession_Data_SpamCheckTotalTimeout(t *testing.T) {
+	logger := slog.Default()
+	enabled := true
+
+	tests := []struct {
+		name     string
+		failMode config.SpamCheckFailMode
+		wantCode int
+		wantEnh  gosmtp.EnhancedCode
+	}{
+		// Fail-open continues past the spam check; the deferred-invalid
+		// recipient then produces the user-unknown rejection.
+		{"fail open continues", config.SpamCheckFailOpen, 550, gosmtp.EnhancedCode{5, 1, 1}},
+		{"tempfail defers", config.SpamCheckFailTempFail, 451, gosmtp.EnhancedCode{4, 7, 1}},
+		{"reject rejects", config.SpamCheckFailReject, 550, gosmtp.EnhancedCode{5, 7, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &Backend{
+				spamChecker: &slowChecker{delay: 10 * time.Second},
+				spamConfig: config.SpamCheckConfig{
+					Enabled:      true,
+					Checkers:     []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+					FailMode:     tt.failMode,
+					TotalTimeout: "50ms",
+				},
+				tempDir: t.TempDir(),
+				logger:  logger,
+			}
+			session := &Session{
+				backend:                  backend,
+				mailFromSeen:             true,
+				from:                     "sender@example.com",
+				deferredInvalidRecipient: "nobody@example.com",
+				logger:                   logger,
+			}
+
+			start := time.Now()
+			err := session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("total timeout did not fire (took %v)", elapsed)
+			}
+
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok {
+				t.Fatalf("expected SMTPError, got %T (%v)", err, err)
+			}
+			if smtpErr.Code != tt.wantCode {
+				t.Errorf("expected code %d, got %d", tt.wantCode, smtpErr.Code)
+			}
+			if smtpErr.EnhancedCode != tt.wantEnh {
+				t.Errorf("expected enhanced code %v, got %v", tt.wantEnh, smtpErr.EnhancedCode)
+			}
+		})
+	}
+}
+
+func TestSession_Data_SpamAuthenticatedAction(t *testing.T) {
+	enabled := true
+
+	tests := []struct {
+		name     string
+		authUser string
+		action   config.SpamAuthenticatedAction
+		wantCode int
+	}{
+		{"inbound rejects", "", config.SpamAuthenticatedTempFail, 550},
+		{"authenticated default rejects", "alice@example.com", "", 550},
+		{"authenticated tempfail defers", "alice@example.com", config.SpamAuthenticatedTempFail, 451},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &Backend{
+				spamChecker: &verdictChecker{result: &spamcheck.CheckResult{Action: spamcheck.ActionReject}},
+				spamConfig: config.SpamCheckConfig{
+					Enabled:             true,
+					Checkers:            []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+					AuthenticatedAction: tt.action,
+				},
+				tempDir: t.TempDir(),
+				logger:  slog.Default(),
+			}
+			session := &Session{
+				backend:      backend,
+				mailFromSeen: true,
+				authUser:     tt.authUser,
+				from:         "alice@example.com",
+				// Spam rejection comes before the deferred user-unknown
+				// rejection (5.1.1), so that one only appears if the
+				// verdict was ignored.
+				deferredInvalidRecipient: "nobody@example.com",
+				logger:                   slog.Default(),
+			}
+
+			err := session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			wantEnh := gosmtp.EnhancedCode{tt.wantCode / 100, 7, 1}
+			if !ok || smtpErr.Code != tt.wantCode || smtpErr.EnhancedCode != wantEnh {
+				t.Fatalf("got %v, want %d %v", err, tt.wantCode, wantEnh)
+			}
+		})
+	}
+}
+
+func TestSession_Data_Greylisted(t *testing.T) {
+	enabled := true
+
+	tests := []struct {
+		name    string
+		result  *spamcheck.CheckResult
+		retry   string
+		wantMsg string
+	}{
+		{"greylist default retry", &spamcheck.CheckResult{Action: spamcheck.ActionTempFail, Greylisted: true}, "", "Greylisted, please retry in 300 seconds"},
+		{"greylist configured retry", &spamcheck.CheckResult{Action: spamcheck.ActionTempFail, Greylisted: true}, "90s", "Greylisted, please retry in 90 seconds"},
+		{"soft reject", &spamcheck.CheckResult{Action: spamcheck.ActionTempFail}, "90s", "Message deferred, please try again later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{
+				backend: &Backend{
+					spamChecker: &verdictChecker{result: tt.result},
+					spamConfig: config.SpamCheckConfig{
+						Enabled:           true,
+						Checkers:          []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+						TempFailThreshold: 100,
+						GreylistRetry:     tt.retry,
+					},
+					tempDir: t.TempDir(),
+					logger:  slog.Default(),
+				},
+				mailFromSeen:             true,
+				from:                     "alice@example.com",
+				deferredInvalidRecipient: "nobody@example.com",
+				logger:                   slog.Default(),
+			}
+
+			err := session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != 451 || smtpErr.EnhancedCode != (gosmtp.EnhancedCode{4, 7, 1}) {
+				t.Fatalf("got %v, want 451 4.7.1", err)
+			}
+			if smtpErr.Message != tt.wantMsg {
+				t.Errorf("message = %q, want %q", smtpErr.Message, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errSharedMailboxUnavailable defers a recipient routed to a shared mailbox
+// that session-manager does not know as a local user. That is a
+// configuration mistake, so the sender retries rather than bouncing.
+var errSharedMailboxUnavailable = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Mailbox temporarily unavailable",
+}
+
+// sharedMailbox returns the mailbox a [smtpd.domains] shared_mailboxes
+// pattern routes to to, or "" when none matches. The mailbox itself must be
+// a local user: session-manager delivers to it like to any other, and the
+// users who share it are given access there.
+func (s *Session) sharedMailbox(ctx context.Context, to, domain string) (string, error) {
+	local := to
+	if i := strings.LastIndex(to, "@"); i >= 0 {
+		local = to[:i]
+	}
+	mailbox := s.backend.domains.Get(domain).SharedMailbox(local)
+	if mailbox == "" {
+		return "", nil
+	}
+	vr, err := s.backend.smDelivery.ValidateRecipient(ctx, mailbox)
+	if err != nil {
+		s.logger.Debug("shared mailbox validation failed",
+			slog.String("mailbox", mailbox),
+			slog.String("error", err.Error()))
+		return "", &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporary lookup failure",
+		}
+	}
+	if !vr.DomainIsLocal || !vr.UserExists {
+		s.logger.Warn("shared mailbox is not a local user",
+			slog.String("recipient", to),
+			slog.String("mailbox", mailbox))
+		return "", errSharedMailboxUnavailable
+	}
+	return mailbox, nil
+}
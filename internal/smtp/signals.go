@@ -0,0 +1,69 @@
+package smtp
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+// errSignals is the DATA reply for a message whose signals reach
+// [smtpd.signals] reject_weight.
+var errSignals = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Message rejected: too many spam indicators",
+}
+
+// noteSignal records that the current message tripped a weak signal (see
+// config.SignalNames). Each signal counts once per message.
+func (s *Session) noteSignal(name string) {
+	if s.backend.signals == nil || slices.Contains(s.signals, name) {
+		return
+	}
+	s.signals = append(s.signals, name)
+	s.logger.Debug("message tripped signal", slog.String("signal", name))
+}
+
+// noteSpamScore records the spam_score signal for a spam check verdict that
+// refused nothing on its own.
+func (s *Session) noteSpamScore(result *spamcheck.CheckResult) {
+	cfg := s.backend.signals
+	if cfg == nil {
+		return
+	}
+	if (cfg.SpamScore > 0 && result.Score >= cfg.SpamScore) ||
+		(cfg.SpamScore == 0 && result.Action == spamcheck.ActionFlag) {
+		s.noteSignal(config.SignalSpamScore)
+	}
+}
+
+// checkSignals rejects the message once the summed weights of its signals
+// reach reject_weight. It runs after every stage that can record one.
+func (s *Session) checkSignals() error {
+	cfg := s.backend.signals
+	if cfg == nil {
+		return nil
+	}
+	total := 0
+	for _, name := range s.signals {
+		total += cfg.Weight(name)
+	}
+	if total < cfg.RejectWeight {
+		s.filterDecision("signals", nil)
+		return nil
+	}
+
+	if s.backend.collector != nil {
+		s.backend.collector.MessageRejected(sessionExtractRecipientDomain(s.recipients), "signals")
+	}
+	s.filterDecision("signals", errSignals)
+	s.logger.Info("message rejected on combined signals",
+		slog.String("signals", strings.Join(s.signals, ",")),
+		slog.Int("weight", total),
+		slog.Int("reject_weight", cfg.RejectWeight))
+	return errSignals
+}
@@ -0,0 +1,97 @@
+package smtp
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	pb "github.com/infodancer/mail-session/proto/mailsession/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+func TestSession_Data_CombinedSignals(t *testing.T) {
+	enabled := true
+	resolver := &mockResolver{
+		ptr:     map[string][]string{"192.0.2.10": {"mail.example.com."}},
+		forward: map[string][]string{"mail.example.com.": {"192.0.2.10"}},
+	}
+	flagged := &spamcheck.CheckResult{Action: spamcheck.ActionFlag, Score: 6}
+	clean := &spamcheck.CheckResult{Action: spamcheck.ActionAccept, Score: 1}
+
+	socketPath := startMockServer(t, &mockDeliveryServer{result: pb.DeliverResult_DELIVER_RESULT_DELIVERED})
+	agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{Socket: socketPath}, nil)
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	defer func() { _ = agent.Close() }()
+
+	tests := []struct {
+		name     string
+		clientIP string
+		result   *spamcheck.CheckResult
+		signals  config.SignalsConfig
+		want     error
+	}{
+		{"no signals", "192.0.2.10", clean, config.SignalsConfig{RejectWeight: 2}, nil},
+		{"no fcrdns alone", "192.0.2.40", clean, config.SignalsConfig{RejectWeight: 2}, nil},
+		{"spam score alone", "192.0.2.10", flagged, config.SignalsConfig{RejectWeight: 2}, nil},
+		{"both together", "192.0.2.40", flagged, config.SignalsConfig{RejectWeight: 2}, errSignals},
+		{"score below spam_score", "192.0.2.40", flagged, config.SignalsConfig{RejectWeight: 2, SpamScore: 8}, nil},
+		{"weighted alone", "192.0.2.10", flagged, config.SignalsConfig{RejectWeight: 2, Weights: map[string]int{config.SignalSpamScore: 2}}, errSignals},
+		{"zero weight", "192.0.2.40", flagged, config.SignalsConfig{RejectWeight: 2, Weights: map[string]int{config.SignalNoFCrDNS: 0}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{
+				backend: &Backend{
+					spamChecker: &verdictChecker{result: tt.result},
+					spamConfig: config.SpamCheckConfig{
+						Enabled:  true,
+						Checkers: []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+					},
+					smDelivery: agent,
+					fcrdns:     config.FCrDNSSignal,
+					resolver:   resolver,
+					signals:    &tt.signals,
+					tempDir:    t.TempDir(),
+					logger:     slog.Default(),
+				},
+				clientIP:   tt.clientIP,
+				recipients: []string{"user@example.com"},
+				logger:     slog.Default(),
+			}
+
+			if err := session.Mail("sender@example.org", nil); err != nil {
+				t.Fatalf("Mail() = %v; no single signal should refuse", err)
+			}
+			err := session.Data(strings.NewReader("Subject: test\r\n\r\nBody\r\n"))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Data() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSession_Signals_Reset(t *testing.T) {
+	s := &Session{
+		backend: &Backend{signals: &config.SignalsConfig{RejectWeight: 2}, logger: slog.Default()},
+		logger:  slog.Default(),
+	}
+	s.noteSignal(config.SignalDataPace)
+	s.noteSignal(config.SignalDataPace)
+	if err := s.checkSignals(); err != nil {
+		t.Errorf("checkSignals() = %v; a signal counts once per message", err)
+	}
+	s.noteSignal(config.SignalHeaderCharset)
+	if err := s.checkSignals(); !errors.Is(err, errSignals) {
+		t.Errorf("checkSignals() = %v, want %v", err, errSignals)
+	}
+
+	s.Reset()
+	if err := s.checkSignals(); err != nil {
+		t.Errorf("checkSignals() after Reset = %v, want nil", err)
+	}
+}
@@ -22,6 +22,13 @@ import (
 // with RunSingleConn tests. Returns the server and a mock delivery server.
 func newSingleConnEnv(t *testing.T) (*smtpserver.Server, *mockSCDeliveryServer) {
 	t.Helper()
+	return newSingleConnEnvWith(t, nil)
+}
+
+// newSingleConnEnvWith is newSingleConnEnv with a hook to adjust the
+// backend configuration.
+func newSingleConnEnvWith(t *testing.T, modify func(*smtpserver.BackendConfig)) (*smtpserver.Server, *mockSCDeliveryServer) {
+	t.Helper()
 
 	domainName := "single.local"
 
@@ -49,13 +56,17 @@ func newSingleConnEnv(t *testing.T) (*smtpserver.Server, *mockSCDeliveryServer)
 	}
 	t.Cleanup(func() { _ = smDelivery.Close() })
 
-	backend := smtpserver.NewBackend(smtpserver.BackendConfig{
+	bcfg := smtpserver.BackendConfig{
 		Hostname:       "single.local",
 		SMDelivery:     smDelivery,
 		MaxRecipients:  10,
 		MaxMessageSize: 10 * 1024 * 1024,
 		TempDir:        t.TempDir(),
-	})
+	}
+	if modify != nil {
+		modify(&bcfg)
+	}
+	backend := smtpserver.NewBackend(bcfg)
 
 	srv, err := smtpserver.NewServer(smtpserver.ServerConfig{
 		Backend: backend,
@@ -0,0 +1,37 @@
+package smtp
+
+import (
+	"context"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// acquireSlot takes one of max slots counted under key in the state store,
+// reporting false when all are taken. The count expires lease after the
+// first of a run of overlapping holders, so a protocol-handler that dies
+// without releasing cannot hold its slot forever.
+func acquireSlot(ctx context.Context, store kvstore.Store, key string, max int, lease time.Duration) (bool, error) {
+	n, err := store.Incr(ctx, key, lease)
+	if err != nil {
+		return true, err
+	}
+	if n > int64(max) {
+		return false, releaseSlot(ctx, store, key, lease)
+	}
+	return true, nil
+}
+
+// releaseSlot gives back a slot taken by acquireSlot.
+func releaseSlot(ctx context.Context, store kvstore.Store, key string, lease time.Duration) error {
+	n, err := store.IncrBy(ctx, key, -1, lease)
+	if err != nil {
+		return err
+	}
+	// The count may have expired while the slot was held; never leave it
+	// negative, which would grant extra slots.
+	if n <= 0 {
+		_ = store.Delete(ctx, key)
+	}
+	return nil
+}
@@ -32,6 +32,10 @@ type SessionManagerDeliveryAgent struct {
 	outbound pb.OutboundServiceClient
 	session  smpb.SessionServiceClient
 	logger   *slog.Logger
+
+	// chunkSize is the buffer message bodies are streamed through, one
+	// gRPC message per chunk ([smtpd.limits] delivery_chunk_size).
+	chunkSize int
 }
 
 // NewSessionManagerDeliveryAgent connects to the session-manager and returns a
@@ -71,6 +75,8 @@ func NewSessionManagerDeliveryAgent(cfg config.SessionManagerConfig, logger *slo
 		outbound: pb.NewOutboundServiceClient(conn),
 		session:  smpb.NewSessionServiceClient(conn),
 		logger:   logger,
+
+		chunkSize: 64 * 1024,
 	}, nil
 }
 
@@ -153,23 +159,18 @@ func (a *SessionManagerDeliveryAgent) Deliver(ctx context.Context, sender, recip
 		return fmt.Errorf("session-manager delivery: send metadata: %w", err)
 	}
 
-	// Stream body in 64KB chunks.
-	buf := make([]byte, 64*1024)
-	for {
-		n, readErr := message.Read(buf)
-		if n > 0 {
-			if err := stream.Send(&pb.DeliverRequest{
-				Payload: &pb.DeliverRequest_Data{Data: buf[:n]},
-			}); err != nil {
-				return fmt.Errorf("session-manager delivery: send body: %w", err)
-			}
-		}
-		if readErr == io.EOF {
-			break
-		}
-		if readErr != nil {
-			return fmt.Errorf("session-manager delivery: read message: %w", readErr)
-		}
+	var sendErr error
+	err = streamBody(message, a.chunkSize, func(chunk []byte) error {
+		sendErr = stream.Send(&pb.DeliverRequest{
+			Payload: &pb.DeliverRequest_Data{Data: chunk},
+		})
+		return sendErr
+	})
+	if sendErr != nil {
+		return fmt.Errorf("session-manager delivery: send body: %w", sendErr)
+	}
+	if err != nil {
+		return fmt.Errorf("session-manager delivery: read message: %w", err)
 	}
 
 	resp, err := stream.CloseAndRecv()
@@ -183,15 +184,14 @@ func (a *SessionManagerDeliveryAgent) Deliver(ctx context.Context, sender, recip
 		return nil
 
 	case pb.DeliverResult_DELIVER_RESULT_REJECTED:
-		code := "550"
-		if resp.GetTemporary() {
-			code = "451"
-		}
 		a.logger.Debug("session-manager delivery rejected",
 			slog.String("recipient", recipient),
-			slog.String("code", code),
+			slog.Bool("temporary", resp.GetTemporary()),
 			slog.String("reason", resp.GetReason()))
-		return fmt.Errorf("delivery rejected (%s): %s", code, resp.GetReason())
+		return &RejectedError{
+			Temporary: resp.GetTemporary(),
+			Reason:    resp.GetReason(),
+		}
 
 	case pb.DeliverResult_DELIVER_RESULT_REDIRECTED:
 		a.logger.Info("session-manager delivery redirected",
@@ -233,23 +233,18 @@ func (a *SessionManagerDeliveryAgent) Enqueue(ctx context.Context, sender string
 		return "", fmt.Errorf("session-manager enqueue: send metadata: %w", err)
 	}
 
-	// Stream body in 64KB chunks.
-	buf := make([]byte, 64*1024)
-	for {
-		n, readErr := message.Read(buf)
-		if n > 0 {
-			if err := stream.Send(&pb.EnqueueRequest{
-				Payload: &pb.EnqueueRequest_Data{Data: buf[:n]},
-			}); err != nil {
-				return "", fmt.Errorf("session-manager enqueue: send body: %w", err)
-			}
-		}
-		if readErr == io.EOF {
-			break
-		}
-		if readErr != nil {
-			return "", fmt.Errorf("session-manager enqueue: read message: %w", readErr)
-		}
+	var sendErr error
+	err = streamBody(message, a.chunkSize, func(chunk []byte) error {
+		sendErr = stream.Send(&pb.EnqueueRequest{
+			Payload: &pb.EnqueueRequest_Data{Data: chunk},
+		})
+		return sendErr
+	})
+	if sendErr != nil {
+		return "", fmt.Errorf("session-manager enqueue: send body: %w", sendErr)
+	}
+	if err != nil {
+		return "", fmt.Errorf("session-manager enqueue: read message: %w", err)
 	}
 
 	resp, err := stream.CloseAndRecv()
@@ -265,6 +260,28 @@ func (a *SessionManagerDeliveryAgent) Enqueue(ctx context.Context, sender string
 	return resp.GetMessageId(), nil
 }
 
+// chunkWriter hands each Write to its function as one chunk.
+type chunkWriter func([]byte) error
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// streamBody copies message to send in chunks of at most size bytes through
+// one reused buffer, so a delivery holds size bytes of body at a time
+// whatever the message length. gRPC marshals each message before Send
+// returns, so the buffer may be refilled straight away. The reader is
+// wrapped to hide any WriteTo: io.CopyBuffer would otherwise skip the
+// buffer, and a bytes.Reader would go out as one oversized message.
+func streamBody(message io.Reader, size int, send func([]byte) error) error {
+	buf := make([]byte, size)
+	_, err := io.CopyBuffer(chunkWriter(send), struct{ io.Reader }{message}, buf)
+	return err
+}
+
 // buildClientTLS creates a TLS config for connecting to the session-manager with mTLS.
 func buildClientTLS(caCertPath, clientCertPath, clientKeyPath string) (*tls.Config, error) {
 	caPEM, err := os.ReadFile(caCertPath)
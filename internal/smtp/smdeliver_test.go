@@ -3,9 +3,12 @@ package smtp
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"io"
 	"net"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -28,6 +31,7 @@ type mockDeliveryServer struct {
 	// captured values
 	metadata *pb.DeliverMetadata
 	body     []byte
+	maxChunk int
 }
 
 func (s *mockDeliveryServer) Deliver(stream grpc.ClientStreamingServer[pb.DeliverRequest, pb.DeliverResponse]) error {
@@ -46,6 +50,7 @@ func (s *mockDeliveryServer) Deliver(stream grpc.ClientStreamingServer[pb.Delive
 			s.metadata = p.Metadata
 		case *pb.DeliverRequest_Data:
 			body.Write(p.Data)
+			s.maxChunk = max(s.maxChunk, len(p.Data))
 		}
 	}
 
@@ -248,6 +253,112 @@ func TestSessionManagerDelivery_LargeMessage(t *testing.T) {
 	if len(mock.body) != 256*1024 {
 		t.Errorf("body size = %d, want %d", len(mock.body), 256*1024)
 	}
+	if mock.maxChunk > 64*1024 {
+		t.Errorf("largest chunk = %d bytes, want at most 64KB", mock.maxChunk)
+	}
+}
+
+func TestSessionManagerDelivery_ChunkSize(t *testing.T) {
+	mock := &mockDeliveryServer{
+		result: pb.DeliverResult_DELIVER_RESULT_DELIVERED,
+	}
+	socketPath := startMockServer(t, mock)
+
+	agent, err := NewSessionManagerDeliveryAgent(config.SessionManagerConfig{
+		Socket: socketPath,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new agent: %v", err)
+	}
+	defer func() { _ = agent.Close() }()
+	agent.chunkSize = config.MaxDeliveryChunkSize
+
+	// A bytes.Reader has WriteTo; it must not bypass the chunk buffer.
+	body := bytes.Repeat([]byte("0123456789abcdef"), 3*1024*1024/16)
+	err = agent.Deliver(context.Background(),
+		"sender@example.com", "user@example.com",
+		"", "", time.Time{}, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	if !bytes.Equal(mock.body, body) {
+		t.Errorf("body differs: got %d bytes, want %d", len(mock.body), len(body))
+	}
+	if mock.maxChunk != config.MaxDeliveryChunkSize {
+		t.Errorf("largest chunk = %d bytes, want %d", mock.maxChunk, config.MaxDeliveryChunkSize)
+	}
+}
+
+// patternReader yields n bytes of a repeating pattern without holding them.
+type patternReader struct {
+	n   int64
+	off int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	const pattern = "The quick brown fox jumps over the lazy dog.\r\n"
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = pattern[r.off]
+		r.off = (r.off + 1) % len(pattern)
+	}
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+func TestStreamBody_BoundedMemory(t *testing.T) {
+	const (
+		size  = 64 * 1024
+		total = 64 * 1024 * 1024
+	)
+	want := sha256.New()
+	if _, err := io.Copy(want, &patternReader{n: total}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := sha256.New()
+	var n int64
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := streamBody(&patternReader{n: total}, size, func(chunk []byte) error {
+		if len(chunk) > size {
+			t.Fatalf("chunk of %d bytes, want at most %d", len(chunk), size)
+		}
+		n += int64(len(chunk))
+		got.Write(chunk)
+		return nil
+	})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("streamBody: %v", err)
+	}
+
+	if n != total || !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		t.Fatalf("streamed %d bytes with a different digest, want %d bytes intact", n, total)
+	}
+	// One chunk buffer plus small change, not the 64 MiB message.
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 4*size {
+		t.Errorf("allocated %d bytes streaming %d, want at most %d", alloc, total, 4*size)
+	}
+}
+
+func BenchmarkStreamBody(b *testing.B) {
+	const total = 64 * 1024 * 1024
+	for _, size := range []int{config.MinDeliveryChunkSize, 64 * 1024, config.MaxDeliveryChunkSize} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(total)
+			b.ReportAllocs()
+			for b.Loop() {
+				_ = streamBody(&patternReader{n: total}, size, func([]byte) error { return nil })
+			}
+		})
+	}
 }
 
 func TestNewSessionManagerDeliveryAgent_SocketRequired(t *testing.T) {
@@ -0,0 +1,94 @@
+package smtp
+
+import (
+	"crypto/subtle"
+	"io"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/textproto"
+	"strings"
+
+	"github.com/infodancer/smtpd/internal/config"
+)
+
+// spamBypass lets trusted internal systems, such as a notification sender,
+// skip the DATA spam check for one message by carrying [spamcheck]
+// bypass_secret in bypass_header. Only bypass_clients and bypass_users may
+// use it, so an outside sender cannot exempt itself by guessing the header;
+// the secret keeps a compromised host on an allowlisted network from doing
+// so without it. The header is stripped from every message, trusted or not,
+// so the secret never reaches a mailbox or the spam checker.
+type spamBypass struct {
+	header  string
+	secret  []byte
+	clients []netip.Prefix
+	users   map[string]bool
+}
+
+// newSpamBypass returns nil when the bypass is disabled.
+func newSpamBypass(cfg config.SpamCheckConfig) *spamBypass {
+	if !cfg.IsBypassEnabled() {
+		return nil
+	}
+	b := &spamBypass{
+		header:  cfg.GetBypassHeader(),
+		secret:  []byte(cfg.BypassSecret),
+		clients: cfg.GetBypassClients(),
+		users:   make(map[string]bool, len(cfg.BypassUsers)),
+	}
+	for _, u := range cfg.BypassUsers {
+		b.users[strings.ToLower(u)] = true
+	}
+	return b
+}
+
+// trusts reports whether a session from clientIP, authenticated as
+// authUser ("" if not), may use the bypass.
+func (b *spamBypass) trusts(clientIP, authUser string) bool {
+	if authUser != "" && b.users[strings.ToLower(authUser)] {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range b.clients {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// granted reports whether the message in r carries the secret in the
+// bypass header.
+func (b *spamBypass) granted(r io.Reader) bool {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return false
+	}
+	for _, v := range msg.Header[textproto.CanonicalMIMEHeaderKey(b.header)] {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(v)), b.secret) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// spamCheckInput returns what the DATA spam check reads from the buffered
+// message in tmp, and whether the check is bypassed for this message.
+func (s *Session) spamCheckInput(tmp tempBuffer) (io.Reader, bool) {
+	b := s.backend.spamBypass
+	if b == nil {
+		return tmp.reader(), false
+	}
+	if b.trusts(s.clientIP, s.authUser) && b.granted(tmp.reader()) {
+		s.logger.Info("spam check bypassed by trusted sender",
+			slog.String("client_ip", s.clientIP),
+			slog.String("auth_user", s.authUser))
+		return nil, true
+	}
+	return newHeaderFilter(tmp.reader(), b.header), false
+}
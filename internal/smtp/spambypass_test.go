@@ -0,0 +1,84 @@
+package smtp
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+func bypassBackend(t *testing.T, checker spamcheck.Checker) *Backend {
+	t.Helper()
+	enabled := true
+	return NewBackend(BackendConfig{
+		SMDelivery:  startMockSessionServer(t, &mockSessionService{}),
+		TempDir:     t.TempDir(),
+		SpamChecker: checker,
+		SpamConfig: config.SpamCheckConfig{
+			Enabled:       true,
+			Checkers:      []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+			BypassSecret:  "s3cret",
+			BypassClients: []string{"10.0.0.0/8"},
+			BypassUsers:   []string{"notify@example.com"},
+		},
+	})
+}
+
+func TestSession_Data_SpamBypass(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientIP  string
+		authUser  string
+		secret    string
+		wantCheck bool
+	}{
+		{"trusted client, correct secret", "10.1.2.3", "", "s3cret", false},
+		{"trusted user, correct secret", "192.0.2.1", "Notify@example.com", "s3cret", false},
+		{"trusted client, wrong secret", "10.1.2.3", "", "guess", true},
+		{"untrusted client, correct secret", "192.0.2.1", "", "s3cret", true},
+		{"untrusted user, correct secret", "192.0.2.1", "alice@example.com", "s3cret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &verdictChecker{result: &spamcheck.CheckResult{Action: spamcheck.ActionAccept}}
+			s := &Session{
+				backend:      bypassBackend(t, checker),
+				clientIP:     tt.clientIP,
+				authUser:     tt.authUser,
+				from:         "notify@example.com",
+				mailFromSeen: true,
+				recipients:   []string{"bob@example.com"},
+				logger:       slog.Default(),
+			}
+
+			msg := "X-Spam-Bypass: " + tt.secret + "\r\nSubject: alert\r\n\r\nbody\r\n"
+			_ = s.Data(strings.NewReader(msg))
+
+			if called := len(checker.calls) > 0; called != tt.wantCheck {
+				t.Fatalf("checker called = %v, want %v", called, tt.wantCheck)
+			}
+			if tt.wantCheck {
+				if got := string(checker.body); strings.Contains(got, "X-Spam-Bypass") || !strings.Contains(got, "Subject: alert") {
+					t.Errorf("checker saw %q, want message without bypass header", got)
+				}
+			}
+		})
+	}
+}
+
+func TestFinalDeliveryMessage_StripsSpamBypass(t *testing.T) {
+	tmp := &memTempBuf{}
+	_, _ = tmp.Write([]byte("X-Spam-Bypass: s3cret\r\nSubject: alert\r\n\r\nbody\r\n"))
+	s := &Session{backend: bypassBackend(t, nil)}
+
+	got, err := io.ReadAll(s.finalDeliveryMessage(tmp, "ID"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := "Subject: alert\r\n\r\nbody\r\n"; string(got) != want {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+// precheckSpam runs the spam checkers with the envelope and an empty body
+// when [spamcheck] precheck selects stage. Checkers such as rspamd score the
+// client IP, HELO and sender without a message, so obvious spam is refused
+// before its body is transferred.
+//
+// The precheck only ever rejects: a checker error, or a score below
+// precheck_threshold, lets the command through, and the full check at DATA
+// applies as usual.
+func (s *Session) precheckSpam(ctx context.Context, stage config.SpamPrecheck, from string, recipients []string) (err error) {
+	cfg := s.backend.spamConfig
+	if s.backend.spamChecker == nil || !cfg.IsEnabled() || cfg.Precheck != stage {
+		return nil
+	}
+	defer func() { s.filterDecision("spam_precheck", err) }()
+
+	if d := cfg.GetTotalTimeout(); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	result, err := s.backend.spamChecker.Check(ctx, strings.NewReader(""), spamcheck.CheckOptions{
+		From:       from,
+		Recipients: recipients,
+		IP:         s.clientIP,
+		Helo:       s.helo,
+		Hostname:   s.backend.hostname,
+		User:       s.authUser,
+	})
+	if err != nil {
+		s.logger.Debug("spam precheck failed, continuing",
+			slog.String("stage", string(stage)),
+			slog.String("error", err.Error()))
+		return nil
+	}
+
+	if !result.ShouldReject(cfg.PrecheckThreshold) {
+		return nil
+	}
+
+	if s.backend.collector != nil {
+		s.backend.collector.MessageRejected(sessionExtractRecipientDomain(recipients), "spam_precheck")
+	}
+	s.logger.Info("rejected by spam precheck",
+		slog.String("stage", string(stage)),
+		slog.String("from", from),
+		slog.Float64("score", result.Score),
+		slog.String("action", string(result.Action)),
+		slog.String("reason", result.RejectMessage))
+	return s.spamRejection()
+}
@@ -0,0 +1,136 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/spamcheck"
+)
+
+// verdictChecker returns a fixed verdict and records what it was asked.
+type verdictChecker struct {
+	result *spamcheck.CheckResult
+	err    error
+	calls  []spamcheck.CheckOptions
+	body   []byte
+}
+
+func (c *verdictChecker) Name() string { return "verdict" }
+
+func (c *verdictChecker) Check(_ context.Context, message io.Reader, opts spamcheck.CheckOptions) (*spamcheck.CheckResult, error) {
+	c.calls = append(c.calls, opts)
+	c.body, _ = io.ReadAll(message)
+	return c.result, c.err
+}
+
+func (c *verdictChecker) Close() error { return nil }
+
+func precheckBackend(checker spamcheck.Checker, stage config.SpamPrecheck, threshold float64) *Backend {
+	enabled := true
+	return NewBackend(BackendConfig{
+		SpamChecker: checker,
+		SpamConfig: config.SpamCheckConfig{
+			Enabled:           true,
+			Checkers:          []config.SpamCheckerConfig{{Type: "rspamd", Enabled: &enabled}},
+			Precheck:          stage,
+			PrecheckThreshold: threshold,
+		},
+	})
+}
+
+func TestSession_Mail_SpamPrecheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		stage     config.SpamPrecheck
+		threshold float64
+		result    *spamcheck.CheckResult
+		err       error
+		wantCalls int
+		wantCode  int // 0 = accepted
+	}{
+		{"reject verdict", config.SpamPrecheckMail, 0, &spamcheck.CheckResult{Action: spamcheck.ActionReject}, nil, 1, 550},
+		{"score at threshold", config.SpamPrecheckMail, 20, &spamcheck.CheckResult{Action: spamcheck.ActionFlag, Score: 20}, nil, 1, 550},
+		{"score below threshold", config.SpamPrecheckMail, 20, &spamcheck.CheckResult{Action: spamcheck.ActionFlag, Score: 19}, nil, 1, 0},
+		{"checker error fails open", config.SpamPrecheckMail, 0, nil, errors.New("rspamd down"), 1, 0},
+		{"precheck off", config.SpamPrecheckOff, 0, &spamcheck.CheckResult{Action: spamcheck.ActionReject}, nil, 0, 0},
+		{"precheck at rcpt", config.SpamPrecheckRcpt, 0, &spamcheck.CheckResult{Action: spamcheck.ActionReject}, nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &verdictChecker{result: tt.result, err: tt.err}
+			session := &Session{
+				backend:  precheckBackend(checker, tt.stage, tt.threshold),
+				clientIP: "192.0.2.7",
+				logger:   slog.Default(),
+			}
+
+			err := session.Mail("spammer@example.net", nil)
+			if len(checker.calls) != tt.wantCalls {
+				t.Fatalf("checker called %d times, want %d", len(checker.calls), tt.wantCalls)
+			}
+			if tt.wantCalls > 0 {
+				if opts := checker.calls[0]; opts.From != "spammer@example.net" || opts.IP != "192.0.2.7" {
+					t.Errorf("CheckOptions = %+v", opts)
+				}
+				if len(checker.body) != 0 {
+					t.Errorf("precheck sent a body: %q", checker.body)
+				}
+			}
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("expected acceptance, got %v", err)
+				}
+				return
+			}
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != tt.wantCode {
+				t.Fatalf("got %v, want %d", err, tt.wantCode)
+			}
+			if session.mailFromSeen {
+				t.Error("rejected MAIL opened a transaction")
+			}
+		})
+	}
+}
+
+func TestSession_Rcpt_SpamPrecheck(t *testing.T) {
+	checker := &verdictChecker{result: &spamcheck.CheckResult{Action: spamcheck.ActionReject}}
+	session := &Session{
+		backend:      precheckBackend(checker, config.SpamPrecheckRcpt, 0),
+		from:         "spammer@example.net",
+		mailFromSeen: true,
+		logger:       slog.Default(),
+	}
+
+	err := session.Rcpt("alice@example.com", nil)
+	smtpErr, ok := err.(*gosmtp.SMTPError)
+	if !ok || smtpErr.Code != 550 {
+		t.Fatalf("got %v, want 550", err)
+	}
+	if len(checker.calls) != 1 || len(checker.calls[0].Recipients) != 1 || checker.calls[0].Recipients[0] != "alice@example.com" {
+		t.Errorf("CheckOptions = %+v", checker.calls)
+	}
+}
+
+func TestSession_Mail_SpamPrecheck_AuthenticatedTempFail(t *testing.T) {
+	checker := &verdictChecker{result: &spamcheck.CheckResult{Action: spamcheck.ActionReject}}
+	backend := precheckBackend(checker, config.SpamPrecheckMail, 0)
+	backend.spamConfig.AuthenticatedAction = config.SpamAuthenticatedTempFail
+	session := &Session{
+		backend:  backend,
+		authUser: "alice@example.com",
+		logger:   slog.Default(),
+	}
+
+	err := session.Mail("alice@example.com", nil)
+	smtpErr, ok := err.(*gosmtp.SMTPError)
+	if !ok || smtpErr.Code != 451 {
+		t.Fatalf("got %v, want 451", err)
+	}
+}
@@ -9,11 +9,16 @@ import (
 	"log/slog"
 
 	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
 	"github.com/infodancer/smtpd/internal/metrics"
 	"github.com/infodancer/smtpd/internal/spamcheck"
+	"github.com/infodancer/smtpd/internal/webhook"
 	goredis "github.com/redis/go-redis/v9"
 )
 
+// StateKeyPrefix namespaces the redis state backend's keys.
+const StateKeyPrefix = "smtpd:state:"
+
 // Stack owns all components of a running smtpd instance and manages their lifecycle.
 type Stack struct {
 	Server  *Server
@@ -30,6 +35,18 @@ type StackConfig struct {
 	SpamConfig  config.SpamCheckConfig
 	Collector   metrics.Collector // nil → NoopCollector
 	Logger      *slog.Logger      // nil → slog.Default()
+	// TLSPolicy is set by the protocol-handler to the tls_policy of the
+	// listener that accepted the connection; "" → optional.
+	TLSPolicy config.TLSPolicy
+	// Honeypot is set by the protocol-handler when the connection was
+	// accepted on a honeypot listener.
+	Honeypot bool
+	// Maintenance is set by the protocol-handler when the listener was in
+	// maintenance mode as the connection was accepted.
+	Maintenance bool
+	// Warmup is set by the protocol-handler when the connection was
+	// accepted within unknown_user_warmup of the listener starting.
+	Warmup bool
 }
 
 // NewStack creates a Stack from the given configuration, wiring up all components.
@@ -56,6 +73,7 @@ func NewStack(cfg StackConfig) (*Stack, error) {
 		s.Close() //nolint:errcheck
 		return nil, err
 	}
+	smDelivery.chunkSize = cfg.Config.Limits.GetDeliveryChunkSize()
 	s.closers = append(s.closers, smDelivery)
 
 	// Create shared Redis client for notifications and rate limiting.
@@ -76,6 +94,55 @@ func NewStack(cfg StackConfig) (*Stack, error) {
 		logger.Info("redis enabled", "url", cfg.Config.Redis.URL)
 	}
 
+	// Defensive state store. Validate guarantees a Redis URL when the
+	// redis backend is selected.
+	var stateStore kvstore.Store
+	switch cfg.Config.State.GetBackend() {
+	case "redis":
+		if redisClient == nil {
+			s.Close() //nolint:errcheck
+			return nil, fmt.Errorf("state backend redis requires [redis] url")
+		}
+		stateStore = kvstore.NewRedis(redisClient, StateKeyPrefix)
+	default:
+		stateStore = kvstore.NewMemory()
+	}
+	logger.Debug("state store configured", "backend", cfg.Config.State.GetBackend())
+	if cfg.Config.Reputation.MaxRejections > 0 && cfg.Config.State.GetBackend() == "memory" {
+		logger.Warn("reputation.max_rejections has no effect across connections with the memory state backend")
+	}
+	if (cfg.Config.AuthRate.MaxPerIP > 0 || cfg.Config.AuthRate.MaxPerUser > 0) && cfg.Config.State.GetBackend() == "memory" {
+		logger.Warn("auth_rate only counts attempts within one connection with the memory state backend")
+	}
+	if cfg.Config.Auth.MaxDistinctUsersPerIP > 0 && cfg.Config.State.GetBackend() == "memory" {
+		logger.Warn("auth.max_distinct_users_per_ip only counts logins within one connection with the memory state backend")
+	}
+	for domain, d := range cfg.Config.Domains {
+		if d.InboundRatePerMinute > 0 && cfg.Config.State.GetBackend() == "memory" {
+			logger.Warn("domains.inbound_rate_per_minute only counts within one connection with the memory state backend", "domain", domain)
+			break
+		}
+	}
+	if cfg.Config.FirstContact.IsEnabled() && cfg.Config.State.GetBackend() == "memory" {
+		logger.Warn("first_contact reports every connection's first sender domain with the memory state backend")
+	}
+	if cfg.Config.Metrics.PerUser && cfg.Config.State.GetBackend() == "memory" {
+		logger.Warn("metrics.per_user counts are lost with each connection with the memory state backend")
+	}
+
+	var hook *webhook.Notifier
+	if cfg.Config.Webhook.IsEnabled() {
+		hook = webhook.New(cfg.Config.Webhook.URL, cfg.Config.Webhook.GetEvents(),
+			cfg.Config.Webhook.GetTimeout(), logger)
+		s.closers = append(s.closers, hook)
+	}
+
+	var honeypotDir string
+	if cfg.Honeypot {
+		honeypotDir = cfg.Config.HoneypotDir
+		logger.Warn("honeypot listener: mail is captured, never delivered", "dir", honeypotDir)
+	}
+
 	backend := NewBackend(BackendConfig{
 		Hostname:        cfg.Config.Hostname,
 		SMDelivery:      smDelivery,
@@ -84,24 +151,72 @@ func NewStack(cfg StackConfig) (*Stack, error) {
 		RejectionMode:   cfg.Config.GetRejectionMode(),
 		SpamtrapConfig:  cfg.Config.Spamtrap,
 		MaxSendsPerHour: cfg.Config.Limits.MaxSendsPerHour,
+		OverloadMessage: cfg.Config.OverloadMessage,
+		RequireTLS:      cfg.TLSPolicy == config.TLSPolicyRequired,
+		AddHeaders:      cfg.Config.AddHeaders,
+		HeaderPolicy:    cfg.Config.GetHeaderPolicy(),
+		FCrDNS:          cfg.Config.GetFCrDNSPolicy(),
+		ReturnPath:      cfg.Config.AddReturnPath(),
+		AuthUserHeader:  cfg.Config.AuthUserHeader,
+		NoBounce:        cfg.Config.NoBounceRecipients,
+		DenySenders:     cfg.Config.DenySenders,
+		AllowSenders:    cfg.Config.AllowSenders,
+		SendAs:          cfg.Config.SendAs,
+		CheckLocalFrom:  cfg.Config.CheckLocalFrom,
+		RejectNullMX:    cfg.Config.RejectNullMX,
+		CheckEncoding:   cfg.Config.CheckEncoding,
+		MessageTimeout:  cfg.Config.Timeouts.CommandTimeout(),
+		UniqueHeaders:   cfg.Config.RejectDupHeaders,
+		HeaderCharset:   cfg.Config.GetHeaderCharsetPolicy(),
+		Domains:         cfg.Config.Domains,
+		Users:           cfg.Config.Users,
+		HoneypotDir:     honeypotDir,
+		Maintenance:     cfg.Maintenance,
+		Warmup:          cfg.Warmup,
+		RoleMailbox:     cfg.Config.RoleMailbox,
+		RoleRecipients:  cfg.Config.GetRoleRecipients(),
 		RedisClient:     redisClient,
 		Notifier:        notifier,
+		Webhook:         hook,
+		StateStore:      stateStore,
+		StateOnError:    cfg.Config.State.GetOnError(),
+		Reputation:      cfg.Config.Reputation,
+		AuthRate:        cfg.Config.AuthRate,
+		Auth:            cfg.Config.Auth,
+		DataPace:        cfg.Config.DataPace,
+		DateCheck:       cfg.Config.DateCheck,
+		FirstContact:    cfg.Config.FirstContact,
+		Signals:         cfg.Config.Signals,
+		Metrics:         cfg.Config.Metrics,
 		Collector:       collector,
 		MaxRecipients:   cfg.Config.Limits.MaxRecipients,
 		MaxMessageSize:  int64(cfg.Config.Limits.MaxMessageSize),
+		MaxMIMEDepth:    cfg.Config.Limits.MaxMIMEDepth,
+		MaxMIMEParts:    cfg.Config.Limits.MaxMIMEParts,
+		MaxTransfers:    cfg.Config.Limits.MaxConcurrentData,
+		MaxConnRcpts:    cfg.Config.Limits.MaxConnRecipients,
+		MaxHeaderRcpts:  cfg.Config.MaxHeaderRcpts,
+		MaxReceived:     cfg.Config.MaxReceived,
+		Rejections:      cfg.Config.RecentRejections,
+		TempDir:         cfg.Config.Temp.Dir,
 		Logger:          logger,
 	})
 
 	srv, err := NewServer(ServerConfig{
-		Backend:        backend,
-		Listeners:      cfg.Config.Listeners,
-		Hostname:       cfg.Config.Hostname,
-		TLSConfig:      cfg.TLSConfig,
-		ReadTimeout:    cfg.Config.Timeouts.ConnectionTimeout(),
-		WriteTimeout:   cfg.Config.Timeouts.ConnectionTimeout(),
-		MaxMessageSize: cfg.Config.Limits.MaxMessageSize,
-		MaxRecipients:  cfg.Config.Limits.MaxRecipients,
-		Logger:         logger,
+		Backend:         backend,
+		Listeners:       cfg.Config.Listeners,
+		Hostname:        cfg.Config.Hostname,
+		TLSConfig:       cfg.TLSConfig,
+		ReadTimeout:     cfg.Config.Timeouts.ConnectionTimeout(),
+		WriteTimeout:    cfg.Config.Timeouts.ConnectionTimeout(),
+		MaxMessageSize:  cfg.Config.Limits.MaxMessageSize,
+		MaxRecipients:   cfg.Config.Limits.MaxRecipients,
+		LogTransactions: cfg.Config.LogTransactions,
+		MaxGreetings:    cfg.Config.Limits.MaxGreetings,
+		Capture:         cfg.Config.Capture,
+		TLSPolicy:       cfg.TLSPolicy,
+		RejectALPN:      cfg.Config.TLS.RejectALPN,
+		Logger:          logger,
 	})
 	if err != nil {
 		s.Close() //nolint:errcheck
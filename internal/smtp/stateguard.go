@@ -0,0 +1,78 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// errStateUnavailable defers a command under [smtpd.state] on_error =
+// "fail_closed" while the state store is failing.
+var errStateUnavailable = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Temporary local problem, try again later",
+}
+
+// stateGuard wraps the state store under fail_closed and remembers whether
+// its last operation failed. The features keeping state there skip their
+// check on a store error, as under fail_open; checkState then turns the
+// command into a deferral, so one policy covers all of them.
+//
+// Every operation sets the flag, so the first to succeed after an outage
+// lets mail through again.
+type stateGuard struct {
+	kvstore.Store
+	failing atomic.Bool
+}
+
+// note records the outcome of one operation. ErrNotInteger means the store
+// answered, so it does not count as a failure.
+func (g *stateGuard) note(err error) error {
+	g.failing.Store(err != nil && !errors.Is(err, kvstore.ErrNotInteger))
+	return err
+}
+
+func (g *stateGuard) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok, err := g.Store.Get(ctx, key)
+	return value, ok, g.note(err)
+}
+
+func (g *stateGuard) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return g.note(g.Store.Set(ctx, key, value, ttl))
+}
+
+func (g *stateGuard) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := g.Store.Incr(ctx, key, ttl)
+	return n, g.note(err)
+}
+
+func (g *stateGuard) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	n, err := g.Store.IncrBy(ctx, key, delta, ttl)
+	return n, g.note(err)
+}
+
+func (g *stateGuard) Delete(ctx context.Context, key string) error {
+	return g.note(g.Store.Delete(ctx, key))
+}
+
+func (g *stateGuard) Enumerate(ctx context.Context, prefix string) (map[string]string, error) {
+	m, err := g.Store.Enumerate(ctx, prefix)
+	return m, g.note(err)
+}
+
+// checkState defers the command when the state store is failing under
+// fail_closed. It runs after the checks that use the store, so their
+// failure is seen.
+func (s *Session) checkState() error {
+	g := s.backend.stateGuard
+	if g == nil || !g.failing.Load() {
+		return nil
+	}
+	s.logger.Warn("state store unavailable, deferring")
+	return errStateUnavailable
+}
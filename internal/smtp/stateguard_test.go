@@ -0,0 +1,111 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// flakyStore is a state store whose backend can be taken down.
+type flakyStore struct {
+	kvstore.Store
+	down atomic.Bool
+}
+
+var errStoreDown = errors.New("connection refused")
+
+func (f *flakyStore) Get(ctx context.Context, key string) (string, bool, error) {
+	if f.down.Load() {
+		return "", false, errStoreDown
+	}
+	return f.Store.Get(ctx, key)
+}
+
+func (f *flakyStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if f.down.Load() {
+		return 0, errStoreDown
+	}
+	return f.Store.Incr(ctx, key, ttl)
+}
+
+func TestSession_Rcpt_StateOnError(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		validateResult: &smpb.ValidateRecipientResponse{DomainIsLocal: true, UserExists: true},
+	})
+
+	tests := []struct {
+		policy config.StateErrorPolicy
+		want   error
+	}{
+		{"", nil},
+		{config.StateFailOpen, nil},
+		{config.StateFailClosed, errStateUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			store := &flakyStore{Store: kvstore.NewMemory()}
+			backend := NewBackend(BackendConfig{
+				SMDelivery:   agent,
+				StateStore:   store,
+				StateOnError: tt.policy,
+				Domains: config.DomainsConfig{
+					"example.com": {InboundRatePerMinute: 10},
+				},
+			})
+			rcpt := func() error {
+				s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+				return s.Rcpt("bob@example.com", nil)
+			}
+
+			if err := rcpt(); err != nil {
+				t.Fatalf("Rcpt with the store up = %v", err)
+			}
+			store.down.Store(true)
+			if err := rcpt(); !errors.Is(err, tt.want) {
+				t.Errorf("Rcpt with the store down = %v, want %v", err, tt.want)
+			}
+			store.down.Store(false)
+			if err := rcpt(); err != nil {
+				t.Errorf("Rcpt after the store recovered = %v", err)
+			}
+		})
+	}
+}
+
+func TestSession_Mail_StateFailClosed(t *testing.T) {
+	store := &flakyStore{Store: kvstore.NewMemory()}
+	backend := NewBackend(BackendConfig{
+		StateStore:   store,
+		StateOnError: config.StateFailClosed,
+		Reputation:   config.ReputationConfig{MaxRejections: 3},
+	})
+
+	// The reputation lookup at connect fails open; MAIL then defers.
+	store.down.Store(true)
+	if backend.reputation.blocked(context.Background(), "192.0.2.1") {
+		t.Fatal("blocked() = true with the store down")
+	}
+	s := &Session{backend: backend, clientIP: "192.0.2.1", logger: slog.Default()}
+	if err := s.Mail("alice@example.net", nil); !errors.Is(err, errStateUnavailable) {
+		t.Errorf("Mail = %v, want %v", err, errStateUnavailable)
+	}
+}
+
+func TestStateGuard_NotIntegerIsNotAFailure(t *testing.T) {
+	g := &stateGuard{Store: kvstore.NewMemory()}
+	ctx := context.Background()
+	_ = g.Set(ctx, "k", "text", 0)
+	if _, err := g.Incr(ctx, "k", 0); !errors.Is(err, kvstore.ErrNotInteger) {
+		t.Fatalf("Incr on text = %v, want %v", err, kvstore.ErrNotInteger)
+	}
+	if g.failing.Load() {
+		t.Error("ErrNotInteger marked the store as failing")
+	}
+}
@@ -2,19 +2,27 @@ package smtp
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/metrics"
 )
 
 // SubprocessServer listens on configured TCP ports and spawns a protocol-handler
 // subprocess per accepted connection. Each subprocess receives the raw TCP socket
-// as fd 3 and handles exactly one SMTP session before exiting.
+// as fd 3 and handles exactly one SMTP session before exiting. When the
+// session ends it writes a HandlerReport as one JSON line to fd 4, which
+// the server adds to its lifetime report.
 //
 // The subprocess is invoked as:
 //
@@ -23,61 +31,186 @@ import (
 // Connection metadata is passed via environment variables:
 //
 //	SMTPD_CLIENT_IP     - remote IP address of the connecting client
+//	SMTPD_CLIENT_ADDR   - client ip:port from a PROXY header (trusted_proxy listeners only)
 //	SMTPD_LISTENER_MODE - listener mode (smtp/submission/smtps/alt)
+//	SMTPD_TLS_POLICY    - the listener's tls_policy (off/optional/required)
+//	SMTPD_HONEYPOT      - "1" on honeypot listeners
+//	SMTPD_MAINTENANCE   - "1" while the server is in maintenance mode
+//	SMTPD_WARMUP        - "1" within unknown_user_warmup of the server starting
+//	SMTPD_REPORT        - "1"; fd 4 is the report pipe
 type SubprocessServer struct {
-	listeners  []config.ListenerConfig
-	execPath   string
-	configPath string
-	logger     *slog.Logger
-	wg         sync.WaitGroup
+	listeners      []config.ListenerConfig
+	execPath       string
+	configPath     string
+	bindBestEffort bool
+	maxConns       int64
+	overloadMsg    string
+	share          *connShare // nil = no per-network cap
+	temp           config.TempConfig
+	active         atomic.Int64 // running protocol-handler subprocesses
+	refused        atomic.Int64 // connections refused with the overload reply
+	unreported     atomic.Int64 // subprocesses that exited without a report
+	maintenance    atomic.Bool  // new sessions refuse MAIL; see SetMaintenance
+	warmupUntil    time.Time    // new sessions defer unknown recipients until then
+	totalsMu       sync.Mutex
+	totals         metrics.Totals
+	rejections     *rejectionLog                // merged from handler reports; nil = disabled
+	collector      *metrics.PrometheusCollector // exports handler counters; nil = disabled
+	logger         *slog.Logger
+	wg             sync.WaitGroup
+}
+
+// SubprocessServerConfig holds configuration for creating a SubprocessServer.
+type SubprocessServerConfig struct {
+	Listeners []config.ListenerConfig
+	// ExecPath is the path to the smtpd binary (use os.Executable()).
+	ExecPath string
+	// ConfigPath is passed to each subprocess as the --config flag value.
+	ConfigPath string
+	// BindBestEffort keeps the server running when some (but not all)
+	// listeners fail to bind. When false, any bind failure is fatal.
+	BindBestEffort bool
+	// MaxConnections caps concurrent protocol-handler subprocesses. Excess
+	// connections get the overload reply. 0 means unlimited.
+	MaxConnections int
+	// MaxConnShare is the fraction of MaxConnections one client network
+	// may hold; its further connections get 421 4.7.0. 0 means no cap.
+	MaxConnShare float64
+	// ConnSharePrefix is the IPv4 prefix length MaxConnShare groups
+	// clients by; 0 means 32. IPv6 clients are grouped by /64.
+	ConnSharePrefix int
+	// OverloadMessage overrides the text of the 421 4.3.2 overload reply.
+	OverloadMessage string
+	// Rejections is how many of the most recent rejections, across all
+	// protocol-handlers, RecentRejections returns. 0 keeps none.
+	Rejections int
+	// Warmup is how long after the server is created its protocol-handlers
+	// answer unknown recipients with 451 rather than 550, while
+	// session-manager may not know every user yet. 0 means never.
+	Warmup time.Duration
+	// Collector, if set, is where the counters in each HandlerReport are
+	// added, so the listener's metrics server exports them.
+	Collector *metrics.PrometheusCollector
+	// Temp is where protocol-handlers buffer messages; the server sweeps
+	// buffers left by crashed handlers at startup and periodically.
+	Temp   config.TempConfig
+	Logger *slog.Logger
 }
 
 // NewSubprocessServer creates a SubprocessServer.
-// execPath is the path to the smtpd binary (use os.Executable()).
-// configPath is passed to each subprocess as the --config flag value.
-func NewSubprocessServer(listeners []config.ListenerConfig, execPath, configPath string, logger *slog.Logger) *SubprocessServer {
+func NewSubprocessServer(cfg SubprocessServerConfig) *SubprocessServer {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var warmupUntil time.Time
+	if cfg.Warmup > 0 {
+		warmupUntil = time.Now().Add(cfg.Warmup)
+	}
+
 	return &SubprocessServer{
-		listeners:  listeners,
-		execPath:   execPath,
-		configPath: configPath,
-		logger:     logger,
+		listeners:      cfg.Listeners,
+		execPath:       cfg.ExecPath,
+		configPath:     cfg.ConfigPath,
+		bindBestEffort: cfg.BindBestEffort,
+		maxConns:       int64(cfg.MaxConnections),
+		overloadMsg:    cfg.OverloadMessage,
+		share:          newConnShare(cfg.MaxConnections, cfg.MaxConnShare, cfg.ConnSharePrefix),
+		temp:           cfg.Temp,
+		warmupUntil:    warmupUntil,
+		rejections:     newRejectionLog(cfg.Rejections),
+		collector:      cfg.Collector,
+		logger:         logger,
 	}
 }
 
+// SetMaintenance switches maintenance mode for connections accepted from
+// now on: their protocol-handlers greet and answer EHLO but refuse MAIL
+// with 421 (see Backend.SetMaintenance). Sessions already running are left
+// to finish, so turning it on drains the server without losing mail.
+func (s *SubprocessServer) SetMaintenance(on bool) {
+	s.maintenance.Store(on)
+}
+
+// Maintenance reports whether maintenance mode is on.
+func (s *SubprocessServer) Maintenance() bool {
+	return s.maintenance.Load()
+}
+
+// boundListener pairs a bound net.Listener with the config that produced it.
+type boundListener struct {
+	ln net.Listener
+	lc config.ListenerConfig
+}
+
 // Run starts accept loops on all configured ports and blocks until ctx is cancelled.
 func (s *SubprocessServer) Run(ctx context.Context) error {
-	lns := make([]net.Listener, 0, len(s.listeners))
-	for _, lc := range s.listeners {
-		ln, err := net.Listen("tcp", lc.Address)
-		if err != nil {
-			for _, l := range lns {
-				_ = l.Close()
-			}
-			return fmt.Errorf("listen %s: %w", lc.Address, err)
-		}
-		lns = append(lns, ln)
-		s.logger.Info("listening (subprocess mode)",
-			slog.String("address", lc.Address),
-			slog.String("mode", string(lc.Mode)))
+	bound, err := s.bindListeners()
+	if err != nil {
+		return err
 	}
 
-	for i, ln := range lns {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		runTempSweeper(ctx, s.temp.Dir, s.temp.GetMaxAge(), s.temp.GetSweepInterval(), s.logger)
+	}()
+
+	for _, b := range bound {
 		s.wg.Add(1)
-		go func(ln net.Listener, lc config.ListenerConfig) {
+		go func(b boundListener) {
 			defer s.wg.Done()
-			s.acceptLoop(ctx, ln, lc)
-		}(ln, s.listeners[i])
+			s.acceptLoop(ctx, b.ln, b.lc)
+		}(b)
 	}
 
 	<-ctx.Done()
 	s.logger.Info("shutting down subprocess server")
-	for _, ln := range lns {
-		_ = ln.Close()
+	for _, b := range bound {
+		_ = b.ln.Close()
 	}
 	s.wg.Wait()
 	return ctx.Err()
 }
 
+// bindListeners binds every configured listener address. In fail-fast mode
+// (the default) the first bind error closes any already-bound listeners and
+// is returned. In best-effort mode bind errors are logged and skipped; an
+// error is returned only if no listener could be bound at all.
+func (s *SubprocessServer) bindListeners() ([]boundListener, error) {
+	bound := make([]boundListener, 0, len(s.listeners))
+	var bindErrs []error
+
+	for _, lc := range s.listeners {
+		ln, err := net.Listen("tcp", lc.Address)
+		if err != nil {
+			err = fmt.Errorf("listen %s: %w", lc.Address, err)
+			if !s.bindBestEffort {
+				for _, b := range bound {
+					_ = b.ln.Close()
+				}
+				return nil, err
+			}
+			s.logger.Error("listener failed to bind, continuing (bind_best_effort)",
+				slog.String("address", lc.Address),
+				slog.String("mode", string(lc.Mode)),
+				slog.String("error", err.Error()))
+			bindErrs = append(bindErrs, err)
+			continue
+		}
+		bound = append(bound, boundListener{ln: ln, lc: lc})
+		s.logger.Info("listening (subprocess mode)",
+			slog.String("address", lc.Address),
+			slog.String("mode", string(lc.Mode)))
+	}
+
+	if len(bound) == 0 && len(bindErrs) > 0 {
+		return nil, fmt.Errorf("no listeners could be bound: %w", errors.Join(bindErrs...))
+	}
+	return bound, nil
+}
+
 func (s *SubprocessServer) acceptLoop(ctx context.Context, ln net.Listener, lc config.ListenerConfig) {
 	for {
 		conn, err := ln.Accept()
@@ -92,14 +225,94 @@ func (s *SubprocessServer) acceptLoop(ctx context.Context, ln net.Listener, lc c
 				return
 			}
 		}
+
+		// A connection accepted while shutting down is refused rather than
+		// dropped, so the client knows to retry elsewhere or later.
+		if ctx.Err() != nil {
+			s.refused.Add(1)
+			rejectOverloaded(conn, s.overloadMsg)
+			return
+		}
+
+		if !s.acquire() {
+			s.logger.Warn("connection limit reached, refusing connection",
+				slog.String("client_ip", extractIPFromConn(conn)),
+				slog.Int64("max_connections", s.maxConns))
+			s.refused.Add(1)
+			rejectOverloaded(conn, s.overloadMsg)
+			continue
+		}
 		go s.spawnHandler(conn, lc)
 	}
 }
 
+// acquire reserves a subprocess slot, reporting false if MaxConnections
+// subprocesses are already running. Each successful acquire must be paired
+// with a release.
+func (s *SubprocessServer) acquire() bool {
+	n := s.active.Add(1)
+	if s.maxConns > 0 && n > s.maxConns {
+		s.active.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release frees a slot reserved by acquire.
+func (s *SubprocessServer) release() {
+	s.active.Add(-1)
+}
+
 // spawnHandler passes conn to a protocol-handler subprocess and reaps it asynchronously.
+// It owns the slot reserved by acquire and releases it when the subprocess
+// exits or fails to start. The per-network share is checked here rather
+// than in acceptLoop, once a PROXY header has given the real client.
 func (s *SubprocessServer) spawnHandler(conn net.Conn, lc config.ListenerConfig) {
+	started := false
+	var network netip.Prefix
+	defer func() {
+		if !started {
+			s.share.release(network)
+			s.release()
+		}
+	}()
+
 	clientIP := extractIPFromConn(conn)
 
+	// Listeners behind a load balancer receive a PROXY header carrying the
+	// real client address. Only trusted listeners parse it; elsewhere the
+	// header reaches go-smtp as an unknown command and is rejected.
+	var clientAddr string
+	if lc.TrustedProxy {
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		addr, err := readProxyHeader(conn)
+		_ = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			s.logger.Warn("rejecting connection with invalid PROXY header",
+				slog.String("proxy_ip", clientIP),
+				slog.String("address", lc.Address),
+				slog.String("error", err.Error()))
+			_ = conn.Close()
+			return
+		}
+		if addr != nil {
+			clientAddr = addr.String()
+			clientIP = addr.IP.String()
+		}
+	}
+
+	network, ok := s.share.acquire(clientIP)
+	if !ok {
+		s.logger.Warn("connection share reached, refusing connection",
+			slog.String("client_ip", clientIP),
+			slog.String("network", network.String()),
+			slog.Int("max_per_network", s.share.max))
+		network = netip.Prefix{} // not taken, so not released
+		s.refused.Add(1)
+		rejectConn(conn, errConnShare)
+		return
+	}
+
 	tcpConn, ok := conn.(*net.TCPConn)
 	if !ok {
 		s.logger.Error("cannot pass non-TCP connection to subprocess",
@@ -119,15 +332,38 @@ func (s *SubprocessServer) spawnHandler(conn net.Conn, lc config.ListenerConfig)
 	// Parent relinquishes its copy of the socket; subprocess owns it.
 	_ = conn.Close()
 
+	reportR, reportW, err := os.Pipe()
+	if err != nil {
+		s.logger.Error("failed to create report pipe", slog.String("error", err.Error()))
+		_ = connFile.Close()
+		return
+	}
+
 	cmd := exec.Command(s.execPath, "protocol-handler", "--config", s.configPath)
-	cmd.ExtraFiles = []*os.File{connFile} // becomes fd 3 in the child
+	cmd.ExtraFiles = []*os.File{connFile, reportW} // fd 3 and fd 4 in the child
 	cmd.Env = append(
 		[]string{
 			"SMTPD_CLIENT_IP=" + clientIP,
 			"SMTPD_LISTENER_MODE=" + string(lc.Mode),
+			"SMTPD_REPORT=1",
 		},
 		inheritEnv("PATH", "HOME", "USER", "TMPDIR", "TMP", "TEMP")...,
 	)
+	if clientAddr != "" {
+		cmd.Env = append(cmd.Env, "SMTPD_CLIENT_ADDR="+clientAddr)
+	}
+	if lc.Mode != config.ModeSmtps {
+		cmd.Env = append(cmd.Env, "SMTPD_TLS_POLICY="+string(lc.GetTLSPolicy()))
+	}
+	if lc.Honeypot {
+		cmd.Env = append(cmd.Env, "SMTPD_HONEYPOT=1")
+	}
+	if s.maintenance.Load() {
+		cmd.Env = append(cmd.Env, "SMTPD_MAINTENANCE=1")
+	}
+	if time.Now().Before(s.warmupUntil) {
+		cmd.Env = append(cmd.Env, "SMTPD_WARMUP=1")
+	}
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Start(); err != nil {
@@ -135,9 +371,13 @@ func (s *SubprocessServer) spawnHandler(conn net.Conn, lc config.ListenerConfig)
 			slog.String("client_ip", clientIP),
 			slog.String("error", err.Error()))
 		_ = connFile.Close()
+		_ = reportR.Close()
+		_ = reportW.Close()
 		return
 	}
 	_ = connFile.Close() // child has the fd; parent closes its dup
+	_ = reportW.Close()  // EOF on reportR once the child exits
+	started = true
 
 	pid := cmd.Process.Pid
 	s.logger.Debug("spawned protocol-handler",
@@ -147,6 +387,9 @@ func (s *SubprocessServer) spawnHandler(conn net.Conn, lc config.ListenerConfig)
 
 	// Reap the subprocess asynchronously to avoid zombies.
 	go func() {
+		defer s.release()
+		defer s.share.release(network)
+		s.collectReport(reportR)
 		if err := cmd.Wait(); err != nil {
 			s.logger.Debug("protocol-handler exited with error",
 				slog.Int("pid", pid),
@@ -157,6 +400,69 @@ func (s *SubprocessServer) spawnHandler(conn net.Conn, lc config.ListenerConfig)
 	}()
 }
 
+// HandlerReport is what a protocol-handler writes to the report pipe when
+// its session ends.
+type HandlerReport struct {
+	metrics.Totals
+	Rejections []Rejection      `json:"rejections,omitempty"`
+	Counters   metrics.Counters `json:"counters"`
+}
+
+// collectReport reads the report a protocol-handler writes when its session
+// ends, adds its totals to the server's lifetime totals, its rejections
+// to the server's log and its counters to the server's collector. A subprocess that exits without writing one, e.g.
+// because it crashed, is counted as unreported.
+func (s *SubprocessServer) collectReport(r *os.File) {
+	defer func() { _ = r.Close() }()
+	var report HandlerReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		s.unreported.Add(1)
+		return
+	}
+	s.totalsMu.Lock()
+	s.totals.Add(report.Totals)
+	s.totalsMu.Unlock()
+	for _, rej := range report.Rejections {
+		s.rejections.add(rej)
+	}
+	if s.collector != nil {
+		s.collector.AddCounters(report.Counters)
+	}
+}
+
+// RecentRejections returns the last rejections reported by protocol-handlers,
+// oldest first, up to SubprocessServerConfig.Rejections.
+func (s *SubprocessServer) RecentRejections() []Rejection {
+	return s.rejections.list()
+}
+
+// Report describes what the server has handled since it started.
+type Report struct {
+	metrics.Totals
+	// ConnectionsRefused counts connections answered with the overload
+	// or connection-share reply instead of being handed to a subprocess.
+	ConnectionsRefused int64 `json:"connections_refused"`
+	// HandlersUnreported counts subprocesses that exited without reporting
+	// their totals; their sessions are missing from Totals.
+	HandlersUnreported int64 `json:"handlers_unreported"`
+	// HandlersRunning counts subprocesses still serving a session, whose
+	// totals are not yet included.
+	HandlersRunning int64 `json:"handlers_running"`
+}
+
+// Report returns the totals of every protocol-handler that has exited so far.
+func (s *SubprocessServer) Report() Report {
+	s.totalsMu.Lock()
+	totals := s.totals
+	s.totalsMu.Unlock()
+	return Report{
+		Totals:             totals,
+		ConnectionsRefused: s.refused.Load(),
+		HandlersUnreported: s.unreported.Load(),
+		HandlersRunning:    s.active.Load(),
+	}
+}
+
 // inheritEnv returns "KEY=VALUE" strings for the named env vars that are set.
 func inheritEnv(keys ...string) []string {
 	var env []string
@@ -0,0 +1,564 @@
+package smtp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// freeAddr returns a loopback address with a currently unused port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+// occupiedAddr binds a loopback port for the duration of the test and returns its address.
+func occupiedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("occupy port: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln.Addr().String()
+}
+
+func TestSubprocessServer_BindFailFast(t *testing.T) {
+	busy := occupiedAddr(t)
+	free := freeAddr(t)
+
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners: []config.ListenerConfig{
+			{Address: busy, Mode: config.ModeSmtp},
+			{Address: free, Mode: config.ModeSubmission},
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), busy) {
+			t.Fatalf("expected bind error for %s, got %v", busy, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not fail fast on bind error")
+	}
+
+	// The listener that did bind must have been released.
+	ln, err := net.Listen("tcp", free)
+	if err != nil {
+		t.Fatalf("free address still held after fail-fast: %v", err)
+	}
+	_ = ln.Close()
+}
+
+func TestSubprocessServer_BindBestEffort(t *testing.T) {
+	busy := occupiedAddr(t)
+	free := freeAddr(t)
+
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners: []config.ListenerConfig{
+			{Address: busy, Mode: config.ModeSmtp},
+			{Address: free, Mode: config.ModeSubmission},
+		},
+		BindBestEffort: true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+
+	// The successfully-bound listener should come up.
+	var conn net.Conn
+	var err error
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err = net.DialTimeout("tcp", free, 100*time.Millisecond)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("best-effort listener not reachable: %v", err)
+	}
+	// Close before the accept loop tries to hand the conn to a subprocess.
+	_ = conn.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop in time")
+	}
+}
+
+func TestSubprocessServer_BindBestEffort_AllFail(t *testing.T) {
+	busy := occupiedAddr(t)
+
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners: []config.ListenerConfig{
+			{Address: busy, Mode: config.ModeSmtp},
+		},
+		BindBestEffort: true,
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error when no listener could be bound")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return when every listener failed to bind")
+	}
+}
+
+// readGreeting reads the first line sent on conn.
+func readGreeting(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read greeting: %v", err)
+	}
+	return line
+}
+
+func TestSubprocessServer_ConnectionCap(t *testing.T) {
+	// A stand-in protocol-handler that holds its slot for a while.
+	handler := filepath.Join(t.TempDir(), "handler.sh")
+	if err := os.WriteFile(handler, []byte("#!/bin/sh\nexec sleep 2\n"), 0o755); err != nil {
+		t.Fatalf("write handler: %v", err)
+	}
+
+	addr := freeAddr(t)
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners:      []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+		ExecPath:       handler,
+		MaxConnections: 1,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Run(ctx) }()
+
+	var first net.Conn
+	var err error
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		first, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = first.Close() }()
+
+	// Wait until the first connection holds the only slot.
+	for srv.active.Load() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = second.Close() }()
+
+	if got, want := readGreeting(t, second), "421 4.3.2 System not accepting network messages\r\n"; got != want {
+		t.Errorf("over-cap reply = %q, want %q", got, want)
+	}
+}
+
+func TestSubprocessServer_ConnectionShare(t *testing.T) {
+	handler := filepath.Join(t.TempDir(), "handler.sh")
+	if err := os.WriteFile(handler, []byte("#!/bin/sh\nexec sleep 2\n"), 0o755); err != nil {
+		t.Fatalf("write handler: %v", err)
+	}
+
+	addr := freeAddr(t)
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners:      []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+		ExecPath:       handler,
+		MaxConnections: 4,
+		MaxConnShare:   0.5, // two per client
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Run(ctx) }()
+
+	dialFrom := func(ip string) net.Conn {
+		t.Helper()
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}, Timeout: 100 * time.Millisecond}
+		var conn net.Conn
+		var err error
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if conn, err = d.Dial("tcp", addr); err == nil {
+				t.Cleanup(func() { _ = conn.Close() })
+				return conn
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("dial from %s: %v", ip, err)
+		return nil
+	}
+	waitActive := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for srv.active.Load() < n && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := srv.active.Load(); got != n {
+			t.Fatalf("active = %d, want %d", got, n)
+		}
+	}
+
+	// One client takes its share of the budget.
+	dialFrom("127.0.0.1")
+	dialFrom("127.0.0.1")
+	waitActive(2)
+
+	flood := dialFrom("127.0.0.1")
+	if got, want := readGreeting(t, flood), "421 4.7.0 Too many connections from your network\r\n"; got != want {
+		t.Errorf("over-share reply = %q, want %q", got, want)
+	}
+
+	// Another client still gets a slot.
+	dialFrom("127.0.0.2")
+	waitActive(3)
+	if got := srv.Report().ConnectionsRefused; got != 1 {
+		t.Errorf("ConnectionsRefused = %d, want 1", got)
+	}
+}
+
+// chanListener is a net.Listener fed from a channel.
+type chanListener struct {
+	conns chan net.Conn
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return c, nil
+}
+func (l *chanListener) Close() error   { return nil }
+func (l *chanListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestSubprocessServer_RefusesDuringShutdown(t *testing.T) {
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		OverloadMessage: "Shutting down",
+	})
+
+	server, client := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	ln := &chanListener{conns: make(chan net.Conn, 1)}
+	ln.conns <- server
+	close(ln.conns)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		srv.acceptLoop(ctx, ln, config.ListenerConfig{Mode: config.ModeSmtp})
+		close(done)
+	}()
+
+	if got, want := readGreeting(t, client), "421 4.3.2 Shutting down\r\n"; got != want {
+		t.Errorf("shutdown reply = %q, want %q", got, want)
+	}
+	<-done
+}
+
+// startRecordingServer runs a SubprocessServer whose stand-in protocol-handler
+// records the client address it was given, and returns the listener address
+// and the record file.
+func startRecordingServer(t *testing.T, trusted bool) (addr, record string) {
+	t.Helper()
+	dir := t.TempDir()
+	record = filepath.Join(dir, "env")
+	handler := filepath.Join(dir, "handler.sh")
+	script := "#!/bin/sh\necho \"$SMTPD_CLIENT_IP|$SMTPD_CLIENT_ADDR\" > " + record + ".tmp && mv " + record + ".tmp " + record + "\n"
+	if err := os.WriteFile(handler, []byte(script), 0o755); err != nil {
+		t.Fatalf("write handler: %v", err)
+	}
+
+	addr = freeAddr(t)
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners: []config.ListenerConfig{
+			{Address: addr, Mode: config.ModeSmtp, TrustedProxy: trusted},
+		},
+		ExecPath: handler,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = srv.Run(ctx) }()
+	return addr, record
+}
+
+func TestSubprocessServer_Maintenance(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "env")
+	handler := filepath.Join(dir, "handler.sh")
+	script := "#!/bin/sh\necho \"m=$SMTPD_MAINTENANCE\" > " + record + ".tmp && mv " + record + ".tmp " + record + "\n"
+	if err := os.WriteFile(handler, []byte(script), 0o755); err != nil {
+		t.Fatalf("write handler: %v", err)
+	}
+
+	addr := freeAddr(t)
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners: []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+		ExecPath:  handler,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = srv.Run(ctx) }()
+
+	for _, on := range []bool{true, false} {
+		srv.SetMaintenance(on)
+		if srv.Maintenance() != on {
+			t.Fatalf("Maintenance() = %v after SetMaintenance(%v)", !on, on)
+		}
+		_ = os.Remove(record)
+		conn := dialRetry(t, addr)
+		want := "m="
+		if on {
+			want = "m=1"
+		}
+		if got := waitRecord(t, record); got != want {
+			t.Errorf("maintenance %v: handler env %q, want %q", on, got, want)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestSubprocessServer_Warmup(t *testing.T) {
+	for _, tt := range []struct {
+		warmup time.Duration
+		want   string
+	}{
+		{time.Hour, "w=1"},
+		{0, "w="},
+	} {
+		dir := t.TempDir()
+		record := filepath.Join(dir, "env")
+		handler := filepath.Join(dir, "handler.sh")
+		script := "#!/bin/sh\necho \"w=$SMTPD_WARMUP\" > " + record + ".tmp && mv " + record + ".tmp " + record + "\n"
+		if err := os.WriteFile(handler, []byte(script), 0o755); err != nil {
+			t.Fatalf("write handler: %v", err)
+		}
+
+		addr := freeAddr(t)
+		srv := NewSubprocessServer(SubprocessServerConfig{
+			Listeners: []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+			ExecPath:  handler,
+			Warmup:    tt.warmup,
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go func() { _ = srv.Run(ctx) }()
+
+		conn := dialRetry(t, addr)
+		if got := waitRecord(t, record); got != tt.want {
+			t.Errorf("warmup %v: handler env %q, want %q", tt.warmup, got, tt.want)
+		}
+		_ = conn.Close()
+	}
+}
+
+// dialRetry dials addr until the listener is up.
+func dialRetry(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dial %s: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitRecord waits for the stand-in handler to write its record file.
+func waitRecord(t *testing.T, record string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(record); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("protocol-handler was not started")
+	return ""
+}
+
+func TestSubprocessServer_TrustedProxy(t *testing.T) {
+	addr, record := startRecordingServer(t, true)
+
+	conn := dialRetry(t, addr)
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n")); err != nil {
+		t.Fatalf("write PROXY header: %v", err)
+	}
+
+	if got, want := waitRecord(t, record), "203.0.113.7|203.0.113.7:40000"; got != want {
+		t.Errorf("handler env = %q, want %q", got, want)
+	}
+}
+
+func TestSubprocessServer_TrustedProxy_RequiresHeader(t *testing.T) {
+	addr, record := startRecordingServer(t, true)
+
+	conn := dialRetry(t, addr)
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("EHLO spoofer.example\r\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// The connection is dropped without spawning a handler.
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected connection to be closed")
+	}
+	if _, err := os.Stat(record); err == nil {
+		t.Error("handler started for connection without PROXY header")
+	}
+}
+
+func TestSubprocessServer_UntrustedListenerIgnoresProxy(t *testing.T) {
+	addr, record := startRecordingServer(t, false)
+
+	conn := dialRetry(t, addr)
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 40000 25\r\n")); err != nil {
+		t.Fatalf("write PROXY header: %v", err)
+	}
+
+	// The spoofed address is not applied; the handler sees the real peer.
+	if got, want := waitRecord(t, record), "127.0.0.1|"; got != want {
+		t.Errorf("handler env = %q, want %q", got, want)
+	}
+}
+
+func TestSubprocessServer_Report(t *testing.T) {
+	// Stand-in protocol-handlers report one session each, as the real one
+	// does on fd 4; the last connection's handler crashes without a report.
+	dir := t.TempDir()
+	handler := filepath.Join(dir, "handler.sh")
+	script := `#!/bin/sh
+if [ -e ` + dir + `/crash ]; then exit 1; fi
+echo '{"connections":1,"messages_accepted":2,"messages_rejected":1,"auth_successes":1,"auth_failures":0,"bytes_delivered":300,"rejections":[{"client_ip":"192.0.2.1","command":"RCPT","code":550,"reason":"User unknown"}],"counters":{"transactions_aborted":{"reset":1},"filter_decisions":{"fcrdns":{"reject":2}}}}' >&4
+`
+	if err := os.WriteFile(handler, []byte(script), 0o755); err != nil {
+		t.Fatalf("write handler: %v", err)
+	}
+
+	addr := freeAddr(t)
+	reg := prometheus.NewRegistry()
+	srv := NewSubprocessServer(SubprocessServerConfig{
+		Listeners:  []config.ListenerConfig{{Address: addr, Mode: config.ModeSmtp}},
+		ExecPath:   handler,
+		Rejections: 2,
+		Collector:  metrics.NewPrometheusCollector(reg),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Run(ctx) }()
+
+	// waitReaped waits until n handlers have been reaped.
+	waitReaped := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			r := srv.Report()
+			if r.Connections+r.HandlersUnreported >= n && r.HandlersRunning == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("handlers not reaped: %+v", srv.Report())
+	}
+
+	for i := 0; i < 3; i++ {
+		conn := dialRetry(t, addr)
+		_ = conn.Close()
+		waitReaped(int64(i + 1))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "crash"), nil, 0o644); err != nil {
+		t.Fatalf("write crash marker: %v", err)
+	}
+	conn := dialRetry(t, addr)
+	_ = conn.Close()
+	waitReaped(4)
+
+	got := srv.Report()
+	want := Report{
+		Totals: metrics.Totals{
+			Connections:      3,
+			MessagesAccepted: 6,
+			MessagesRejected: 3,
+			AuthSuccesses:    3,
+			BytesDelivered:   900,
+		},
+		HandlersUnreported: 1,
+	}
+	if got != want {
+		t.Errorf("Report() = %+v, want %+v", got, want)
+	}
+	// Three handlers reported one rejection each; two are kept.
+	if rej := srv.RecentRejections(); len(rej) != 2 || rej[0].Reason != "User unknown" || rej[0].Code != 550 {
+		t.Errorf("RecentRejections() = %+v, want two 550 User unknown", rej)
+	}
+	// Their counters are added to the exported series.
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	exported := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			exported[mf.GetName()] += m.GetCounter().GetValue()
+		}
+	}
+	if n := exported["smtpd_transactions_aborted_total"]; n != 3 {
+		t.Errorf("smtpd_transactions_aborted_total = %v, want 3", n)
+	}
+	if n := exported["smtpd_filter_decisions_total"]; n != 6 {
+		t.Errorf("smtpd_filter_decisions_total = %v, want 6", n)
+	}
+}
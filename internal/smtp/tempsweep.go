@@ -0,0 +1,113 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempFilePrefix names the message buffers newTempBuffer creates, so the
+// sweep never touches anything else in a shared temp directory.
+const tempFilePrefix = "smtp-msg-"
+
+// tempDayLayout names the per-day subdirectories of a configured temp dir.
+const tempDayLayout = "2006-01-02"
+
+// tempDayDir returns the subdirectory of dir that buffers created at t go in.
+func tempDayDir(dir string, t time.Time) string {
+	return filepath.Join(dir, t.Format(tempDayLayout))
+}
+
+// sweepTempDir removes message buffers under dir, and in its per-day
+// subdirectories, last written more than maxAge before now. A buffer in
+// use is written as the message arrives and removed when its session
+// ends, so anything that old was left by a protocol-handler that crashed.
+// Day directories older than yesterday are removed once empty; yesterday's
+// is kept for a handler that picked it just before midnight. It returns
+// the number of buffers removed.
+func sweepTempDir(dir string, maxAge time.Duration, now time.Time, logger *slog.Logger) int {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Debug("temp sweep: read dir failed", slog.String("dir", dir), slog.String("error", err.Error()))
+		return 0
+	}
+
+	cutoff := now.Add(-maxAge)
+	yesterday := now.AddDate(0, 0, -1).Format(tempDayLayout)
+	removed := 0
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			if _, err := time.Parse(tempDayLayout, e.Name()); err != nil {
+				continue
+			}
+			removed += sweepTempFiles(path, cutoff, logger)
+			if e.Name() < yesterday {
+				_ = os.Remove(path) // fails while not empty
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Name(), tempFilePrefix) && removeStale(path, cutoff, logger) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// sweepTempFiles removes the stale buffers directly in dir.
+func sweepTempFiles(dir string, cutoff time.Time, logger *slog.Logger) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), tempFilePrefix) &&
+			removeStale(filepath.Join(dir, e.Name()), cutoff, logger) {
+			removed++
+		}
+	}
+	return removed
+}
+
+func removeStale(path string, cutoff time.Time, logger *slog.Logger) bool {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+		return false
+	}
+	if err := os.Remove(path); err != nil {
+		logger.Warn("temp sweep: remove failed", slog.String("file", path), slog.String("error", err.Error()))
+		return false
+	}
+	return true
+}
+
+// runTempSweeper sweeps dir at once and then every interval until ctx is
+// done.
+func runTempSweeper(ctx context.Context, dir string, maxAge, interval time.Duration, logger *slog.Logger) {
+	sweep := func() {
+		if n := sweepTempDir(dir, maxAge, time.Now(), logger); n > 0 {
+			logger.Info("removed stale message buffers",
+				slog.String("dir", dir),
+				slog.Int("files", n))
+		}
+	}
+	sweep()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package smtp
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSweepTempDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+
+	write := func(path string, mtime time.Time) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staleFlat := filepath.Join(dir, "smtp-msg-1")
+	liveFlat := filepath.Join(dir, "smtp-msg-2")
+	foreign := filepath.Join(dir, "other-file")
+	staleDay := filepath.Join(tempDayDir(dir, old), "smtp-msg-3")
+	liveToday := filepath.Join(tempDayDir(dir, now), "smtp-msg-4")
+	staleYesterday := filepath.Join(tempDayDir(dir, now.AddDate(0, 0, -1)), "smtp-msg-5")
+	write(staleFlat, old)
+	write(liveFlat, now.Add(-time.Minute))
+	write(foreign, old)
+	write(staleDay, old)
+	write(liveToday, now)
+	write(staleYesterday, old)
+
+	if n := sweepTempDir(dir, 24*time.Hour, now, slog.Default()); n != 3 {
+		t.Errorf("sweepTempDir() removed %d, want 3", n)
+	}
+
+	for _, p := range []string{staleFlat, staleDay, staleYesterday, tempDayDir(dir, old)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s survived the sweep", p)
+		}
+	}
+	for _, p := range []string{liveFlat, foreign, liveToday, tempDayDir(dir, now.AddDate(0, 0, -1))} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s was swept: %v", p, err)
+		}
+	}
+}
+
+func TestNewTempBuffer_DayDir(t *testing.T) {
+	dir := t.TempDir()
+	tmp := newTempBuffer(dir)
+	defer tmp.cleanup()
+
+	f, ok := tmp.(*fileTempBuf)
+	if !ok {
+		t.Fatalf("newTempBuffer() = %T, want a file buffer", tmp)
+	}
+	if got, want := filepath.Dir(f.f.Name()), tempDayDir(dir, time.Now()); got != want {
+		t.Errorf("buffer created in %s, want %s", got, want)
+	}
+}
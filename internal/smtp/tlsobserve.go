@@ -0,0 +1,227 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/metrics"
+)
+
+// TLS handshake failure reasons reported to metrics.Collector.TLSHandshakeFailed.
+const (
+	// tlsFailVersionTooLow is a client whose newest TLS version is below
+	// [server.tls] min_version, counted apart so the impact of raising the
+	// minimum can be measured before enforcing it.
+	tlsFailVersionTooLow = "tls_version_too_low"
+	tlsFailVersion       = "version"
+	tlsFailCipher        = "cipher"
+	tlsFailCert          = "cert"
+	tlsFailOther         = "other"
+	// tlsFailALPN is a client offering only non-mail ALPN protocols (e.g. a
+	// browser sending h2 to port 465), refused under [smtpd.tls] reject_alpn.
+	tlsFailALPN = "alpn"
+)
+
+// tlsObserver records the outcome of TLS handshakes on one connection.
+//
+// go-smtp performs the STARTTLS handshake itself and discards the error, so
+// the observer hooks the server's tls.Config instead: GetConfigForClient sees
+// the ClientHello and counts version or cipher mismatches (the handshake then
+// fails as usual). Any other STARTTLS failure is inferred: a handshake that
+// saw a ClientHello but was never followed by a command over TLS is counted
+// when the connection ends.
+//
+// With rejectALPN set the observer also refuses a ClientHello whose ALPN
+// list does not include "smtp", aborting the handshake.
+type tlsObserver struct {
+	collector  metrics.Collector
+	logger     *slog.Logger
+	rejectALPN bool
+
+	mu      sync.Mutex
+	pending bool // ClientHello received, outcome not yet recorded
+	counted bool // failure already recorded
+}
+
+func newTLSObserver(collector metrics.Collector, logger *slog.Logger, rejectALPN bool) *tlsObserver {
+	if collector == nil {
+		collector = &metrics.NoopCollector{}
+	}
+	return &tlsObserver{collector: collector, logger: logger, rejectALPN: rejectALPN}
+}
+
+// smtpALPN is the protocol a client may offer when rejectALPN is set.
+const smtpALPN = "smtp"
+
+// wrap returns a copy of base with the observer's ClientHello hook installed.
+// A GetConfigForClient hook already present on base is still called.
+func (o *tlsObserver) wrap(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	if o.rejectALPN && len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{smtpALPN}
+	}
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if reason := incompatibleHello(base, hello); reason != "" {
+			o.failed(reason, helloError(base, hello, reason))
+		} else if o.rejectALPN && len(hello.SupportedProtos) > 0 && !slices.Contains(hello.SupportedProtos, smtpALPN) {
+			err := fmt.Errorf("client offers ALPN %s", strings.Join(hello.SupportedProtos, ","))
+			o.failed(tlsFailALPN, err)
+			return nil, err
+		} else {
+			o.mu.Lock()
+			o.pending = true
+			o.mu.Unlock()
+		}
+		if base.GetConfigForClient != nil {
+			return base.GetConfigForClient(hello)
+		}
+		return nil, nil
+	}
+	return cfg
+}
+
+// established marks the pending handshake as successful.
+func (o *tlsObserver) established() {
+	o.mu.Lock()
+	o.pending = false
+	o.mu.Unlock()
+}
+
+// observingBackend marks the STARTTLS handshake as successful once go-smtp
+// opens a session over the upgraded connection, i.e. the client sent a
+// command over TLS.
+type observingBackend struct {
+	gosmtp.Backend
+	obs *tlsObserver
+}
+
+func (b *observingBackend) NewSession(c *gosmtp.Conn) (gosmtp.Session, error) {
+	if _, ok := c.TLSConnectionState(); ok {
+		b.obs.established()
+	}
+	return b.Backend.NewSession(c)
+}
+
+// handshakeError records a failure returned by an explicit Handshake call,
+// unless the ClientHello check has already recorded it.
+func (o *tlsObserver) handshakeError(err error) {
+	o.mu.Lock()
+	counted := o.counted
+	o.mu.Unlock()
+	if !counted {
+		o.failed(classifyTLSError(err), err)
+	}
+}
+
+// finish records a handshake that started but never completed, e.g. a
+// STARTTLS client that rejected the certificate and hung up.
+func (o *tlsObserver) finish() {
+	o.mu.Lock()
+	pending := o.pending
+	o.mu.Unlock()
+	if pending {
+		o.failed(tlsFailOther, errors.New("handshake did not complete"))
+	}
+}
+
+func (o *tlsObserver) failed(reason string, err error) {
+	o.mu.Lock()
+	o.pending = false
+	o.counted = true
+	o.mu.Unlock()
+	o.collector.TLSHandshakeFailed(reason)
+	o.logger.Info("TLS handshake failed",
+		slog.String("reason", reason),
+		slog.String("error", err.Error()))
+}
+
+// incompatibleHello returns tlsFailVersion or tlsFailCipher if the client's
+// ClientHello cannot be satisfied by cfg, or "" if a handshake can proceed.
+func incompatibleHello(cfg *tls.Config, hello *tls.ClientHelloInfo) string {
+	minVer, maxVer := versionRange(cfg)
+
+	var best uint16
+	for _, v := range hello.SupportedVersions {
+		if v >= minVer && v <= maxVer && v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		if newest := newestVersion(hello); newest != 0 && newest < minVer {
+			return tlsFailVersionTooLow
+		}
+		return tlsFailVersion
+	}
+	if best == tls.VersionTLS13 {
+		return "" // TLS 1.3 suites are not configurable and always available
+	}
+
+	suites := cfg.CipherSuites
+	if len(suites) == 0 {
+		for _, s := range tls.CipherSuites() {
+			suites = append(suites, s.ID)
+		}
+	}
+	for _, id := range hello.CipherSuites {
+		if slices.Contains(suites, id) {
+			return ""
+		}
+	}
+	return tlsFailCipher
+}
+
+// versionRange returns the TLS versions cfg accepts, with crypto/tls's
+// server defaults filled in.
+func versionRange(cfg *tls.Config) (minVer, maxVer uint16) {
+	minVer, maxVer = cfg.MinVersion, cfg.MaxVersion
+	if minVer == 0 {
+		minVer = tls.VersionTLS12
+	}
+	if maxVer == 0 {
+		maxVer = tls.VersionTLS13
+	}
+	return minVer, maxVer
+}
+
+// newestVersion returns the highest TLS version in the ClientHello, ignoring
+// GREASE values (RFC 8701), or 0 if it lists none.
+func newestVersion(hello *tls.ClientHelloInfo) uint16 {
+	var newest uint16
+	for _, v := range hello.SupportedVersions {
+		if v&0x0f0f != 0x0a0a && v > newest {
+			newest = v
+		}
+	}
+	return newest
+}
+
+// helloError describes why incompatibleHello refused hello, for the log.
+func helloError(cfg *tls.Config, hello *tls.ClientHelloInfo, reason string) error {
+	if reason == tlsFailVersionTooLow {
+		minVer, _ := versionRange(cfg)
+		return fmt.Errorf("client offers at most %s, minimum is %s",
+			tls.VersionName(newestVersion(hello)), tls.VersionName(minVer))
+	}
+	return fmt.Errorf("client offers no acceptable TLS %s", reason)
+}
+
+// classifyTLSError maps a handshake error to a failure reason.
+func classifyTLSError(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "protocol version"):
+		return tlsFailVersion
+	case strings.Contains(msg, "cipher suite"), strings.Contains(msg, "handshake failure"):
+		return tlsFailCipher
+	case strings.Contains(msg, "certificate"):
+		return tlsFailCert
+	default:
+		return tlsFailOther
+	}
+}
@@ -0,0 +1,387 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/metrics"
+)
+
+// tlsFailureCollector records TLSHandshakeFailed calls.
+type tlsFailureCollector struct {
+	metrics.NoopCollector
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (c *tlsFailureCollector) TLSHandshakeFailed(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reasons = append(c.reasons, reason)
+}
+
+func (c *tlsFailureCollector) got() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.reasons...)
+}
+
+// selfSignedTLS returns a server config with a throwaway certificate and a
+// client config that trusts it.
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test.local"},
+		DNSNames:     []string{"test.local"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse cert: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	client = &tls.Config{RootCAs: pool, ServerName: "test.local"}
+	return server, client
+}
+
+// runObservedConn serves one connection in mode via RunSingleConn and
+// returns the client side. The returned channel closes when the session ends.
+func runObservedConn(t *testing.T, mode config.ListenerMode, serverTLS *tls.Config, collector metrics.Collector) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	return serveOneConn(t, mode, serverTLS, BackendConfig{Hostname: "test.local", Collector: collector})
+}
+
+// serveOneConn serves one connection in mode via RunSingleConn with a
+// backend built from bcfg and returns the client side. The returned channel
+// closes when the session ends.
+func serveOneConn(t *testing.T, mode config.ListenerMode, serverTLS *tls.Config, bcfg BackendConfig) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	return serveConfiguredConn(t, mode, ServerConfig{Backend: NewBackend(bcfg), TLSConfig: serverTLS})
+}
+
+// serveConfiguredConn is serveOneConn for tests that need server settings
+// beyond the backend; listener, hostname and timeouts are filled in.
+func serveConfiguredConn(t *testing.T, mode config.ListenerMode, cfg ServerConfig) (net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	serverTLS := cfg.TLSConfig
+	cfg.Listeners = []config.ListenerConfig{{Address: "127.0.0.1:0", Mode: mode}}
+	cfg.Hostname = "test.local"
+	cfg.ReadTimeout = 5 * time.Second
+	cfg.WriteTimeout = 5 * time.Second
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = srv.RunSingleConn(conn, mode, serverTLS)
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client, done
+}
+
+// startTLS issues EHLO and STARTTLS and waits for the 220 go-ahead.
+func startTLS(t *testing.T, conn net.Conn) {
+	t.Helper()
+	r := bufio.NewReader(conn)
+	expect := func(prefix string) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if strings.HasPrefix(line, prefix+" ") {
+				return
+			}
+			if !strings.HasPrefix(line, prefix+"-") {
+				t.Fatalf("expected %s, got %q", prefix, line)
+			}
+		}
+	}
+	expect("220")
+	_, _ = io.WriteString(conn, "EHLO client.example\r\n")
+	expect("250")
+	_, _ = io.WriteString(conn, "STARTTLS\r\n")
+	expect("220")
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not end")
+	}
+}
+
+func TestTLSHandshakeFailed_STARTTLSVersion(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	collector := &tlsFailureCollector{}
+	conn, done := runObservedConn(t, config.ModeSmtp, serverTLS, collector)
+
+	startTLS(t, conn)
+	clientTLS.MinVersion = tls.VersionTLS10
+	clientTLS.MaxVersion = tls.VersionTLS11
+	if err := tls.Client(conn, clientTLS).Handshake(); err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+	_ = conn.Close()
+	waitDone(t, done)
+
+	if got := collector.got(); len(got) != 1 || got[0] != tlsFailVersionTooLow {
+		t.Errorf("TLSHandshakeFailed reasons = %v, want [%s]", got, tlsFailVersionTooLow)
+	}
+}
+
+func TestTLSHandshakeFailed_STARTTLSSuccess(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	collector := &tlsFailureCollector{}
+	conn, done := runObservedConn(t, config.ModeSmtp, serverTLS, collector)
+
+	startTLS(t, conn)
+	tlsConn := tls.Client(conn, clientTLS)
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	r := bufio.NewReader(tlsConn)
+	_, _ = io.WriteString(tlsConn, "EHLO client.example\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if strings.HasPrefix(line, "250 ") {
+			break
+		}
+	}
+	_, _ = io.WriteString(tlsConn, "QUIT\r\n")
+	_, _ = r.ReadString('\n')
+	_ = conn.Close()
+	waitDone(t, done)
+
+	if got := collector.got(); len(got) != 0 {
+		t.Errorf("unexpected TLSHandshakeFailed reasons %v", got)
+	}
+}
+
+func TestTLSHandshakeFailed_STARTTLSCertRejected(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	collector := &tlsFailureCollector{}
+	conn, done := runObservedConn(t, config.ModeSmtp, serverTLS, collector)
+
+	startTLS(t, conn)
+	clientTLS.RootCAs = x509.NewCertPool() // does not trust the server
+	if err := tls.Client(conn, clientTLS).Handshake(); err == nil {
+		t.Fatal("expected handshake to fail")
+	}
+	_ = conn.Close()
+	waitDone(t, done)
+
+	if got := collector.got(); len(got) != 1 {
+		t.Errorf("TLSHandshakeFailed reasons = %v, want one failure", got)
+	}
+}
+
+func TestTLSHandshakeFailed_SMTPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*tls.Config)
+		want   string
+	}{
+		{
+			name: "version",
+			modify: func(c *tls.Config) {
+				c.MinVersion = tls.VersionTLS10
+				c.MaxVersion = tls.VersionTLS11
+			},
+			want: tlsFailVersionTooLow,
+		},
+		{
+			name: "cipher",
+			modify: func(c *tls.Config) {
+				c.MaxVersion = tls.VersionTLS12
+				// RSA key exchange only; the server has an ECDSA certificate.
+				c.CipherSuites = []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256}
+			},
+			want: tlsFailCipher,
+		},
+		{
+			name:   "cert",
+			modify: func(c *tls.Config) { c.RootCAs = x509.NewCertPool() },
+			want:   tlsFailCert,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverTLS, clientTLS := selfSignedTLS(t)
+			collector := &tlsFailureCollector{}
+			conn, done := runObservedConn(t, config.ModeSmtps, serverTLS, collector)
+
+			tt.modify(clientTLS)
+			if err := tls.Client(conn, clientTLS).Handshake(); err == nil {
+				t.Fatal("expected handshake to fail")
+			}
+			_ = conn.Close()
+			waitDone(t, done)
+
+			if got := collector.got(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("TLSHandshakeFailed reasons = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyTLSError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{"tls: client offered only unsupported versions: [302 301]", tlsFailOther},
+		{"remote error: tls: protocol version not supported", tlsFailVersion},
+		{"tls: no cipher suite supported by both client and server", tlsFailCipher},
+		{"remote error: tls: handshake failure", tlsFailCipher},
+		{"remote error: tls: bad certificate", tlsFailCert},
+		{"EOF", tlsFailOther},
+	}
+	for _, tt := range tests {
+		if got := classifyTLSError(&net.OpError{Op: "read", Err: errString(tt.msg)}); got != tt.want {
+			t.Errorf("classifyTLSError(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+type errString string
+
+func (e errString) Error() string { return string(e) }
+
+func TestIncompatibleHello_Version(t *testing.T) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12}
+	tests := []struct {
+		name     string
+		versions []uint16
+		want     string
+	}{
+		{"TLS 1.0 only", []uint16{tls.VersionTLS10}, tlsFailVersionTooLow},
+		{"TLS 1.1 with GREASE", []uint16{0x1a1a, tls.VersionTLS11, tls.VersionTLS10}, tlsFailVersionTooLow},
+		{"TLS 1.3 only", []uint16{tls.VersionTLS13}, tlsFailVersion},
+		{"nothing offered", nil, tlsFailVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := &tls.ClientHelloInfo{SupportedVersions: tt.versions}
+			if got := incompatibleHello(cfg, hello); got != tt.want {
+				t.Errorf("incompatibleHello = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	hello := &tls.ClientHelloInfo{SupportedVersions: []uint16{tls.VersionTLS10}}
+	want := "client offers at most TLS 1.0, minimum is TLS 1.2"
+	if err := helloError(cfg, hello, tlsFailVersionTooLow); err.Error() != want {
+		t.Errorf("helloError = %q, want %q", err, want)
+	}
+}
+
+func TestTLSRejectALPN(t *testing.T) {
+	tests := []struct {
+		name       string
+		rejectALPN bool
+		offer      []string
+		wantFail   bool
+	}{
+		{name: "h2 refused", rejectALPN: true, offer: []string{"h2", "http/1.1"}, wantFail: true},
+		{name: "smtp accepted", rejectALPN: true, offer: []string{"h2", "smtp"}},
+		{name: "no ALPN accepted", rejectALPN: true},
+		{name: "h2 allowed when off", offer: []string{"h2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverTLS, clientTLS := selfSignedTLS(t)
+			collector := &tlsFailureCollector{}
+			conn, done := serveConfiguredConn(t, config.ModeSmtps, ServerConfig{
+				Backend:    NewBackend(BackendConfig{Hostname: "test.local", Collector: collector}),
+				TLSConfig:  serverTLS,
+				RejectALPN: tt.rejectALPN,
+			})
+
+			clientTLS.NextProtos = tt.offer
+			tlsConn := tls.Client(conn, clientTLS)
+			err := tlsConn.Handshake()
+			if tt.wantFail {
+				if err == nil {
+					t.Fatal("expected handshake to fail")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("handshake: %v", err)
+				}
+				if tt.rejectALPN && len(tt.offer) > 0 {
+					if got := tlsConn.ConnectionState().NegotiatedProtocol; got != "smtp" {
+						t.Errorf("NegotiatedProtocol = %q, want smtp", got)
+					}
+				}
+				_, _ = io.WriteString(tlsConn, "QUIT\r\n")
+			}
+			_ = conn.Close()
+			waitDone(t, done)
+
+			var want []string
+			if tt.wantFail {
+				want = []string{tlsFailALPN}
+			}
+			if got := collector.got(); !slices.Equal(got, want) {
+				t.Errorf("TLSHandshakeFailed reasons = %v, want %v", got, want)
+			}
+		})
+	}
+}
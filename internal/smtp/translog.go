@@ -0,0 +1,123 @@
+package smtp
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// maxTransactionLine bounds how much of an unterminated line is buffered
+// before it is logged anyway.
+const maxTransactionLine = 4096
+
+// transactionLog is an io.Writer for go-smtp's Server.Debug that passes each
+// protocol line, with AUTH credentials redacted, to its sinks: the debug log
+// (log_transactions) and a session capture file ([smtpd.capture]).
+//
+// go-smtp tees both directions of the (post-STARTTLS, plaintext) stream into
+// one writer, so direction is inferred: server replies start with a three-digit
+// code followed by a space or hyphen, which neither SMTP commands nor base64
+// SASL responses can. After a 334 challenge the next client line is a SASL
+// response and is redacted.
+//
+// Each protocol-handler subprocess serves one connection, so a transactionLog
+// must not be shared between connections.
+type transactionLog struct {
+	mu           sync.Mutex
+	sinks        []transactionSink
+	buf          []byte
+	awaitingSASL bool
+}
+
+// transactionSink receives one redacted protocol line. direction is
+// "client" or "server".
+type transactionSink func(direction, data string)
+
+// newTransactionLog creates a redacting transaction log for one connection.
+func newTransactionLog(sinks ...transactionSink) *transactionLog {
+	return &transactionLog{sinks: sinks}
+}
+
+// logSink logs each protocol line at debug level.
+func logSink(logger *slog.Logger) transactionSink {
+	return func(direction, data string) {
+		logger.Debug("transaction",
+			slog.String("direction", direction),
+			slog.String("data", data))
+	}
+}
+
+// Write implements io.Writer. Data is buffered until a full line is available.
+// The whole session, message bodies included, passes through here, so the
+// buffer is reused rather than resliced forward and regrown.
+func (t *transactionLog) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(t.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		t.logLine(string(t.buf[start : start+i]))
+		start += i + 1
+	}
+	t.buf = append(t.buf[:0], t.buf[start:]...)
+	if len(t.buf) > maxTransactionLine {
+		t.logLine(string(t.buf))
+		t.buf = t.buf[:0]
+	}
+	return len(p), nil
+}
+
+func (t *transactionLog) logLine(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	direction, data := t.redact(line)
+	for _, sink := range t.sinks {
+		sink(direction, data)
+	}
+}
+
+// redact classifies line as client or server and strips credentials.
+func (t *transactionLog) redact(line string) (direction, data string) {
+	if isReplyLine(line) {
+		// 334 is the only reply that expects a SASL response next.
+		t.awaitingSASL = strings.HasPrefix(line, "334")
+		return "server", line
+	}
+
+	if t.awaitingSASL {
+		t.awaitingSASL = false
+		if line == "*" {
+			return "client", line // client cancelled the exchange
+		}
+		return "client", "[redacted]"
+	}
+
+	// Only split lines that can be AUTH: most client lines are message
+	// body, and Fields allocates.
+	if l := strings.TrimLeft(line, " \t"); len(l) > 5 && strings.EqualFold(l[:4], "AUTH") && (l[4] == ' ' || l[4] == '\t') {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 {
+			return "client", "AUTH " + strings.ToUpper(fields[1]) + " [redacted]"
+		}
+	}
+	return "client", line
+}
+
+// isReplyLine reports whether line looks like an SMTP server reply
+// ("250 ok", "250-PIPELINING", or a bare "354").
+func isReplyLine(line string) bool {
+	if len(line) < 3 {
+		return false
+	}
+	for i := range 3 {
+		if line[i] < '0' || line[i] > '9' {
+			return false
+		}
+	}
+	return len(line) == 3 || line[3] == ' ' || line[3] == '-'
+}
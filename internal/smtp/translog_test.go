@@ -0,0 +1,206 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func newTestTransactionLog() (*transactionLog, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return newTransactionLog(logSink(logger)), &out
+}
+
+func TestTransactionLog_RedactsCredentials(t *testing.T) {
+	secret := "hunter2-s3cret"
+	plain := base64.StdEncoding.EncodeToString([]byte("\x00alice@example.com\x00" + secret))
+	user := base64.StdEncoding.EncodeToString([]byte("alice@example.com"))
+	pass := base64.StdEncoding.EncodeToString([]byte(secret))
+
+	tests := []struct {
+		name       string
+		transcript string
+		forbidden  []string
+		want       []string
+	}{
+		{
+			name: "AUTH PLAIN initial response",
+			transcript: "220 mail.example.com ESMTP\r\n" +
+				"EHLO client\r\n" +
+				"250-mail.example.com\r\n250 AUTH PLAIN LOGIN\r\n" +
+				"AUTH PLAIN " + plain + "\r\n" +
+				"235 2.0.0 Authentication succeeded\r\n" +
+				"MAIL FROM:<alice@example.com>\r\n",
+			forbidden: []string{plain},
+			want:      []string{"AUTH PLAIN [redacted]", "EHLO client", "MAIL FROM:<alice@example.com>"},
+		},
+		{
+			name: "AUTH PLAIN lowercase mechanism",
+			transcript: "auth plain " + plain + "\r\n" +
+				"235 2.0.0 Authentication succeeded\r\n",
+			forbidden: []string{plain},
+			want:      []string{"AUTH PLAIN [redacted]"},
+		},
+		{
+			name: "AUTH PLAIN continuation",
+			transcript: "AUTH PLAIN\r\n" +
+				"334 \r\n" +
+				plain + "\r\n" +
+				"235 2.0.0 Authentication succeeded\r\n",
+			forbidden: []string{plain},
+			want:      []string{"AUTH PLAIN", "[redacted]"},
+		},
+		{
+			name: "AUTH LOGIN multi-step",
+			transcript: "AUTH LOGIN\r\n" +
+				"334 VXNlcm5hbWU6\r\n" +
+				user + "\r\n" +
+				"334 UGFzc3dvcmQ6\r\n" +
+				pass + "\r\n" +
+				"235 2.0.0 Authentication succeeded\r\n" +
+				"QUIT\r\n",
+			forbidden: []string{user, pass},
+			want:      []string{"AUTH LOGIN", "QUIT"},
+		},
+		{
+			name: "failed AUTH does not redact following commands",
+			transcript: "AUTH PLAIN " + plain + "\r\n" +
+				"535 5.7.8 Authentication failed\r\n" +
+				"RSET\r\n",
+			forbidden: []string{plain},
+			want:      []string{"RSET"},
+		},
+		{
+			name: "cancelled exchange",
+			transcript: "AUTH LOGIN\r\n" +
+				"334 VXNlcm5hbWU6\r\n" +
+				"*\r\n" +
+				"501 5.0.0 Negotiation cancelled\r\n" +
+				"NOOP\r\n",
+			want: []string{"NOOP"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Feed the transcript one byte at a time as well as in one write,
+			// since go-smtp may hand the writer arbitrary fragments.
+			for _, chunked := range []bool{false, true} {
+				tl, out := newTestTransactionLog()
+				if chunked {
+					for i := range len(tt.transcript) {
+						_, _ = tl.Write([]byte{tt.transcript[i]})
+					}
+				} else {
+					_, _ = tl.Write([]byte(tt.transcript))
+				}
+
+				logged := out.String()
+				for _, f := range tt.forbidden {
+					if strings.Contains(logged, f) {
+						t.Errorf("chunked=%v: credential %q leaked into log:\n%s", chunked, f, logged)
+					}
+				}
+				if strings.Contains(logged, secret) {
+					t.Errorf("chunked=%v: password leaked into log:\n%s", chunked, logged)
+				}
+				for _, w := range tt.want {
+					if !strings.Contains(logged, w) {
+						t.Errorf("chunked=%v: log missing %q:\n%s", chunked, w, logged)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionLog_Direction(t *testing.T) {
+	tl, out := newTestTransactionLog()
+	_, _ = tl.Write([]byte("220 ready\r\nHELO x\r\n354\r\n"))
+
+	logged := out.String()
+	for _, want := range []string{
+		`direction=server data="220 ready"`,
+		`direction=client data="HELO x"`,
+		`direction=server data=354`,
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log missing %q:\n%s", want, logged)
+		}
+	}
+}
+
+func TestIsReplyLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"250 OK", true},
+		{"250-PIPELINING", true},
+		{"354", true},
+		{"334 ", true},
+		{"EHLO host", false},
+		{"AGFsaWNl", false},
+		{"2500", false},
+		{"25", false},
+	}
+	for _, tt := range tests {
+		if got := isReplyLine(tt.line); got != tt.want {
+			t.Errorf("isReplyLine(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+// benchSession is a typical pipelined session as go-smtp tees it to
+// Server.Debug: commands, replies and a message body, in uneven reads.
+func benchSession() [][]byte {
+	var s strings.Builder
+	s.WriteString("220 test.local ESMTP ready\r\n")
+	s.WriteString("EHLO client.example\r\n250-test.local\r\n250-PIPELINING\r\n250 8BITMIME\r\n")
+	s.WriteString("MAIL FROM:<alice@example.com>\r\nRCPT TO:<bob@example.com>\r\nDATA\r\n")
+	s.WriteString("250 2.0.0 Roger\r\n250 2.0.0 Roger\r\n354 Go ahead\r\n")
+	s.WriteString("From: alice@example.com\r\nTo: bob@example.com\r\nSubject: report\r\n\r\n")
+	for range 200 {
+		s.WriteString("the quick brown fox jumps over the lazy dog, again and again\r\n")
+	}
+	s.WriteString(".\r\n250 2.0.0 OK: queued\r\nQUIT\r\n221 2.0.0 Bye\r\n")
+
+	data := []byte(s.String())
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := min(len(data), 1500)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+func BenchmarkTransactionLog(b *testing.B) {
+	chunks := benchSession()
+	total := 0
+	for _, c := range chunks {
+		total += len(c)
+	}
+	for _, bb := range []struct {
+		name string
+		new  func() io.Writer
+	}{
+		{"capture", func() io.Writer { return newTransactionLog(func(string, string) {}) }},
+		{"greetings", func() io.Writer { return newGreetingCounter(10) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.SetBytes(int64(total))
+			b.ReportAllocs()
+			for b.Loop() {
+				w := bb.new()
+				for _, c := range chunks {
+					_, _ = w.Write(c)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package smtp
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+// userSessionLease bounds how long a session count lives in the state store
+// without being renewed (see acquireSlot); a session outliving it may
+// briefly allow one more concurrent session than configured.
+const userSessionLease = time.Hour
+
+// userSessionLimit caps concurrent authenticated sessions per user
+// ([smtpd.auth] max_sessions_per_user). Session.Auth takes a slot when
+// credentials are accepted and Logout gives it back.
+//
+// State store errors fail open, as in reputation.go.
+type userSessionLimit struct {
+	store  kvstore.Store
+	max    int
+	logger *slog.Logger
+}
+
+// newUserSessionLimit returns nil when the cap is disabled.
+func newUserSessionLimit(cfg config.AuthConfig, store kvstore.Store, logger *slog.Logger) *userSessionLimit {
+	if cfg.MaxSessionsPerUser <= 0 || store == nil {
+		return nil
+	}
+	return &userSessionLimit{store: store, max: cfg.MaxSessionsPerUser, logger: logger}
+}
+
+func userSessionKey(user string) string {
+	return "authsessions:" + strings.ToLower(user)
+}
+
+// acquire takes a slot for user, reporting false if user already holds the
+// maximum.
+func (l *userSessionLimit) acquire(ctx context.Context, user string) bool {
+	ok, err := acquireSlot(ctx, l.store, userSessionKey(user), l.max, userSessionLease)
+	if err != nil {
+		l.logger.Debug("session count update failed", slog.String("error", err.Error()))
+	}
+	return ok
+}
+
+// release gives back a slot taken by acquire.
+func (l *userSessionLimit) release(ctx context.Context, user string) {
+	if err := releaseSlot(ctx, l.store, userSessionKey(user), userSessionLease); err != nil {
+		l.logger.Debug("session count update failed", slog.String("error", err.Error()))
+	}
+}
+
+// errTooManySessions is the AUTH reply once a user holds every slot.
+var errTooManySessions = &smtp.SMTPError{
+	Code:         454,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+	Message:      "Too many concurrent sessions",
+}
+
+// acquireUserSession takes a session slot for user after a successful
+// credential check. The slot is released by Logout.
+func (s *Session) acquireUserSession(user string) error {
+	l := s.backend.userSessions
+	if l == nil {
+		return nil
+	}
+	if !l.acquire(context.Background(), user) {
+		s.logger.Warn("too many concurrent sessions",
+			slog.String("username", user),
+			slog.Int("max_sessions_per_user", l.max))
+		return errTooManySessions
+	}
+	s.sessionSlot = user
+	return nil
+}
+
+// releaseUserSession gives back the slot taken by acquireUserSession.
+func (s *Session) releaseUserSession() {
+	if s.sessionSlot == "" {
+		return
+	}
+	s.backend.userSessions.release(context.Background(), s.sessionSlot)
+	s.sessionSlot = ""
+}
@@ -0,0 +1,85 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	smpb "github.com/infodancer/session-manager/proto/sessionmanager/v1"
+	"github.com/infodancer/smtpd/internal/config"
+	"github.com/infodancer/smtpd/internal/kvstore"
+)
+
+func TestUserSessionLimit_Disabled(t *testing.T) {
+	if l := newUserSessionLimit(config.AuthConfig{}, kvstore.NewMemory(), slog.Default()); l != nil {
+		t.Error("expected nil limit when max_sessions_per_user is 0")
+	}
+}
+
+func TestSession_Auth_MaxSessionsPerUser(t *testing.T) {
+	agent := startMockSessionServer(t, &mockSessionService{
+		loginResult: &smpb.LoginResponse{Mailbox: "alice@example.com"},
+	})
+	store, _ := newRedisState(t)
+	backend := NewBackend(BackendConfig{
+		SMDelivery: agent,
+		StateStore: store,
+		Auth:       config.AuthConfig{MaxSessionsPerUser: 2},
+	})
+
+	login := func() (*Session, error) {
+		t.Helper()
+		s := &Session{backend: backend, clientIP: "127.0.0.1", logger: slog.Default()}
+		server, err := s.Auth(sasl.Plain)
+		if err != nil {
+			t.Fatalf("Auth: %v", err)
+		}
+		_, _, err = server.Next([]byte("\x00Alice@Example.com\x00secret"))
+		return s, err
+	}
+
+	first, err := login()
+	if err != nil {
+		t.Fatalf("first session: %v", err)
+	}
+	if _, err := login(); err != nil {
+		t.Fatalf("second session: %v", err)
+	}
+
+	third, err := login()
+	if !errors.Is(err, errTooManySessions) {
+		t.Fatalf("third session: got %v, want %v", err, errTooManySessions)
+	}
+	if third.authUser != "" {
+		t.Error("refused session is authenticated")
+	}
+	// A refused session holds no slot, so its logout frees nothing.
+	_ = third.Logout()
+	if _, err := login(); !errors.Is(err, errTooManySessions) {
+		t.Fatalf("after refused logout: got %v, want %v", err, errTooManySessions)
+	}
+
+	if err := first.Logout(); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := login(); err != nil {
+		t.Errorf("after logout: %v", err)
+	}
+}
+
+func TestUserSessionLimit_ReleaseNeverNegative(t *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewMemory()
+	l := newUserSessionLimit(config.AuthConfig{MaxSessionsPerUser: 1}, store, slog.Default())
+
+	// A release after the count expired must not grant an extra slot.
+	l.release(ctx, "alice@example.com")
+	if !l.acquire(ctx, "alice@example.com") {
+		t.Fatal("first acquire refused")
+	}
+	if l.acquire(ctx, "alice@example.com") {
+		t.Error("second acquire allowed over the limit")
+	}
+}
@@ -0,0 +1,32 @@
+package smtp
+
+import (
+	"log/slog"
+
+	"github.com/emersion/go-smtp"
+)
+
+// errUnknownUserWarmup defers an unknown recipient during
+// unknown_user_warmup.
+var errUnknownUserWarmup = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Unable to verify recipient, try again later",
+}
+
+// unknownUser is the reply for a recipient session-manager reports as
+// unknown: 550, or 451 while the connection falls within [smtpd]
+// unknown_user_warmup. Just after startup session-manager may not yet see
+// every user, and a valid one bounced then is lost mail; a deferral costs
+// only a retry.
+func (s *Session) unknownUser(recipient string) error {
+	if s.backend.warmup {
+		s.logger.Info("deferring unknown recipient during warmup", slog.String("recipient", recipient))
+		return errUnknownUserWarmup
+	}
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+		Message:      "User unknown",
+	}
+}
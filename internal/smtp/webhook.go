@@ -0,0 +1,44 @@
+package smtp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/infodancer/smtpd/internal/webhook"
+)
+
+// postMessageEvent reports the outcome of DATA to [smtpd.webhook]: accepted
+// when Data returns nil, otherwise rejected or deferred by the class of the
+// reply. go-smtp answers an error that is not an *smtp.SMTPError with 554,
+// so those count as rejected. Posting happens in the background.
+func (s *Session) postMessageEvent(queueID string, size int64, err error) {
+	if s.backend.webhook == nil {
+		return
+	}
+	e := webhook.Event{
+		Event:      webhook.EventAccepted,
+		Time:       time.Now().UTC(),
+		QueueID:    queueID,
+		ClientIP:   s.clientIP,
+		Helo:       s.helo,
+		User:       s.authUser,
+		From:       s.from,
+		Recipients: append(append([]string{}, s.recipients...), s.remoteRecipients...),
+		Size:       size,
+	}
+	if err != nil {
+		e.Event = webhook.EventRejected
+		e.Code = 554
+		e.Message = err.Error()
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			e.Code = smtpErr.Code
+			e.Message = smtpErr.Message
+		}
+		if e.Code < 500 {
+			e.Event = webhook.EventDeferred
+		}
+	}
+	s.backend.webhook.Send(e)
+}
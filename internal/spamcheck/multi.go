@@ -156,6 +156,7 @@ func (m *MultiChecker) aggregateFirstReject(results []*CheckResult) *CheckResult
 				Action:        ActionTempFail,
 				IsSpam:        false,
 				RejectMessage: r.RejectMessage,
+				Greylisted:    r.Greylisted,
 				Details: map[string]interface{}{
 					"tempfail_by": r.CheckerName,
 					"score":       r.Score,
@@ -249,6 +250,7 @@ func (m *MultiChecker) aggregateHighestScore(results []*CheckResult) *CheckResul
 		Action:        action,
 		IsSpam:        highest.IsSpam,
 		RejectMessage: highest.RejectMessage,
+		Greylisted:    action == ActionTempFail && highest.Greylisted,
 		Details: map[string]interface{}{
 			"highest_score_from": highest.CheckerName,
 		},
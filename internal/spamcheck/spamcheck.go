@@ -64,6 +64,10 @@ type CheckResult struct {
 	// RejectMessage is the message to send when rejecting (optional).
 	RejectMessage string
 
+	// Greylisted marks an ActionTempFail that is greylisting rather than a
+	// verdict on the content: the sender is expected to retry shortly.
+	Greylisted bool
+
 	// Details contains checker-specific details for logging.
 	Details map[string]interface{}
 }
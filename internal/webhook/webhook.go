@@ -0,0 +1,143 @@
+// Package webhook posts message events to an HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event names, matching config.WebhookEvents.
+const (
+	EventAccepted = "accepted" // 250 at the end of DATA
+	EventRejected = "rejected" // 5xx at the end of DATA
+	EventDeferred = "deferred" // 4xx at the end of DATA
+)
+
+// QueueSize bounds the events waiting to be posted. Beyond it new events
+// are dropped, so a slow endpoint never holds up an SMTP session.
+const QueueSize = 64
+
+// Event is the JSON body of one webhook request.
+type Event struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	QueueID    string    `json:"queue_id,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Helo       string    `json:"helo,omitempty"`
+	User       string    `json:"user,omitempty"`
+	From       string    `json:"from"`
+	Recipients []string  `json:"recipients"`
+	Size       int64     `json:"size"`
+	Code       int       `json:"code,omitempty"`    // SMTP reply code, rejected and deferred only
+	Message    string    `json:"message,omitempty"` // SMTP reply text, rejected and deferred only
+}
+
+// Notifier posts events from a single background goroutine. It is safe for
+// concurrent use.
+type Notifier struct {
+	url    string
+	events map[string]bool
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+}
+
+// New starts a Notifier posting the named events to url, each request
+// bounded by timeout.
+func New(url string, events []string, timeout time.Duration, logger *slog.Logger) *Notifier {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	n := &Notifier{
+		url:    url,
+		events: make(map[string]bool, len(events)),
+		client: &http.Client{Timeout: timeout},
+		logger: logger,
+		queue:  make(chan Event, QueueSize),
+		done:   make(chan struct{}),
+	}
+	for _, e := range events {
+		n.events[e] = true
+	}
+	go n.run()
+	return n
+}
+
+// Send queues e for posting if its event is one the Notifier was configured
+// with. It never blocks: when the queue is full, or the Notifier is closed,
+// the event is dropped. Send on a nil Notifier is a no-op.
+func (n *Notifier) Send(e Event) {
+	if n == nil || !n.events[e.Event] {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		n.logger.Warn("webhook queue full, event dropped",
+			slog.String("event", e.Event),
+			slog.String("queue_id", e.QueueID))
+	}
+}
+
+// Close stops accepting events and waits for those already queued to be
+// posted. A protocol-handler exits with its connection, so this is what
+// gets the last message's event out.
+func (n *Notifier) Close() error {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	<-n.done
+	return nil
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for e := range n.queue {
+		n.post(e)
+	}
+}
+
+// post delivers one event. Failures are logged and the event is dropped.
+func (n *Notifier) post(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		n.logger.Warn("webhook event encoding failed", slog.String("error", err.Error()))
+		return
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		n.logger.Warn("webhook post failed",
+			slog.String("event", e.Event),
+			slog.String("queue_id", e.QueueID),
+			slog.String("error", err.Error()))
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		n.logger.Warn("webhook endpoint refused event",
+			slog.String("event", e.Event),
+			slog.String("queue_id", e.QueueID),
+			slog.Int("status", resp.StatusCode))
+	}
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a fake webhook endpoint that records the events posted to it.
+type receiver struct {
+	mu     sync.Mutex
+	events []Event
+	block  chan struct{} // non-nil: requests wait on it
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.block != nil {
+		<-r.block
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		http.Error(w, "bad content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	var e Event
+	if err := json.NewDecoder(req.Body).Decode(&e); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+func (r *receiver) got() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func TestNotifier_PostsConfiguredEvents(t *testing.T) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := New(srv.URL, []string{EventAccepted, EventRejected}, time.Second, nil)
+	n.Send(Event{Event: EventAccepted, QueueID: "A1", From: "a@example.com", Recipients: []string{"b@example.org"}, Size: 42})
+	n.Send(Event{Event: EventDeferred, QueueID: "D1"})
+	n.Send(Event{Event: EventRejected, QueueID: "R1", Code: 550, Message: "Spam"})
+	if err := n.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got := rcv.got()
+	if len(got) != 2 {
+		t.Fatalf("received %d events, want 2: %+v", len(got), got)
+	}
+	if e := got[0]; e.Event != EventAccepted || e.QueueID != "A1" || e.Size != 42 || len(e.Recipients) != 1 {
+		t.Errorf("first event = %+v", e)
+	}
+	if e := got[1]; e.Event != EventRejected || e.Code != 550 || e.Message != "Spam" {
+		t.Errorf("second event = %+v", e)
+	}
+}
+
+func TestNotifier_SendNeverBlocks(t *testing.T) {
+	rcv := &receiver{block: make(chan struct{})}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	n := New(srv.URL, []string{EventAccepted}, time.Second, nil)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < QueueSize*2; i++ {
+			n.Send(Event{Event: EventAccepted})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Send blocked behind a stalled endpoint")
+	}
+
+	close(rcv.block)
+	_ = n.Close()
+	if got := len(rcv.got()); got > QueueSize+1 {
+		t.Errorf("received %d events, want at most %d", got, QueueSize+1)
+	}
+}
+
+func TestNotifier_SendAfterClose(t *testing.T) {
+	n := New("http://127.0.0.1:0/", []string{EventAccepted}, time.Second, nil)
+	_ = n.Close()
+	n.Send(Event{Event: EventAccepted}) // must not panic
+	_ = n.Close()
+
+	var nilNotifier *Notifier
+	nilNotifier.Send(Event{Event: EventAccepted})
+	_ = nilNotifier.Close()
+}